@@ -0,0 +1,100 @@
+package common
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// LogMaxSize is the size in MB after which a new log file is started, the
+// log files older than LogMaxAge days are removed, 0 disables either limit.
+var LogMaxSize = GetEnvOrDefault("LOG_MAX_SIZE", 100)
+var LogMaxAge = GetEnvOrDefault("LOG_MAX_AGE", 0)
+
+// rotateWriter writes to oneapi-<date>.log in dir, a new file is started
+// every day and whenever the current one reaches maxSize.
+type rotateWriter struct {
+	mu      sync.Mutex
+	dir     string
+	maxSize int64
+	maxAge  time.Duration
+	file    *os.File
+	date    string
+	size    int64
+}
+
+func newRotateWriter(dir string, maxSizeMB int, maxAgeDays int) (*rotateWriter, error) {
+	w := &rotateWriter{
+		dir:     dir,
+		maxSize: int64(maxSizeMB) * 1024 * 1024,
+		maxAge:  time.Duration(maxAgeDays) * 24 * time.Hour,
+	}
+	err := w.rotate(0)
+	return w, err
+}
+
+func (w *rotateWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.date != time.Now().Format("20060102") || (w.maxSize > 0 && w.size+int64(len(p)) > w.maxSize) {
+		if err := w.rotate(int64(len(p))); err != nil {
+			return 0, err
+		}
+	}
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// rotate opens the first file of today that still has room for next bytes.
+func (w *rotateWriter) rotate(next int64) error {
+	date := time.Now().Format("20060102")
+	var path string
+	var size int64
+	for i := 0; ; i++ {
+		path = filepath.Join(w.dir, fmt.Sprintf("oneapi-%s.log", date))
+		if i > 0 {
+			path = filepath.Join(w.dir, fmt.Sprintf("oneapi-%s.%d.log", date, i))
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			size = 0
+			break
+		}
+		size = info.Size()
+		if w.maxSize <= 0 || size+next <= w.maxSize || size == 0 {
+			break
+		}
+	}
+	fd, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if w.file != nil {
+		_ = w.file.Close()
+	}
+	w.file = fd
+	w.date = date
+	w.size = size
+	w.removeExpired()
+	return nil
+}
+
+func (w *rotateWriter) removeExpired() {
+	if w.maxAge <= 0 {
+		return
+	}
+	paths, err := filepath.Glob(filepath.Join(w.dir, "oneapi-*.log"))
+	if err != nil {
+		return
+	}
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil || time.Since(info.ModTime()) <= w.maxAge || path == w.file.Name() {
+			continue
+		}
+		_ = os.Remove(path)
+	}
+}
@@ -8,54 +8,119 @@ import (
 	"io"
 	"log"
 	"os"
-	"path/filepath"
-	"sync"
 	"time"
 )
 
 const (
+	loggerDebug = "DEBUG"
 	loggerINFO  = "INFO"
 	loggerWarn  = "WARN"
 	loggerError = "ERR"
 )
 
-const maxLogCount = 1000000
+var logLevels = map[string]int{
+	"debug": 0,
+	"info":  1,
+	"warn":  2,
+	"error": 3,
+}
+
+var loggerLevels = map[string]string{
+	loggerDebug: "debug",
+	loggerINFO:  "info",
+	loggerWarn:  "warn",
+	loggerError: "error",
+}
 
-var logCount int
-var setupLogLock sync.Mutex
-var setupLogWorking bool
+// LogLevel is the lowest level written, one of debug, info, warn and error,
+// it can be changed at runtime from the options.
+var LogLevel = GetEnvOrDefaultString("LOG_LEVEL", "info")
+
+// LogJSONEnabled writes one JSON object per line instead of the text format.
+var LogJSONEnabled = GetEnvOrDefaultString("LOG_FORMAT", "text") == "json"
+
+func IsValidLogLevel(level string) bool {
+	_, ok := logLevels[level]
+	return ok
+}
 
 func SetupLogger() {
 	if *LogDir != "" {
-		ok := setupLogLock.TryLock()
-		if !ok {
-			log.Println("setup log is already working")
-			return
-		}
-		defer func() {
-			setupLogLock.Unlock()
-			setupLogWorking = false
-		}()
-		logPath := filepath.Join(*LogDir, fmt.Sprintf("oneapi-%s.log", time.Now().Format("20060102")))
-		fd, err := os.OpenFile(logPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		writer, err := newRotateWriter(*LogDir, LogMaxSize, LogMaxAge)
 		if err != nil {
 			log.Fatal("failed to open log file")
 		}
-		gin.DefaultWriter = io.MultiWriter(os.Stdout, fd)
-		gin.DefaultErrorWriter = io.MultiWriter(os.Stderr, fd)
+		gin.DefaultWriter = io.MultiWriter(os.Stdout, writer)
+		gin.DefaultErrorWriter = io.MultiWriter(os.Stderr, writer)
 	}
 }
 
+// logEntry is a log line in the JSON format, the request fields are taken
+// from the context when it carries them.
+type logEntry struct {
+	Time      string `json:"time"`
+	Level     string `json:"level"`
+	RequestId string `json:"request_id,omitempty"`
+	UserId    int    `json:"user_id,omitempty"`
+	TokenId   int    `json:"token_id,omitempty"`
+	ChannelId int    `json:"channel_id,omitempty"`
+	Model     string `json:"model,omitempty"`
+	Msg       string `json:"msg"`
+}
+
+func writeJSONLog(writer io.Writer, ctx context.Context, level string, msg string) {
+	entry := logEntry{
+		Time:  time.Now().Format(time.RFC3339Nano),
+		Level: loggerLevels[level],
+		Msg:   msg,
+	}
+	if ctx != nil {
+		entry.RequestId, _ = ctx.Value(RequestIdKey).(string)
+		entry.UserId, _ = ctx.Value("id").(int)
+		entry.TokenId, _ = ctx.Value("token_id").(int)
+		entry.ChannelId, _ = ctx.Value("channel_id").(int)
+		entry.Model, _ = ctx.Value("original_model").(string)
+	}
+	jsonBytes, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	_, _ = writer.Write(append(jsonBytes, '\n'))
+}
+
+func shouldLog(level string) bool {
+	minLevel, ok := logLevels[LogLevel]
+	if !ok {
+		minLevel = logLevels["info"]
+	}
+	return logLevels[loggerLevels[level]] >= minLevel
+}
+
 func SysLog(s string) {
+	if !shouldLog(loggerINFO) {
+		return
+	}
+	if LogJSONEnabled {
+		writeJSONLog(gin.DefaultWriter, nil, loggerINFO, s)
+		return
+	}
 	t := time.Now()
 	_, _ = fmt.Fprintf(gin.DefaultWriter, "[SYS] %v | %s \n", t.Format("2006/01/02 - 15:04:05"), s)
 }
 
 func SysError(s string) {
+	if LogJSONEnabled {
+		writeJSONLog(gin.DefaultErrorWriter, nil, loggerError, s)
+		return
+	}
 	t := time.Now()
 	_, _ = fmt.Fprintf(gin.DefaultErrorWriter, "[SYS] %v | %s \n", t.Format("2006/01/02 - 15:04:05"), s)
 }
 
+func LogDebug(ctx context.Context, msg string) {
+	logHelper(ctx, loggerDebug, msg)
+}
+
 func LogInfo(ctx context.Context, msg string) {
 	logHelper(ctx, loggerINFO, msg)
 }
@@ -69,24 +134,27 @@ func LogError(ctx context.Context, msg string) {
 }
 
 func logHelper(ctx context.Context, level string, msg string) {
+	if !shouldLog(level) {
+		return
+	}
 	writer := gin.DefaultErrorWriter
-	if level == loggerINFO {
+	if level == loggerINFO || level == loggerDebug {
 		writer = gin.DefaultWriter
 	}
+	if LogJSONEnabled {
+		writeJSONLog(writer, ctx, level, msg)
+		return
+	}
 	id := ctx.Value(RequestIdKey)
 	now := time.Now()
 	_, _ = fmt.Fprintf(writer, "[%s] %v | %s | %s \n", level, now.Format("2006/01/02 - 15:04:05"), id, msg)
-	logCount++ // we don't need accurate count, so no lock here
-	if logCount > maxLogCount && !setupLogWorking {
-		logCount = 0
-		setupLogWorking = true
-		go func() {
-			SetupLogger()
-		}()
-	}
 }
 
 func FatalLog(v ...any) {
+	if LogJSONEnabled {
+		writeJSONLog(gin.DefaultErrorWriter, nil, loggerError, fmt.Sprint(v...))
+		os.Exit(1)
+	}
 	t := time.Now()
 	_, _ = fmt.Fprintf(gin.DefaultErrorWriter, "[FATAL] %v | %v \n", t.Format("2006/01/02 - 15:04:05"), v)
 	os.Exit(1)
@@ -0,0 +1,236 @@
+package common
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MetricsEnabled serves the metrics on /metrics in the prometheus text
+// format, MetricsToken protects the endpoint with a bearer token when set.
+var MetricsEnabled = GetEnvOrDefaultBool("METRICS_ENABLED", false)
+var MetricsToken = GetEnvOrDefaultString("METRICS_TOKEN", "")
+
+var latencyBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+var (
+	RelayRequestsTotal = newMetricVec("one_api_relay_requests_total", "Relay requests sent to a channel, by response status code.",
+		"counter", nil, "channel", "model", "group", "code")
+	RelayErrorsTotal = newMetricVec("one_api_relay_errors_total", "Relay requests that failed, by error code.",
+		"counter", nil, "channel", "model", "group", "error_code")
+	RelayRequestDuration = newMetricVec("one_api_relay_request_duration_seconds", "Duration of relay requests sent to a channel.",
+		"histogram", latencyBuckets, "channel", "model", "group")
+	RelayFirstTokenDuration = newMetricVec("one_api_relay_first_token_seconds", "Time until the first token of a relay response.",
+		"histogram", latencyBuckets, "channel", "model", "group")
+	RelayTokensTotal = newMetricVec("one_api_relay_tokens_total", "Tokens consumed by relay requests.",
+		"counter", nil, "channel", "model", "group", "type")
+	RelayQuotaTotal = newMetricVec("one_api_relay_quota_total", "Quota consumed by relay requests.",
+		"counter", nil, "channel", "model", "group")
+	RelayInFlight = newMetricVec("one_api_relay_in_flight_requests", "Relay requests being served.",
+		"gauge", nil)
+	ChannelStatus = newMetricVec("one_api_channel_status", "Channel status, 1 enabled, 2 manually disabled, 3 auto disabled.",
+		"gauge", nil, "channel", "name")
+	CacheRequestsTotal = newMetricVec("one_api_cache_requests_total", "Redis cache lookups, a miss falls back to the database.",
+		"counter", nil, "cache", "result")
+)
+
+var metricVecs = []*MetricVec{
+	RelayRequestsTotal, RelayErrorsTotal, RelayRequestDuration, RelayFirstTokenDuration, RelayTokensTotal,
+	RelayQuotaTotal, RelayInFlight, ChannelStatus, CacheRequestsTotal,
+}
+
+// MetricVec is a counter, gauge or histogram with a fixed set of labels.
+type MetricVec struct {
+	name    string
+	help    string
+	kind    string
+	buckets []float64
+	labels  []string
+	mu      sync.Mutex
+	values  map[string]*metricValue
+}
+
+type metricValue struct {
+	labelValues []string
+	value       float64
+	counts      []uint64
+	count       uint64
+}
+
+func newMetricVec(name string, help string, kind string, buckets []float64, labels ...string) *MetricVec {
+	m := &MetricVec{
+		name:    name,
+		help:    help,
+		kind:    kind,
+		buckets: buckets,
+		labels:  labels,
+		values:  make(map[string]*metricValue),
+	}
+	if len(labels) == 0 {
+		// a metric without labels is reported from the start
+		m.get(nil)
+	}
+	return m
+}
+
+func (m *MetricVec) get(labelValues []string) *metricValue {
+	key := strings.Join(labelValues, "\xff")
+	value, ok := m.values[key]
+	if !ok {
+		value = &metricValue{labelValues: labelValues}
+		if m.kind == "histogram" {
+			value.counts = make([]uint64, len(m.buckets))
+		}
+		m.values[key] = value
+	}
+	return value
+}
+
+// Add adds delta to the counter or gauge with the given label values.
+func (m *MetricVec) Add(delta float64, labelValues ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.get(labelValues).value += delta
+}
+
+// Set sets the gauge with the given label values.
+func (m *MetricVec) Set(value float64, labelValues ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.get(labelValues).value = value
+}
+
+// Observe records a sample of the histogram with the given label values.
+func (m *MetricVec) Observe(sample float64, labelValues ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	value := m.get(labelValues)
+	for i, bucket := range m.buckets {
+		if sample <= bucket {
+			value.counts[i]++
+		}
+	}
+	value.count++
+	value.value += sample
+}
+
+// Reset drops every series, gauges that are rebuilt on each scrape use it.
+func (m *MetricVec) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.values = make(map[string]*metricValue)
+}
+
+func (m *MetricVec) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+	keys := make([]string, 0, len(m.values))
+	for key := range m.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value := m.values[key]
+		labels := formatMetricLabels(m.labels, value.labelValues)
+		if m.kind != "histogram" {
+			fmt.Fprintf(w, "%s%s %s\n", m.name, wrapMetricLabels(labels), formatMetricValue(value.value))
+			continue
+		}
+		for i, bucket := range m.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", m.name, wrapMetricLabels(joinMetricLabels(labels, `le="`+formatMetricValue(bucket)+`"`)), value.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", m.name, wrapMetricLabels(joinMetricLabels(labels, `le="+Inf"`)), value.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", m.name, wrapMetricLabels(labels), formatMetricValue(value.value))
+		fmt.Fprintf(w, "%s_count%s %d\n", m.name, wrapMetricLabels(labels), value.count)
+	}
+}
+
+func formatMetricLabels(names []string, values []string) string {
+	pairs := make([]string, 0, len(names))
+	for i, name := range names {
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		value = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+		pairs = append(pairs, name+`="`+value+`"`)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func joinMetricLabels(labels string, label string) string {
+	if labels == "" {
+		return label
+	}
+	return labels + "," + label
+}
+
+func wrapMetricLabels(labels string) string {
+	if labels == "" {
+		return ""
+	}
+	return "{" + labels + "}"
+}
+
+func formatMetricValue(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+// WriteMetrics writes every metric in the prometheus text format.
+func WriteMetrics(w io.Writer) {
+	for _, m := range metricVecs {
+		m.write(w)
+	}
+}
+
+// RecordCacheLookup counts a Redis cache lookup as a hit or a miss.
+func RecordCacheLookup(cache string, hit bool) {
+	if !MetricsEnabled {
+		return
+	}
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	CacheRequestsTotal.Add(1, cache, result)
+}
+
+// RecordRelayRequest records a relay request sent to a channel, errorCode is
+// empty for a successful request.
+func RecordRelayRequest(channelId int, model string, group string, statusCode int, errorCode string, duration time.Duration) {
+	if !MetricsEnabled {
+		return
+	}
+	channel := strconv.Itoa(channelId)
+	RelayRequestsTotal.Add(1, channel, model, group, strconv.Itoa(statusCode))
+	if errorCode != "" {
+		RelayErrorsTotal.Add(1, channel, model, group, errorCode)
+	}
+	RelayRequestDuration.Observe(duration.Seconds(), channel, model, group)
+}
+
+func RecordRelayFirstToken(channelId int, model string, group string, duration time.Duration) {
+	if !MetricsEnabled {
+		return
+	}
+	RelayFirstTokenDuration.Observe(duration.Seconds(), strconv.Itoa(channelId), model, group)
+}
+
+func RecordRelayConsume(channelId int, model string, group string, promptTokens int, completionTokens int, quota int) {
+	if !MetricsEnabled {
+		return
+	}
+	channel := strconv.Itoa(channelId)
+	RelayTokensTotal.Add(float64(promptTokens), channel, model, group, "prompt")
+	RelayTokensTotal.Add(float64(completionTokens), channel, model, group, "completion")
+	RelayQuotaTotal.Add(float64(quota), channel, model, group)
+}
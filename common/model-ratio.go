@@ -104,6 +104,7 @@ var defaultModelRatio = map[string]float64{
 	"claude-3-sonnet-20240229":       1.5,   // $3 / 1M tokens
 	"claude-3-5-sonnet-20240620":     1.5,
 	"claude-3-5-sonnet-20241022":     1.5,
+	"claude-3-7-sonnet-20250219":     1.5,
 	"claude-3-opus-20240229":         7.5, // $15 / 1M tokens
 	"ERNIE-4.0-8K":                   0.120 * RMB,
 	"ERNIE-3.5-8K":                   0.012 * RMB,
@@ -133,6 +134,8 @@ var defaultModelRatio = map[string]float64{
 	"gemini-1.0-pro-latest":          1,
 	"gemini-1.0-pro-vision-latest":   1,
 	"gemini-ultra":                   1,
+	"text-embedding-004":             0.01,
+	"gemini-embedding-exp-03-07":     0.01,
 	"chatglm_turbo":                  0.3572,     // ￥0.005 / 1k tokens
 	"chatglm_pro":                    0.7143,     // ￥0.01 / 1k tokens
 	"chatglm_std":                    0.3572,     // ￥0.005 / 1k tokens
@@ -202,6 +205,7 @@ var defaultModelPrice = map[string]float64{
 	"suno_lyrics":       0.01,
 	"dall-e-3":          0.04,
 	"dall-e-2":          0.02,
+	"wanx2.1-imageedit": 0.02,
 	"gpt-4-gizmo-*":     0.1,
 	"mj_imagine":        0.1,
 	"mj_variation":      0.1,
@@ -312,6 +316,10 @@ func GetModelRatio(name string) float64 {
 		name = "gpt-4-gizmo-*"
 	}
 	ratio, ok := modelRatioMap[name]
+	if !ok && strings.HasSuffix(name, "-thinking") {
+		// extended thinking variants are billed like their base model
+		ratio, ok = modelRatioMap[strings.TrimSuffix(name, "-thinking")]
+	}
 	if !ok {
 		SysError("model ratio not found: " + name)
 		return 30
@@ -440,6 +448,35 @@ func GetCompletionRatio(name string) float64 {
 	return 1
 }
 
+// GetCacheRatio returns the price of a cached prompt token relative to a normal
+// prompt token.
+func GetCacheRatio(name string) float64 {
+	lowercaseName := strings.ToLower(name)
+	if strings.Contains(name, "claude") {
+		return 0.1
+	}
+	if strings.HasPrefix(lowercaseName, "deepseek") {
+		return 0.1
+	}
+	if strings.HasPrefix(name, "gemini-") {
+		return 0.25
+	}
+	if strings.HasPrefix(name, "gpt-4o") || strings.HasPrefix(name, "gpt-4.1") || strings.HasPrefix(name, "o1") ||
+		strings.HasPrefix(name, "o3") || strings.HasPrefix(name, "o4") || name == "chatgpt-4o-latest" {
+		return 0.5
+	}
+	return 1
+}
+
+// GetCreateCacheRatio returns the price of a prompt token written to the cache
+// relative to a normal prompt token.
+func GetCreateCacheRatio(name string) float64 {
+	if strings.Contains(name, "claude") {
+		return 1.25
+	}
+	return 1
+}
+
 func GetAudioRatio(name string) float64 {
 	if strings.HasPrefix(name, "gpt-4o-realtime") {
 		return 20
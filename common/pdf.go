@@ -0,0 +1,219 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/signintech/gopdf"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/gomono"
+	"golang.org/x/image/font/gofont/goregular"
+)
+
+const (
+	PDFFontRegular = "regular"
+	PDFFontBold    = "bold"
+	PDFFontMono    = "mono"
+
+	pdfFontWide = "wide"
+)
+
+// PDFFontPath is a TrueType font for the text beyond latin, e.g. Chinese. The
+// bundled Go fonts only cover latin, without it such text is an error.
+var PDFFontPath = GetEnvOrDefaultString("PDF_FONT_PATH", "")
+
+// PDFLine is a line of text, a line with an empty text leaves a gap.
+type PDFLine struct {
+	Text string
+	Size float64
+	Font string
+}
+
+const (
+	pdfPageWidth  = 595.0
+	pdfPageHeight = 842.0
+	pdfMargin     = 50.0
+	// pdfMonoAdvance is the advance of a Go Mono character in ems
+	pdfMonoAdvance = 0.6
+)
+
+var (
+	pdfWideFontOnce sync.Once
+	pdfWideFontData []byte
+	pdfWideFontErr  error
+)
+
+func loadPDFWideFont() ([]byte, error) {
+	pdfWideFontOnce.Do(func() {
+		if PDFFontPath == "" {
+			pdfWideFontErr = errors.New("导出内容包含中文等非拉丁字符，请设置环境变量 PDF_FONT_PATH 指向支持这些字符的 TrueType 字体")
+			return
+		}
+		pdfWideFontData, pdfWideFontErr = os.ReadFile(PDFFontPath)
+		if pdfWideFontErr != nil {
+			SysError("failed to read PDF font: " + pdfWideFontErr.Error())
+			pdfWideFontErr = fmt.Errorf("读取 PDF 字体失败：%s", pdfWideFontErr.Error())
+		}
+	})
+	return pdfWideFontData, pdfWideFontErr
+}
+
+func pdfIsLatin(r rune) bool {
+	return r >= 32 && r <= 126
+}
+
+func pdfIsLatinText(text string) bool {
+	for _, r := range text {
+		if !pdfIsLatin(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// pdfRuneCells is the number of PDFFontMono cells r takes, wide East Asian
+// characters take two.
+func pdfRuneCells(r rune) int {
+	switch {
+	case r >= 0x1100 && r <= 0x115F,
+		r >= 0x2E80 && r <= 0xA4CF && r != 0x303F,
+		r >= 0xAC00 && r <= 0xD7A3,
+		r >= 0xF900 && r <= 0xFAFF,
+		r >= 0xFE30 && r <= 0xFE4F,
+		r >= 0xFF00 && r <= 0xFF60,
+		r >= 0xFFE0 && r <= 0xFFE6,
+		r >= 0x20000 && r <= 0x3FFFD:
+		return 2
+	}
+	return 1
+}
+
+// PDFPad fits text in the given number of PDFFontMono cells, cutting it or
+// padding it with spaces on the right.
+func PDFPad(text string, cells int) string {
+	var b strings.Builder
+	used := 0
+	for _, r := range text {
+		if used+pdfRuneCells(r) > cells {
+			break
+		}
+		used += pdfRuneCells(r)
+		b.WriteRune(r)
+	}
+	return b.String() + strings.Repeat(" ", cells-used)
+}
+
+func newPDF(title string, lines []PDFLine) (*gopdf.GoPdf, error) {
+	pdf := &gopdf.GoPdf{}
+	pdf.Start(gopdf.Config{PageSize: gopdf.Rect{W: pdfPageWidth, H: pdfPageHeight}})
+	pdf.SetInfo(gopdf.PdfInfo{Title: title, Producer: SystemName})
+	fonts := map[string][]byte{
+		PDFFontRegular: goregular.TTF,
+		PDFFontBold:    gobold.TTF,
+		PDFFontMono:    gomono.TTF,
+	}
+	for _, line := range lines {
+		if !pdfIsLatinText(line.Text) {
+			data, err := loadPDFWideFont()
+			if err != nil {
+				return nil, err
+			}
+			fonts[pdfFontWide] = data
+			break
+		}
+	}
+	for family, data := range fonts {
+		err := pdf.AddTTFFontDataWithOption(family, data, gopdf.TtfOption{
+			OnGlyphNotFoundSubstitute: func(r rune) rune { return '?' },
+		})
+		if err != nil {
+			if family == pdfFontWide {
+				return nil, fmt.Errorf("加载 PDF 字体失败，请使用 TrueType 字体：%s", err.Error())
+			}
+			return nil, err
+		}
+	}
+	return pdf, nil
+}
+
+// writePDFText shows text with its baseline at y. Text beyond latin is shown
+// in the PDF_FONT_PATH font, which has no bold face. In a PDFFontMono line
+// every such character is put on the cells it takes so the columns stay
+// aligned.
+func writePDFText(pdf *gopdf.GoPdf, text string, font string, size float64, y float64) error {
+	show := func(font string, x float64, text string) error {
+		if err := pdf.SetFont(font, "", size); err != nil {
+			return err
+		}
+		pdf.SetXY(x, y)
+		return pdf.Text(text)
+	}
+	if pdfIsLatinText(text) {
+		return show(font, pdfMargin, text)
+	}
+	if font != PDFFontMono {
+		return show(pdfFontWide, pdfMargin, text)
+	}
+	runes := []rune(text)
+	x := pdfMargin
+	cell := size * pdfMonoAdvance
+	for start := 0; start < len(runes); {
+		if !pdfIsLatin(runes[start]) {
+			if err := show(pdfFontWide, x, string(runes[start])); err != nil {
+				return err
+			}
+			x += float64(pdfRuneCells(runes[start])) * cell
+			start++
+			continue
+		}
+		end := start
+		for end < len(runes) && pdfIsLatin(runes[end]) {
+			end++
+		}
+		if err := show(PDFFontMono, x, string(runes[start:end])); err != nil {
+			return err
+		}
+		x += float64(end-start) * cell
+		start = end
+	}
+	return nil
+}
+
+// WritePDF writes the lines as a plain A4 document, starting a new page
+// whenever the current one is full. Latin text is set in the bundled Go fonts
+// and anything else needs PDFFontPath.
+func WritePDF(w io.Writer, title string, lines []PDFLine) error {
+	pdf, err := newPDF(title, lines)
+	if err != nil {
+		return err
+	}
+	pdf.AddPage()
+	y := pdfMargin
+	for _, line := range lines {
+		size := line.Size
+		if size <= 0 {
+			size = 10
+		}
+		font := line.Font
+		if font == "" {
+			font = PDFFontRegular
+		}
+		height := size * 1.4
+		if y+height > pdfPageHeight-pdfMargin {
+			pdf.AddPage()
+			y = pdfMargin
+		}
+		y += height
+		if line.Text == "" {
+			continue
+		}
+		if err = writePDFText(pdf, line.Text, font, size, y); err != nil {
+			return err
+		}
+	}
+	return pdf.Write(w)
+}
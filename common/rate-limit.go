@@ -68,3 +68,108 @@ func (l *InMemoryRateLimiter) Request(key string, maxRequestNum int, duration in
 	}
 	return true
 }
+
+type slidingWindowEntry struct {
+	time  int64 // unix milliseconds
+	value int64
+}
+
+// InMemorySlidingWindow sums weighted events over a sliding window, it is the
+// fallback of the redis based relay rate limits.
+type InMemorySlidingWindow struct {
+	store              map[string][]slidingWindowEntry
+	mutex              sync.Mutex
+	expirationDuration time.Duration
+}
+
+func (w *InMemorySlidingWindow) Init(expirationDuration time.Duration) {
+	if w.store == nil {
+		w.mutex.Lock()
+		if w.store == nil {
+			w.store = make(map[string][]slidingWindowEntry)
+			w.expirationDuration = expirationDuration
+			if expirationDuration > 0 {
+				go w.clearExpiredItems()
+			}
+		}
+		w.mutex.Unlock()
+	}
+}
+
+func (w *InMemorySlidingWindow) clearExpiredItems() {
+	for {
+		time.Sleep(w.expirationDuration)
+		w.mutex.Lock()
+		now := time.Now().UnixMilli()
+		for key, entries := range w.store {
+			size := len(entries)
+			if size == 0 || now-entries[size-1].time > w.expirationDuration.Milliseconds() {
+				delete(w.store, key)
+			}
+		}
+		w.mutex.Unlock()
+	}
+}
+
+// Usage returns the sum of the window and when its oldest entry was added,
+// the oldest time is zero for an empty window.
+func (w *InMemorySlidingWindow) Usage(key string, window time.Duration) (int64, int64) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.usage(key, window)
+}
+
+func (w *InMemorySlidingWindow) usage(key string, window time.Duration) (int64, int64) {
+	entries := w.store[key]
+	start := time.Now().Add(-window).UnixMilli()
+	i := 0
+	for i < len(entries) && entries[i].time <= start {
+		i++
+	}
+	entries = entries[i:]
+	w.store[key] = entries
+	var sum int64
+	for _, entry := range entries {
+		sum += entry.value
+	}
+	if len(entries) == 0 {
+		return 0, 0
+	}
+	return sum, entries[0].time
+}
+
+func (w *InMemorySlidingWindow) Add(key string, value int64) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.store[key] = append(w.store[key], slidingWindowEntry{time: time.Now().UnixMilli(), value: value})
+}
+
+// Request adds one event to every window when all of them are below their
+// limit, the checks and the adds happen under the same lock. It returns the
+// sum and oldest time of each window and the index of the first window at its
+// limit, -1 when the event was added.
+func (w *InMemorySlidingWindow) Request(keys []string, limits []int64, window time.Duration) ([]int64, []int64, int) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	sums := make([]int64, len(keys))
+	oldests := make([]int64, len(keys))
+	rejected := -1
+	for i, key := range keys {
+		sums[i], oldests[i] = w.usage(key, window)
+		if rejected < 0 && sums[i] >= limits[i] {
+			rejected = i
+		}
+	}
+	if rejected >= 0 {
+		return sums, oldests, rejected
+	}
+	now := time.Now().UnixMilli()
+	for i, key := range keys {
+		w.store[key] = append(w.store[key], slidingWindowEntry{time: now, value: 1})
+		sums[i]++
+		if oldests[i] == 0 {
+			oldests[i] = now
+		}
+	}
+	return sums, oldests, -1
+}
@@ -0,0 +1,111 @@
+package common
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TracingEnabled exports spans over OTLP/HTTP, the endpoint and headers are
+// read from the standard OTEL_EXPORTER_OTLP_* environment variables.
+var TracingEnabled = GetEnvOrDefaultBool("OTEL_TRACING_ENABLED", false)
+
+// traceContextKey holds the context of the innermost open span of a request.
+const traceContextKey = "trace_context"
+
+var tracer = otel.Tracer("one-api")
+
+// InitTracing installs the OTLP exporter and the W3C trace context
+// propagator, the returned func flushes the pending spans.
+func InitTracing() (func(context.Context) error, error) {
+	if !TracingEnabled {
+		return func(context.Context) error { return nil }, nil
+	}
+	exporter, err := otlptracehttp.New(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	res, err := resource.New(context.Background(),
+		resource.WithAttributes(semconv.ServiceName("one-api"), semconv.ServiceVersion(Version)),
+		resource.WithFromEnv())
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return provider.Shutdown, nil
+}
+
+// StartRequestSpan starts the server span of a request, continuing the trace
+// of an incoming traceparent header.
+func StartRequestSpan(c *gin.Context, name string, attrs ...attribute.KeyValue) trace.Span {
+	if !TracingEnabled {
+		return trace.SpanFromContext(context.Background())
+	}
+	ctx := otel.GetTextMapPropagator().Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+	ctx, span := tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(attrs...))
+	c.Request = c.Request.WithContext(ctx)
+	c.Set(traceContextKey, ctx)
+	return span
+}
+
+// SpanContext returns the context of the innermost open span of the request.
+func SpanContext(c *gin.Context) context.Context {
+	if ctx, ok := c.Get(traceContextKey); ok {
+		return ctx.(context.Context)
+	}
+	return c.Request.Context()
+}
+
+// StartSpan starts a span under the innermost open span of the request, the
+// spans started until end is called are nested under it. end may be called
+// more than once.
+func StartSpan(c *gin.Context, name string, attrs ...attribute.KeyValue) (trace.Span, func()) {
+	if !TracingEnabled {
+		return trace.SpanFromContext(context.Background()), func() {}
+	}
+	parent := SpanContext(c)
+	ctx, span := tracer.Start(parent, name, trace.WithAttributes(attrs...))
+	c.Set(traceContextKey, ctx)
+	ended := false
+	return span, func() {
+		if ended {
+			return
+		}
+		ended = true
+		span.End()
+		c.Set(traceContextKey, parent)
+	}
+}
+
+// StartLeafSpan starts a span that does not become the parent of later spans,
+// it is safe to use from goroutines that outlive the request.
+func StartLeafSpan(c *gin.Context, name string, attrs ...attribute.KeyValue) trace.Span {
+	if !TracingEnabled {
+		return trace.SpanFromContext(context.Background())
+	}
+	_, span := tracer.Start(SpanContext(c), name, trace.WithAttributes(attrs...))
+	return span
+}
+
+// StartUpstreamSpan starts the client span of an upstream request and sets
+// its traceparent header.
+func StartUpstreamSpan(c *gin.Context, req *http.Request) trace.Span {
+	if !TracingEnabled {
+		return trace.SpanFromContext(context.Background())
+	}
+	ctx, span := tracer.Start(SpanContext(c), "upstream "+req.Method, trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.HTTPRequestMethodKey.String(req.Method), semconv.ServerAddress(req.URL.Hostname())))
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+	return span
+}
@@ -7,6 +7,8 @@ var (
 	UserId2GroupCacheSeconds  = common.SyncFrequency
 	UserId2QuotaCacheSeconds  = common.SyncFrequency
 	UserId2StatusCacheSeconds = common.SyncFrequency
+	SubscriptionCacheSeconds  = common.SyncFrequency
+	ChannelKeyCacheSeconds    = common.SyncFrequency
 )
 
 // Cache keys
@@ -15,9 +17,16 @@ const (
 	UserQuotaKeyFmt    = "user_quota:%d"
 	UserEnabledKeyFmt  = "user_enabled:%d"
 	UserUsernameKeyFmt = "user_name:%d"
+
+	UserSubscriptionKeyFmt = "user_subscription:%d"
+	PlanKeyFmt             = "plan:%d"
+
+	ChannelDisabledKeysKeyFmt = "channel_disabled_keys:%d"
 )
 
 const (
 	TokenFiledRemainQuota = "RemainQuota"
 	TokenFieldGroup       = "Group"
+
+	SubscriptionFieldRemainQuota = "RemainQuota"
 )
@@ -1,5 +1,9 @@
 package constant
 
 var (
-	ForceFormat = "force_format" // ForceFormat 强制格式化为OpenAI格式
+	ForceFormat    = "force_format"    // ForceFormat 强制格式化为OpenAI格式
+	MaxConcurrency = "max_concurrency" // MaxConcurrency 渠道最大并发请求数，0 为不限制
+	QueueSize      = "queue_size"      // QueueSize 渠道并发已满时最多排队等待的请求数
+	QueueTimeout   = "queue_timeout"   // QueueTimeout 排队等待的最长秒数
+	CostRatio      = "cost_ratio"      // CostRatio 渠道相对成本，用于最低成本路由，默认为 1
 )
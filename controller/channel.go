@@ -119,7 +119,8 @@ func FetchUpstreamModels(c *gin.Context) {
 		baseURL = channel.GetBaseURL()
 	}
 	url := fmt.Sprintf("%s/v1/models", baseURL)
-	body, err := GetResponseBody("GET", url, channel, GetAuthHeader(channel.Key))
+	key, _ := channel.SelectKey()
+	body, err := GetResponseBody("GET", url, channel, GetAuthHeader(key))
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
@@ -267,6 +268,18 @@ func AddChannel(c *gin.Context) {
 		}
 		keys = []string{channel.Key}
 	}
+	switch channel.GetMultiKeyMode() {
+	case "":
+	case model.MultiKeyModeRoundRobin, model.MultiKeyModeRandom:
+		// all keys stay in one channel and are rotated per request
+		keys = []string{channel.Key}
+	default:
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "不支持的多密钥模式",
+		})
+		return
+	}
 	channels := make([]model.Channel, 0, len(keys))
 	for _, key := range keys {
 		if key == "" {
@@ -480,6 +493,15 @@ func UpdateChannel(c *gin.Context) {
 			}
 		}
 	}
+	switch channel.GetMultiKeyMode() {
+	case "", model.MultiKeyModeRoundRobin, model.MultiKeyModeRandom:
+	default:
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "不支持的多密钥模式",
+		})
+		return
+	}
 	err = channel.Update()
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
@@ -597,3 +619,14 @@ func BatchSetChannelTag(c *gin.Context) {
 	})
 	return
 }
+
+// GetChannelHealths shows the passive health and circuit breaker state of the
+// channels that served requests on this node recently.
+func GetChannelHealths(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    model.GetChannelHealths(),
+	})
+	return
+}
@@ -0,0 +1,156 @@
+package controller
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"one-api/common"
+	"one-api/model"
+	"one-api/service"
+	"strconv"
+)
+
+type ChannelKeyInfo struct {
+	Index        int    `json:"index"`
+	Key          string `json:"key"`
+	KeyHash      string `json:"key_hash"`
+	Status       int    `json:"status"`
+	StatusReason string `json:"status_reason"`
+	StatusTime   int64  `json:"status_time"`
+	UsedQuota    int64  `json:"used_quota"`
+	RequestCount int64  `json:"request_count"`
+}
+
+type ChannelKeyStatusRequest struct {
+	KeyHash string `json:"key_hash"`
+	Status  int    `json:"status"`
+}
+
+func maskChannelKey(key string) string {
+	if len(key) <= 8 {
+		return "****"
+	}
+	return key[:4] + "****" + key[len(key)-4:]
+}
+
+func GetChannelKeys(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	channel, err := model.GetChannelById(id, true)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	channelKeys, err := model.GetChannelKeys(id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	channelKeyMap := make(map[string]*model.ChannelKey, len(channelKeys))
+	for _, channelKey := range channelKeys {
+		channelKeyMap[channelKey.KeyHash] = channelKey
+	}
+	keys := channel.GetKeys()
+	data := make([]ChannelKeyInfo, 0, len(keys))
+	for i, key := range keys {
+		keyInfo := ChannelKeyInfo{
+			Index:   i,
+			Key:     maskChannelKey(key),
+			KeyHash: model.GetChannelKeyHash(key),
+			Status:  common.ChannelStatusEnabled,
+		}
+		if channelKey, ok := channelKeyMap[keyInfo.KeyHash]; ok {
+			keyInfo.Status = channelKey.Status
+			keyInfo.StatusReason = channelKey.StatusReason
+			keyInfo.StatusTime = channelKey.StatusTime
+			keyInfo.UsedQuota = channelKey.UsedQuota
+			keyInfo.RequestCount = channelKey.RequestCount
+		}
+		data = append(data, keyInfo)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    data,
+	})
+	return
+}
+
+func UpdateChannelKeyStatus(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	req := ChannelKeyStatusRequest{}
+	err = c.ShouldBindJSON(&req)
+	if err != nil || req.KeyHash == "" ||
+		(req.Status != common.ChannelStatusEnabled && req.Status != common.ChannelStatusManuallyDisabled) {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "参数错误",
+		})
+		return
+	}
+	channel, err := model.GetChannelById(id, true)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if !channel.IsMultiKey() {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "该渠道不是多密钥渠道",
+		})
+		return
+	}
+	found := false
+	for _, key := range channel.GetKeys() {
+		if model.GetChannelKeyHash(key) == req.KeyHash {
+			found = true
+			break
+		}
+	}
+	if !found {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "密钥不存在",
+		})
+		return
+	}
+	enabledCount, err := model.UpdateChannelKeyStatus(channel, req.KeyHash, req.Status, "")
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	// a channel disabled with its last key comes back with the first key enabled
+	if enabledCount > 0 && channel.Status == common.ChannelStatusAutoDisabled {
+		service.EnableChannel(channel.Id, channel.Name)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    enabledCount,
+	})
+	return
+}
@@ -0,0 +1,90 @@
+package controller
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"one-api/common"
+	"one-api/model"
+	"one-api/service"
+	"strconv"
+)
+
+func pageParams(c *gin.Context) (int, int) {
+	p, _ := strconv.Atoi(c.Query("p"))
+	pageSize, _ := strconv.Atoi(c.Query("page_size"))
+	if p < 1 {
+		p = 1
+	}
+	if pageSize < 1 {
+		pageSize = common.ItemsPerPage
+	}
+	return p, pageSize
+}
+
+// GetQuotaLedgers lists ledger entries, filtered by user_id, account (e.g.
+// user:1 or token:2), source and reference.
+func GetQuotaLedgers(c *gin.Context) {
+	p, pageSize := pageParams(c)
+	userId, _ := strconv.Atoi(c.Query("user_id"))
+	entries, total, err := model.GetQuotaLedgers(userId, c.Query("account"), c.Query("source"), c.Query("reference"), (p-1)*pageSize, pageSize)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"items":     entries,
+			"total":     total,
+			"page":      p,
+			"page_size": pageSize,
+		},
+	})
+}
+
+// GetQuotaDiscrepancies reports the accounts whose ledger balance did not
+// match in the last reconciliation, min_occurrences hides the ones seen by
+// fewer runs in a row.
+func GetQuotaDiscrepancies(c *gin.Context) {
+	p, pageSize := pageParams(c)
+	userId, _ := strconv.Atoi(c.Query("user_id"))
+	minOccurrences, _ := strconv.Atoi(c.Query("min_occurrences"))
+	discrepancies, total, err := model.GetQuotaDiscrepancies(userId, minOccurrences, (p-1)*pageSize, pageSize)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"items":     discrepancies,
+			"total":     total,
+			"page":      p,
+			"page_size": pageSize,
+		},
+	})
+}
+
+func ReconcileQuotaLedger(c *gin.Context) {
+	result, err := service.ReconcileQuotaLedger()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    result,
+	})
+}
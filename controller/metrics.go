@@ -0,0 +1,27 @@
+package controller
+
+import (
+	"net/http"
+	"one-api/common"
+	"one-api/model"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetMetrics serves the gateway metrics in the prometheus text format, the
+// channel status is read from the database on every scrape.
+func GetMetrics(c *gin.Context) {
+	channels, err := model.GetChannelStatuses()
+	if err != nil {
+		common.SysError("failed to get channel statuses: " + err.Error())
+	} else {
+		common.ChannelStatus.Reset()
+		for _, channel := range channels {
+			common.ChannelStatus.Set(float64(channel.Status), strconv.Itoa(channel.Id), channel.Name)
+		}
+	}
+	c.Header("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	c.Status(http.StatusOK)
+	common.WriteMetrics(c.Writer)
+}
@@ -7,7 +7,6 @@ import (
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"io"
-	"log"
 	"net/http"
 	"one-api/common"
 	"one-api/dto"
@@ -91,7 +90,8 @@ func UpdateMidjourneyTaskBulk() {
 			// 使用带有超时的 context 创建新的请求
 			req = req.WithContext(ctx)
 			req.Header.Set("Content-Type", "application/json")
-			req.Header.Set("mj-api-secret", midjourneyChannel.Key)
+			key, _ := midjourneyChannel.SelectKey()
+			req.Header.Set("mj-api-secret", key)
 			resp, err := service.GetHttpClient().Do(req)
 			if err != nil {
 				common.LogError(ctx, fmt.Sprintf("Get Task Do req error: %v", err))
@@ -136,6 +136,12 @@ func UpdateMidjourneyTaskBulk() {
 				task.StartTime = responseItem.StartTime
 				task.FinishTime = responseItem.FinishTime
 				task.ImageUrl = responseItem.ImageUrl
+				if responseItem.Status == "SUCCESS" && responseItem.ImageUrl != "" {
+					task.ImageUrl, err = service.StoreRemoteFile(ctx, responseItem.ImageUrl, "mj")
+					if err != nil {
+						common.LogError(ctx, "store midjourney image failed: "+err.Error())
+					}
+				}
 				task.Status = responseItem.Status
 				task.FailReason = responseItem.FailReason
 				if responseItem.Properties != nil {
@@ -159,7 +165,7 @@ func UpdateMidjourneyTaskBulk() {
 					common.LogError(ctx, "UpdateMidjourneyTask task error: "+err.Error())
 				} else {
 					if shouldReturnQuota {
-						err = model.IncreaseUserQuota(task.UserId, task.Quota)
+						err = model.RefundTaskQuota(task.UserId, task.Quota, task.PlanQuota, model.QuotaRef{Source: model.LedgerSourceRelay, Reference: task.MjId})
 						if err != nil {
 							common.LogError(ctx, "fail to increase user quota: "+err.Error())
 						}
@@ -251,7 +257,7 @@ func GetUserMidjourney(c *gin.Context) {
 	}
 
 	userId := c.GetInt("id")
-	log.Printf("userId = %d \n", userId)
+	common.LogDebug(c, fmt.Sprintf("userId = %d", userId))
 
 	queryParams := model.TaskQueryParams{
 		MjID:           c.Query("mj_id"),
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"one-api/common"
 	"one-api/model"
+	"one-api/service"
 	"one-api/setting"
 	"strings"
 
@@ -64,6 +65,10 @@ func GetStatus(c *gin.Context) {
 			"data_export_default_time": common.DataExportDefaultTime,
 			"default_collapse_sidebar": common.DefaultCollapseSidebar,
 			"enable_online_topup":      setting.PayAddress != "" && setting.EpayId != "" && setting.EpayKey != "",
+			"enable_stripe_topup":      setting.StripeEnabled(),
+			"stripe_currency":          setting.StripeCurrency,
+			"stripe_price":             setting.StripePrice,
+			"payment_providers":        service.GetEnabledPaymentProviders(),
 			"mj_notify_enabled":        setting.MjNotifyEnabled,
 			"chats":                    setting.Chats,
 		},
@@ -92,6 +92,58 @@ func UpdateOption(c *gin.Context) {
 			})
 			return
 		}
+	case "GroupRateLimit":
+		err = setting.CheckGroupRateLimit(option.Value)
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+	case "ChannelRoutingStrategy":
+		err = setting.CheckChannelRoutingStrategy(option.Value)
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+	case "ModelFallback":
+		err = setting.CheckModelFallback(option.Value)
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+	case "HedgeRequestDelay":
+		err = setting.CheckHedgeRequestDelay(option.Value)
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+	case "LogLevel":
+		if !common.IsValidLogLevel(option.Value) {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "无效的日志级别，可选值为 debug、info、warn、error",
+			})
+			return
+		}
+	case "ResponseCacheScope":
+		if !setting.IsValidResponseCacheScope(option.Value) {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "无效的响应缓存范围，可选值为 token、user、group",
+			})
+			return
+		}
 	}
 	err = model.UpdateOption(option.Key, option.Value)
 	if err != nil {
@@ -5,7 +5,6 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
 	"one-api/common"
 	rawconstant "one-api/constant"
@@ -16,17 +15,21 @@ import (
 	"one-api/relay/constant"
 	relayconstant "one-api/relay/constant"
 	"one-api/service"
+	"one-api/setting"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 )
 
 func relayHandler(c *gin.Context, relayMode int) *dto.OpenAIErrorWithStatusCode {
 	var err *dto.OpenAIErrorWithStatusCode
 	switch relayMode {
-	case relayconstant.RelayModeImagesGenerations:
+	case relayconstant.RelayModeImagesGenerations, relayconstant.RelayModeImagesEdits, relayconstant.RelayModeImagesVariations:
 		err = relay.ImageHelper(c, relayMode)
 	case relayconstant.RelayModeAudioSpeech:
 		fallthrough
@@ -36,6 +39,12 @@ func relayHandler(c *gin.Context, relayMode int) *dto.OpenAIErrorWithStatusCode
 		err = relay.AudioHelper(c)
 	case relayconstant.RelayModeRerank:
 		err = relay.RerankHelper(c, relayMode)
+	case relayconstant.RelayModeClaudeMessages:
+		err = relay.ClaudeHelper(c)
+	case relayconstant.RelayModeGemini:
+		err = relay.GeminiHelper(c)
+	case relayconstant.RelayModeResponses:
+		err = relay.ResponsesHelper(c)
 	default:
 		err = relay.TextHelper(c)
 	}
@@ -52,29 +61,61 @@ func wsHandler(c *gin.Context, ws *websocket.Conn, relayMode int) *dto.OpenAIErr
 }
 
 func Relay(c *gin.Context) {
+	common.RelayInFlight.Add(1)
+	defer common.RelayInFlight.Add(-1)
 	relayMode := constant.Path2RelayMode(c.Request.URL.Path)
 	requestId := c.GetString(common.RequestIdKey)
 	group := c.GetString("group")
 	originalModel := c.GetString("original_model")
 	var openaiErr *dto.OpenAIErrorWithStatusCode
 
-	for i := 0; i <= common.RetryTimes; i++ {
-		channel, err := getChannel(c, group, originalModel, i)
-		if err != nil {
-			common.LogError(c, err.Error())
-			openaiErr = service.OpenAIErrorWrapperLocal(err, "get_channel_failed", http.StatusInternalServerError)
+	servedModel := originalModel
+	fallbackModels := getFallbackModels(c, relayMode, originalModel)
+	hedgeDelay, hedgeEnabled := getHedgeDelay(c, relayMode, group, originalModel)
+	for {
+		for i := 0; i <= common.RetryTimes; i++ {
+			channel, err := getChannel(c, group, servedModel, i)
+			if err != nil {
+				common.LogError(c, err.Error())
+				openaiErr = service.OpenAIErrorWrapperLocal(err, "get_channel_failed", http.StatusInternalServerError)
+				break
+			}
+
+			if i == 0 && servedModel == originalModel && hedgeEnabled {
+				channel, openaiErr = relayHedgedRequest(c, relayMode, channel, group, servedModel, hedgeDelay)
+			} else {
+				startTime := time.Now()
+				openaiErr = relayRequest(c, relayMode, channel)
+				recordChannelOutcome(c, channel.Id, openaiErr, time.Since(startTime))
+			}
+
+			if openaiErr == nil {
+				return // 成功处理请求，直接返回
+			}
+
+			go processChannelError(c, channel.Id, c.GetString("channel_key_hash"), channel.Type, channel.Name, channel.GetAutoBan(), servedModel, openaiErr)
+
+			if !shouldRetry(c, openaiErr, common.RetryTimes-i) {
+				break
+			}
+		}
+		if len(fallbackModels) == 0 || !shouldFallback(c, originalModel, openaiErr) {
 			break
 		}
-
-		openaiErr = relayRequest(c, relayMode, channel)
-
-		if openaiErr == nil {
-			return // 成功处理请求，直接返回
+		// move on to the first fallback model that has a channel
+		servedModel = ""
+		for servedModel == "" && len(fallbackModels) > 0 {
+			fallbackModel := fallbackModels[0]
+			fallbackModels = fallbackModels[1:]
+			err := switchFallbackModel(c, group, originalModel, fallbackModel)
+			if err != nil {
+				common.LogError(c, err.Error())
+				openaiErr = service.OpenAIErrorWrapperLocal(err, "get_channel_failed", http.StatusInternalServerError)
+				continue
+			}
+			servedModel = fallbackModel
 		}
-
-		go processChannelError(c, channel.Id, channel.Type, channel.Name, channel.GetAutoBan(), originalModel, openaiErr)
-
-		if !shouldRetry(c, openaiErr, common.RetryTimes-i) {
+		if servedModel == "" {
 			break
 		}
 	}
@@ -97,6 +138,28 @@ func Relay(c *gin.Context) {
 			openaiErr.Error.Message = "当前分组上游负载已饱和，请稍后再试"
 		}
 		openaiErr.Error.Message = common.MessageWithRequestId(openaiErr.Error.Message, requestId)
+		if relayMode == relayconstant.RelayModeClaudeMessages {
+			// anthropic sdk error envelope
+			c.JSON(openaiErr.StatusCode, gin.H{
+				"type": "error",
+				"error": gin.H{
+					"type":    openaiErr.Error.Type,
+					"message": openaiErr.Error.Message,
+				},
+			})
+			return
+		}
+		if relayMode == relayconstant.RelayModeGemini {
+			// google genai sdk error envelope
+			c.JSON(openaiErr.StatusCode, gin.H{
+				"error": gin.H{
+					"code":    openaiErr.StatusCode,
+					"message": openaiErr.Error.Message,
+					"status":  openaiErr.Error.Type,
+				},
+			})
+			return
+		}
 		c.JSON(openaiErr.StatusCode, gin.H{
 			"error": openaiErr.Error,
 		})
@@ -122,6 +185,8 @@ func WssRelay(c *gin.Context) {
 		return
 	}
 
+	common.RelayInFlight.Add(1)
+	defer common.RelayInFlight.Add(-1)
 	relayMode := constant.Path2RelayMode(c.Request.URL.Path)
 	requestId := c.GetString(common.RequestIdKey)
 	group := c.GetString("group")
@@ -138,12 +203,13 @@ func WssRelay(c *gin.Context) {
 		}
 
 		openaiErr = wssRequest(c, ws, relayMode, channel)
+		recordChannelOutcome(c, channel.Id, openaiErr, 0)
 
 		if openaiErr == nil {
 			return // 成功处理请求，直接返回
 		}
 
-		go processChannelError(c, channel.Id, channel.Type, channel.Name, channel.GetAutoBan(), originalModel, openaiErr)
+		go processChannelError(c, channel.Id, c.GetString("channel_key_hash"), channel.Type, channel.Name, channel.GetAutoBan(), originalModel, openaiErr)
 
 		if !shouldRetry(c, openaiErr, common.RetryTimes-i) {
 			break
@@ -164,26 +230,89 @@ func WssRelay(c *gin.Context) {
 	}
 }
 
-func relayRequest(c *gin.Context, relayMode int, channel *model.Channel) *dto.OpenAIErrorWithStatusCode {
+func relayRequest(c *gin.Context, relayMode int, channel *model.Channel) (openaiErr *dto.OpenAIErrorWithStatusCode) {
 	addUsedChannel(c, channel.Id)
+	span, endSpan := common.StartSpan(c, "relay attempt", attribute.Int("channel.id", channel.Id),
+		attribute.String("model", c.GetString("original_model")))
+	startTime := time.Now()
+	defer func() {
+		recordRelayMetrics(c, channel.Id, openaiErr, time.Since(startTime))
+		if openaiErr != nil {
+			span.SetStatus(codes.Error, openaiErr.Error.Message)
+		}
+		endSpan()
+	}()
+	release, openaiErr := service.AcquireChannelSlot(c, channel.Id)
+	if openaiErr != nil {
+		return openaiErr
+	}
+	defer release()
 	requestBody, _ := common.GetRequestBody(c)
 	c.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
 	return relayHandler(c, relayMode)
 }
 
-func wssRequest(c *gin.Context, ws *websocket.Conn, relayMode int, channel *model.Channel) *dto.OpenAIErrorWithStatusCode {
+func wssRequest(c *gin.Context, ws *websocket.Conn, relayMode int, channel *model.Channel) (openaiErr *dto.OpenAIErrorWithStatusCode) {
 	addUsedChannel(c, channel.Id)
+	span, endSpan := common.StartSpan(c, "relay attempt", attribute.Int("channel.id", channel.Id),
+		attribute.String("model", c.GetString("original_model")))
+	startTime := time.Now()
+	defer func() {
+		recordRelayMetrics(c, channel.Id, openaiErr, time.Since(startTime))
+		if openaiErr != nil {
+			span.SetStatus(codes.Error, openaiErr.Error.Message)
+		}
+		endSpan()
+	}()
+	release, openaiErr := service.AcquireChannelSlot(c, channel.Id)
+	if openaiErr != nil {
+		return openaiErr
+	}
+	defer release()
 	requestBody, _ := common.GetRequestBody(c)
 	c.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
 	return relay.WssHelper(c, ws)
 }
 
+// recordChannelOutcome feeds the channel health, latency is only meaningful
+// for successful non stream requests.
+func recordChannelOutcome(c *gin.Context, channelId int, openaiErr *dto.OpenAIErrorWithStatusCode, latency time.Duration) {
+	if openaiErr != nil || c.GetBool("stream") {
+		latency = 0
+	}
+	if openaiErr != nil && !service.IsChannelFailure(openaiErr) {
+		return
+	}
+	model.RecordChannelOutcome(channelId, openaiErr != nil, latency)
+}
+
+// recordRelayMetrics feeds the relay request metrics of one channel attempt.
+func recordRelayMetrics(c *gin.Context, channelId int, openaiErr *dto.OpenAIErrorWithStatusCode, duration time.Duration) {
+	statusCode := c.Writer.Status()
+	errorCode := ""
+	if openaiErr != nil {
+		statusCode = openaiErr.StatusCode
+		errorCode = openaiErr.Error.Type
+		if code, ok := openaiErr.Error.Code.(string); ok && code != "" {
+			errorCode = code
+		}
+	}
+	common.RecordRelayRequest(channelId, c.GetString("original_model"), c.GetString("group"), statusCode, errorCode, duration)
+}
+
 func addUsedChannel(c *gin.Context, channelId int) {
 	useChannel := c.GetStringSlice("use_channel")
 	useChannel = append(useChannel, fmt.Sprintf("%d", channelId))
 	c.Set("use_channel", useChannel)
 }
 
+func getChannelRouteKey(c *gin.Context) model.ChannelRouteKey {
+	return model.ChannelRouteKey{
+		UserId:  c.GetInt("id"),
+		TokenId: c.GetInt("token_id"),
+	}
+}
+
 func getChannel(c *gin.Context, group, originalModel string, retryCount int) (*model.Channel, error) {
 	if retryCount == 0 {
 		autoBan := c.GetBool("auto_ban")
@@ -198,14 +327,74 @@ func getChannel(c *gin.Context, group, originalModel string, retryCount int) (*m
 			AutoBan: &autoBanInt,
 		}, nil
 	}
-	channel, err := model.CacheGetRandomSatisfiedChannel(group, originalModel, retryCount)
+	span, endSpan := common.StartSpan(c, "channel selection", attribute.Int("retry", retryCount))
+	defer endSpan()
+	channel, err := model.CacheGetRandomSatisfiedChannel(group, originalModel, retryCount, getChannelRouteKey(c))
 	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
 		return nil, errors.New(fmt.Sprintf("获取重试渠道失败: %s", err.Error()))
 	}
+	span.SetAttributes(attribute.Int("channel.id", channel.Id))
 	middleware.SetupContextForSelectedChannel(c, channel, originalModel)
 	return channel, nil
 }
 
+// getFallbackModels returns the fallback chain of a model, only chat and
+// completions requests can be served by another model and the token model
+// limits still apply.
+func getFallbackModels(c *gin.Context, relayMode int, originalModel string) []string {
+	if relayMode != relayconstant.RelayModeChatCompletions && relayMode != relayconstant.RelayModeCompletions {
+		return nil
+	}
+	fallback, ok := setting.GetModelFallback(originalModel)
+	if !ok {
+		return nil
+	}
+	modelLimitEnable := c.GetBool("token_model_limit_enabled")
+	tokenModelLimit, _ := c.Value("token_model_limit").(map[string]bool)
+	fallbackModels := make([]string, 0, len(fallback.Models))
+	for _, fallbackModel := range fallback.Models {
+		if modelLimitEnable && !tokenModelLimit[fallbackModel] {
+			continue
+		}
+		fallbackModels = append(fallbackModels, fallbackModel)
+	}
+	return fallbackModels
+}
+
+// shouldFallback reports whether the next model of the chain should be tried,
+// either every channel of the current model failed or the error class is one
+// the chain falls back on right away.
+func shouldFallback(c *gin.Context, originalModel string, openaiErr *dto.OpenAIErrorWithStatusCode) bool {
+	if openaiErr == nil {
+		return false
+	}
+	if _, ok := c.Get("specific_channel_id"); ok {
+		return false
+	}
+	fallback, _ := setting.GetModelFallback(originalModel)
+	errorClass := service.GetFallbackErrorClass(openaiErr)
+	if errorClass != "" && slices.Contains(fallback.Errors, errorClass) {
+		return true
+	}
+	// retries exhausted, or no channel was left to retry on
+	return errorClass == setting.FallbackErrorUnavailable || shouldRetry(c, openaiErr, 1)
+}
+
+func switchFallbackModel(c *gin.Context, group string, originalModel string, fallbackModel string) error {
+	channel, err := model.CacheGetRandomSatisfiedChannel(group, fallbackModel, 0, getChannelRouteKey(c))
+	if err != nil {
+		return errors.New(fmt.Sprintf("获取降级模型 %s 的渠道失败: %s", fallbackModel, err.Error()))
+	}
+	middleware.SetupContextForSelectedChannel(c, channel, fallbackModel)
+	c.Set("fallback_from", originalModel)
+	if setting.ModelFallbackHeaderEnabled {
+		c.Header("X-Served-Model", fallbackModel)
+	}
+	common.LogInfo(c, fmt.Sprintf("模型降级：%s -> %s", originalModel, fallbackModel))
+	return nil
+}
+
 func shouldRetry(c *gin.Context, openaiErr *dto.OpenAIErrorWithStatusCode, retryTimes int) bool {
 	if openaiErr == nil {
 		return false
@@ -249,7 +438,7 @@ func shouldRetry(c *gin.Context, openaiErr *dto.OpenAIErrorWithStatusCode, retry
 	return true
 }
 
-func processChannelError(c *gin.Context, channelId int, channelType int, channelName string, autoBan bool, originalModel string, err *dto.OpenAIErrorWithStatusCode) {
+func processChannelError(c *gin.Context, channelId int, channelKeyHash string, channelType int, channelName string, autoBan bool, originalModel string, err *dto.OpenAIErrorWithStatusCode) {
 	// 不要使用context获取渠道信息，异步处理时可能会出现渠道信息不一致的情况
 	// do not use context to get channel info, there may be inconsistent channel info when processing asynchronously
 	startTime := c.GetTime(rawconstant.ContextKeyRequestStartTime)
@@ -258,6 +447,14 @@ func processChannelError(c *gin.Context, channelId int, channelType int, channel
 		channelId, err.StatusCode, originalModel, processingTime, err.Error.Message))
 
 	if service.ShouldDisableChannel(channelType, err) && autoBan {
+		if channelKeyHash != "" {
+			// multi key channel, only the failing key is taken out of the pool
+			channel, getErr := model.GetChannelById(channelId, true)
+			if getErr == nil {
+				service.DisableChannelKey(channel, channelKeyHash, err.Error.Message)
+				return
+			}
+		}
 		service.DisableChannel(channelId, channelName, err.Error.Message)
 	}
 }
@@ -278,7 +475,6 @@ func RelayMidjourney(c *gin.Context) {
 		err = relay.RelayMidjourneySubmit(c, relayMode)
 	}
 	//err = relayMidjourneySubmit(c, relayMode)
-	log.Println(err)
 	if err != nil {
 		statusCode := http.StatusBadRequest
 		if err.Code == 30 {
@@ -331,7 +527,7 @@ func RelayTask(c *gin.Context) {
 		retryTimes = 0
 	}
 	for i := 0; shouldRetryTaskRelay(c, channelId, taskErr, retryTimes) && i < retryTimes; i++ {
-		channel, err := model.CacheGetRandomSatisfiedChannel(group, originalModel, i)
+		channel, err := model.CacheGetRandomSatisfiedChannel(group, originalModel, i, getChannelRouteKey(c))
 		if err != nil {
 			common.LogError(c, fmt.Sprintf("CacheGetRandomSatisfiedChannel failed: %s", err.Error()))
 			break
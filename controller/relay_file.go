@@ -0,0 +1,69 @@
+package controller
+
+import (
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"one-api/common"
+	"one-api/dto"
+	"one-api/model"
+	"one-api/relay"
+	"strconv"
+)
+
+// RelayFile relays the files and batches endpoints. There is no retry here,
+// an existing file or batch is bound to the channel that created it.
+func RelayFile(c *gin.Context) {
+	openaiErr := relay.FileHelper(c)
+	if openaiErr != nil {
+		requestId := c.GetString(common.RequestIdKey)
+		openaiErr.Error.Message = common.MessageWithRequestId(openaiErr.Error.Message, requestId)
+		c.JSON(openaiErr.StatusCode, gin.H{
+			"error": openaiErr.Error,
+		})
+	}
+}
+
+func ListFiles(c *gin.Context) {
+	listRelayObjects(c, model.RelayObjectFile)
+}
+
+func ListBatches(c *gin.Context) {
+	listRelayObjects(c, model.RelayObjectBatch)
+}
+
+// listRelayObjects serves the list endpoints from the recorded objects, the
+// upstream lists would mix in objects of other users of the same channel.
+func listRelayObjects(c *gin.Context, object string) {
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+	relayObjects, err := model.GetUserRelayObjects(c.GetInt("id"), object, c.Query("after"), limit+1)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": dto.OpenAIError{
+				Message: err.Error(),
+				Type:    "new_api_error",
+				Code:    "list_objects_failed",
+			},
+		})
+		return
+	}
+	response := dto.OpenAIListResponse{
+		Object: "list",
+		Data:   make([]json.RawMessage, 0, len(relayObjects)),
+	}
+	if len(relayObjects) > limit {
+		relayObjects = relayObjects[:limit]
+		response.HasMore = true
+	}
+	for _, relayObject := range relayObjects {
+		response.Data = append(response.Data, relayObject.Data)
+	}
+	if len(relayObjects) > 0 {
+		response.FirstId = relayObjects[0].ObjectId
+		response.LastId = relayObjects[len(relayObjects)-1].ObjectId
+	}
+	c.JSON(http.StatusOK, response)
+}
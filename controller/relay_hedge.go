@@ -0,0 +1,276 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"one-api/dto"
+	"one-api/middleware"
+	"one-api/model"
+	relayconstant "one-api/relay/constant"
+	"one-api/setting"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var errHedgeLost = errors.New("hedged request lost")
+
+// hedgeRequest runs the same request on up to two channels, the attempt that
+// writes the first byte of the response wins and the other one is cancelled.
+type hedgeRequest struct {
+	mu       sync.Mutex
+	winner   *hedgeAttempt
+	attempts []*hedgeAttempt
+}
+
+type hedgeAttempt struct {
+	c         *gin.Context
+	channel   *model.Channel
+	cancel    context.CancelFunc
+	done      chan struct{}
+	openaiErr *dto.OpenAIErrorWithStatusCode
+	latency   time.Duration
+}
+
+// hedgeWriter holds back the headers of an attempt until it wins, the body
+// of a lost attempt is dropped.
+type hedgeWriter struct {
+	gin.ResponseWriter
+	hedge   *hedgeRequest
+	attempt *hedgeAttempt
+	header  http.Header
+	status  int
+}
+
+func (w *hedgeWriter) won() bool {
+	w.hedge.mu.Lock()
+	defer w.hedge.mu.Unlock()
+	return w.hedge.winner == w.attempt
+}
+
+// claim makes the attempt the winner if no other attempt answered yet.
+func (w *hedgeWriter) claim() bool {
+	h := w.hedge
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.winner == nil {
+		h.winner = w.attempt
+		header := w.ResponseWriter.Header()
+		for k, v := range w.header {
+			header[k] = v
+		}
+		w.ResponseWriter.WriteHeader(w.status)
+		for _, attempt := range h.attempts {
+			if attempt != w.attempt {
+				attempt.c.Set("hedge_lost", true)
+				attempt.cancel()
+			}
+		}
+	}
+	return h.winner == w.attempt
+}
+
+func (w *hedgeWriter) Header() http.Header {
+	if w.won() {
+		return w.ResponseWriter.Header()
+	}
+	return w.header
+}
+
+func (w *hedgeWriter) WriteHeader(code int) {
+	if w.won() {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if code > 0 {
+		w.status = code
+	}
+}
+
+func (w *hedgeWriter) WriteHeaderNow() {
+	if w.won() {
+		w.ResponseWriter.WriteHeaderNow()
+	}
+}
+
+func (w *hedgeWriter) Write(data []byte) (int, error) {
+	if !w.claim() {
+		return 0, errHedgeLost
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *hedgeWriter) WriteString(s string) (int, error) {
+	if !w.claim() {
+		return 0, errHedgeLost
+	}
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *hedgeWriter) Flush() {
+	if w.won() {
+		w.ResponseWriter.Flush()
+	}
+}
+
+func (w *hedgeWriter) Status() int {
+	if w.won() {
+		return w.ResponseWriter.Status()
+	}
+	return w.status
+}
+
+func (w *hedgeWriter) Size() int {
+	if w.won() {
+		return w.ResponseWriter.Size()
+	}
+	return -1
+}
+
+func (w *hedgeWriter) Written() bool {
+	if w.won() {
+		return w.ResponseWriter.Written()
+	}
+	return false
+}
+
+// newAttempt copies the context for an attempt, it gets its own request so
+// the channel key header and the cancellation do not leak into the other one.
+func (h *hedgeRequest) newAttempt(c *gin.Context) *hedgeAttempt {
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	attempt := &hedgeAttempt{
+		c:      c.Copy(),
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	attempt.c.Request = c.Request.Clone(ctx)
+	attempt.c.Writer = &hedgeWriter{
+		ResponseWriter: c.Writer,
+		hedge:          h,
+		attempt:        attempt,
+		header:         http.Header{},
+		status:         http.StatusOK,
+	}
+	attempt.c.Set("hedged", true)
+	attempt.c.Set("use_channel", []string{})
+	return attempt
+}
+
+// run starts the attempt, it is not started when another attempt already
+// answered.
+func (h *hedgeRequest) run(attempt *hedgeAttempt, relayMode int, channel *model.Channel) bool {
+	attempt.channel = channel
+	h.mu.Lock()
+	if h.winner != nil {
+		h.mu.Unlock()
+		attempt.cancel()
+		return false
+	}
+	h.attempts = append(h.attempts, attempt)
+	h.mu.Unlock()
+	go func() {
+		defer close(attempt.done)
+		defer attempt.cancel()
+		startTime := time.Now()
+		attempt.openaiErr = relayRequest(attempt.c, relayMode, channel)
+		attempt.latency = time.Since(startTime)
+	}()
+	return true
+}
+
+// getHedgeDelay returns the hedge delay of the request, only chat and
+// completions requests that are not pinned to a channel are hedged.
+func getHedgeDelay(c *gin.Context, relayMode int, group string, modelName string) (time.Duration, bool) {
+	if relayMode != relayconstant.RelayModeChatCompletions && relayMode != relayconstant.RelayModeCompletions {
+		return 0, false
+	}
+	if _, ok := c.Get("specific_channel_id"); ok {
+		return 0, false
+	}
+	return setting.GetHedgeRequestDelay(group, modelName)
+}
+
+// getHedgeChannel picks a second channel for the model, another channel of
+// the same priority is preferred over the next priority.
+func getHedgeChannel(c *gin.Context, group string, modelName string, channelId int) *model.Channel {
+	for retry := 0; retry <= 1; retry++ {
+		channel, err := model.CacheGetRandomSatisfiedChannel(group, modelName, retry, getChannelRouteKey(c))
+		if err == nil && channel.Id != channelId {
+			return channel
+		}
+	}
+	return nil
+}
+
+// relayHedgedRequest relays the request on channel and, when no response
+// started within delay, on a second channel as well. It returns the channel
+// and error of the attempt that answered, or of the first attempt when both
+// failed, and leaves the context of that attempt on c for the retry loop.
+func relayHedgedRequest(c *gin.Context, relayMode int, channel *model.Channel, group string, modelName string,
+	delay time.Duration) (*model.Channel, *dto.OpenAIErrorWithStatusCode) {
+	hedge := &hedgeRequest{}
+	first := hedge.newAttempt(c)
+	hedge.run(first, relayMode, channel)
+	addUsedChannel(c, channel.Id)
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-first.done:
+	case <-timer.C:
+		hedgeChannel := getHedgeChannel(c, group, modelName, channel.Id)
+		if hedgeChannel == nil {
+			break
+		}
+		second := hedge.newAttempt(c)
+		middleware.SetupContextForSelectedChannel(second.c, hedgeChannel, modelName)
+		if hedge.run(second, relayMode, hedgeChannel) {
+			addUsedChannel(c, hedgeChannel.Id)
+			common.LogInfo(c, fmt.Sprintf("对冲请求：渠道 #%d %dms 内未响应，同时请求渠道 #%d", channel.Id, delay.Milliseconds(), hedgeChannel.Id))
+		}
+	}
+
+	hedge.mu.Lock()
+	attempts := hedge.attempts
+	hedge.mu.Unlock()
+	for _, attempt := range attempts {
+		<-attempt.done
+	}
+	winner := hedge.winner
+
+	result := first
+	for _, attempt := range attempts {
+		if attempt == winner {
+			result = attempt
+			break
+		}
+		if attempt.openaiErr == nil && result.openaiErr != nil {
+			result = attempt
+		}
+	}
+	for _, attempt := range attempts {
+		if attempt.c.GetBool("hedge_lost") {
+			continue
+		}
+		recordChannelOutcome(attempt.c, attempt.channel.Id, attempt.openaiErr, attempt.latency)
+		if attempt != result && attempt.openaiErr != nil {
+			go processChannelError(attempt.c, attempt.channel.Id, attempt.c.GetString("channel_key_hash"), attempt.channel.Type,
+				attempt.channel.Name, attempt.channel.GetAutoBan(), modelName, attempt.openaiErr)
+		}
+	}
+	if len(attempts) > 1 && winner != nil {
+		common.LogInfo(c, fmt.Sprintf("对冲请求：渠道 #%d 先响应", winner.channel.Id))
+	}
+
+	for k, v := range result.c.Keys {
+		if k == "use_channel" || k == "hedged" || k == "hedge_lost" {
+			continue
+		}
+		c.Set(k, v)
+	}
+	return result.channel, result.openaiErr
+}
@@ -0,0 +1,112 @@
+package controller
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"one-api/service"
+	"strconv"
+)
+
+func writeStatement(c *gin.Context, statement *service.Statement) {
+	format := c.DefaultQuery("format", "json")
+	filename := fmt.Sprintf("statement-%d-%s", statement.UserId, statement.Month)
+	var buf bytes.Buffer
+	var err error
+	var contentType string
+	switch format {
+	case "json":
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"message": "",
+			"data":    statement,
+		})
+		return
+	case "csv":
+		contentType = "text/csv; charset=utf-8"
+		err = service.WriteStatementCSV(&buf, statement)
+	case "pdf":
+		contentType = "application/pdf"
+		err = service.WriteStatementPDF(&buf, statement)
+	default:
+		err = fmt.Errorf("不支持的格式 %s", format)
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s.%s", filename, format))
+	c.Data(http.StatusOK, contentType, buf.Bytes())
+}
+
+// GetSelfStatement returns the statement of the month query parameter, the
+// format parameter picks json (default), csv or pdf.
+func GetSelfStatement(c *gin.Context) {
+	statement, err := service.BuildStatement(c.GetInt("id"), c.Query("month"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	writeStatement(c, statement)
+}
+
+func GetUserStatement(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	statement, err := service.BuildStatement(id, c.Query("month"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	writeStatement(c, statement)
+}
+
+// ExportStatements returns the statement summaries of all users for a month
+// as csv (default) or json.
+func ExportStatements(c *gin.Context) {
+	statements, err := service.BuildStatements(0, c.Query("month"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	format := c.DefaultQuery("format", "csv")
+	if format == "json" {
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"message": "",
+			"data":    statements,
+		})
+		return
+	}
+	if format != "csv" {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": fmt.Sprintf("不支持的格式 %s", format),
+		})
+		return
+	}
+	var buf bytes.Buffer
+	err = service.WriteStatementsCSV(&buf, statements)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	start, _, _ := service.ParseStatementMonth(c.Query("month"))
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=statements-%s.csv", start.Format(service.StatementMonthLayout)))
+	c.Data(http.StatusOK, "text/csv; charset=utf-8", buf.Bytes())
+}
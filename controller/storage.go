@@ -0,0 +1,11 @@
+package controller
+
+import (
+	"github.com/gin-gonic/gin"
+	"one-api/service"
+	"strings"
+)
+
+func GetStoredFile(c *gin.Context) {
+	service.ServeStoredFile(c, strings.TrimPrefix(c.Param("path"), "/"))
+}
@@ -0,0 +1,200 @@
+package controller
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"one-api/common"
+	"one-api/model"
+	"one-api/service"
+	"strconv"
+)
+
+type PlanPayRequest struct {
+	PlanId        int    `json:"plan_id"`
+	Periods       int    `json:"periods"`
+	Provider      string `json:"provider"`
+	PaymentMethod string `json:"payment_method"`
+}
+
+type AddSubscriptionRequest struct {
+	UserId  int `json:"user_id"`
+	PlanId  int `json:"plan_id"`
+	Periods int `json:"periods"`
+}
+
+// maxPlanPeriods bounds how many periods are bought at once.
+const maxPlanPeriods = 120
+
+func GetSelfSubscription(c *gin.Context) {
+	subscription, err := model.GetUserActiveSubscription(c.GetInt("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	data := gin.H{"subscription": subscription}
+	if subscription != nil {
+		plan, err := model.GetPlanById(subscription.PlanId)
+		if err == nil {
+			data["plan"] = plan
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    data,
+	})
+}
+
+// RequestPlanPay starts the payment of periods periods of a plan, the
+// subscription starts or renews once the payment succeeds.
+func RequestPlanPay(c *gin.Context) {
+	var req PlanPayRequest
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		c.JSON(200, gin.H{"message": "error", "data": "参数错误"})
+		return
+	}
+	if req.Periods == 0 {
+		req.Periods = 1
+	}
+	if req.Periods < 0 || req.Periods > maxPlanPeriods {
+		c.JSON(200, gin.H{"message": "error", "data": fmt.Sprintf("订阅周期数必须在 1-%d 之间", maxPlanPeriods)})
+		return
+	}
+	provider := service.GetPaymentProvider(req.Provider)
+	if provider == nil || !provider.Enabled() {
+		c.JSON(200, gin.H{"message": "error", "data": "当前管理员未配置支付信息"})
+		return
+	}
+	plan, err := model.GetPlanById(req.PlanId)
+	if err != nil || plan.Status != model.PlanStatusEnabled {
+		c.JSON(200, gin.H{"message": "error", "data": "套餐不存在或已停用"})
+		return
+	}
+	id := c.GetInt("id")
+	subscription, err := model.GetUserActiveSubscription(id)
+	if err != nil {
+		c.JSON(200, gin.H{"message": "error", "data": "获取订阅失败"})
+		return
+	}
+	if subscription != nil && subscription.PlanId != plan.Id {
+		c.JSON(200, gin.H{"message": "error", "data": "已有其他生效中的套餐，请先取消"})
+		return
+	}
+	payMoney := plan.Price * float64(req.Periods) * provider.Price()
+	if payMoney < 0.01 {
+		c.JSON(200, gin.H{"message": "error", "data": "套餐价格过低"})
+		return
+	}
+	topUp := &model.TopUp{
+		UserId:      id,
+		Money:       payMoney,
+		TradeNo:     newTradeNo(id),
+		PlanId:      plan.Id,
+		PlanPeriods: req.Periods,
+	}
+	createPaymentOrder(c, provider, topUp, req.PaymentMethod, fmt.Sprintf("PLAN%dx%d", plan.Id, req.Periods))
+}
+
+func CancelSelfSubscription(c *gin.Context) {
+	subscription, err := model.GetUserActiveSubscription(c.GetInt("id"))
+	if err == nil && subscription == nil {
+		err = fmt.Errorf("没有生效中的订阅")
+	}
+	if err == nil {
+		subscription, err = model.CancelSubscription(subscription.Id)
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	model.RecordLog(subscription.UserId, model.LogTypeManage, fmt.Sprintf("取消套餐订阅 %d", subscription.Id))
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    subscription,
+	})
+}
+
+func GetAllSubscriptions(c *gin.Context) {
+	p, _ := strconv.Atoi(c.Query("p"))
+	pageSize, _ := strconv.Atoi(c.Query("page_size"))
+	if p < 1 {
+		p = 1
+	}
+	if pageSize < 1 {
+		pageSize = common.ItemsPerPage
+	}
+	userId, _ := strconv.Atoi(c.Query("user_id"))
+	subscriptions, total, err := model.GetAllSubscriptions(userId, c.Query("status"), (p-1)*pageSize, pageSize)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"items":     subscriptions,
+			"total":     total,
+			"page":      p,
+			"page_size": pageSize,
+		},
+	})
+}
+
+// AddSubscription lets an admin start or renew a subscription without payment.
+func AddSubscription(c *gin.Context) {
+	var req AddSubscriptionRequest
+	err := c.ShouldBindJSON(&req)
+	if err == nil && (req.Periods <= 0 || req.Periods > maxPlanPeriods) {
+		err = fmt.Errorf("订阅周期数必须在 1-%d 之间", maxPlanPeriods)
+	}
+	if err == nil {
+		_, err = model.GetUserById(req.UserId, false)
+	}
+	if err == nil {
+		err = service.StartPlanSubscription(c, req.UserId, req.PlanId, req.Periods, fmt.Sprintf("管理员 %d 开通", c.GetInt("id")))
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	subscription, _ := model.GetUserActiveSubscription(req.UserId)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    subscription,
+	})
+}
+
+func CancelSubscription(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	subscription, err := model.CancelSubscription(id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	model.RecordLog(subscription.UserId, model.LogTypeManage, fmt.Sprintf("管理员取消套餐订阅 %d", subscription.Id))
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    subscription,
+	})
+}
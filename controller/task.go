@@ -14,6 +14,7 @@ import (
 	"one-api/dto"
 	"one-api/model"
 	"one-api/relay"
+	"one-api/service"
 	"sort"
 	"strconv"
 	"time"
@@ -74,6 +75,8 @@ func UpdateTaskByPlatform(platform constant.TaskPlatform, taskChannelM map[int][
 		//_ = UpdateMidjourneyTaskAll(context.Background(), tasks)
 	case constant.TaskPlatformSuno:
 		_ = UpdateSunoTaskAll(context.Background(), taskChannelM, taskM)
+	case constant.TaskPlatformBatch:
+		_ = UpdateBatchTaskAll(context.Background(), taskChannelM, taskM)
 	default:
 		common.SysLog("未知平台")
 	}
@@ -83,7 +86,7 @@ func UpdateSunoTaskAll(ctx context.Context, taskChannelM map[int][]string, taskM
 	for channelId, taskIds := range taskChannelM {
 		err := updateSunoTaskAll(ctx, channelId, taskIds, taskM)
 		if err != nil {
-			common.LogError(ctx, fmt.Sprintf("渠道 #%d 更新异步任务失败: %d", channelId, err.Error()))
+			common.LogError(ctx, fmt.Sprintf("渠道 #%d 更新异步任务失败: %s", channelId, err.Error()))
 		}
 	}
 	return nil
@@ -111,7 +114,8 @@ func updateSunoTaskAll(ctx context.Context, channelId int, taskIds []string, tas
 	if adaptor == nil {
 		return errors.New("adaptor not found")
 	}
-	resp, err := adaptor.FetchTask(*channel.BaseURL, channel.Key, map[string]any{
+	key, _ := channel.SelectKey()
+	resp, err := adaptor.FetchTask(*channel.BaseURL, key, map[string]any{
 		"ids": taskIds,
 	})
 	if err != nil {
@@ -135,7 +139,7 @@ func updateSunoTaskAll(ctx context.Context, channelId int, taskIds []string, tas
 		return err
 	}
 	if !responseItems.IsSuccess() {
-		common.SysLog(fmt.Sprintf("渠道 #%d 未完成的任务有: %d, 成功获取到任务数: %d", channelId, len(taskIds), string(responseBody)))
+		common.SysLog(fmt.Sprintf("渠道 #%d 未完成的任务有: %d, 成功获取到任务数: %s", channelId, len(taskIds), string(responseBody)))
 		return err
 	}
 
@@ -159,7 +163,7 @@ func updateSunoTaskAll(ctx context.Context, channelId int, taskIds []string, tas
 			} else {
 				quota := task.Quota
 				if quota != 0 {
-					err = model.IncreaseUserQuota(task.UserId, quota)
+					err = model.RefundTaskQuota(task.UserId, quota, task.PlanQuota, model.QuotaRef{Source: model.LedgerSourceRelay, Reference: task.TaskID})
 					if err != nil {
 						common.LogError(ctx, "fail to increase user quota: "+err.Error())
 					}
@@ -170,6 +174,7 @@ func updateSunoTaskAll(ctx context.Context, channelId int, taskIds []string, tas
 		}
 		if responseItem.Status == model.TaskStatusSuccess {
 			task.Progress = "100%"
+			responseItem.Data = service.StoreJsonUrls(ctx, responseItem.Data, "suno")
 		}
 		task.Data = responseItem.Data
 
@@ -181,6 +186,87 @@ func updateSunoTaskAll(ctx context.Context, channelId int, taskIds []string, tas
 	return nil
 }
 
+func UpdateBatchTaskAll(ctx context.Context, taskChannelM map[int][]string, taskM map[string]*model.Task) error {
+	for channelId, taskIds := range taskChannelM {
+		err := updateBatchTaskAll(ctx, channelId, taskIds, taskM)
+		if err != nil {
+			common.LogError(ctx, fmt.Sprintf("渠道 #%d 更新批量任务失败: %s", channelId, err.Error()))
+		}
+	}
+	return nil
+}
+
+func updateBatchTaskAll(ctx context.Context, channelId int, taskIds []string, taskM map[string]*model.Task) error {
+	common.LogInfo(ctx, fmt.Sprintf("渠道 #%d 未完成的批量任务有: %d", channelId, len(taskIds)))
+	channel, err := model.CacheGetChannel(channelId)
+	if err != nil {
+		common.SysLog(fmt.Sprintf("CacheGetChannel: %v", err))
+		err = model.TaskBulkUpdate(taskIds, map[string]any{
+			"fail_reason": fmt.Sprintf("获取渠道信息失败，请联系管理员，渠道ID：%d", channelId),
+			"status":      "FAILURE",
+			"progress":    "100%",
+		})
+		if err != nil {
+			common.SysError(fmt.Sprintf("UpdateBatchTask error: %v", err))
+		}
+		return err
+	}
+	for _, taskId := range taskIds {
+		task := taskM[taskId]
+		batch, responseBody, err := relay.FetchBatch(channel, taskId)
+		if err != nil {
+			common.LogError(ctx, fmt.Sprintf("fetch batch %s error: %v", taskId, err))
+			continue
+		}
+		finished := true
+		switch batch.Status {
+		case "validating":
+			task.Status = model.TaskStatusQueued
+			finished = false
+		case "in_progress", "finalizing", "cancelling":
+			task.Status = model.TaskStatusInProgress
+			finished = false
+		case "completed":
+			task.Status = model.TaskStatusSuccess
+		case "failed", "expired", "cancelled":
+			task.Status = model.TaskStatusFailure
+			task.FailReason = "batch " + batch.Status
+		default:
+			continue
+		}
+		task.StartTime = lo.If(batch.InProgressAt != 0, batch.InProgressAt).Else(task.StartTime)
+		if total := batch.RequestCounts.Total; total > 0 {
+			// keep below 100% until the batch is billed, the loop skips finished tasks
+			progress := (batch.RequestCounts.Completed + batch.RequestCounts.Failed) * 100 / total
+			task.Progress = fmt.Sprintf("%d%%", min(progress, 99))
+		}
+		if finished {
+			// expired and cancelled batches may still have partial output to bill
+			quota, err := relay.SettleBatch(ctx, channel, task, batch)
+			task.Quota += quota
+			if err != nil {
+				common.LogError(ctx, fmt.Sprintf("settle batch %s error: %v", taskId, err))
+			}
+			// retry on the next round only if nothing has been billed yet
+			if err == nil || quota > 0 {
+				task.FinishTime = common.GetTimestamp()
+				task.Progress = "100%"
+			}
+		}
+		task.Data = responseBody
+		err = task.Update()
+		if err != nil {
+			common.SysError("UpdateBatchTask task error: " + err.Error())
+		}
+		relayObject, exist, err := model.GetRelayObject(taskId)
+		if err == nil && exist {
+			relayObject.Data = responseBody
+			_ = relayObject.Update()
+		}
+	}
+	return nil
+}
+
 func checkTaskNeedUpdate(oldTask *model.Task, newTask dto.SunoDataResponse) bool {
 
 	if oldTask.SubmitTime != newTask.SubmitTime {
@@ -145,6 +145,10 @@ func AddToken(c *gin.Context) {
 		ModelLimits:        token.ModelLimits,
 		AllowIps:           token.AllowIps,
 		Group:              token.Group,
+		ResponseCache:      token.ResponseCache,
+		ResponseCacheTTL:   token.ResponseCacheTTL,
+		RateLimitRPM:       token.RateLimitRPM,
+		RateLimitTPM:       token.RateLimitTPM,
 	}
 	err = cleanToken.Insert()
 	if err != nil {
@@ -234,6 +238,10 @@ func UpdateToken(c *gin.Context) {
 		cleanToken.ModelLimits = token.ModelLimits
 		cleanToken.AllowIps = token.AllowIps
 		cleanToken.Group = token.Group
+		cleanToken.ResponseCache = token.ResponseCache
+		cleanToken.ResponseCacheTTL = token.ResponseCacheTTL
+		cleanToken.RateLimitRPM = token.RateLimitRPM
+		cleanToken.RateLimitTPM = token.RateLimitTPM
 	}
 	err = cleanToken.Update()
 	if err != nil {
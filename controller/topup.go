@@ -2,17 +2,13 @@ package controller
 
 import (
 	"fmt"
-	"github.com/Calcium-Ion/go-epay/epay"
 	"github.com/gin-gonic/gin"
-	"github.com/samber/lo"
-	"log"
-	"net/url"
+	"net/http"
 	"one-api/common"
 	"one-api/model"
 	"one-api/service"
 	"one-api/setting"
 	"strconv"
-	"sync"
 	"time"
 )
 
@@ -27,21 +23,15 @@ type AmountRequest struct {
 	TopUpCode string `json:"top_up_code"`
 }
 
-func GetEpayClient() *epay.Client {
-	if setting.PayAddress == "" || setting.EpayId == "" || setting.EpayKey == "" {
-		return nil
-	}
-	withUrl, err := epay.NewClient(&epay.Config{
-		PartnerID: setting.EpayId,
-		Key:       setting.EpayKey,
-	}, setting.PayAddress)
-	if err != nil {
-		return nil
-	}
-	return withUrl
+type RefundTopUpRequest struct {
+	Money float64 `json:"money"`
 }
 
 func getPayMoney(amount float64, group string) float64 {
+	return getPayMoneyWithPrice(amount, group, setting.Price)
+}
+
+func getPayMoneyWithPrice(amount float64, group string, price float64) float64 {
 	if !common.DisplayInCurrencyEnabled {
 		amount = amount / common.QuotaPerUnit
 	}
@@ -50,7 +40,7 @@ func getPayMoney(amount float64, group string) float64 {
 	if topupGroupRatio == 0 {
 		topupGroupRatio = 1
 	}
-	payMoney := amount * setting.Price * topupGroupRatio
+	payMoney := amount * price * topupGroupRatio
 	return payMoney
 }
 
@@ -62,13 +52,35 @@ func getMinTopup() int {
 	return minTopup
 }
 
+func newTradeNo(userId int) string {
+	tradeNo := fmt.Sprintf("%s%d", common.GetRandomString(6), time.Now().Unix())
+	return fmt.Sprintf("USR%dNO%s", userId, tradeNo)
+}
+
 func RequestEpay(c *gin.Context) {
+	requestPayment(c, model.PaymentMethodEpay)
+}
+
+func RequestStripePay(c *gin.Context) {
+	requestPayment(c, model.PaymentMethodStripe)
+}
+
+func RequestPayment(c *gin.Context) {
+	requestPayment(c, c.Param("provider"))
+}
+
+func requestPayment(c *gin.Context, providerName string) {
 	var req EpayRequest
 	err := c.ShouldBindJSON(&req)
 	if err != nil {
 		c.JSON(200, gin.H{"message": "error", "data": "参数错误"})
 		return
 	}
+	provider := service.GetPaymentProvider(providerName)
+	if provider == nil || !provider.Enabled() {
+		c.JSON(200, gin.H{"message": "error", "data": "当前管理员未配置支付信息"})
+		return
+	}
 	if req.Amount < getMinTopup() {
 		c.JSON(200, gin.H{"message": "error", "data": fmt.Sprintf("充值数量不能小于 %d", getMinTopup())})
 		return
@@ -80,147 +92,83 @@ func RequestEpay(c *gin.Context) {
 		c.JSON(200, gin.H{"message": "error", "data": "获取用户分组失败"})
 		return
 	}
-	payMoney := getPayMoney(float64(req.Amount), group)
+	payMoney := getPayMoneyWithPrice(float64(req.Amount), group, provider.Price())
 	if payMoney < 0.01 {
 		c.JSON(200, gin.H{"message": "error", "data": "充值金额过低"})
 		return
 	}
-	payType := "wxpay"
-	if req.PaymentMethod == "zfb" {
-		payType = "alipay"
-	}
-	if req.PaymentMethod == "wx" {
-		req.PaymentMethod = "wxpay"
-		payType = "wxpay"
+	tradeNo := newTradeNo(id)
+	amount := req.Amount
+	if !common.DisplayInCurrencyEnabled {
+		amount = amount / int(common.QuotaPerUnit)
 	}
-	callBackAddress := service.GetCallbackAddress()
-	returnUrl, _ := url.Parse(setting.ServerAddress + "/log")
-	notifyUrl, _ := url.Parse(callBackAddress + "/api/user/epay/notify")
-	tradeNo := fmt.Sprintf("%s%d", common.GetRandomString(6), time.Now().Unix())
-	tradeNo = fmt.Sprintf("USR%dNO%s", id, tradeNo)
-	client := GetEpayClient()
-	if client == nil {
-		c.JSON(200, gin.H{"message": "error", "data": "当前管理员未配置支付信息"})
-		return
+	topUp := &model.TopUp{
+		UserId:  id,
+		Amount:  amount,
+		Money:   payMoney,
+		TradeNo: tradeNo,
 	}
-	uri, params, err := client.Purchase(&epay.PurchaseArgs{
-		Type:           payType,
-		ServiceTradeNo: tradeNo,
-		Name:           fmt.Sprintf("TUC%d", req.Amount),
-		Money:          strconv.FormatFloat(payMoney, 'f', 2, 64),
-		Device:         epay.PC,
-		NotifyUrl:      notifyUrl,
-		ReturnUrl:      returnUrl,
+	createPaymentOrder(c, provider, topUp, req.PaymentMethod, fmt.Sprintf("TUC%d", req.Amount))
+}
+
+// createPaymentOrder starts the payment of topUp with the provider and
+// inserts the order once the provider accepted it.
+func createPaymentOrder(c *gin.Context, provider service.PaymentProvider, topUp *model.TopUp, method string, name string) {
+	topUp.CreateTime = time.Now().Unix()
+	topUp.Status = model.TopUpStatusPending
+	topUp.PaymentMethod = provider.Name()
+	order, err := provider.CreateOrder(topUp, &service.PaymentOrderArgs{
+		Method:    method,
+		Name:      name,
+		NotifyUrl: service.GetCallbackAddress() + "/api/user/payment/" + provider.Name() + "/notify",
+		ReturnUrl: setting.ServerAddress + "/log",
 	})
 	if err != nil {
+		common.LogError(c, fmt.Sprintf("%s 拉起支付失败: %s", provider.Name(), err.Error()))
 		c.JSON(200, gin.H{"message": "error", "data": "拉起支付失败"})
 		return
 	}
-	amount := req.Amount
-	if !common.DisplayInCurrencyEnabled {
-		amount = amount / int(common.QuotaPerUnit)
-	}
-	topUp := &model.TopUp{
-		UserId:     id,
-		Amount:     amount,
-		Money:      payMoney,
-		TradeNo:    tradeNo,
-		CreateTime: time.Now().Unix(),
-		Status:     "pending",
-	}
 	err = topUp.Insert()
 	if err != nil {
 		c.JSON(200, gin.H{"message": "error", "data": "创建订单失败"})
 		return
 	}
-	c.JSON(200, gin.H{"message": "success", "data": params, "url": uri})
+	c.JSON(200, gin.H{"message": "success", "data": order.Params, "url": order.Url})
 }
 
-// tradeNo lock
-var orderLocks sync.Map
-var createLock sync.Mutex
+func EpayNotify(c *gin.Context) {
+	paymentNotify(c, model.PaymentMethodEpay)
+}
 
-// LockOrder 尝试对给定订单号加锁
-func LockOrder(tradeNo string) {
-	lock, ok := orderLocks.Load(tradeNo)
-	if !ok {
-		createLock.Lock()
-		defer createLock.Unlock()
-		lock, ok = orderLocks.Load(tradeNo)
-		if !ok {
-			lock = new(sync.Mutex)
-			orderLocks.Store(tradeNo, lock)
-		}
-	}
-	lock.(*sync.Mutex).Lock()
+func StripeWebhook(c *gin.Context) {
+	paymentNotify(c, model.PaymentMethodStripe)
 }
 
-// UnlockOrder 释放给定订单号的锁
-func UnlockOrder(tradeNo string) {
-	lock, ok := orderLocks.Load(tradeNo)
-	if ok {
-		lock.(*sync.Mutex).Unlock()
-	}
+func PaymentNotify(c *gin.Context) {
+	paymentNotify(c, c.Param("provider"))
 }
 
-func EpayNotify(c *gin.Context) {
-	params := lo.Reduce(lo.Keys(c.Request.URL.Query()), func(r map[string]string, t string, i int) map[string]string {
-		r[t] = c.Request.URL.Query().Get(t)
-		return r
-	}, map[string]string{})
-	client := GetEpayClient()
-	if client == nil {
-		log.Println("易支付回调失败 未找到配置信息")
-		_, err := c.Writer.Write([]byte("fail"))
-		if err != nil {
-			log.Println("易支付回调写入失败")
-			return
-		}
+func paymentNotify(c *gin.Context, providerName string) {
+	provider := service.GetPaymentProvider(providerName)
+	if provider == nil || !provider.Enabled() {
+		common.LogError(c, fmt.Sprintf("支付回调失败 未找到配置信息: %s", providerName))
+		c.String(http.StatusNotFound, "fail")
+		return
 	}
-	verifyInfo, err := client.Verify(params)
-	if err == nil && verifyInfo.VerifyStatus {
-		_, err := c.Writer.Write([]byte("success"))
-		if err != nil {
-			log.Println("易支付回调写入失败")
-		}
-	} else {
-		_, err := c.Writer.Write([]byte("fail"))
-		if err != nil {
-			log.Println("易支付回调写入失败")
-		}
-		log.Println("易支付回调签名验证失败")
+	notify, err := provider.VerifyNotify(c)
+	if err != nil {
+		common.LogError(c, err.Error())
+		provider.NotifyResponse(c, err)
 		return
 	}
-
-	if verifyInfo.TradeStatus == epay.StatusTradeSuccess {
-		log.Println(verifyInfo)
-		LockOrder(verifyInfo.ServiceTradeNo)
-		defer UnlockOrder(verifyInfo.ServiceTradeNo)
-		topUp := model.GetTopUpByTradeNo(verifyInfo.ServiceTradeNo)
-		if topUp == nil {
-			log.Printf("易支付回调未找到订单: %v", verifyInfo)
-			return
-		}
-		if topUp.Status == "pending" {
-			topUp.Status = "success"
-			err := topUp.Update()
-			if err != nil {
-				log.Printf("易支付回调更新订单失败: %v", topUp)
-				return
-			}
-			//user, _ := model.GetUserById(topUp.UserId, false)
-			//user.Quota += topUp.Amount * 500000
-			err = model.IncreaseUserQuota(topUp.UserId, topUp.Amount*int(common.QuotaPerUnit))
-			if err != nil {
-				log.Printf("易支付回调更新用户失败: %v", topUp)
-				return
-			}
-			log.Printf("易支付回调更新用户成功 %v", topUp)
-			model.RecordLog(topUp.UserId, model.LogTypeTopup, fmt.Sprintf("使用在线充值成功，充值金额: %v，支付金额：%f", common.LogQuota(topUp.Amount*int(common.QuotaPerUnit)), topUp.Money))
-		}
-	} else {
-		log.Printf("易支付异常回调: %v", verifyInfo)
+	if notify.Event == service.PaymentEventIgnored {
+		common.LogDebug(c, fmt.Sprintf("%s 忽略回调: %s", provider.Name(), notify.TradeNo))
 	}
+	err = service.HandlePaymentNotify(c, provider, notify)
+	if err != nil {
+		common.LogError(c, fmt.Sprintf("%s 回调处理失败: %s", provider.Name(), err.Error()))
+	}
+	provider.NotifyResponse(c, err)
 }
 
 func RequestAmount(c *gin.Context) {
@@ -248,3 +196,129 @@ func RequestAmount(c *gin.Context) {
 	}
 	c.JSON(200, gin.H{"message": "success", "data": strconv.FormatFloat(payMoney, 'f', 2, 64)})
 }
+
+func GetAllTopUps(c *gin.Context) {
+	p, _ := strconv.Atoi(c.Query("p"))
+	pageSize, _ := strconv.Atoi(c.Query("page_size"))
+	if p < 1 {
+		p = 1
+	}
+	if pageSize < 1 {
+		pageSize = common.ItemsPerPage
+	}
+	userId, _ := strconv.Atoi(c.Query("user_id"))
+	topUps, total, err := model.GetAllTopUps(userId, c.Query("status"), c.Query("payment_method"), c.Query("trade_no"), (p-1)*pageSize, pageSize)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"items":     topUps,
+			"total":     total,
+			"page":      p,
+			"page_size": pageSize,
+		},
+	})
+}
+
+// GetTopUp returns the order with its status changes, query=true also asks
+// the payment provider for its view of the order.
+func GetTopUp(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	topUp := model.GetTopUpById(id)
+	if topUp == nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "订单不存在",
+		})
+		return
+	}
+	logs, err := model.GetTopUpStatusLogs(topUp.Id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	data := gin.H{
+		"top_up":      topUp,
+		"transitions": logs,
+	}
+	if c.Query("query") == "true" {
+		provider := service.GetPaymentProvider(topUp.PaymentMethod)
+		if provider != nil && provider.Enabled() {
+			query, err := provider.QueryOrder(topUp)
+			if err != nil {
+				data["provider_error"] = err.Error()
+			} else {
+				data["provider"] = query
+			}
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    data,
+	})
+}
+
+func ReconcileTopUp(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	topUp := model.GetTopUpById(id)
+	if topUp == nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "订单不存在",
+		})
+		return
+	}
+	err := service.ReconcileTopUp(c, topUp, service.PaymentSourceAdmin)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    model.GetTopUpById(id),
+	})
+}
+
+// RefundTopUp refunds a paid order through its provider, money 0 refunds what
+// is left of it.
+func RefundTopUp(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	var req RefundTopUpRequest
+	_ = c.ShouldBindJSON(&req)
+	topUp := model.GetTopUpById(id)
+	if topUp == nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "订单不存在",
+		})
+		return
+	}
+	err := service.RefundTopUp(c, topUp, req.Money)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    model.GetTopUpById(id),
+	})
+}
@@ -543,7 +543,8 @@ func UpdateUser(c *gin.Context) {
 		updatedUser.Password = "" // rollback to what it should be
 	}
 	updatePassword := updatedUser.Password != ""
-	if err := updatedUser.Edit(updatePassword); err != nil {
+	ref := model.QuotaRef{Source: model.LedgerSourceAdmin, Reference: strconv.Itoa(c.GetInt("id"))}
+	if err := updatedUser.Edit(updatePassword, ref); err != nil {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
 			"message": err.Error(),
@@ -86,18 +86,20 @@ func (r GeneralOpenAIRequest) ParseInput() []string {
 }
 
 type Message struct {
-	Role       string          `json:"role"`
-	Content    json.RawMessage `json:"content"`
-	Name       *string         `json:"name,omitempty"`
-	ToolCalls  json.RawMessage `json:"tool_calls,omitempty"`
-	ToolCallId string          `json:"tool_call_id,omitempty"`
+	Role             string          `json:"role"`
+	Content          json.RawMessage `json:"content"`
+	ReasoningContent string          `json:"reasoning_content,omitempty"`
+	Name             *string         `json:"name,omitempty"`
+	ToolCalls        json.RawMessage `json:"tool_calls,omitempty"`
+	ToolCallId       string          `json:"tool_call_id,omitempty"`
 }
 
 type MediaContent struct {
-	Type       string `json:"type"`
-	Text       string `json:"text,omitempty"`
-	ImageUrl   any    `json:"image_url,omitempty"`
-	InputAudio any    `json:"input_audio,omitempty"`
+	Type         string          `json:"type"`
+	Text         string          `json:"text,omitempty"`
+	ImageUrl     any             `json:"image_url,omitempty"`
+	InputAudio   any             `json:"input_audio,omitempty"`
+	CacheControl json.RawMessage `json:"cache_control,omitempty"`
 }
 
 type MessageImageUrl struct {
@@ -170,6 +172,7 @@ func (m *Message) ParseContent() []MediaContent {
 			if err := json.Unmarshal(contentItem, &contentMap); err != nil {
 				continue
 			}
+			parsed := len(contentList)
 			switch contentMap["type"] {
 			case ContentTypeText:
 				if subStr, ok := contentMap["text"].(string); ok {
@@ -213,6 +216,9 @@ func (m *Message) ParseContent() []MediaContent {
 					})
 				}
 			}
+			if cacheControl, ok := contentMap["cache_control"]; ok && len(contentList) > parsed {
+				contentList[parsed].CacheControl, _ = json.Marshal(cacheControl)
+			}
 		}
 		return contentList
 	}
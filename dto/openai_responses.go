@@ -0,0 +1,181 @@
+package dto
+
+import "encoding/json"
+
+// OpenAIResponsesRequest is a request to the OpenAI Responses API (/v1/responses).
+type OpenAIResponsesRequest struct {
+	Model              string          `json:"model"`
+	Input              json.RawMessage `json:"input,omitempty"`
+	Instructions       string          `json:"instructions,omitempty"`
+	MaxOutputTokens    uint            `json:"max_output_tokens,omitempty"`
+	Temperature        float64         `json:"temperature,omitempty"`
+	TopP               float64         `json:"top_p,omitempty"`
+	Stream             bool            `json:"stream,omitempty"`
+	Tools              []ResponsesTool `json:"tools,omitempty"`
+	ToolChoice         any             `json:"tool_choice,omitempty"`
+	ParallelToolCalls  *bool           `json:"parallel_tool_calls,omitempty"`
+	Reasoning          *Reasoning      `json:"reasoning,omitempty"`
+	Text               *ResponsesText  `json:"text,omitempty"`
+	PreviousResponseId string          `json:"previous_response_id,omitempty"`
+	Store              *bool           `json:"store,omitempty"`
+	Metadata           any             `json:"metadata,omitempty"`
+	User               string          `json:"user,omitempty"`
+}
+
+type Reasoning struct {
+	Effort  string `json:"effort,omitempty"`
+	Summary string `json:"summary,omitempty"`
+}
+
+type ResponsesText struct {
+	Format *ResponsesTextFormat `json:"format,omitempty"`
+}
+
+type ResponsesTextFormat struct {
+	Type        string `json:"type"`
+	Name        string `json:"name,omitempty"`
+	Description string `json:"description,omitempty"`
+	Schema      any    `json:"schema,omitempty"`
+	Strict      any    `json:"strict,omitempty"`
+}
+
+type ResponsesTool struct {
+	Type        string `json:"type"`
+	Name        string `json:"name,omitempty"`
+	Description string `json:"description,omitempty"`
+	Parameters  any    `json:"parameters,omitempty"`
+	Strict      any    `json:"strict,omitempty"`
+}
+
+// ResponsesInputItem is one entry of an input array, either a message or a
+// function_call, function_call_output or reasoning item.
+type ResponsesInputItem struct {
+	Type      string          `json:"type,omitempty"`
+	Role      string          `json:"role,omitempty"`
+	Content   json.RawMessage `json:"content,omitempty"`
+	CallId    string          `json:"call_id,omitempty"`
+	Name      string          `json:"name,omitempty"`
+	Arguments string          `json:"arguments,omitempty"`
+	Output    any             `json:"output,omitempty"`
+}
+
+type ResponsesInputContent struct {
+	Type     string `json:"type"`
+	Text     string `json:"text,omitempty"`
+	ImageUrl string `json:"image_url,omitempty"`
+	FileId   string `json:"file_id,omitempty"`
+	Detail   string `json:"detail,omitempty"`
+}
+
+func (r OpenAIResponsesRequest) GetMaxTokens() int {
+	return int(r.MaxOutputTokens)
+}
+
+// ParseInput returns the input as items, a plain string input becomes a single
+// user message.
+func (r OpenAIResponsesRequest) ParseInput() ([]ResponsesInputItem, error) {
+	if len(r.Input) == 0 {
+		return nil, nil
+	}
+	var text string
+	if err := json.Unmarshal(r.Input, &text); err == nil {
+		content, _ := json.Marshal(text)
+		return []ResponsesInputItem{{Type: "message", Role: "user", Content: content}}, nil
+	}
+	var items []ResponsesInputItem
+	err := json.Unmarshal(r.Input, &items)
+	return items, err
+}
+
+// ParseContent returns the content parts of a message item, a plain string
+// content becomes a single input_text part.
+func (i ResponsesInputItem) ParseContent() []ResponsesInputContent {
+	if len(i.Content) == 0 {
+		return nil
+	}
+	var text string
+	if err := json.Unmarshal(i.Content, &text); err == nil {
+		return []ResponsesInputContent{{Type: "input_text", Text: text}}
+	}
+	var contents []ResponsesInputContent
+	_ = json.Unmarshal(i.Content, &contents)
+	return contents
+}
+
+type OpenAIResponsesResponse struct {
+	Id                string               `json:"id"`
+	Object            string               `json:"object"`
+	CreatedAt         int64                `json:"created_at"`
+	Status            string               `json:"status"`
+	Model             string               `json:"model"`
+	Output            []ResponsesOutput    `json:"output"`
+	IncompleteDetails *ResponsesIncomplete `json:"incomplete_details,omitempty"`
+	Error             *ResponsesError      `json:"error,omitempty"`
+	Usage             *ResponsesUsage      `json:"usage,omitempty"`
+	Metadata          any                  `json:"metadata,omitempty"`
+	ParallelToolCalls *bool                `json:"parallel_tool_calls,omitempty"`
+	Instructions      string               `json:"instructions,omitempty"`
+}
+
+type ResponsesIncomplete struct {
+	Reason string `json:"reason"`
+}
+
+type ResponsesError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// ResponsesOutput is one output item: a message, function_call or reasoning item.
+type ResponsesOutput struct {
+	Type      string                   `json:"type"`
+	Id        string                   `json:"id"`
+	Status    string                   `json:"status,omitempty"`
+	Role      string                   `json:"role,omitempty"`
+	Content   []ResponsesOutputContent `json:"content,omitempty"`
+	CallId    string                   `json:"call_id,omitempty"`
+	Name      string                   `json:"name,omitempty"`
+	Arguments *string                  `json:"arguments,omitempty"`
+	Summary   []ResponsesOutputContent `json:"summary,omitempty"`
+}
+
+type ResponsesOutputContent struct {
+	Type        string `json:"type"`
+	Text        string `json:"text"`
+	Annotations []any  `json:"annotations,omitempty"`
+}
+
+type ResponsesUsage struct {
+	InputTokens         int                          `json:"input_tokens"`
+	OutputTokens        int                          `json:"output_tokens"`
+	TotalTokens         int                          `json:"total_tokens"`
+	InputTokensDetails  ResponsesInputTokensDetails  `json:"input_tokens_details"`
+	OutputTokensDetails ResponsesOutputTokensDetails `json:"output_tokens_details"`
+}
+
+type ResponsesInputTokensDetails struct {
+	CachedTokens int `json:"cached_tokens"`
+}
+
+type ResponsesOutputTokensDetails struct {
+	ReasoningTokens int `json:"reasoning_tokens"`
+}
+
+// ResponsesStreamResponse is a Responses API stream event, only the fields
+// needed to follow a stream are decoded.
+type ResponsesStreamResponse struct {
+	Type     string                   `json:"type"`
+	Response *OpenAIResponsesResponse `json:"response,omitempty"`
+	Delta    string                   `json:"delta,omitempty"`
+}
+
+func (u *ResponsesUsage) ToUsage() *Usage {
+	usage := &Usage{
+		PromptTokens:     u.InputTokens,
+		CompletionTokens: u.OutputTokens,
+		TotalTokens:      u.InputTokens + u.OutputTokens,
+	}
+	usage.PromptTokensDetails.CachedTokens = u.InputTokensDetails.CachedTokens
+	usage.CompletionTokenDetails.ReasoningTokens = u.OutputTokensDetails.ReasoningTokens
+	return usage
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"embed"
 	"fmt"
 	"log"
@@ -111,6 +112,23 @@ func main() {
 		gopool.Go(func() {
 			controller.UpdateTaskBulk()
 		})
+		gopool.Go(func() {
+			service.CleanStorage(3600)
+		})
+	}
+	if common.IsMasterNode {
+		gopool.Go(func() {
+			service.ReconcileTopUps(300)
+		})
+		gopool.Go(func() {
+			service.UpdateSubscriptions(60)
+		})
+		gopool.Go(func() {
+			service.SnapshotQuotas(600)
+		})
+		gopool.Go(func() {
+			service.ReconcileQuotaLedgers(3600)
+		})
 	}
 	if os.Getenv("BATCH_UPDATE_ENABLED") == "true" {
 		common.BatchUpdateEnabled = true
@@ -128,6 +146,12 @@ func main() {
 
 	service.InitTokenEncoders()
 
+	shutdownTracing, err := common.InitTracing()
+	if err != nil {
+		common.FatalLog("failed to initialize tracing: " + err.Error())
+	}
+	defer shutdownTracing(context.Background())
+
 	// Initialize HTTP server
 	server := gin.New()
 	server.Use(gin.CustomRecovery(func(c *gin.Context, err any) {
@@ -142,6 +166,7 @@ func main() {
 	// This will cause SSE not to work!!!
 	//server.Use(gzip.Gzip(gzip.DefaultCompression))
 	server.Use(middleware.RequestId())
+	server.Use(middleware.Tracing())
 	middleware.SetUpLogger(server)
 	// Initialize session store
 	store := cookie.NewStore([]byte(common.SessionSecret))
@@ -1,6 +1,7 @@
 package middleware
 
 import (
+	"crypto/subtle"
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
 	"net/http"
@@ -161,6 +162,8 @@ func WssAuth(c *gin.Context) {
 
 func TokenAuth() func(c *gin.Context) {
 	return func(c *gin.Context) {
+		_, endSpan := common.StartSpan(c, "auth")
+		defer endSpan()
 		// 先检测是否为ws
 		if c.Request.Header.Get("Sec-WebSocket-Protocol") != "" {
 			// Sec-WebSocket-Protocol: realtime, openai-insecure-api-key.sk-xxx, openai-beta.realtime-v1
@@ -177,6 +180,17 @@ func TokenAuth() func(c *gin.Context) {
 			c.Request.Header.Set("Authorization", "Bearer "+key)
 		}
 		key := c.Request.Header.Get("Authorization")
+		if key == "" {
+			// anthropic sdk sends the key in x-api-key
+			key = c.Request.Header.Get("x-api-key")
+		}
+		if key == "" && strings.HasPrefix(c.Request.URL.Path, "/v1beta/") {
+			// google genai sdk sends the key in x-goog-api-key or the key query
+			key = c.Request.Header.Get("x-goog-api-key")
+			if key == "" {
+				key = c.Query("key")
+			}
+		}
 		parts := make([]string, 0)
 		key = strings.TrimPrefix(key, "Bearer ")
 		if key == "" || key == "midjourney-proxy" {
@@ -226,6 +240,10 @@ func TokenAuth() func(c *gin.Context) {
 		}
 		c.Set("allow_ips", token.GetIpLimitsMap())
 		c.Set("token_group", token.Group)
+		c.Set("token_response_cache", token.ResponseCache)
+		c.Set("token_response_cache_ttl", token.ResponseCacheTTL)
+		c.Set("token_rate_limit_rpm", token.RateLimitRPM)
+		c.Set("token_rate_limit_tpm", token.RateLimitTPM)
 		if len(parts) > 1 {
 			if model.IsAdmin(token.UserId) {
 				c.Set("specific_channel_id", parts[1])
@@ -234,6 +252,20 @@ func TokenAuth() func(c *gin.Context) {
 				return
 			}
 		}
+		endSpan()
+		c.Next()
+	}
+}
+
+// MetricsAuth checks the bearer token of the metrics endpoint when
+// METRICS_TOKEN is set.
+func MetricsAuth() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		token := strings.TrimPrefix(c.Request.Header.Get("Authorization"), "Bearer ")
+		if common.MetricsToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(common.MetricsToken)) != 1 {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
 		c.Next()
 	}
 }
@@ -1,8 +1,12 @@
 package middleware
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"one-api/common"
 	"one-api/constant"
@@ -16,6 +20,7 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type ModelRequest struct {
@@ -24,6 +29,8 @@ type ModelRequest struct {
 
 func Distribute() func(c *gin.Context) {
 	return func(c *gin.Context) {
+		span, endSpan := common.StartSpan(c, "channel selection")
+		defer endSpan()
 		allowIpsMap := c.GetStringMap("allow_ips")
 		if len(allowIpsMap) != 0 {
 			clientIp := c.ClientIP()
@@ -34,12 +41,12 @@ func Distribute() func(c *gin.Context) {
 		}
 		userId := c.GetInt("id")
 		var channel *model.Channel
-		channelId, ok := c.Get("specific_channel_id")
 		modelRequest, shouldSelectChannel, err := getModelRequest(c)
 		if err != nil {
 			abortWithOpenAiMessage(c, http.StatusBadRequest, "Invalid request, "+err.Error())
 			return
 		}
+		channelId, ok := c.Get("specific_channel_id")
 		userGroup, _ := model.GetUserGroup(userId, false)
 		tokenGroup := c.GetString("token_group")
 		if tokenGroup != "" {
@@ -96,7 +103,10 @@ func Distribute() func(c *gin.Context) {
 			}
 
 			if shouldSelectChannel {
-				channel, err = model.CacheGetRandomSatisfiedChannel(userGroup, modelRequest.Model, 0)
+				channel, err = model.CacheGetRandomSatisfiedChannel(userGroup, modelRequest.Model, 0, model.ChannelRouteKey{
+					UserId:  userId,
+					TokenId: c.GetInt("token_id"),
+				})
 				if err != nil {
 					message := fmt.Sprintf("当前分组 %s 下对于模型 %s 无可用渠道", userGroup, modelRequest.Model)
 					// 如果错误，但是渠道不为空，说明是数据库一致性问题
@@ -116,6 +126,11 @@ func Distribute() func(c *gin.Context) {
 		}
 		c.Set(constant.ContextKeyRequestStartTime, time.Now())
 		SetupContextForSelectedChannel(c, channel, modelRequest.Model)
+		if channel != nil {
+			span.SetAttributes(attribute.Int("channel.id", channel.Id))
+		}
+		span.SetAttributes(attribute.String("model", modelRequest.Model), attribute.String("group", userGroup))
+		endSpan()
 		c.Next()
 	}
 }
@@ -166,7 +181,28 @@ func getModelRequest(c *gin.Context) (*ModelRequest, bool, error) {
 		}
 		c.Set("platform", string(constant.TaskPlatformSuno))
 		c.Set("relay_mode", relayMode)
-	} else if !strings.HasPrefix(c.Request.URL.Path, "/v1/audio/transcriptions") {
+	} else if strings.HasPrefix(c.Request.URL.Path, "/v1/files") || strings.HasPrefix(c.Request.URL.Path, "/v1/batches") {
+		objectId := c.Param("id")
+		if c.Request.Method == http.MethodPost && c.Request.URL.Path == "/v1/batches" {
+			batchRequest := dto.OpenAIBatchRequest{}
+			err = common.UnmarshalBodyReusable(c, &batchRequest)
+			if err == nil && batchRequest.InputFileId == "" {
+				err = errors.New("input_file_id is required")
+			}
+			objectId = batchRequest.InputFileId
+		}
+		if err == nil {
+			if objectId != "" {
+				// files and batches only exist on the channel that created them
+				shouldSelectChannel = false
+				err = setRelayObjectChannel(c, objectId)
+			} else {
+				modelRequest.Model, err = getFileRequestModel(c)
+			}
+		}
+	} else if !strings.HasPrefix(c.Request.URL.Path, "/v1/audio/transcriptions") &&
+		!strings.HasPrefix(c.Request.URL.Path, "/v1/images/edits") &&
+		!strings.HasPrefix(c.Request.URL.Path, "/v1/images/variations") {
 		err = common.UnmarshalBodyReusable(c, &modelRequest)
 	}
 	if err != nil {
@@ -177,6 +213,10 @@ func getModelRequest(c *gin.Context) (*ModelRequest, bool, error) {
 		//wss://api.openai.com/v1/realtime?model=gpt-4o-realtime-preview-2024-10-01
 		modelRequest.Model = c.Query("model")
 	}
+	if strings.HasPrefix(c.Request.URL.Path, "/v1beta/models/") {
+		// the model is part of the path, e.g. gemini-pro:generateContent
+		modelRequest.Model = strings.Split(c.Param("model"), ":")[0]
+	}
 	if strings.HasPrefix(c.Request.URL.Path, "/v1/moderations") {
 		if modelRequest.Model == "" {
 			modelRequest.Model = "text-moderation-stable"
@@ -190,6 +230,10 @@ func getModelRequest(c *gin.Context) (*ModelRequest, bool, error) {
 	if strings.HasPrefix(c.Request.URL.Path, "/v1/images/generations") {
 		modelRequest.Model = common.GetStringIfEmpty(modelRequest.Model, "dall-e")
 	}
+	if strings.HasPrefix(c.Request.URL.Path, "/v1/images/edits") || strings.HasPrefix(c.Request.URL.Path, "/v1/images/variations") {
+		// multipart requests, the model is a form field
+		modelRequest.Model = common.GetStringIfEmpty(c.PostForm("model"), "dall-e-2")
+	}
 	if strings.HasPrefix(c.Request.URL.Path, "/v1/audio") {
 		relayMode := relayconstant.RelayModeAudioSpeech
 		if strings.HasPrefix(c.Request.URL.Path, "/v1/audio/speech") {
@@ -208,6 +252,54 @@ func getModelRequest(c *gin.Context) (*ModelRequest, bool, error) {
 	return &modelRequest, shouldSelectChannel, nil
 }
 
+func setRelayObjectChannel(c *gin.Context, objectId string) error {
+	relayObject, exist, err := model.GetUserRelayObject(c.GetInt("id"), objectId)
+	if err != nil {
+		return err
+	}
+	if !exist {
+		return fmt.Errorf("no such file or batch: %s", objectId)
+	}
+	c.Set("specific_channel_id", strconv.Itoa(relayObject.ChannelId))
+	return nil
+}
+
+// getFileRequestModel picks the model used to select a channel for a file
+// upload, the model query or form field wins, otherwise the model of the
+// first request in a batch input file is used.
+func getFileRequestModel(c *gin.Context) (string, error) {
+	if modelName := c.Query("model"); modelName != "" {
+		return modelName, nil
+	}
+	requestBody, err := common.GetRequestBody(c)
+	if err != nil {
+		return "", err
+	}
+	c.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
+	defer func() {
+		c.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
+	}()
+	if modelName := c.PostForm("model"); modelName != "" {
+		return modelName, nil
+	}
+	file, _, err := c.Request.FormFile("file")
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 32<<20)
+	if scanner.Scan() {
+		var line struct {
+			Body ModelRequest `json:"body"`
+		}
+		if json.Unmarshal(scanner.Bytes(), &line) == nil && line.Body.Model != "" {
+			return line.Body.Model, nil
+		}
+	}
+	return "", errors.New("model is required to select a channel")
+}
+
 func SetupContextForSelectedChannel(c *gin.Context, channel *model.Channel, modelName string) {
 	c.Set("original_model", modelName) // for retry
 	if channel == nil {
@@ -223,7 +315,9 @@ func SetupContextForSelectedChannel(c *gin.Context, channel *model.Channel, mode
 	c.Set("auto_ban", channel.GetAutoBan())
 	c.Set("model_mapping", channel.GetModelMapping())
 	c.Set("status_code_mapping", channel.GetStatusCodeMapping())
-	c.Request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", channel.Key))
+	key, keyHash := channel.SelectKey()
+	c.Set("channel_key_hash", keyHash)
+	c.Request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", key))
 	c.Set("base_url", channel.GetBaseURL())
 	// TODO: api_version统一
 	switch channel.Type {
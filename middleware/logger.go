@@ -1,17 +1,58 @@
 package middleware
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"one-api/common"
+	"time"
 )
 
+// accessLogEntry is an access log line in the JSON format.
+type accessLogEntry struct {
+	Time      string  `json:"time"`
+	Level     string  `json:"level"`
+	RequestId string  `json:"request_id,omitempty"`
+	UserId    int     `json:"user_id,omitempty"`
+	TokenId   int     `json:"token_id,omitempty"`
+	ChannelId int     `json:"channel_id,omitempty"`
+	Model     string  `json:"model,omitempty"`
+	Status    int     `json:"status"`
+	LatencyMs float64 `json:"latency_ms"`
+	ClientIp  string  `json:"client_ip"`
+	Method    string  `json:"method"`
+	Path      string  `json:"path"`
+	Msg       string  `json:"msg"`
+}
+
 func SetUpLogger(server *gin.Engine) {
 	server.Use(gin.LoggerWithFormatter(func(param gin.LogFormatterParams) string {
 		var requestID string
 		if param.Keys != nil {
 			requestID = param.Keys[common.RequestIdKey].(string)
 		}
+		if common.LogJSONEnabled {
+			entry := accessLogEntry{
+				Time:      param.TimeStamp.Format(time.RFC3339Nano),
+				Level:     "info",
+				RequestId: requestID,
+				Status:    param.StatusCode,
+				LatencyMs: float64(param.Latency.Microseconds()) / 1000,
+				ClientIp:  param.ClientIP,
+				Method:    param.Method,
+				Path:      param.Path,
+				Msg:       "access",
+			}
+			entry.UserId, _ = param.Keys["id"].(int)
+			entry.TokenId, _ = param.Keys["token_id"].(int)
+			entry.ChannelId, _ = param.Keys["channel_id"].(int)
+			entry.Model, _ = param.Keys["original_model"].(string)
+			jsonBytes, err := json.Marshal(entry)
+			if err != nil {
+				return ""
+			}
+			return string(jsonBytes) + "\n"
+		}
 		return fmt.Sprintf("[GIN] %s | %s | %3d | %13v | %15s | %7s %s\n",
 			param.TimeStamp.Format("2006/01/02 - 15:04:05"),
 			requestID,
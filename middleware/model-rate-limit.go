@@ -0,0 +1,101 @@
+package middleware
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"one-api/model"
+	"one-api/service"
+	"one-api/setting"
+	"strconv"
+)
+
+type relayRateLimit struct {
+	key string
+	rpm int
+	tpm int
+}
+
+func setRateLimitHeaders(c *gin.Context, kind string, status *service.RateLimitStatus) {
+	if status == nil {
+		return
+	}
+	c.Header("x-ratelimit-limit-"+kind, strconv.Itoa(status.Limit))
+	c.Header("x-ratelimit-remaining-"+kind, strconv.Itoa(status.Remaining))
+	c.Header("x-ratelimit-reset-"+kind, service.FormatRateLimitReset(status.Reset))
+}
+
+// tighter reports whether a leaves less room than b, the headers show the
+// limit that is closest to being hit.
+func tighter(a *service.RateLimitStatus, b *service.RateLimitStatus) bool {
+	return b == nil || a.Remaining < b.Remaining
+}
+
+// ModelRequestRateLimit enforces the requests and tokens per minute limits
+// of the token, of the user group and of a subscription plan covering the
+// request, it must run after Distribute so the group and model are known.
+func ModelRequestRateLimit() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		groupRateLimit := setting.GetGroupRateLimit(c.GetString("group"))
+		limits := []relayRateLimit{
+			{
+				key: service.GetTokenRateLimitKey(c.GetInt("token_id")),
+				rpm: c.GetInt("token_rate_limit_rpm"),
+				tpm: c.GetInt("token_rate_limit_tpm"),
+			},
+			{
+				key: service.GetUserRateLimitKey(c.GetInt("id")),
+				rpm: groupRateLimit.RPM,
+				tpm: groupRateLimit.TPM,
+			},
+		}
+		_, plan := model.GetUserActivePlan(c.GetInt("id"), c.GetString("group"), c.GetString("original_model"))
+		if plan != nil && plan.RPM > 0 {
+			limits = append(limits, relayRateLimit{
+				key: service.GetPlanRateLimitKey(c.GetInt("id")),
+				rpm: plan.RPM,
+			})
+		}
+		var requestStatus, tokenStatus *service.RateLimitStatus
+		for _, limit := range limits {
+			if limit.tpm <= 0 {
+				continue
+			}
+			status, ok := service.RateLimitTokens(limit.key, limit.tpm)
+			if tighter(status, tokenStatus) {
+				tokenStatus = status
+			}
+			if !ok {
+				setRateLimitHeaders(c, "tokens", status)
+				abortWithOpenAiMessage(c, http.StatusTooManyRequests,
+					fmt.Sprintf("已达到每分钟 %d tokens 的限制，请于 %s 后重试", limit.tpm, service.FormatRateLimitReset(status.Reset)))
+				return
+			}
+		}
+		// every request window is checked before any of them counts the request
+		var requestKeys []string
+		var requestLimits []int
+		for _, limit := range limits {
+			if limit.rpm > 0 {
+				requestKeys = append(requestKeys, limit.key)
+				requestLimits = append(requestLimits, limit.rpm)
+			}
+		}
+		statuses, rejected := service.RateLimitRequests(requestKeys, requestLimits)
+		if rejected >= 0 {
+			status := statuses[rejected]
+			setRateLimitHeaders(c, "requests", status)
+			abortWithOpenAiMessage(c, http.StatusTooManyRequests,
+				fmt.Sprintf("已达到每分钟 %d 次请求的限制，请于 %s 后重试", requestLimits[rejected], service.FormatRateLimitReset(status.Reset)))
+			return
+		}
+		for _, status := range statuses {
+			if tighter(status, requestStatus) {
+				requestStatus = status
+			}
+		}
+		setRateLimitHeaders(c, "requests", requestStatus)
+		setRateLimitHeaders(c, "tokens", tokenStatus)
+		c.Next()
+	}
+}
@@ -93,7 +93,7 @@ func getChannelQuery(group string, model string, retry int) *gorm.DB {
 	return channelQuery
 }
 
-func GetRandomSatisfiedChannel(group string, model string, retry int) (*Channel, error) {
+func GetRandomSatisfiedChannel(group string, model string, retry int, routeKey ChannelRouteKey) (*Channel, error) {
 	var abilities []Ability
 
 	var err error = nil
@@ -106,28 +106,24 @@ func GetRandomSatisfiedChannel(group string, model string, retry int) (*Channel,
 	if err != nil {
 		return nil, err
 	}
-	channel := Channel{}
-	if len(abilities) > 0 {
-		// Randomly choose one
-		weightSum := uint(0)
-		for _, ability_ := range abilities {
-			weightSum += ability_.Weight + 10
-		}
-		// Randomly choose one
-		weight := common.GetRandomInt(int(weightSum))
-		for _, ability_ := range abilities {
-			weight -= int(ability_.Weight) + 10
-			//log.Printf("weight: %d, ability weight: %d", weight, *ability_.Weight)
-			if weight <= 0 {
-				channel.Id = ability_.ChannelId
-				break
-			}
-		}
-	} else {
+	if len(abilities) == 0 {
+		return nil, errors.New("channel not found")
+	}
+	channelIds := make([]int, 0, len(abilities))
+	for _, ability_ := range abilities {
+		channelIds = append(channelIds, ability_.ChannelId)
+	}
+	channels, err := GetChannelsByIds(channelIds)
+	if err != nil {
+		return nil, err
+	}
+	channel := pickProbedChannel(skipSaturatedChannels(skipOpenChannels(channels)), func(channels []*Channel) *Channel {
+		return pickChannel(channels, group, model, routeKey)
+	})
+	if channel == nil {
 		return nil, errors.New("channel not found")
 	}
-	err = DB.First(&channel, "id = ?", channel.Id).Error
-	return &channel, err
+	return channel, nil
 }
 
 func (channel *Channel) AddAbilities() error {
@@ -3,7 +3,6 @@ package model
 import (
 	"errors"
 	"fmt"
-	"math/rand"
 	"one-api/common"
 	"sort"
 	"strings"
@@ -161,6 +160,7 @@ func InitChannelCache() {
 	group2model2channels = newGroup2model2channels
 	channelsIDM = newChannelsIDM
 	channelSyncLock.Unlock()
+	initDisabledChannelKeysCache()
 	common.SysLog("channels synced from database")
 }
 
@@ -172,7 +172,7 @@ func SyncChannelCache(frequency int) {
 	}
 }
 
-func CacheGetRandomSatisfiedChannel(group string, model string, retry int) (*Channel, error) {
+func CacheGetRandomSatisfiedChannel(group string, model string, retry int, routeKey ChannelRouteKey) (*Channel, error) {
 	if strings.HasPrefix(model, "gpt-4-gizmo") {
 		model = "gpt-4-gizmo-*"
 	}
@@ -182,7 +182,7 @@ func CacheGetRandomSatisfiedChannel(group string, model string, retry int) (*Cha
 
 	// if memory cache is disabled, get channel directly from database
 	if !common.MemoryCacheEnabled {
-		return GetRandomSatisfiedChannel(group, model, retry)
+		return GetRandomSatisfiedChannel(group, model, retry, routeKey)
 	}
 	channelSyncLock.RLock()
 	defer channelSyncLock.RUnlock()
@@ -190,49 +190,41 @@ func CacheGetRandomSatisfiedChannel(group string, model string, retry int) (*Cha
 	if len(channels) == 0 {
 		return nil, errors.New("channel not found")
 	}
+	channels = skipSaturatedChannels(skipOpenChannels(channels))
 
-	uniquePriorities := make(map[int]bool)
-	for _, channel := range channels {
-		uniquePriorities[int(channel.GetPriority())] = true
-	}
-	var sortedUniquePriorities []int
-	for priority := range uniquePriorities {
-		sortedUniquePriorities = append(sortedUniquePriorities, priority)
-	}
-	sort.Sort(sort.Reverse(sort.IntSlice(sortedUniquePriorities)))
-
-	if retry >= len(uniquePriorities) {
-		retry = len(uniquePriorities) - 1
-	}
-	targetPriority := int64(sortedUniquePriorities[retry])
-
-	// get the priority for the given retry number
-	var targetChannels []*Channel
-	for _, channel := range channels {
-		if channel.GetPriority() == targetPriority {
-			targetChannels = append(targetChannels, channel)
+	channel := pickProbedChannel(channels, func(channels []*Channel) *Channel {
+		uniquePriorities := make(map[int]bool)
+		for _, channel := range channels {
+			uniquePriorities[int(channel.GetPriority())] = true
 		}
-	}
+		if len(uniquePriorities) == 0 {
+			return nil
+		}
+		var sortedUniquePriorities []int
+		for priority := range uniquePriorities {
+			sortedUniquePriorities = append(sortedUniquePriorities, priority)
+		}
+		sort.Sort(sort.Reverse(sort.IntSlice(sortedUniquePriorities)))
 
-	// 平滑系数
-	smoothingFactor := 10
-	// Calculate the total weight of all channels up to endIdx
-	totalWeight := 0
-	for _, channel := range targetChannels {
-		totalWeight += channel.GetWeight() + smoothingFactor
-	}
-	// Generate a random value in the range [0, totalWeight)
-	randomWeight := rand.Intn(totalWeight)
+		priorityIndex := retry
+		if priorityIndex >= len(uniquePriorities) {
+			priorityIndex = len(uniquePriorities) - 1
+		}
+		targetPriority := int64(sortedUniquePriorities[priorityIndex])
 
-	// Find a channel based on its weight
-	for _, channel := range targetChannels {
-		randomWeight -= channel.GetWeight() + smoothingFactor
-		if randomWeight < 0 {
-			return channel, nil
+		// get the priority for the given retry number
+		var targetChannels []*Channel
+		for _, channel := range channels {
+			if channel.GetPriority() == targetPriority {
+				targetChannels = append(targetChannels, channel)
+			}
 		}
+		return pickChannel(targetChannels, group, model, routeKey)
+	})
+	if channel == nil {
+		return nil, errors.New("channel not found")
 	}
-	// return null if no channel is not found
-	return nil, errors.New("channel not found")
+	return channel, nil
 }
 
 func CacheGetChannel(id int) (*Channel, error) {
@@ -36,6 +36,7 @@ type Channel struct {
 	OtherInfo         string  `json:"other_info"`
 	Tag               *string `json:"tag" gorm:"index"`
 	Setting           string  `json:"setting" gorm:"type:text"`
+	MultiKeyMode      *string `json:"multi_key_mode" gorm:"type:varchar(16);default:''"` // empty for a single key
 }
 
 func (channel *Channel) GetModels() []string {
@@ -102,6 +103,13 @@ func GetAllChannels(startIdx int, num int, selectAll bool, idSort bool) ([]*Chan
 	return channels, err
 }
 
+// GetChannelStatuses returns the id, name and status of every channel.
+func GetChannelStatuses() ([]*Channel, error) {
+	var channels []*Channel
+	err := DB.Select("id", "name", "status").Find(&channels).Error
+	return channels, err
+}
+
 func GetChannelsByTag(tag string, idSort bool) ([]*Channel, error) {
 	var channels []*Channel
 	order := "priority desc"
@@ -0,0 +1,181 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"one-api/common"
+	"one-api/constant"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// channelSlotLease bounds how long a slot is held when the node holding it
+// dies before releasing it, the holder renews it every ChannelSlotRenewInterval
+// for as long as the request runs.
+const (
+	channelSlotLease         = time.Minute
+	ChannelSlotRenewInterval = channelSlotLease / 3
+)
+
+var channelSlots = make(map[int]int)
+var channelSlotsLock sync.Mutex
+
+// acquireChannelSlotScript drops expired holders and adds one when there is
+// room, the check and the add must be atomic across nodes.
+var acquireChannelSlotScript = redis.NewScript(`
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', ARGV[1])
+if redis.call('ZCARD', KEYS[1]) < tonumber(ARGV[2]) then
+	redis.call('ZADD', KEYS[1], ARGV[3], ARGV[4])
+	redis.call('PEXPIRE', KEYS[1], ARGV[5])
+	return 1
+end
+return 0
+`)
+
+func getChannelSlotKey(channelId int) string {
+	return fmt.Sprintf("channelSlots:%d", channelId)
+}
+
+// GetSettingInt reads an integer from a channel setting map, json numbers
+// are decoded as float64.
+func GetSettingInt(setting map[string]interface{}, key string) int {
+	switch v := setting[key].(type) {
+	case float64:
+		return int(v)
+	case int:
+		return v
+	case string:
+		i, _ := strconv.Atoi(v)
+		return i
+	}
+	return 0
+}
+
+func (channel *Channel) GetMaxConcurrency() int {
+	if !strings.Contains(channel.Setting, constant.MaxConcurrency) {
+		return 0
+	}
+	return GetSettingInt(channel.GetSetting(), constant.MaxConcurrency)
+}
+
+// TryAcquireChannelSlot takes one of the maxConcurrency slots of a channel,
+// the returned slot must be passed to ReleaseChannelSlot.
+func TryAcquireChannelSlot(channelId int, maxConcurrency int) (string, bool) {
+	if !common.RedisEnabled {
+		channelSlotsLock.Lock()
+		defer channelSlotsLock.Unlock()
+		if channelSlots[channelId] >= maxConcurrency {
+			return "", false
+		}
+		channelSlots[channelId]++
+		return "", true
+	}
+	now := time.Now()
+	slot := common.GetUUID()
+	acquired, err := acquireChannelSlotScript.Run(context.Background(), common.RDB, []string{getChannelSlotKey(channelId)},
+		now.UnixMilli(), maxConcurrency, now.Add(channelSlotLease).UnixMilli(), slot, channelSlotLease.Milliseconds()).Int()
+	if err != nil {
+		// do not block the relay on redis errors
+		common.SysError("failed to acquire channel slot: " + err.Error())
+		return "", true
+	}
+	return slot, acquired == 1
+}
+
+func ReleaseChannelSlot(channelId int, slot string) {
+	if !common.RedisEnabled {
+		channelSlotsLock.Lock()
+		defer channelSlotsLock.Unlock()
+		if channelSlots[channelId] > 0 {
+			channelSlots[channelId]--
+		}
+		if channelSlots[channelId] == 0 {
+			delete(channelSlots, channelId)
+		}
+		return
+	}
+	if slot == "" {
+		return
+	}
+	err := common.RDB.ZRem(context.Background(), getChannelSlotKey(channelId), slot).Err()
+	if err != nil {
+		common.SysError("failed to release channel slot: " + err.Error())
+	}
+}
+
+// RenewChannelSlot extends the lease of a slot taken with TryAcquireChannelSlot,
+// a slot that already expired is not taken again.
+func RenewChannelSlot(channelId int, slot string) {
+	if !common.RedisEnabled || slot == "" {
+		return
+	}
+	key := getChannelSlotKey(channelId)
+	pipe := common.RDB.TxPipeline()
+	pipe.ZAddXX(context.Background(), key, &redis.Z{
+		Score:  float64(time.Now().Add(channelSlotLease).UnixMilli()),
+		Member: slot,
+	})
+	pipe.PExpire(context.Background(), key, channelSlotLease)
+	_, err := pipe.Exec(context.Background())
+	if err != nil {
+		common.SysError("failed to renew channel slot: " + err.Error())
+	}
+}
+
+// IsChannelSaturated reports whether every slot of a channel is taken,
+// channels without a concurrency limit are never saturated.
+func IsChannelSaturated(channel *Channel) bool {
+	maxConcurrency := channel.GetMaxConcurrency()
+	if maxConcurrency <= 0 {
+		return false
+	}
+	if !common.RedisEnabled {
+		channelSlotsLock.Lock()
+		defer channelSlotsLock.Unlock()
+		return channelSlots[channel.Id] >= maxConcurrency
+	}
+	count, err := common.RDB.ZCount(context.Background(), getChannelSlotKey(channel.Id),
+		strconv.FormatInt(time.Now().UnixMilli(), 10), "+inf").Result()
+	if err != nil {
+		return false
+	}
+	return count >= int64(maxConcurrency)
+}
+
+// skipSaturatedChannels keeps the channels with a free slot, all channels are
+// kept when every one is saturated so the request can queue on one of them.
+func skipSaturatedChannels(channels []*Channel) []*Channel {
+	available := make([]*Channel, 0, len(channels))
+	for _, channel := range channels {
+		if !IsChannelSaturated(channel) {
+			available = append(available, channel)
+		}
+	}
+	if len(available) == 0 {
+		return channels
+	}
+	return available
+}
+
+// channelOutstanding counts the requests this node has in flight per channel,
+// with or without a concurrency limit.
+var channelOutstanding = make(map[int]int)
+var channelOutstandingLock sync.Mutex
+
+func AddChannelOutstanding(channelId int, delta int) {
+	channelOutstandingLock.Lock()
+	defer channelOutstandingLock.Unlock()
+	channelOutstanding[channelId] += delta
+	if channelOutstanding[channelId] <= 0 {
+		delete(channelOutstanding, channelId)
+	}
+}
+
+func GetChannelOutstanding(channelId int) int {
+	channelOutstandingLock.Lock()
+	defer channelOutstandingLock.Unlock()
+	return channelOutstanding[channelId]
+}
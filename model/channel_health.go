@@ -0,0 +1,336 @@
+package model
+
+import (
+	"fmt"
+	"math"
+	"one-api/common"
+	"one-api/setting"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half_open"
+)
+
+// channelHealthWindow is how far back relay outcomes count towards the error
+// rate and latency of a channel, outcomes are kept in buckets so busy
+// channels stay cheap to score.
+const (
+	channelHealthWindow     = 5 * time.Minute
+	channelHealthBucketSize = 10 * time.Second
+)
+
+type channelHealthBucket struct {
+	start              int64 // unix seconds
+	requests           int
+	failures           int
+	latencySum         int64 // milliseconds
+	latencyCount       int64
+	firstResponseSum   int64 // milliseconds
+	firstResponseCount int64
+}
+
+type channelHealth struct {
+	buckets  []*channelHealthBucket
+	state    string
+	openedAt int64 // unix seconds
+	probedAt int64 // unix seconds, the half open probe
+}
+
+// ChannelHealth is the passive health of a channel as seen by this node.
+type ChannelHealth struct {
+	ChannelId        int     `json:"channel_id"`
+	State            string  `json:"state"`
+	Requests         int     `json:"requests"`
+	Failures         int     `json:"failures"`
+	ErrorRate        float64 `json:"error_rate"`
+	AvgLatency       int64   `json:"avg_latency"`        // milliseconds
+	AvgFirstResponse int64   `json:"avg_first_response"` // time to first token, milliseconds
+	Score            float64 `json:"score"`
+	OpenedAt         int64   `json:"opened_at"`
+}
+
+var channelHealths = make(map[int]*channelHealth)
+var channelHealthsLock sync.Mutex
+
+func (h *channelHealth) prune(now int64) {
+	start := now - int64(channelHealthWindow.Seconds())
+	i := 0
+	for i < len(h.buckets) && h.buckets[i].start < start {
+		i++
+	}
+	h.buckets = h.buckets[i:]
+}
+
+func (h *channelHealth) stats() (requests int, failures int, avgLatency int64) {
+	var latencySum, latencyCount int64
+	for _, bucket := range h.buckets {
+		requests += bucket.requests
+		failures += bucket.failures
+		latencySum += bucket.latencySum
+		latencyCount += bucket.latencyCount
+	}
+	if latencyCount > 0 {
+		avgLatency = latencySum / latencyCount
+	}
+	return
+}
+
+// score is the success rate, scaled down when the average latency is above
+// the channel test threshold.
+func (h *channelHealth) score() float64 {
+	requests, failures, avgLatency := h.stats()
+	if requests == 0 {
+		return 1
+	}
+	score := 1 - float64(failures)/float64(requests)
+	threshold := common.ChannelDisableThreshold * 1000
+	if threshold > 0 && float64(avgLatency) > threshold {
+		score *= threshold / float64(avgLatency)
+	}
+	return score
+}
+
+func getChannelHealth(channelId int) *channelHealth {
+	h, ok := channelHealths[channelId]
+	if !ok {
+		h = &channelHealth{state: CircuitClosed}
+		channelHealths[channelId] = h
+	}
+	return h
+}
+
+func (h *channelHealth) currentBucket(now time.Time) *channelHealthBucket {
+	h.prune(now.Unix())
+	bucketStart := now.Truncate(channelHealthBucketSize).Unix()
+	if len(h.buckets) == 0 || h.buckets[len(h.buckets)-1].start != bucketStart {
+		h.buckets = append(h.buckets, &channelHealthBucket{start: bucketStart})
+	}
+	return h.buckets[len(h.buckets)-1]
+}
+
+func (h *channelHealth) avgFirstResponse() (int64, bool) {
+	var sum, count int64
+	for _, bucket := range h.buckets {
+		sum += bucket.firstResponseSum
+		count += bucket.firstResponseCount
+	}
+	if count == 0 {
+		return 0, false
+	}
+	return sum / count, true
+}
+
+// RecordChannelFirstResponse feeds the time to first token of a successful
+// relay, it drives the least latency routing.
+func RecordChannelFirstResponse(channelId int, latency time.Duration) {
+	channelHealthsLock.Lock()
+	defer channelHealthsLock.Unlock()
+	bucket := getChannelHealth(channelId).currentBucket(time.Now())
+	bucket.firstResponseSum += latency.Milliseconds()
+	bucket.firstResponseCount++
+}
+
+// GetChannelFirstResponse returns the average time to first token of a
+// channel, ok is false when there is no recent sample.
+func GetChannelFirstResponse(channelId int) (int64, bool) {
+	channelHealthsLock.Lock()
+	defer channelHealthsLock.Unlock()
+	h, ok := channelHealths[channelId]
+	if !ok {
+		return 0, false
+	}
+	h.prune(time.Now().Unix())
+	return h.avgFirstResponse()
+}
+
+// RecordChannelOutcome feeds the result of a relay to the health of the
+// channel and moves its circuit breaker.
+func RecordChannelOutcome(channelId int, failed bool, latency time.Duration) {
+	channelHealthsLock.Lock()
+	defer channelHealthsLock.Unlock()
+	h := getChannelHealth(channelId)
+	now := time.Now()
+	bucket := h.currentBucket(now)
+	bucket.requests++
+	if failed {
+		bucket.failures++
+	}
+	if latency > 0 {
+		bucket.latencySum += latency.Milliseconds()
+		bucket.latencyCount++
+	}
+	if !setting.CircuitBreakerEnabled {
+		h.state = CircuitClosed
+		return
+	}
+	switch h.state {
+	case CircuitHalfOpen:
+		if failed {
+			h.state = CircuitOpen
+			h.openedAt = now.Unix()
+		} else {
+			// the probe passed, start over with a clean window
+			h.state = CircuitClosed
+			h.buckets = nil
+		}
+	case CircuitClosed:
+		requests, failures, _ := h.stats()
+		if requests >= setting.CircuitBreakerMinRequests &&
+			float64(failures)/float64(requests) >= setting.CircuitBreakerErrorRate {
+			h.state = CircuitOpen
+			h.openedAt = now.Unix()
+			common.SysLog(fmt.Sprintf("circuit breaker opened for channel #%d, error rate %d/%d", channelId, failures, requests))
+		}
+	}
+}
+
+// allows reports whether the breaker lets a request through, an open
+// breaker turns half open after CircuitBreakerOpenSeconds and then lets one
+// probe through every CircuitBreakerOpenSeconds until a result comes back.
+func (h *channelHealth) allows(now int64) bool {
+	openSeconds := int64(setting.CircuitBreakerOpenSeconds)
+	switch h.state {
+	case CircuitOpen:
+		return now-h.openedAt >= openSeconds
+	case CircuitHalfOpen:
+		return now-h.probedAt >= openSeconds
+	}
+	return true
+}
+
+// channelBreakerAllows reports whether a channel may be selected, it only
+// reads the breaker so filtering candidates does not use up probes.
+func channelBreakerAllows(channelId int) bool {
+	if !setting.CircuitBreakerEnabled {
+		return true
+	}
+	channelHealthsLock.Lock()
+	defer channelHealthsLock.Unlock()
+	h, ok := channelHealths[channelId]
+	if !ok {
+		return true
+	}
+	return h.allows(time.Now().Unix())
+}
+
+// claimChannelProbe takes the probe of a channel whose breaker is not closed,
+// it fails when another request took the probe since the channel was picked.
+func claimChannelProbe(channelId int) bool {
+	if !setting.CircuitBreakerEnabled {
+		return true
+	}
+	channelHealthsLock.Lock()
+	defer channelHealthsLock.Unlock()
+	h, ok := channelHealths[channelId]
+	if !ok || h.state == CircuitClosed {
+		return true
+	}
+	now := time.Now().Unix()
+	if !h.allows(now) {
+		return false
+	}
+	h.state = CircuitHalfOpen
+	h.probedAt = now
+	return true
+}
+
+// GetChannelHealthScore is between 0 and 1, channels without recent
+// requests score 1.
+func GetChannelHealthScore(channelId int) float64 {
+	channelHealthsLock.Lock()
+	defer channelHealthsLock.Unlock()
+	h, ok := channelHealths[channelId]
+	if !ok {
+		return 1
+	}
+	h.prune(time.Now().Unix())
+	return h.score()
+}
+
+func GetChannelHealths() []*ChannelHealth {
+	channelHealthsLock.Lock()
+	defer channelHealthsLock.Unlock()
+	now := time.Now().Unix()
+	healths := make([]*ChannelHealth, 0, len(channelHealths))
+	for channelId, h := range channelHealths {
+		h.prune(now)
+		requests, failures, avgLatency := h.stats()
+		health := &ChannelHealth{
+			ChannelId:  channelId,
+			State:      h.state,
+			Requests:   requests,
+			Failures:   failures,
+			AvgLatency: avgLatency,
+			Score:      math.Round(h.score()*1000) / 1000,
+		}
+		health.AvgFirstResponse, _ = h.avgFirstResponse()
+		if requests > 0 {
+			health.ErrorRate = math.Round(float64(failures)/float64(requests)*1000) / 1000
+		}
+		if h.state != CircuitClosed {
+			health.OpenedAt = h.openedAt
+		}
+		healths = append(healths, health)
+	}
+	sort.Slice(healths, func(i, j int) bool {
+		return healths[i].ChannelId < healths[j].ChannelId
+	})
+	return healths
+}
+
+// getChannelSelectionWeight scales the weight of a channel by its health score
+// when the circuit breaker is on.
+func getChannelSelectionWeight(channel *Channel, smoothingFactor int) int {
+	weight := channel.GetWeight() + smoothingFactor
+	if !setting.CircuitBreakerEnabled {
+		return weight
+	}
+	// keep at least 1 so a channel never drops out by its score alone
+	return max(1, int(math.Round(float64(weight)*GetChannelHealthScore(channel.Id))))
+}
+
+// skipOpenChannels drops the channels whose breaker is open, all channels are
+// kept when every breaker is open so requests still have somewhere to go.
+func skipOpenChannels(channels []*Channel) []*Channel {
+	if !setting.CircuitBreakerEnabled {
+		return channels
+	}
+	available := make([]*Channel, 0, len(channels))
+	for _, channel := range channels {
+		if channelBreakerAllows(channel.Id) {
+			available = append(available, channel)
+		}
+	}
+	if len(available) == 0 {
+		return channels
+	}
+	return available
+}
+
+// pickProbedChannel picks a channel and claims its probe, a channel that lost
+// its probe to another request is dropped and the pick repeated. The first
+// pick is kept when no probe can be claimed, like skipOpenChannels does when
+// every breaker is open.
+func pickProbedChannel(channels []*Channel, pick func(channels []*Channel) *Channel) *Channel {
+	first := pick(channels)
+	channel := first
+	for channel != nil && !claimChannelProbe(channel.Id) {
+		remaining := make([]*Channel, 0, len(channels))
+		for _, c := range channels {
+			if c.Id != channel.Id {
+				remaining = append(remaining, c)
+			}
+		}
+		channels = remaining
+		channel = pick(channels)
+	}
+	if channel == nil {
+		return first
+	}
+	return channel
+}
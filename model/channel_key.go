@@ -0,0 +1,353 @@
+package model
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"one-api/common"
+	"one-api/constant"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	MultiKeyModeRoundRobin = "round_robin"
+	MultiKeyModeRandom     = "random"
+)
+
+// ChannelKey holds the state of one key of a multi key channel, the keys
+// themselves stay in Channel.Key and rows are matched by the key hash so
+// editing the pool does not shift them. Rows are created on first use.
+type ChannelKey struct {
+	Id           int    `json:"id"`
+	ChannelId    int    `json:"channel_id" gorm:"uniqueIndex:idx_channel_key_hash"`
+	KeyHash      string `json:"key_hash" gorm:"type:varchar(64);uniqueIndex:idx_channel_key_hash"`
+	Status       int    `json:"status" gorm:"default:1"`
+	StatusReason string `json:"status_reason"`
+	StatusTime   int64  `json:"status_time" gorm:"bigint"`
+	UsedQuota    int64  `json:"used_quota" gorm:"bigint;default:0"`
+	RequestCount int64  `json:"request_count" gorm:"bigint;default:0"`
+}
+
+var channelKeyCounters sync.Map // channel id -> *uint64 for round robin
+
+// disabledChannelKeys is the memory cache of the disabled key hashes of each
+// channel. The inner maps are replaced rather than changed once published, so
+// they can be read after the lock is released.
+var disabledChannelKeys map[int]map[string]bool
+var disabledChannelKeysLock sync.RWMutex
+
+// Without the memory cache the disabled key hashes of a channel are cached in
+// Redis or, without it, in disabledChannelKeysCache until they expire.
+type disabledChannelKeysCacheEntry struct {
+	keyHashes []string
+	expiresAt int64
+}
+
+var disabledChannelKeysCache = make(map[int]*disabledChannelKeysCacheEntry)
+var disabledChannelKeysCacheLock sync.Mutex
+
+func GetChannelKeyHash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func (channel *Channel) GetMultiKeyMode() string {
+	if channel.MultiKeyMode == nil {
+		return ""
+	}
+	return *channel.MultiKeyMode
+}
+
+func (channel *Channel) IsMultiKey() bool {
+	return channel.GetMultiKeyMode() != ""
+}
+
+// GetKeys returns the key pool of a multi key channel, a single key channel
+// has exactly one key.
+func (channel *Channel) GetKeys() []string {
+	if !channel.IsMultiKey() {
+		return []string{channel.Key}
+	}
+	keys := make([]string, 0)
+	for _, key := range strings.Split(channel.Key, "\n") {
+		key = strings.TrimSpace(key)
+		if key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// SelectKey picks the key for a request, multi key channels rotate over the
+// enabled keys of the pool. The key hash is empty for single key channels.
+func (channel *Channel) SelectKey() (string, string) {
+	if !channel.IsMultiKey() {
+		return channel.Key, ""
+	}
+	keys := channel.GetKeys()
+	if len(keys) == 0 {
+		return "", ""
+	}
+	disabled := getDisabledChannelKeys(channel.Id)
+	enabledKeys := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if !disabled[GetChannelKeyHash(key)] {
+			enabledKeys = append(enabledKeys, key)
+		}
+	}
+	if len(enabledKeys) == 0 {
+		// the channel is disabled with its last key, keep requests in flight working
+		enabledKeys = keys
+	}
+	var key string
+	if channel.GetMultiKeyMode() == MultiKeyModeRandom {
+		key = enabledKeys[rand.Intn(len(enabledKeys))]
+	} else {
+		counter, _ := channelKeyCounters.LoadOrStore(channel.Id, new(uint64))
+		index := atomic.AddUint64(counter.(*uint64), 1) - 1
+		key = enabledKeys[index%uint64(len(enabledKeys))]
+	}
+	return key, GetChannelKeyHash(key)
+}
+
+func getDisabledChannelKeys(channelId int) map[string]bool {
+	if common.MemoryCacheEnabled {
+		disabledChannelKeysLock.RLock()
+		defer disabledChannelKeysLock.RUnlock()
+		return disabledChannelKeys[channelId]
+	}
+	keyHashes, ok := cacheGetDisabledChannelKeys(channelId)
+	if !ok {
+		err := DB.Model(&ChannelKey{}).Where("channel_id = ? and status != ?", channelId, common.ChannelStatusEnabled).Pluck("key_hash", &keyHashes).Error
+		if err != nil {
+			common.SysError("failed to get disabled channel keys: " + err.Error())
+			return nil
+		}
+		cacheSetDisabledChannelKeys(channelId, keyHashes)
+	}
+	disabled := make(map[string]bool, len(keyHashes))
+	for _, keyHash := range keyHashes {
+		disabled[keyHash] = true
+	}
+	return disabled
+}
+
+func getDisabledChannelKeysCacheKey(channelId int) string {
+	return fmt.Sprintf(constant.ChannelDisabledKeysKeyFmt, channelId)
+}
+
+func channelKeyCacheDuration() time.Duration {
+	return time.Duration(constant.ChannelKeyCacheSeconds) * time.Second
+}
+
+func cacheGetDisabledChannelKeys(channelId int) ([]string, bool) {
+	if common.RedisEnabled {
+		value, err := common.RedisGet(getDisabledChannelKeysCacheKey(channelId))
+		if err != nil {
+			return nil, false
+		}
+		var keyHashes []string
+		if json.Unmarshal([]byte(value), &keyHashes) != nil {
+			return nil, false
+		}
+		return keyHashes, true
+	}
+	disabledChannelKeysCacheLock.Lock()
+	defer disabledChannelKeysCacheLock.Unlock()
+	entry, ok := disabledChannelKeysCache[channelId]
+	if !ok || entry.expiresAt <= time.Now().Unix() {
+		return nil, false
+	}
+	return entry.keyHashes, true
+}
+
+func cacheSetDisabledChannelKeys(channelId int, keyHashes []string) {
+	if keyHashes == nil {
+		keyHashes = []string{}
+	}
+	if common.RedisEnabled {
+		data, _ := json.Marshal(keyHashes)
+		err := common.RedisSet(getDisabledChannelKeysCacheKey(channelId), string(data), channelKeyCacheDuration())
+		if err != nil {
+			common.SysError("failed to cache disabled channel keys: " + err.Error())
+		}
+		return
+	}
+	disabledChannelKeysCacheLock.Lock()
+	defer disabledChannelKeysCacheLock.Unlock()
+	disabledChannelKeysCache[channelId] = &disabledChannelKeysCacheEntry{
+		keyHashes: keyHashes,
+		expiresAt: time.Now().Add(channelKeyCacheDuration()).Unix(),
+	}
+}
+
+func invalidateDisabledChannelKeysCache(channelId int) {
+	if common.RedisEnabled {
+		err := common.RedisDel(getDisabledChannelKeysCacheKey(channelId))
+		if err != nil {
+			common.SysError("failed to invalidate disabled channel keys cache: " + err.Error())
+		}
+		return
+	}
+	disabledChannelKeysCacheLock.Lock()
+	defer disabledChannelKeysCacheLock.Unlock()
+	delete(disabledChannelKeysCache, channelId)
+}
+
+func initDisabledChannelKeysCache() {
+	var channelKeys []*ChannelKey
+	DB.Where("status != ?", common.ChannelStatusEnabled).Find(&channelKeys)
+	newDisabledChannelKeys := make(map[int]map[string]bool)
+	for _, channelKey := range channelKeys {
+		if newDisabledChannelKeys[channelKey.ChannelId] == nil {
+			newDisabledChannelKeys[channelKey.ChannelId] = make(map[string]bool)
+		}
+		newDisabledChannelKeys[channelKey.ChannelId][channelKey.KeyHash] = true
+	}
+	disabledChannelKeysLock.Lock()
+	disabledChannelKeys = newDisabledChannelKeys
+	disabledChannelKeysLock.Unlock()
+}
+
+func GetChannelKeys(channelId int) ([]*ChannelKey, error) {
+	var channelKeys []*ChannelKey
+	err := DB.Where("channel_id = ?", channelId).Find(&channelKeys).Error
+	return channelKeys, err
+}
+
+// UpdateChannelKeyStatus sets the status of one key and returns how many keys
+// of the channel are still enabled.
+func UpdateChannelKeyStatus(channel *Channel, keyHash string, status int, reason string) (int, error) {
+	result := DB.Model(&ChannelKey{}).Where("channel_id = ? and key_hash = ?", channel.Id, keyHash).Updates(map[string]interface{}{
+		"status":        status,
+		"status_reason": reason,
+		"status_time":   common.GetTimestamp(),
+	})
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	if result.RowsAffected == 0 {
+		err := DB.Create(&ChannelKey{
+			ChannelId:    channel.Id,
+			KeyHash:      keyHash,
+			Status:       status,
+			StatusReason: reason,
+			StatusTime:   common.GetTimestamp(),
+		}).Error
+		if err != nil {
+			return 0, err
+		}
+	}
+	if common.MemoryCacheEnabled {
+		disabledChannelKeysLock.Lock()
+		channelDisabledKeys := make(map[string]bool, len(disabledChannelKeys[channel.Id])+1)
+		for disabledKeyHash := range disabledChannelKeys[channel.Id] {
+			channelDisabledKeys[disabledKeyHash] = true
+		}
+		if status == common.ChannelStatusEnabled {
+			delete(channelDisabledKeys, keyHash)
+		} else {
+			channelDisabledKeys[keyHash] = true
+		}
+		if disabledChannelKeys == nil {
+			disabledChannelKeys = make(map[int]map[string]bool)
+		}
+		disabledChannelKeys[channel.Id] = channelDisabledKeys
+		disabledChannelKeysLock.Unlock()
+	} else {
+		invalidateDisabledChannelKeysCache(channel.Id)
+	}
+
+	var disabledKeyHashes []string
+	err := DB.Model(&ChannelKey{}).Where("channel_id = ? and status != ?", channel.Id, common.ChannelStatusEnabled).Pluck("key_hash", &disabledKeyHashes).Error
+	if err != nil {
+		return 0, err
+	}
+	disabled := make(map[string]bool, len(disabledKeyHashes))
+	for _, disabledKeyHash := range disabledKeyHashes {
+		disabled[disabledKeyHash] = true
+	}
+	enabledCount := 0
+	for _, key := range channel.GetKeys() {
+		if !disabled[GetChannelKeyHash(key)] {
+			enabledCount++
+		}
+	}
+	return enabledCount, nil
+}
+
+type channelKeyUsage struct {
+	quota int64
+	count int64
+}
+
+var channelKeyUsages = make(map[int]map[string]*channelKeyUsage)
+var channelKeyUsagesLock sync.Mutex
+
+// UpdateChannelKeyUsedQuota counts a billed request against the key it used,
+// in batch mode the usage is added up and flushed with the other counters.
+func UpdateChannelKeyUsedQuota(channelId int, keyHash string, quota int) {
+	if keyHash == "" {
+		return
+	}
+	if common.BatchUpdateEnabled {
+		channelKeyUsagesLock.Lock()
+		defer channelKeyUsagesLock.Unlock()
+		if channelKeyUsages[channelId] == nil {
+			channelKeyUsages[channelId] = make(map[string]*channelKeyUsage)
+		}
+		usage, ok := channelKeyUsages[channelId][keyHash]
+		if !ok {
+			usage = &channelKeyUsage{}
+			channelKeyUsages[channelId][keyHash] = usage
+		}
+		usage.quota += int64(quota)
+		usage.count++
+		return
+	}
+	err := increaseChannelKeyUsedQuota(channelId, keyHash, int64(quota), 1)
+	if err != nil {
+		common.SysError("failed to update channel key used quota: " + err.Error())
+	}
+}
+
+// increaseChannelKeyUsedQuota creates the row of a key on first use, so two
+// requests racing on a new key do not both try to insert it.
+func increaseChannelKeyUsedQuota(channelId int, keyHash string, quota int64, count int64) error {
+	return DB.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "channel_id"}, {Name: "key_hash"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"used_quota":    gorm.Expr("channel_keys.used_quota + ?", quota),
+			"request_count": gorm.Expr("channel_keys.request_count + ?", count),
+		}),
+	}).Create(&ChannelKey{
+		ChannelId:    channelId,
+		KeyHash:      keyHash,
+		Status:       common.ChannelStatusEnabled,
+		UsedQuota:    quota,
+		RequestCount: count,
+	}).Error
+}
+
+func batchUpdateChannelKeyUsedQuota() {
+	channelKeyUsagesLock.Lock()
+	usages := channelKeyUsages
+	channelKeyUsages = make(map[int]map[string]*channelKeyUsage)
+	channelKeyUsagesLock.Unlock()
+	for channelId, keyUsages := range usages {
+		for keyHash, usage := range keyUsages {
+			err := increaseChannelKeyUsedQuota(channelId, keyHash, usage.quota, usage.count)
+			if err != nil {
+				common.SysError("failed to batch update channel key used quota: " + err.Error())
+			}
+		}
+	}
+}
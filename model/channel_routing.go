@@ -0,0 +1,122 @@
+package model
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/rand"
+	"one-api/constant"
+	"one-api/setting"
+	"strconv"
+	"strings"
+)
+
+// ChannelRouteKey tells who a request belongs to, the sticky strategies use
+// it to send the same caller to the same channel for upstream cache hits.
+type ChannelRouteKey struct {
+	UserId  int
+	TokenId int
+}
+
+func (channel *Channel) GetCostRatio() float64 {
+	if !strings.Contains(channel.Setting, constant.CostRatio) {
+		return 1
+	}
+	switch v := channel.GetSetting()[constant.CostRatio].(type) {
+	case float64:
+		return v
+	case string:
+		ratio, err := strconv.ParseFloat(v, 64)
+		if err == nil {
+			return ratio
+		}
+	}
+	return 1
+}
+
+// pickChannel chooses among channels of the same priority with the routing
+// strategy configured for the group and model.
+func pickChannel(channels []*Channel, group string, model string, routeKey ChannelRouteKey) *Channel {
+	if len(channels) == 0 {
+		return nil
+	}
+	switch setting.GetChannelRoutingStrategy(group, model) {
+	case setting.RoutingLeastLatency:
+		// channels without a recent sample score 0 so they get measured
+		return pickLowestChannel(channels, func(channel *Channel) float64 {
+			latency, _ := GetChannelFirstResponse(channel.Id)
+			return float64(latency)
+		})
+	case setting.RoutingLowestCost:
+		return pickLowestChannel(channels, func(channel *Channel) float64 {
+			return channel.GetCostRatio()
+		})
+	case setting.RoutingLeastOutstanding:
+		return pickLowestChannel(channels, func(channel *Channel) float64 {
+			return float64(GetChannelOutstanding(channel.Id))
+		})
+	case setting.RoutingStickyToken:
+		return pickStickyChannel(channels, fmt.Sprintf("token:%d", routeKey.TokenId))
+	case setting.RoutingStickyUser:
+		return pickStickyChannel(channels, fmt.Sprintf("user:%d", routeKey.UserId))
+	}
+	return pickWeightedChannel(channels)
+}
+
+// pickLowestChannel keeps the channels with the lowest value, ties are
+// broken by weight.
+func pickLowestChannel(channels []*Channel, value func(channel *Channel) float64) *Channel {
+	lowest := math.Inf(1)
+	var candidates []*Channel
+	for _, channel := range channels {
+		v := value(channel)
+		if v < lowest {
+			lowest = v
+			candidates = candidates[:0]
+		}
+		if v == lowest {
+			candidates = append(candidates, channel)
+		}
+	}
+	return pickWeightedChannel(candidates)
+}
+
+// pickStickyChannel uses weighted rendezvous hashing, a key keeps its
+// channel as long as that channel stays available and only the keys of a
+// removed channel move.
+func pickStickyChannel(channels []*Channel, key string) *Channel {
+	var best *Channel
+	bestScore := math.Inf(-1)
+	for _, channel := range channels {
+		sum := sha256.Sum256([]byte(key + "/" + strconv.Itoa(channel.Id)))
+		// map the hash into (0, 1)
+		h := (float64(binary.BigEndian.Uint64(sum[:8])>>11) + 0.5) / (1 << 53)
+		score := float64(channel.GetWeight()+10) / -math.Log(h)
+		if score > bestScore {
+			best = channel
+			bestScore = score
+		}
+	}
+	return best
+}
+
+func pickWeightedChannel(channels []*Channel) *Channel {
+	// 平滑系数
+	smoothingFactor := 10
+	totalWeight := 0
+	weights := make([]int, len(channels))
+	for i, channel := range channels {
+		weights[i] = getChannelSelectionWeight(channel, smoothingFactor)
+		totalWeight += weights[i]
+	}
+	// Generate a random value in the range [0, totalWeight)
+	randomWeight := rand.Intn(totalWeight)
+	for i, channel := range channels {
+		randomWeight -= weights[i]
+		if randomWeight < 0 {
+			return channel
+		}
+	}
+	return channels[len(channels)-1]
+}
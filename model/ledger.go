@@ -0,0 +1,373 @@
+package model
+
+import (
+	"one-api/common"
+	relaycommon "one-api/relay/common"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	LedgerSourceTopUp      = "topup"
+	LedgerSourceRefund     = "refund"
+	LedgerSourceRedemption = "redemption"
+	LedgerSourceRelay      = "relay"
+	LedgerSourceAdmin      = "admin"
+	LedgerSourceAffiliate  = "affiliate"
+	LedgerSourceGift       = "gift"
+	LedgerSourceAllowance  = "allowance"
+	LedgerSourceOpening    = "opening"
+)
+
+const (
+	ledgerUserPrefix   = "user:"
+	ledgerTokenPrefix  = "token:"
+	ledgerSystemPrefix = "system:"
+)
+
+// QuotaRef says where a quota change comes from, Reference identifies it
+// within the source, e.g. the trade no of a top-up or the request id of a
+// relay request.
+type QuotaRef struct {
+	Source    string
+	Reference string
+}
+
+// QuotaLedger is an append-only journal entry moving Amount of quota from
+// DebitAccount to CreditAccount. User and token balances are the accounts
+// user:<id> and token:<id>, the other side of a change is the account
+// system:<source> so that every entry balances.
+type QuotaLedger struct {
+	Id            int    `json:"id"`
+	CreatedAt     int64  `json:"created_at" gorm:"bigint;index"`
+	UserId        int    `json:"user_id" gorm:"index"`
+	DebitAccount  string `json:"debit_account" gorm:"type:varchar(64);index"`
+	CreditAccount string `json:"credit_account" gorm:"type:varchar(64);index"`
+	Amount        int    `json:"amount"`
+	Source        string `json:"source" gorm:"type:varchar(32);index"`
+	Reference     string `json:"reference" gorm:"type:varchar(128);index"`
+}
+
+// QuotaDiscrepancy is an account whose ledger balance did not match its
+// stored quota in the last reconciliation. CacheQuota is only set when the
+// quota was cached in Redis, Occurrences counts the runs in a row that saw it.
+type QuotaDiscrepancy struct {
+	Id          int    `json:"id"`
+	Account     string `json:"account" gorm:"type:varchar(64);uniqueIndex"`
+	UserId      int    `json:"user_id" gorm:"index"`
+	LedgerQuota int64  `json:"ledger_quota"`
+	DBQuota     int64  `json:"db_quota"`
+	CacheQuota  *int64 `json:"cache_quota"`
+	Diff        int64  `json:"diff"`
+	Occurrences int    `json:"occurrences"`
+	FirstSeenAt int64  `json:"first_seen_at" gorm:"bigint"`
+	LastSeenAt  int64  `json:"last_seen_at" gorm:"bigint;index"`
+}
+
+func UserLedgerAccount(userId int) string {
+	return ledgerUserPrefix + strconv.Itoa(userId)
+}
+
+func TokenLedgerAccount(tokenId int) string {
+	return ledgerTokenPrefix + strconv.Itoa(tokenId)
+}
+
+// RelayQuotaRef refers to the relay request the quota is spent on.
+func RelayQuotaRef(relayInfo *relaycommon.RelayInfo) QuotaRef {
+	return QuotaRef{Source: LedgerSourceRelay, Reference: relayInfo.RequestId}
+}
+
+func newQuotaLedger(userId int, account string, amount int, ref QuotaRef) *QuotaLedger {
+	entry := &QuotaLedger{
+		CreatedAt: common.GetTimestamp(),
+		UserId:    userId,
+		Amount:    amount,
+		Source:    ref.Source,
+		Reference: ref.Reference,
+	}
+	system := ledgerSystemPrefix + ref.Source
+	if amount >= 0 {
+		entry.DebitAccount, entry.CreditAccount = system, account
+	} else {
+		entry.DebitAccount, entry.CreditAccount = account, system
+		entry.Amount = -amount
+	}
+	return entry
+}
+
+// recordQuotaLedger journals amount credited to account within tx, a
+// negative amount is a debit. It is called in the transaction that moves the
+// quota so a failed write rolls the change back.
+func recordQuotaLedger(tx *gorm.DB, userId int, account string, amount int, ref QuotaRef) error {
+	if amount == 0 {
+		return nil
+	}
+	return tx.Create(newQuotaLedger(userId, account, amount, ref)).Error
+}
+
+// recordQuotaLedgers writes the entries batched with a quota change within tx.
+func recordQuotaLedgers(tx *gorm.DB, entries []*QuotaLedger) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	return tx.CreateInBatches(entries, 100).Error
+}
+
+func GetQuotaLedgers(userId int, account string, source string, reference string, startIdx int, num int) (entries []*QuotaLedger, total int64, err error) {
+	tx := DB.Model(&QuotaLedger{})
+	if userId != 0 {
+		tx = tx.Where("user_id = ?", userId)
+	}
+	if account != "" {
+		tx = tx.Where("debit_account = ? OR credit_account = ?", account, account)
+	}
+	if source != "" {
+		tx = tx.Where("source = ?", source)
+	}
+	if reference != "" {
+		tx = tx.Where("reference = ?", reference)
+	}
+	err = tx.Count(&total).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	err = tx.Order("id desc").Limit(num).Offset(startIdx).Find(&entries).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	return entries, total, nil
+}
+
+type ledgerAccountSum struct {
+	Account string
+	Amount  int64
+}
+
+// getLedgerBalances returns the balance of every account starting with
+// prefix, keyed by the id that follows it.
+func getLedgerBalances(prefix string) (map[int]int64, error) {
+	balances := make(map[int]int64)
+	for _, side := range []string{"credit_account", "debit_account"} {
+		var sums []*ledgerAccountSum
+		err := DB.Model(&QuotaLedger{}).Select(side+" as account, sum(amount) as amount").
+			Where(side+" LIKE ?", prefix+"%").Group(side).Scan(&sums).Error
+		if err != nil {
+			return nil, err
+		}
+		for _, sum := range sums {
+			id, err := strconv.Atoi(strings.TrimPrefix(sum.Account, prefix))
+			if err != nil {
+				continue
+			}
+			if side == "credit_account" {
+				balances[id] += sum.Amount
+			} else {
+				balances[id] -= sum.Amount
+			}
+		}
+	}
+	return balances, nil
+}
+
+// getOpenedLedgerAccounts returns the accounts that have an opening entry.
+func getOpenedLedgerAccounts() (map[string]bool, error) {
+	var accounts []string
+	err := DB.Model(&QuotaLedger{}).Where("source = ?", LedgerSourceOpening).Pluck("credit_account", &accounts).Error
+	if err != nil {
+		return nil, err
+	}
+	var debitAccounts []string
+	err = DB.Model(&QuotaLedger{}).Where("source = ?", LedgerSourceOpening).Pluck("debit_account", &debitAccounts).Error
+	if err != nil {
+		return nil, err
+	}
+	opened := make(map[string]bool, len(accounts))
+	for _, account := range append(accounts, debitAccounts...) {
+		opened[account] = true
+	}
+	return opened, nil
+}
+
+// pendingBatchUpdate is what this node has yet to write to the quota column
+// and the ledger when batch updates are enabled, pending updates of other
+// nodes are unknown.
+func pendingBatchUpdate(type_ int, id int) int {
+	if !common.BatchUpdateEnabled {
+		return 0
+	}
+	batchUpdateLocks[type_].Lock()
+	defer batchUpdateLocks[type_].Unlock()
+	return batchUpdateStores[type_][id]
+}
+
+// QuotaReconcileResult counts the accounts a reconciliation looked at.
+type QuotaReconcileResult struct {
+	Checked       int `json:"checked"`
+	Opened        int `json:"opened"`
+	Discrepancies int `json:"discrepancies"`
+}
+
+// ledgerCheck compares the committed ledger balance with the quota in the
+// DB, the cache already includes the pending batch updates.
+type ledgerCheck struct {
+	account    string
+	userId     int
+	ledger     int64
+	dbQuota    int64
+	pending    int64
+	cacheQuota *int64
+	opened     bool
+}
+
+// reconcileAccount opens the ledger of an account first seen by the
+// reconciliation with its current balance, or compares the balances and
+// records or clears its discrepancy.
+func reconcileAccount(check *ledgerCheck, result *QuotaReconcileResult, now int64) error {
+	result.Checked++
+	if !check.opened {
+		result.Opened++
+		// written even when zero, it marks the account as opened
+		return DB.Create(newQuotaLedger(check.userId, check.account, int(check.dbQuota-check.ledger),
+			QuotaRef{Source: LedgerSourceOpening})).Error
+	}
+	diff := check.dbQuota - check.ledger
+	if diff == 0 && (check.cacheQuota == nil || *check.cacheQuota == check.ledger+check.pending) {
+		return DB.Where("account = ?", check.account).Delete(&QuotaDiscrepancy{}).Error
+	}
+	result.Discrepancies++
+	discrepancy := &QuotaDiscrepancy{
+		Account:     check.account,
+		UserId:      check.userId,
+		LedgerQuota: check.ledger,
+		DBQuota:     check.dbQuota,
+		CacheQuota:  check.cacheQuota,
+		Diff:        diff,
+		Occurrences: 1,
+		FirstSeenAt: now,
+		LastSeenAt:  now,
+	}
+	return DB.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "account"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"ledger_quota": discrepancy.LedgerQuota,
+			"db_quota":     discrepancy.DBQuota,
+			"cache_quota":  discrepancy.CacheQuota,
+			"diff":         discrepancy.Diff,
+			"occurrences":  gorm.Expr("occurrences + 1"),
+			"last_seen_at": now,
+		}),
+	}).Create(discrepancy).Error
+}
+
+// ReconcileQuotaLedger compares the ledger balance of every user and token
+// with its quota in the DB and, adjusted by pending batch updates, in Redis.
+// Changes in flight while it runs can show up as a discrepancy that is gone
+// by the next run, Occurrences tells them apart from real drift.
+func ReconcileQuotaLedger() (*QuotaReconcileResult, error) {
+	result := &QuotaReconcileResult{}
+	now := common.GetTimestamp()
+	opened, err := getOpenedLedgerAccounts()
+	if err != nil {
+		return nil, err
+	}
+	userBalances, err := getLedgerBalances(ledgerUserPrefix)
+	if err != nil {
+		return nil, err
+	}
+	lastId := 0
+	for {
+		var users []*User
+		err = DB.Select("id", "quota").Where("id > ?", lastId).Order("id asc").Limit(1000).Find(&users).Error
+		if err != nil {
+			return nil, err
+		}
+		if len(users) == 0 {
+			break
+		}
+		lastId = users[len(users)-1].Id
+		for _, user := range users {
+			check := &ledgerCheck{
+				account: UserLedgerAccount(user.Id),
+				userId:  user.Id,
+				ledger:  userBalances[user.Id],
+				dbQuota: int64(user.Quota),
+				pending: int64(pendingBatchUpdate(BatchUpdateTypeUserQuota, user.Id)),
+			}
+			check.opened = opened[check.account]
+			if common.RedisEnabled {
+				if quota, err := getUserQuotaCache(user.Id); err == nil {
+					cacheQuota := int64(quota)
+					check.cacheQuota = &cacheQuota
+				}
+			}
+			if err = reconcileAccount(check, result, now); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	tokenBalances, err := getLedgerBalances(ledgerTokenPrefix)
+	if err != nil {
+		return nil, err
+	}
+	lastId = 0
+	for {
+		var tokens []*Token
+		err = DB.Select("id", "user_id", keyCol, "remain_quota").Where("id > ?", lastId).Order("id asc").Limit(1000).Find(&tokens).Error
+		if err != nil {
+			return nil, err
+		}
+		if len(tokens) == 0 {
+			break
+		}
+		lastId = tokens[len(tokens)-1].Id
+		for _, token := range tokens {
+			check := &ledgerCheck{
+				account: TokenLedgerAccount(token.Id),
+				userId:  token.UserId,
+				ledger:  tokenBalances[token.Id],
+				dbQuota: int64(token.RemainQuota),
+				pending: int64(pendingBatchUpdate(BatchUpdateTypeTokenQuota, token.Id)),
+			}
+			check.opened = opened[check.account]
+			if common.RedisEnabled {
+				if cached, err := cacheGetTokenByKey(token.Key); err == nil && cached != nil {
+					cacheQuota := int64(cached.RemainQuota)
+					check.cacheQuota = &cacheQuota
+				}
+			}
+			if err = reconcileAccount(check, result, now); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// accounts of deleted users and tokens are no longer compared
+	err = DB.Where("last_seen_at < ?", now).Delete(&QuotaDiscrepancy{}).Error
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func GetQuotaDiscrepancies(userId int, minOccurrences int, startIdx int, num int) (discrepancies []*QuotaDiscrepancy, total int64, err error) {
+	tx := DB.Model(&QuotaDiscrepancy{})
+	if userId != 0 {
+		tx = tx.Where("user_id = ?", userId)
+	}
+	if minOccurrences > 0 {
+		tx = tx.Where("occurrences >= ?", minOccurrences)
+	}
+	err = tx.Count(&total).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	err = tx.Order("occurrences desc, id asc").Limit(num).Offset(startIdx).Find(&discrepancies).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	return discrepancies, total, nil
+}
@@ -208,6 +208,30 @@ func migrateDB() error {
 	if err != nil {
 		return err
 	}
+	err = DB.AutoMigrate(&TopUpStatusLog{})
+	if err != nil {
+		return err
+	}
+	err = DB.AutoMigrate(&Plan{})
+	if err != nil {
+		return err
+	}
+	err = DB.AutoMigrate(&Subscription{})
+	if err != nil {
+		return err
+	}
+	err = DB.AutoMigrate(&QuotaSnapshot{})
+	if err != nil {
+		return err
+	}
+	err = DB.AutoMigrate(&QuotaLedger{})
+	if err != nil {
+		return err
+	}
+	err = DB.AutoMigrate(&QuotaDiscrepancy{})
+	if err != nil {
+		return err
+	}
 	err = DB.AutoMigrate(&QuotaData{})
 	if err != nil {
 		return err
@@ -216,6 +240,18 @@ func migrateDB() error {
 	if err != nil {
 		return err
 	}
+	err = DB.AutoMigrate(&RelayObject{})
+	if err != nil {
+		return err
+	}
+	err = DB.AutoMigrate(&StoredFile{})
+	if err != nil {
+		return err
+	}
+	err = DB.AutoMigrate(&ChannelKey{})
+	if err != nil {
+		return err
+	}
 	common.SysLog("database migrated")
 	err = createRootAccountIfNeed()
 	return err
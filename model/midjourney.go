@@ -19,6 +19,7 @@ type Midjourney struct {
 	FailReason  string `json:"fail_reason"`
 	ChannelId   int    `json:"channel_id"`
 	Quota       int    `json:"quota"`
+	PlanQuota   int    `json:"plan_quota"`
 	Buttons     string `json:"buttons"`
 	Properties  string `json:"properties"`
 }
@@ -155,6 +156,13 @@ func (midjourney *Midjourney) Update() error {
 	return err
 }
 
+// UpdatePlanQuota records the part of the quota a subscription plan paid, it
+// is only known once the task was billed.
+func (midjourney *Midjourney) UpdatePlanQuota(planQuota int) error {
+	midjourney.PlanQuota = planQuota
+	return DB.Model(&Midjourney{}).Where("id = ?", midjourney.Id).Update("plan_quota", planQuota).Error
+}
+
 func MjBulkUpdate(mjIds []string, params map[string]any) error {
 	return DB.Model(&Midjourney{}).
 		Where("mj_id in (?)", mjIds).
@@ -69,6 +69,12 @@ func InitOptionMap() {
 	common.OptionMap["EpayKey"] = ""
 	common.OptionMap["Price"] = strconv.FormatFloat(setting.Price, 'f', -1, 64)
 	common.OptionMap["MinTopUp"] = strconv.Itoa(setting.MinTopUp)
+	common.OptionMap["StripeApiSecret"] = ""
+	common.OptionMap["StripeWebhookSecret"] = ""
+	common.OptionMap["StripeCurrency"] = setting.StripeCurrency
+	common.OptionMap["StripePrice"] = strconv.FormatFloat(setting.StripePrice, 'f', -1, 64)
+	common.OptionMap["StripeApiAddress"] = setting.StripeApiAddress
+	common.OptionMap["TopUpOrderExpireMinutes"] = strconv.Itoa(setting.TopUpOrderExpireMinutes)
 	common.OptionMap["TopupGroupRatio"] = common.TopupGroupRatio2JSONString()
 	common.OptionMap["Chats"] = setting.Chats2JsonString()
 	common.OptionMap["GitHubClientId"] = ""
@@ -89,6 +95,12 @@ func InitOptionMap() {
 	common.OptionMap["ModelPrice"] = common.ModelPrice2JSONString()
 	common.OptionMap["GroupRatio"] = setting.GroupRatio2JSONString()
 	common.OptionMap["UserUsableGroups"] = setting.UserUsableGroups2JSONString()
+	common.OptionMap["GroupRateLimit"] = setting.GroupRateLimit2JSONString()
+	common.OptionMap["ChannelRoutingStrategy"] = setting.ChannelRoutingStrategy2JSONString()
+	common.OptionMap["ModelFallback"] = setting.ModelFallback2JSONString()
+	common.OptionMap["ModelFallbackHeaderEnabled"] = strconv.FormatBool(setting.ModelFallbackHeaderEnabled)
+	common.OptionMap["HedgeRequestDelay"] = setting.HedgeRequestDelay2JSONString()
+	common.OptionMap["LogLevel"] = common.LogLevel
 	common.OptionMap["CompletionRatio"] = common.CompletionRatio2JSONString()
 	common.OptionMap["TopUpLink"] = common.TopUpLink
 	common.OptionMap["ChatLink"] = common.ChatLink
@@ -98,6 +110,24 @@ func InitOptionMap() {
 	common.OptionMap["DataExportInterval"] = strconv.Itoa(common.DataExportInterval)
 	common.OptionMap["DataExportDefaultTime"] = common.DataExportDefaultTime
 	common.OptionMap["DefaultCollapseSidebar"] = strconv.FormatBool(common.DefaultCollapseSidebar)
+	common.OptionMap["BatchRatio"] = strconv.FormatFloat(setting.BatchRatio, 'f', -1, 64)
+	common.OptionMap["ResponseCacheTTL"] = strconv.Itoa(setting.ResponseCacheTTL)
+	common.OptionMap["ResponseCacheRatio"] = strconv.FormatFloat(setting.ResponseCacheRatio, 'f', -1, 64)
+	common.OptionMap["ResponseCacheMaxSize"] = strconv.Itoa(setting.ResponseCacheMaxSize)
+	common.OptionMap["ResponseCacheScope"] = setting.ResponseCacheScope
+	common.OptionMap["StorageType"] = setting.StorageType
+	common.OptionMap["StorageLocalPath"] = setting.StorageLocalPath
+	common.OptionMap["StorageS3Endpoint"] = setting.StorageS3Endpoint
+	common.OptionMap["StorageS3Region"] = setting.StorageS3Region
+	common.OptionMap["StorageS3Bucket"] = setting.StorageS3Bucket
+	common.OptionMap["StorageS3AccessKey"] = setting.StorageS3AccessKey
+	common.OptionMap["StorageS3SecretKey"] = setting.StorageS3SecretKey
+	common.OptionMap["StorageRetentionDays"] = strconv.Itoa(setting.StorageRetentionDays)
+	common.OptionMap["StorageMaxSize"] = strconv.Itoa(setting.StorageMaxSize)
+	common.OptionMap["CircuitBreakerEnabled"] = strconv.FormatBool(setting.CircuitBreakerEnabled)
+	common.OptionMap["CircuitBreakerErrorRate"] = strconv.FormatFloat(setting.CircuitBreakerErrorRate, 'f', -1, 64)
+	common.OptionMap["CircuitBreakerMinRequests"] = strconv.Itoa(setting.CircuitBreakerMinRequests)
+	common.OptionMap["CircuitBreakerOpenSeconds"] = strconv.Itoa(setting.CircuitBreakerOpenSeconds)
 	common.OptionMap["MjNotifyEnabled"] = strconv.FormatBool(setting.MjNotifyEnabled)
 	common.OptionMap["MjAccountFilterEnabled"] = strconv.FormatBool(setting.MjAccountFilterEnabled)
 	common.OptionMap["MjModeClearEnabled"] = strconv.FormatBool(setting.MjModeClearEnabled)
@@ -228,6 +258,10 @@ func updateOptionMap(key string, value string) (err error) {
 			setting.StopOnSensitiveEnabled = boolValue
 		case "SMTPSSLEnabled":
 			common.SMTPSSLEnabled = boolValue
+		case "CircuitBreakerEnabled":
+			setting.CircuitBreakerEnabled = boolValue
+		case "ModelFallbackHeaderEnabled":
+			setting.ModelFallbackHeaderEnabled = boolValue
 		}
 	}
 	switch key {
@@ -264,6 +298,18 @@ func updateOptionMap(key string, value string) (err error) {
 		setting.Price, _ = strconv.ParseFloat(value, 64)
 	case "MinTopUp":
 		setting.MinTopUp, _ = strconv.Atoi(value)
+	case "StripeApiSecret":
+		setting.StripeApiSecret = value
+	case "StripeWebhookSecret":
+		setting.StripeWebhookSecret = value
+	case "StripeCurrency":
+		setting.StripeCurrency = strings.ToLower(value)
+	case "StripePrice":
+		setting.StripePrice, _ = strconv.ParseFloat(value, 64)
+	case "StripeApiAddress":
+		setting.StripeApiAddress = strings.TrimRight(value, "/")
+	case "TopUpOrderExpireMinutes":
+		setting.TopUpOrderExpireMinutes, _ = strconv.Atoi(value)
 	case "TopupGroupRatio":
 		err = common.UpdateTopupGroupRatioByJSONString(value)
 	case "GitHubClientId":
@@ -316,6 +362,16 @@ func updateOptionMap(key string, value string) (err error) {
 		err = setting.UpdateGroupRatioByJSONString(value)
 	case "UserUsableGroups":
 		err = setting.UpdateUserUsableGroupsByJSONString(value)
+	case "GroupRateLimit":
+		err = setting.UpdateGroupRateLimitByJSONString(value)
+	case "ChannelRoutingStrategy":
+		err = setting.UpdateChannelRoutingStrategyByJSONString(value)
+	case "ModelFallback":
+		err = setting.UpdateModelFallbackByJSONString(value)
+	case "HedgeRequestDelay":
+		err = setting.UpdateHedgeRequestDelayByJSONString(value)
+	case "LogLevel":
+		common.LogLevel = value
 	case "CompletionRatio":
 		err = common.UpdateCompletionRatioByJSONString(value)
 	case "ModelPrice":
@@ -330,6 +386,40 @@ func updateOptionMap(key string, value string) (err error) {
 		common.ChannelDisableThreshold, _ = strconv.ParseFloat(value, 64)
 	case "QuotaPerUnit":
 		common.QuotaPerUnit, _ = strconv.ParseFloat(value, 64)
+	case "BatchRatio":
+		setting.BatchRatio, _ = strconv.ParseFloat(value, 64)
+	case "ResponseCacheTTL":
+		setting.ResponseCacheTTL, _ = strconv.Atoi(value)
+	case "ResponseCacheRatio":
+		setting.ResponseCacheRatio, _ = strconv.ParseFloat(value, 64)
+	case "ResponseCacheMaxSize":
+		setting.ResponseCacheMaxSize, _ = strconv.Atoi(value)
+	case "ResponseCacheScope":
+		setting.ResponseCacheScope = value
+	case "StorageType":
+		setting.StorageType = value
+	case "StorageLocalPath":
+		setting.StorageLocalPath = value
+	case "StorageS3Endpoint":
+		setting.StorageS3Endpoint = value
+	case "StorageS3Region":
+		setting.StorageS3Region = value
+	case "StorageS3Bucket":
+		setting.StorageS3Bucket = value
+	case "StorageS3AccessKey":
+		setting.StorageS3AccessKey = value
+	case "StorageS3SecretKey":
+		setting.StorageS3SecretKey = value
+	case "StorageRetentionDays":
+		setting.StorageRetentionDays, _ = strconv.Atoi(value)
+	case "StorageMaxSize":
+		setting.StorageMaxSize, _ = strconv.Atoi(value)
+	case "CircuitBreakerErrorRate":
+		setting.CircuitBreakerErrorRate, _ = strconv.ParseFloat(value, 64)
+	case "CircuitBreakerMinRequests":
+		setting.CircuitBreakerMinRequests, _ = strconv.Atoi(value)
+	case "CircuitBreakerOpenSeconds":
+		setting.CircuitBreakerOpenSeconds, _ = strconv.Atoi(value)
 	case "SensitiveWords":
 		setting.SensitiveWordsFromString(value)
 	case "StreamCacheQueueLength":
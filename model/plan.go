@@ -0,0 +1,111 @@
+package model
+
+import (
+	"errors"
+	"one-api/common"
+	"strings"
+)
+
+const (
+	PlanStatusEnabled  = 1
+	PlanStatusDisabled = 2
+)
+
+// Plan is a subscription offer, Price is in the same unit as a top-up amount
+// and converted with the price of the payment provider. AllowedGroups and
+// AllowedModels are comma separated, empty allows all of them.
+type Plan struct {
+	Id            int     `json:"id"`
+	Name          string  `json:"name" gorm:"index"`
+	Description   string  `json:"description"`
+	Price         float64 `json:"price"`
+	PeriodDays    int     `json:"period_days" gorm:"default:30"`
+	Quota         int     `json:"quota"`
+	AllowedGroups string  `json:"allowed_groups"`
+	AllowedModels string  `json:"allowed_models"`
+	RPM           int     `json:"rpm"`
+	Status        int     `json:"status" gorm:"default:1"`
+	CreatedTime   int64   `json:"created_time" gorm:"bigint"`
+}
+
+func containsCommaSeparated(list string, value string) bool {
+	if list == "" {
+		return true
+	}
+	for _, item := range strings.Split(list, ",") {
+		if strings.TrimSpace(item) == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Covers reports whether requests of group for modelName are billed to the plan.
+func (plan *Plan) Covers(group string, modelName string) bool {
+	return containsCommaSeparated(plan.AllowedGroups, group) && containsCommaSeparated(plan.AllowedModels, modelName)
+}
+
+func (plan *Plan) Validate() error {
+	if plan.Name == "" {
+		return errors.New("套餐名称不能为空")
+	}
+	if plan.Price < 0 || plan.Quota < 0 || plan.RPM < 0 {
+		return errors.New("价格、额度和 RPM 不能为负数")
+	}
+	if plan.PeriodDays <= 0 {
+		return errors.New("周期天数必须大于 0")
+	}
+	return nil
+}
+
+func GetAllPlans(enabledOnly bool) (plans []*Plan, err error) {
+	tx := DB.Order("id asc")
+	if enabledOnly {
+		tx = tx.Where("status = ?", PlanStatusEnabled)
+	}
+	err = tx.Find(&plans).Error
+	return plans, err
+}
+
+func GetPlanById(id int) (*Plan, error) {
+	if id == 0 {
+		return nil, errors.New("id 为空！")
+	}
+	plan := Plan{Id: id}
+	err := DB.First(&plan, "id = ?", id).Error
+	return &plan, err
+}
+
+func (plan *Plan) Insert() error {
+	plan.CreatedTime = common.GetTimestamp()
+	return DB.Create(plan).Error
+}
+
+func (plan *Plan) Update() error {
+	err := DB.Model(plan).Select("name", "description", "price", "period_days", "quota", "allowed_groups", "allowed_models", "rpm", "status").Updates(plan).Error
+	if err != nil {
+		return err
+	}
+	invalidatePlanCache(plan.Id)
+	return nil
+}
+
+func DeletePlanById(id int) error {
+	if id == 0 {
+		return errors.New("id 为空！")
+	}
+	var count int64
+	err := DB.Model(&Subscription{}).Where("plan_id = ? AND status = ?", id, SubscriptionStatusActive).Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return errors.New("该套餐仍有生效中的订阅，请先停用")
+	}
+	err = DB.Delete(&Plan{}, "id = ?", id).Error
+	if err != nil {
+		return err
+	}
+	invalidatePlanCache(id)
+	return nil
+}
@@ -139,7 +139,11 @@ func Redeem(key string, userId int) (quota int, err error) {
 		redemption.Status = common.RedemptionCodeStatusUsed
 		redemption.UsedUserId = userId
 		err = tx.Save(redemption).Error
-		return err
+		if err != nil {
+			return err
+		}
+		return recordQuotaLedger(tx, userId, UserLedgerAccount(userId), redemption.Quota,
+			QuotaRef{Source: LedgerSourceRedemption, Reference: strconv.Itoa(redemption.Id)})
 	})
 	if err != nil {
 		return 0, errors.New("兑换失败，" + err.Error())
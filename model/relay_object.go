@@ -0,0 +1,75 @@
+package model
+
+import (
+	"encoding/json"
+)
+
+const (
+	RelayObjectFile  = "file"
+	RelayObjectBatch = "batch"
+)
+
+// RelayObject records the user, token and channel a file or batch was created
+// with. Upstream ids are only valid on the channel that issued them, so later
+// requests on the id are sent back to that channel.
+type RelayObject struct {
+	Id        int             `json:"id"`
+	ObjectId  string          `json:"object_id" gorm:"type:varchar(64);uniqueIndex"`
+	Object    string          `json:"object" gorm:"type:varchar(16);index"`
+	UserId    int             `json:"user_id" gorm:"index"`
+	TokenId   int             `json:"token_id" gorm:"index"`
+	ChannelId int             `json:"channel_id" gorm:"index"`
+	Group     string          `json:"group" gorm:"type:varchar(64)"`
+	CreatedAt int64           `json:"created_at" gorm:"bigint;index"`
+	Data      json.RawMessage `json:"data" gorm:"type:json"`
+}
+
+func GetRelayObject(objectId string) (*RelayObject, bool, error) {
+	var relayObject *RelayObject
+	err := DB.Where("object_id = ?", objectId).First(&relayObject).Error
+	exist, err := RecordExist(err)
+	if err != nil {
+		return nil, false, err
+	}
+	return relayObject, exist, nil
+}
+
+func GetUserRelayObject(userId int, objectId string) (*RelayObject, bool, error) {
+	var relayObject *RelayObject
+	err := DB.Where("user_id = ? and object_id = ?", userId, objectId).First(&relayObject).Error
+	exist, err := RecordExist(err)
+	if err != nil {
+		return nil, false, err
+	}
+	return relayObject, exist, nil
+}
+
+// GetUserRelayObjects lists the newest objects of a kind, after is the object
+// id of the last item of the previous page.
+func GetUserRelayObjects(userId int, object string, after string, limit int) ([]*RelayObject, error) {
+	var relayObjects []*RelayObject
+	query := DB.Where("user_id = ? and object = ?", userId, object)
+	if after != "" {
+		afterObject, exist, err := GetUserRelayObject(userId, after)
+		if err != nil {
+			return nil, err
+		}
+		if exist {
+			query = query.Where("id < ?", afterObject.Id)
+		}
+	}
+	err := query.Order("id desc").Limit(limit).Find(&relayObjects).Error
+	return relayObjects, err
+}
+
+func (relayObject *RelayObject) Insert() error {
+	return DB.Create(relayObject).Error
+}
+
+func (relayObject *RelayObject) Update() error {
+	return DB.Save(relayObject).Error
+}
+
+func (relayObject *RelayObject) Delete() error {
+	return DB.Delete(relayObject).Error
+}
@@ -0,0 +1,194 @@
+package model
+
+import (
+	"one-api/common"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// QuotaSnapshot is the quota of a user at the start of Month (2006-01), it is
+// the opening balance of that month's statement.
+type QuotaSnapshot struct {
+	Id        int    `json:"id"`
+	UserId    int    `json:"user_id" gorm:"uniqueIndex:idx_snapshot_user_month,priority:1"`
+	Month     string `json:"month" gorm:"type:varchar(7);uniqueIndex:idx_snapshot_user_month,priority:2"`
+	Quota     int    `json:"quota"`
+	CreatedAt int64  `json:"created_at" gorm:"bigint"`
+}
+
+// UserQuotaTotal is the number of records and the quota they add up to for a
+// user over a statement period. PlanQuota is only summed for consumption, it
+// is the part of Quota subscription plans paid.
+type UserQuotaTotal struct {
+	UserId    int   `json:"user_id"`
+	Count     int64 `json:"count"`
+	Quota     int64 `json:"quota"`
+	PlanQuota int64 `json:"plan_quota"`
+}
+
+type ModelUsage struct {
+	UserId           int    `json:"-"`
+	ModelName        string `json:"model_name"`
+	Requests         int64  `json:"requests"`
+	PromptTokens     int64  `json:"prompt_tokens"`
+	CompletionTokens int64  `json:"completion_tokens"`
+	Quota            int64  `json:"quota"`
+	PlanQuota        int64  `json:"plan_quota"`
+}
+
+// SnapshotUserQuotas records the current quota of every user as the opening
+// balance of month, users that already have one are left alone.
+func SnapshotUserQuotas(month string) (int, error) {
+	created := 0
+	lastId := 0
+	for {
+		var users []*User
+		err := DB.Select("id", "quota").Where("id > ?", lastId).Order("id asc").Limit(1000).Find(&users).Error
+		if err != nil {
+			return created, err
+		}
+		if len(users) == 0 {
+			return created, nil
+		}
+		lastId = users[len(users)-1].Id
+		now := common.GetTimestamp()
+		snapshots := make([]*QuotaSnapshot, 0, len(users))
+		for _, user := range users {
+			snapshots = append(snapshots, &QuotaSnapshot{UserId: user.Id, Month: month, Quota: user.Quota, CreatedAt: now})
+		}
+		result := DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&snapshots)
+		if result.Error != nil {
+			return created, result.Error
+		}
+		created += int(result.RowsAffected)
+	}
+}
+
+// GetQuotaSnapshots returns the snapshots of month keyed by user, userId 0
+// returns the ones of all users.
+func GetQuotaSnapshots(userId int, month string) (map[int]int64, error) {
+	var snapshots []*QuotaSnapshot
+	tx := DB.Where("month = ?", month)
+	if userId != 0 {
+		tx = tx.Where("user_id = ?", userId)
+	}
+	err := tx.Find(&snapshots).Error
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[int]int64, len(snapshots))
+	for _, snapshot := range snapshots {
+		result[snapshot.UserId] = int64(snapshot.Quota)
+	}
+	return result, nil
+}
+
+func collectUserQuotaTotals(totals []*UserQuotaTotal) map[int]*UserQuotaTotal {
+	result := make(map[int]*UserQuotaTotal, len(totals))
+	for _, total := range totals {
+		result[total.UserId] = total
+	}
+	return result
+}
+
+// successfulTopUps are the orders whose quota was credited, refunded ones
+// included since a refund is deducted separately.
+func successfulTopUps(userId int, start int64, end int64) *gorm.DB {
+	tx := DB.Model(&TopUp{}).Where("status IN ? AND create_time >= ? AND create_time < ?",
+		[]string{TopUpStatusSuccess, TopUpStatusRefunded}, start, end)
+	if userId != 0 {
+		tx = tx.Where("user_id = ?", userId)
+	}
+	return tx
+}
+
+// GetStatementTopUps returns the paid orders created in [start, end).
+func GetStatementTopUps(userId int, start int64, end int64) (topUps []*TopUp, err error) {
+	err = successfulTopUps(userId, start, end).Order("id asc").Find(&topUps).Error
+	return topUps, err
+}
+
+// SumTopUpQuota adds up the quota credited by orders created in [start, end),
+// userId 0 sums them for all users.
+func SumTopUpQuota(userId int, start int64, end int64) (map[int]*UserQuotaTotal, error) {
+	var totals []*UserQuotaTotal
+	err := successfulTopUps(userId, start, end).
+		Select("user_id, count(*) as count, sum(amount) as quota").Group("user_id").Scan(&totals).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, total := range totals {
+		total.Quota = int64(float64(total.Quota) * common.QuotaPerUnit)
+	}
+	return collectUserQuotaTotals(totals), nil
+}
+
+// redeemedCodes includes deleted codes, their quota was still credited.
+func redeemedCodes(userId int, start int64, end int64) *gorm.DB {
+	tx := DB.Unscoped().Model(&Redemption{}).Where("status = ? AND redeemed_time >= ? AND redeemed_time < ?",
+		common.RedemptionCodeStatusUsed, start, end)
+	if userId != 0 {
+		tx = tx.Where("used_user_id = ?", userId)
+	}
+	return tx
+}
+
+// GetStatementRedemptions returns the codes redeemed in [start, end).
+func GetStatementRedemptions(userId int, start int64, end int64) (redemptions []*Redemption, err error) {
+	err = redeemedCodes(userId, start, end).Order("redeemed_time asc").Find(&redemptions).Error
+	return redemptions, err
+}
+
+func SumRedemptionQuota(userId int, start int64, end int64) (map[int]*UserQuotaTotal, error) {
+	var totals []*UserQuotaTotal
+	err := redeemedCodes(userId, start, end).
+		Select("used_user_id as user_id, count(*) as count, sum(quota) as quota").Group("used_user_id").Scan(&totals).Error
+	if err != nil {
+		return nil, err
+	}
+	return collectUserQuotaTotals(totals), nil
+}
+
+func consumeLogs(userId int, start int64, end int64) *gorm.DB {
+	tx := LOG_DB.Model(&Log{}).Where("type = ? AND created_at >= ? AND created_at < ?", LogTypeConsume, start, end)
+	if userId != 0 {
+		tx = tx.Where("user_id = ?", userId)
+	}
+	return tx
+}
+
+// GetModelUsages adds up the consume logs of [start, end) per user and model,
+// it only sees what was logged while consume logging was enabled.
+func GetModelUsages(userId int, start int64, end int64) (usages []*ModelUsage, err error) {
+	err = consumeLogs(userId, start, end).
+		Select("user_id, model_name, count(*) as requests, sum(prompt_tokens) as prompt_tokens, " +
+			"sum(completion_tokens) as completion_tokens, sum(quota) as quota, sum(plan_quota) as plan_quota").
+		Group("user_id, model_name").Order("user_id asc, model_name asc").Scan(&usages).Error
+	return usages, err
+}
+
+func SumConsumeQuota(userId int, start int64, end int64) (map[int]*UserQuotaTotal, error) {
+	var totals []*UserQuotaTotal
+	err := consumeLogs(userId, start, end).
+		Select("user_id, count(*) as count, sum(quota) as quota, sum(plan_quota) as plan_quota").Group("user_id").Scan(&totals).Error
+	if err != nil {
+		return nil, err
+	}
+	return collectUserQuotaTotals(totals), nil
+}
+
+// GetUserQuotas reads id, username and quota of the given users from the DB.
+func GetUserQuotas(ids []int) (users []*User, err error) {
+	for len(ids) > 0 {
+		batch := ids[:min(len(ids), 1000)]
+		ids = ids[len(batch):]
+		var batchUsers []*User
+		err = DB.Select("id", "username", "quota").Where("id IN ?", batch).Find(&batchUsers).Error
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, batchUsers...)
+	}
+	return users, nil
+}
@@ -0,0 +1,60 @@
+package model
+
+// StoredFile is a generated file downloaded from an upstream into the
+// configured storage backend, SourceHash is the sha256 of the upstream url so
+// the same output is only stored once.
+type StoredFile struct {
+	Id          int    `json:"id"`
+	Path        string `json:"path" gorm:"type:varchar(191);uniqueIndex"`
+	SourceHash  string `json:"source_hash" gorm:"type:varchar(64);index"`
+	Backend     string `json:"backend" gorm:"type:varchar(16)"`
+	ContentType string `json:"content_type" gorm:"type:varchar(128)"`
+	Size        int64  `json:"size"`
+	CreatedAt   int64  `json:"created_at" gorm:"bigint;index"`
+}
+
+func GetStoredFile(path string) (*StoredFile, bool, error) {
+	var storedFile *StoredFile
+	err := DB.Where("path = ?", path).First(&storedFile).Error
+	exist, err := RecordExist(err)
+	if err != nil {
+		return nil, false, err
+	}
+	return storedFile, exist, nil
+}
+
+func GetStoredFileBySource(sourceHash string) (*StoredFile, bool, error) {
+	var storedFile *StoredFile
+	err := DB.Where("source_hash = ?", sourceHash).First(&storedFile).Error
+	exist, err := RecordExist(err)
+	if err != nil {
+		return nil, false, err
+	}
+	return storedFile, exist, nil
+}
+
+// GetOldestStoredFiles returns the oldest files first, created before the
+// given timestamp when it is not 0.
+func GetOldestStoredFiles(createdBefore int64, limit int) ([]*StoredFile, error) {
+	var storedFiles []*StoredFile
+	query := DB.Model(&StoredFile{})
+	if createdBefore != 0 {
+		query = query.Where("created_at < ?", createdBefore)
+	}
+	err := query.Order("id").Limit(limit).Find(&storedFiles).Error
+	return storedFiles, err
+}
+
+func SumStoredFileSize() (int64, error) {
+	var size int64
+	err := DB.Model(&StoredFile{}).Select("COALESCE(SUM(size), 0)").Scan(&size).Error
+	return size, err
+}
+
+func (storedFile *StoredFile) Insert() error {
+	return DB.Create(storedFile).Error
+}
+
+func (storedFile *StoredFile) Delete() error {
+	return DB.Delete(storedFile).Error
+}
@@ -0,0 +1,334 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"one-api/common"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	SubscriptionStatusActive   = "active"
+	SubscriptionStatusCanceled = "canceled"
+	SubscriptionStatusExpired  = "expired"
+)
+
+// Subscription is a plan bought by a user, it is paid up to ExpireTime and
+// RemainQuota is reset to the plan quota at the start of every period.
+type Subscription struct {
+	Id           int    `json:"id"`
+	UserId       int    `json:"user_id" gorm:"index"`
+	PlanId       int    `json:"plan_id" gorm:"index"`
+	Status       string `json:"status" gorm:"type:varchar(32);index"`
+	StartTime    int64  `json:"start_time" gorm:"bigint"`
+	PeriodStart  int64  `json:"period_start" gorm:"bigint"`
+	PeriodEnd    int64  `json:"period_end" gorm:"bigint;index"`
+	ExpireTime   int64  `json:"expire_time" gorm:"bigint"`
+	RemainQuota  int    `json:"remain_quota"`
+	UsedQuota    int    `json:"used_quota"`
+	CanceledTime int64  `json:"canceled_time" gorm:"bigint"`
+}
+
+func planPeriod(plan *Plan) int64 {
+	return int64(plan.PeriodDays) * int64(24*time.Hour/time.Second)
+}
+
+// GetUserActiveSubscription returns nil without an error when the user has
+// no active subscription.
+func GetUserActiveSubscription(userId int) (*Subscription, error) {
+	var subscription Subscription
+	err := DB.Where("user_id = ? AND status = ?", userId, SubscriptionStatusActive).First(&subscription).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &subscription, nil
+}
+
+// GetUserActivePlan returns the plan of the active subscription when it
+// covers requests of group for modelName.
+func GetUserActivePlan(userId int, group string, modelName string) (*Subscription, *Plan) {
+	subscription, err := CacheGetUserActiveSubscription(userId)
+	if err != nil || subscription == nil {
+		return nil, nil
+	}
+	plan, err := CacheGetPlanById(subscription.PlanId)
+	if err != nil || !plan.Covers(group, modelName) {
+		return nil, nil
+	}
+	return subscription, plan
+}
+
+// GetUserQuotaWithPlan is the quota a request may spend, the user quota plus
+// what is left of a plan covering the request in the current period.
+func GetUserQuotaWithPlan(userId int, group string, modelName string) (int, error) {
+	quota, err := GetUserQuota(userId, false)
+	if err != nil {
+		return 0, err
+	}
+	subscription, _ := GetUserActivePlan(userId, group, modelName)
+	if subscription != nil && subscription.PeriodEnd > common.GetTimestamp() && subscription.RemainQuota > 0 {
+		quota += subscription.RemainQuota
+	}
+	return quota, nil
+}
+
+// ConsumeSubscriptionQuota takes up to quota from the plan covering the
+// request and returns how much it took, the rest is for the user quota. When
+// a concurrent request took part of the plan quota first it retries with
+// what is left.
+func ConsumeSubscriptionQuota(userId int, group string, modelName string, quota int) int {
+	subscription, _ := GetUserActivePlan(userId, group, modelName)
+	if subscription == nil {
+		return 0
+	}
+	for i := 0; i < 3; i++ {
+		if subscription.Status != SubscriptionStatusActive || subscription.PeriodEnd <= common.GetTimestamp() {
+			return 0
+		}
+		planQuota := min(quota, subscription.RemainQuota)
+		if planQuota <= 0 {
+			return 0
+		}
+		result := DB.Model(&Subscription{}).Where("id = ? AND status = ? AND remain_quota >= ?",
+			subscription.Id, SubscriptionStatusActive, planQuota).Updates(
+			map[string]interface{}{
+				"remain_quota": gorm.Expr("remain_quota - ?", planQuota),
+				"used_quota":   gorm.Expr("used_quota + ?", planQuota),
+			},
+		)
+		if result.Error != nil {
+			common.SysError("failed to consume subscription quota: " + result.Error.Error())
+			return 0
+		}
+		if result.RowsAffected > 0 {
+			cacheIncrSubscriptionQuota(userId, -planQuota)
+			return planQuota
+		}
+		// the cached remain quota is behind, read what is left and try again
+		invalidateSubscriptionCache(userId)
+		err := DB.Select("id", "status", "period_end", "remain_quota").First(subscription, "id = ?", subscription.Id).Error
+		if err != nil {
+			return 0
+		}
+	}
+	return 0
+}
+
+// refundSubscriptionQuota gives quota taken by ConsumeSubscriptionQuota back
+// to the active subscription of the user, it is dropped when the
+// subscription ended in the meantime.
+func refundSubscriptionQuota(userId int, quota int) error {
+	if quota <= 0 {
+		return nil
+	}
+	result := DB.Model(&Subscription{}).Where("user_id = ? AND status = ?", userId, SubscriptionStatusActive).Updates(
+		map[string]interface{}{
+			"remain_quota": gorm.Expr("remain_quota + ?", quota),
+			"used_quota":   gorm.Expr("used_quota - ?", quota),
+		},
+	)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected > 0 {
+		cacheIncrSubscriptionQuota(userId, quota)
+	}
+	return nil
+}
+
+// RefundTaskQuota gives the quota of a failed async task back, the part a
+// subscription plan paid goes back to the plan and the rest to the user.
+func RefundTaskQuota(userId int, quota int, planQuota int, ref QuotaRef) error {
+	planQuota = min(planQuota, quota)
+	err := refundSubscriptionQuota(userId, planQuota)
+	if err != nil {
+		return err
+	}
+	if quota > planQuota {
+		return IncreaseUserQuota(userId, quota-planQuota, ref)
+	}
+	return nil
+}
+
+// StartSubscription subscribes the user to the plan for periods periods, a
+// user already subscribed to the same plan has it renewed instead. It runs
+// within tx when given, e.g. the settlement of the order paying for it.
+func StartSubscription(tx *gorm.DB, userId int, planId int, periods int) (*Subscription, error) {
+	if periods <= 0 {
+		return nil, errors.New("订阅周期数必须大于 0")
+	}
+	plan, err := GetPlanById(planId)
+	if err != nil {
+		return nil, errors.New("套餐不存在")
+	}
+	if tx == nil {
+		tx = DB
+	}
+	subscription := &Subscription{}
+	err = tx.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("user_id = ? AND status = ?", userId, SubscriptionStatusActive).First(subscription).Error
+		if err == nil {
+			if subscription.PlanId != planId {
+				return errors.New("已有其他生效中的套餐，请先取消")
+			}
+			// only the expire time changes, the quota is consumed concurrently
+			extension := int64(periods) * planPeriod(plan)
+			subscription.ExpireTime += extension
+			return tx.Model(&Subscription{}).Where("id = ?", subscription.Id).
+				Update("expire_time", gorm.Expr("expire_time + ?", extension)).Error
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		now := common.GetTimestamp()
+		*subscription = Subscription{
+			UserId:      userId,
+			PlanId:      planId,
+			Status:      SubscriptionStatusActive,
+			StartTime:   now,
+			PeriodStart: now,
+			PeriodEnd:   now + planPeriod(plan),
+			ExpireTime:  now + int64(periods)*planPeriod(plan),
+			RemainQuota: plan.Quota,
+		}
+		return tx.Create(subscription).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	invalidateSubscriptionCache(userId)
+	return subscription, nil
+}
+
+// CancelSubscription ends the subscription at once, the quota left in the
+// current period is dropped.
+func CancelSubscription(id int) (*Subscription, error) {
+	subscription := &Subscription{}
+	err := DB.First(subscription, "id = ?", id).Error
+	if err != nil {
+		return nil, err
+	}
+	if subscription.Status != SubscriptionStatusActive {
+		return nil, fmt.Errorf("订阅状态为 %s，无法取消", subscription.Status)
+	}
+	subscription.Status = SubscriptionStatusCanceled
+	subscription.RemainQuota = 0
+	subscription.CanceledTime = common.GetTimestamp()
+	result := DB.Model(&Subscription{}).Where("id = ? AND status = ?", id, SubscriptionStatusActive).
+		Select("status", "remain_quota", "canceled_time").Updates(subscription)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, errors.New("订阅已被修改，请重试")
+	}
+	invalidateSubscriptionCache(subscription.UserId)
+	return subscription, nil
+}
+
+// ShortenSubscription takes periods periods off the active subscription of the
+// user to the plan, e.g. when its payment was refunded, and expires it when
+// nothing paid is left. It runs within tx when given.
+func ShortenSubscription(tx *gorm.DB, userId int, planId int, periods int) error {
+	plan, err := GetPlanById(planId)
+	if err != nil {
+		return err
+	}
+	if tx == nil {
+		tx = DB
+	}
+	err = tx.Transaction(func(tx *gorm.DB) error {
+		subscription := &Subscription{}
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("user_id = ? AND plan_id = ? AND status = ?", userId, planId, SubscriptionStatusActive).First(subscription).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		subscription.ExpireTime -= int64(periods) * planPeriod(plan)
+		if subscription.ExpireTime <= common.GetTimestamp() {
+			subscription.Status = SubscriptionStatusExpired
+			subscription.RemainQuota = 0
+			return tx.Model(subscription).Select("expire_time", "status", "remain_quota").Updates(subscription).Error
+		}
+		if subscription.PeriodEnd > subscription.ExpireTime {
+			subscription.PeriodEnd = subscription.ExpireTime
+		}
+		return tx.Model(subscription).Select("expire_time", "period_end").Updates(subscription).Error
+	})
+	if err != nil {
+		return err
+	}
+	invalidateSubscriptionCache(userId)
+	return nil
+}
+
+// GetDueSubscriptions returns active subscriptions whose period ended by now.
+func GetDueSubscriptions(now int64, afterId int, limit int) (subscriptions []*Subscription, err error) {
+	err = DB.Where("id > ? AND status = ? AND period_end <= ?", afterId, SubscriptionStatusActive, now).
+		Order("id asc").Limit(limit).Find(&subscriptions).Error
+	return subscriptions, err
+}
+
+// AdvanceSubscription starts the period the subscription is in at now with a
+// fresh plan quota, or expires it when it is no longer paid. It reports the
+// status the subscription ended up in.
+func AdvanceSubscription(subscription *Subscription, now int64) (string, error) {
+	periodEnd := subscription.PeriodEnd
+	updates := map[string]interface{}{}
+	status := SubscriptionStatusActive
+	plan, err := GetPlanById(subscription.PlanId)
+	if err != nil || now >= subscription.ExpireTime || planPeriod(plan) <= 0 {
+		status = SubscriptionStatusExpired
+		updates["status"] = status
+		updates["remain_quota"] = 0
+	} else {
+		period := planPeriod(plan)
+		periodStart := periodEnd
+		for periodStart+period <= now {
+			periodStart += period
+		}
+		updates["period_start"] = periodStart
+		updates["period_end"] = min(periodStart+period, subscription.ExpireTime)
+		updates["remain_quota"] = plan.Quota
+	}
+	// the period end guards against another node advancing it concurrently
+	result := DB.Model(&Subscription{}).Where("id = ? AND status = ? AND period_end = ?",
+		subscription.Id, SubscriptionStatusActive, periodEnd).Updates(updates)
+	if result.Error != nil {
+		return "", result.Error
+	}
+	if result.RowsAffected == 0 {
+		return "", nil
+	}
+	invalidateSubscriptionCache(subscription.UserId)
+	return status, nil
+}
+
+func GetAllSubscriptions(userId int, status string, startIdx int, num int) (subscriptions []*Subscription, total int64, err error) {
+	tx := DB.Model(&Subscription{})
+	if userId != 0 {
+		tx = tx.Where("user_id = ?", userId)
+	}
+	if status != "" {
+		tx = tx.Where("status = ?", status)
+	}
+	err = tx.Count(&total).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	err = tx.Order("id desc").Limit(num).Offset(startIdx).Find(&subscriptions).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	return subscriptions, total, nil
+}
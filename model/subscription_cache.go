@@ -0,0 +1,183 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"one-api/common"
+	"one-api/constant"
+	"sync"
+	"time"
+)
+
+// The active subscription of a user and the plans are read on every relay
+// request, they are cached in Redis or, without it, in memory. A user
+// without an active subscription is cached as a subscription with id 0.
+
+type subscriptionCacheEntry struct {
+	subscription Subscription
+	expiresAt    int64
+}
+
+type planCacheEntry struct {
+	plan      Plan
+	expiresAt int64
+}
+
+var subscriptionCache = make(map[int]*subscriptionCacheEntry)
+var subscriptionCacheLock sync.Mutex
+
+var planCache = make(map[int]*planCacheEntry)
+var planCacheLock sync.Mutex
+
+func getSubscriptionCacheKey(userId int) string {
+	return fmt.Sprintf(constant.UserSubscriptionKeyFmt, userId)
+}
+
+func getPlanCacheKey(planId int) string {
+	return fmt.Sprintf(constant.PlanKeyFmt, planId)
+}
+
+func subscriptionCacheDuration() time.Duration {
+	return time.Duration(constant.SubscriptionCacheSeconds) * time.Second
+}
+
+func cacheGetUserActiveSubscription(userId int) (*Subscription, bool) {
+	var subscription Subscription
+	if common.RedisEnabled {
+		if err := common.RedisHGetObj(getSubscriptionCacheKey(userId), &subscription); err != nil {
+			return nil, false
+		}
+	} else {
+		subscriptionCacheLock.Lock()
+		entry, ok := subscriptionCache[userId]
+		ok = ok && entry.expiresAt > time.Now().Unix()
+		if ok {
+			subscription = entry.subscription
+		}
+		subscriptionCacheLock.Unlock()
+		if !ok {
+			return nil, false
+		}
+	}
+	if subscription.Id == 0 {
+		return nil, true
+	}
+	return &subscription, true
+}
+
+func cacheSetUserActiveSubscription(userId int, subscription *Subscription) {
+	cached := Subscription{UserId: userId}
+	if subscription != nil {
+		cached = *subscription
+	}
+	if common.RedisEnabled {
+		err := common.RedisHSetObj(getSubscriptionCacheKey(userId), &cached, subscriptionCacheDuration())
+		if err != nil {
+			common.SysError("failed to cache subscription: " + err.Error())
+		}
+		return
+	}
+	subscriptionCacheLock.Lock()
+	defer subscriptionCacheLock.Unlock()
+	subscriptionCache[userId] = &subscriptionCacheEntry{
+		subscription: cached,
+		expiresAt:    time.Now().Add(subscriptionCacheDuration()).Unix(),
+	}
+}
+
+// cacheIncrSubscriptionQuota follows a change of the remain quota of the
+// cached subscription, a missing entry is left to be loaded from the DB.
+func cacheIncrSubscriptionQuota(userId int, delta int) {
+	if common.RedisEnabled {
+		err := common.RedisHIncrBy(getSubscriptionCacheKey(userId), constant.SubscriptionFieldRemainQuota, int64(delta))
+		if err != nil {
+			common.SysError("failed to update subscription cache: " + err.Error())
+		}
+		return
+	}
+	subscriptionCacheLock.Lock()
+	defer subscriptionCacheLock.Unlock()
+	if entry, ok := subscriptionCache[userId]; ok && entry.subscription.Id != 0 {
+		entry.subscription.RemainQuota += delta
+	}
+}
+
+func invalidateSubscriptionCache(userId int) {
+	if common.RedisEnabled {
+		err := common.RedisDel(getSubscriptionCacheKey(userId))
+		if err != nil {
+			common.SysError("failed to invalidate subscription cache: " + err.Error())
+		}
+		return
+	}
+	subscriptionCacheLock.Lock()
+	defer subscriptionCacheLock.Unlock()
+	delete(subscriptionCache, userId)
+}
+
+// CacheGetUserActiveSubscription is GetUserActiveSubscription served from
+// the cache when possible.
+func CacheGetUserActiveSubscription(userId int) (*Subscription, error) {
+	if subscription, ok := cacheGetUserActiveSubscription(userId); ok {
+		return subscription, nil
+	}
+	subscription, err := GetUserActiveSubscription(userId)
+	if err != nil {
+		return nil, err
+	}
+	cacheSetUserActiveSubscription(userId, subscription)
+	return subscription, nil
+}
+
+// CacheGetPlanById is GetPlanById served from the cache when possible.
+func CacheGetPlanById(id int) (*Plan, error) {
+	if common.RedisEnabled {
+		value, err := common.RedisGet(getPlanCacheKey(id))
+		if err == nil {
+			var plan Plan
+			if json.Unmarshal([]byte(value), &plan) == nil {
+				return &plan, nil
+			}
+		}
+	} else {
+		planCacheLock.Lock()
+		entry, ok := planCache[id]
+		planCacheLock.Unlock()
+		if ok && entry.expiresAt > time.Now().Unix() {
+			plan := entry.plan
+			return &plan, nil
+		}
+	}
+	plan, err := GetPlanById(id)
+	if err != nil {
+		return nil, err
+	}
+	if common.RedisEnabled {
+		data, _ := json.Marshal(plan)
+		err = common.RedisSet(getPlanCacheKey(id), string(data), subscriptionCacheDuration())
+		if err != nil {
+			common.SysError("failed to cache plan: " + err.Error())
+		}
+	} else {
+		planCacheLock.Lock()
+		planCache[id] = &planCacheEntry{
+			plan:      *plan,
+			expiresAt: time.Now().Add(subscriptionCacheDuration()).Unix(),
+		}
+		planCacheLock.Unlock()
+	}
+	return plan, nil
+}
+
+func invalidatePlanCache(id int) {
+	if common.RedisEnabled {
+		err := common.RedisDel(getPlanCacheKey(id))
+		if err != nil {
+			common.SysError("failed to invalidate plan cache: " + err.Error())
+		}
+		return
+	}
+	planCacheLock.Lock()
+	defer planCacheLock.Unlock()
+	delete(planCache, id)
+}
@@ -29,6 +29,7 @@ type Task struct {
 	UserId     int                   `json:"user_id" gorm:"index"`
 	ChannelId  int                   `json:"channel_id" gorm:"index"`
 	Quota      int                   `json:"quota"`
+	PlanQuota  int                   `json:"plan_quota"`
 	Action     string                `json:"action" gorm:"type:varchar(40);index"` // 任务类型, song, lyrics, description-mode
 	Status     TaskStatus            `json:"status" gorm:"type:varchar(20);index"` // 任务状态
 	FailReason string                `json:"fail_reason"`
@@ -240,6 +241,13 @@ func (Task *Task) Update() error {
 	return err
 }
 
+// UpdatePlanQuota records the part of the quota a subscription plan paid, it
+// is only known once the task was billed.
+func (Task *Task) UpdatePlanQuota(planQuota int) error {
+	Task.PlanQuota = planQuota
+	return DB.Model(Task).Update("plan_quota", planQuota).Error
+}
+
 func TaskBulkUpdate(TaskIds []string, params map[string]any) error {
 	if len(TaskIds) == 0 {
 		return nil
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"github.com/bytedance/gopkg/util/gopool"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"one-api/common"
 	relaycommon "one-api/relay/common"
 	"one-api/setting"
@@ -28,6 +29,10 @@ type Token struct {
 	AllowIps           *string        `json:"allow_ips" gorm:"default:''"`
 	UsedQuota          int            `json:"used_quota" gorm:"default:0"` // used quota
 	Group              string         `json:"group" gorm:"default:''"`
+	ResponseCache      bool           `json:"response_cache" gorm:"default:false"`
+	ResponseCacheTTL   int            `json:"response_cache_ttl" gorm:"default:0"` // 0 means the global default
+	RateLimitRPM       int            `json:"rate_limit_rpm" gorm:"default:0"`     // requests per minute, 0 means unlimited
+	RateLimitTPM       int            `json:"rate_limit_tpm" gorm:"default:0"`     // tokens per minute, 0 means unlimited
 	DeletedAt          gorm.DeletedAt `gorm:"index"`
 }
 
@@ -157,6 +162,7 @@ func GetTokenByKey(key string, fromDB bool) (token *Token, err error) {
 	if !fromDB && common.RedisEnabled {
 		// Try Redis first
 		token, err := cacheGetTokenByKey(key)
+		common.RecordCacheLookup("token", err == nil)
 		if err == nil {
 			return token, nil
 		}
@@ -169,11 +175,17 @@ func GetTokenByKey(key string, fromDB bool) (token *Token, err error) {
 
 func (token *Token) Insert() error {
 	var err error
-	err = DB.Create(token).Error
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(token).Error; err != nil {
+			return err
+		}
+		return recordQuotaLedger(tx, token.UserId, TokenLedgerAccount(token.Id), token.RemainQuota, QuotaRef{Source: LedgerSourceAllowance})
+	})
 	return err
 }
 
 // Update Make sure your token's fields is completed, because this will update non-zero values
+// A change of the remain quota is journaled as allowance in the same transaction.
 func (token *Token) Update() (err error) {
 	defer func() {
 		if shouldUpdateRedis(true, err) {
@@ -185,8 +197,21 @@ func (token *Token) Update() (err error) {
 			})
 		}
 	}()
-	err = DB.Model(token).Select("name", "status", "expired_time", "remain_quota", "unlimited_quota",
-		"model_limits_enabled", "model_limits", "allow_ips", "group").Updates(token).Error
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		var current Token
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id", "remain_quota").First(&current, token.Id).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Model(token).Select("name", "status", "expired_time", "remain_quota", "unlimited_quota",
+			"model_limits_enabled", "model_limits", "allow_ips", "group", "response_cache", "response_cache_ttl",
+			"rate_limit_rpm", "rate_limit_tpm").Updates(token).Error
+		if err != nil {
+			return err
+		}
+		return recordQuotaLedger(tx, token.UserId, TokenLedgerAccount(token.Id), token.RemainQuota-current.RemainQuota,
+			QuotaRef{Source: LedgerSourceAllowance})
+	})
 	return err
 }
 
@@ -263,7 +288,9 @@ func DeleteTokenById(id int, userId int) (err error) {
 	return token.Delete()
 }
 
-func IncreaseTokenQuota(id int, key string, quota int) (err error) {
+// IncreaseTokenQuota gives quota back to the token of userId and journals it
+// in the quota ledger under ref.
+func IncreaseTokenQuota(userId int, id int, key string, quota int, ref QuotaRef) (err error) {
 	if quota < 0 {
 		return errors.New("quota 不能为负数！")
 	}
@@ -275,15 +302,29 @@ func IncreaseTokenQuota(id int, key string, quota int) (err error) {
 			}
 		})
 	}
+	return updateTokenQuotaJournaled(userId, id, quota, ref)
+}
+
+// updateTokenQuotaJournaled writes a change of the token quota with its
+// ledger entry, in one transaction or in one batch.
+func updateTokenQuotaJournaled(userId int, id int, delta int, ref QuotaRef) error {
+	if delta == 0 {
+		return nil
+	}
 	if common.BatchUpdateEnabled {
-		addNewRecord(BatchUpdateTypeTokenQuota, id, quota)
+		addNewQuotaRecord(BatchUpdateTypeTokenQuota, id, delta, newQuotaLedger(userId, TokenLedgerAccount(id), delta, ref))
 		return nil
 	}
-	return increaseTokenQuota(id, quota)
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := increaseTokenQuota(tx, id, delta); err != nil {
+			return err
+		}
+		return recordQuotaLedger(tx, userId, TokenLedgerAccount(id), delta, ref)
+	})
 }
 
-func increaseTokenQuota(id int, quota int) (err error) {
-	err = DB.Model(&Token{}).Where("id = ?", id).Updates(
+func increaseTokenQuota(tx *gorm.DB, id int, quota int) (err error) {
+	err = tx.Model(&Token{}).Where("id = ?", id).Updates(
 		map[string]interface{}{
 			"remain_quota":  gorm.Expr("remain_quota + ?", quota),
 			"used_quota":    gorm.Expr("used_quota - ?", quota),
@@ -293,7 +334,9 @@ func increaseTokenQuota(id int, quota int) (err error) {
 	return err
 }
 
-func DecreaseTokenQuota(id int, key string, quota int) (err error) {
+// DecreaseTokenQuota takes quota from the token of userId and journals it in
+// the quota ledger under ref.
+func DecreaseTokenQuota(userId int, id int, key string, quota int, ref QuotaRef) (err error) {
 	if quota < 0 {
 		return errors.New("quota 不能为负数！")
 	}
@@ -305,22 +348,7 @@ func DecreaseTokenQuota(id int, key string, quota int) (err error) {
 			}
 		})
 	}
-	if common.BatchUpdateEnabled {
-		addNewRecord(BatchUpdateTypeTokenQuota, id, -quota)
-		return nil
-	}
-	return decreaseTokenQuota(id, quota)
-}
-
-func decreaseTokenQuota(id int, quota int) (err error) {
-	err = DB.Model(&Token{}).Where("id = ?", id).Updates(
-		map[string]interface{}{
-			"remain_quota":  gorm.Expr("remain_quota - ?", quota),
-			"used_quota":    gorm.Expr("used_quota + ?", quota),
-			"accessed_time": common.GetTimestamp(),
-		},
-	).Error
-	return err
+	return updateTokenQuotaJournaled(userId, id, -quota, ref)
 }
 
 func PreConsumeTokenQuota(relayInfo *relaycommon.RelayInfo, quota int) error {
@@ -340,29 +368,39 @@ func PreConsumeTokenQuota(relayInfo *relaycommon.RelayInfo, quota int) error {
 	if !relayInfo.TokenUnlimited && token.RemainQuota < quota {
 		return errors.New("令牌额度不足")
 	}
-	err = DecreaseTokenQuota(relayInfo.TokenId, relayInfo.TokenKey, quota)
+	err = DecreaseTokenQuota(relayInfo.UserId, relayInfo.TokenId, relayInfo.TokenKey, quota, RelayQuotaRef(relayInfo))
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
+// PostConsumeQuota settles quota on top of the preConsumedQuota already taken
+// from the user quota. A subscription plan covering the request pays first,
+// so the part of the whole cost it takes is given back to the user quota.
 func PostConsumeQuota(relayInfo *relaycommon.RelayInfo, userQuota int, quota int, preConsumedQuota int, sendEmail bool) (err error) {
 
-	if quota > 0 {
-		err = DecreaseUserQuota(relayInfo.UserId, quota)
+	userDelta := quota
+	if total := quota + preConsumedQuota; total > 0 {
+		planQuota := ConsumeSubscriptionQuota(relayInfo.UserId, relayInfo.Group, relayInfo.OriginModelName, total)
+		relayInfo.PlanQuota += planQuota
+		userDelta -= planQuota
+	}
+	ref := RelayQuotaRef(relayInfo)
+	if userDelta > 0 {
+		err = DecreaseUserQuota(relayInfo.UserId, userDelta, ref)
 	} else {
-		err = IncreaseUserQuota(relayInfo.UserId, -quota)
+		err = IncreaseUserQuota(relayInfo.UserId, -userDelta, ref)
 	}
 	if err != nil {
 		return err
 	}
 
-	if !relayInfo.IsPlayground {
+	if !relayInfo.IsPlayground && quota != 0 {
 		if quota > 0 {
-			err = DecreaseTokenQuota(relayInfo.TokenId, relayInfo.TokenKey, quota)
+			err = DecreaseTokenQuota(relayInfo.UserId, relayInfo.TokenId, relayInfo.TokenKey, quota, ref)
 		} else {
-			err = IncreaseTokenQuota(relayInfo.TokenId, relayInfo.TokenKey, -quota)
+			err = IncreaseTokenQuota(relayInfo.UserId, relayInfo.TokenId, relayInfo.TokenKey, -quota, ref)
 		}
 		if err != nil {
 			return err
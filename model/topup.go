@@ -1,13 +1,57 @@
 package model
 
+import (
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"time"
+)
+
+const (
+	TopUpStatusPending  = "pending"
+	TopUpStatusSuccess  = "success"
+	TopUpStatusExpired  = "expired"
+	TopUpStatusRefunded = "refunded"
+)
+
+const (
+	PaymentMethodEpay   = "epay"
+	PaymentMethodStripe = "stripe"
+)
+
+// topUpTransitions lists the statuses an order may move to, an expired
+// order can still succeed when the payment arrives late.
+var topUpTransitions = map[string][]string{
+	TopUpStatusPending: {TopUpStatusSuccess, TopUpStatusExpired},
+	TopUpStatusExpired: {TopUpStatusSuccess},
+	TopUpStatusSuccess: {TopUpStatusRefunded},
+}
+
 type TopUp struct {
-	Id         int     `json:"id"`
-	UserId     int     `json:"user_id" gorm:"index"`
-	Amount     int     `json:"amount"`
-	Money      float64 `json:"money"`
-	TradeNo    string  `json:"trade_no"`
-	CreateTime int64   `json:"create_time"`
-	Status     string  `json:"status"`
+	Id            int     `json:"id"`
+	UserId        int     `json:"user_id" gorm:"index"`
+	Amount        int     `json:"amount"`
+	Money         float64 `json:"money"`
+	TradeNo       string  `json:"trade_no"`
+	CreateTime    int64   `json:"create_time"`
+	Status        string  `json:"status"`
+	PaymentMethod string  `json:"payment_method" gorm:"type:varchar(32);default:'epay'"`
+	Currency      string  `json:"currency" gorm:"type:varchar(8)"`
+	PaymentId     string  `json:"payment_id" gorm:"type:varchar(255)"`
+	RefundedMoney float64 `json:"refunded_money"`
+	PlanId        int     `json:"plan_id"`
+	PlanPeriods   int     `json:"plan_periods"`
+}
+
+// TopUpStatusLog records every status change of an order and what caused it.
+type TopUpStatusLog struct {
+	Id         int    `json:"id"`
+	TopUpId    int    `json:"top_up_id" gorm:"index"`
+	FromStatus string `json:"from_status" gorm:"type:varchar(32)"`
+	ToStatus   string `json:"to_status" gorm:"type:varchar(32)"`
+	Source     string `json:"source" gorm:"type:varchar(32)"`
+	Message    string `json:"message"`
+	CreatedAt  int64  `json:"created_at" gorm:"bigint"`
 }
 
 func (topUp *TopUp) Insert() error {
@@ -22,6 +66,131 @@ func (topUp *TopUp) Update() error {
 	return err
 }
 
+func CanTransitTopUp(from string, to string) bool {
+	for _, status := range topUpTransitions[from] {
+		if status == to {
+			return true
+		}
+	}
+	return false
+}
+
+// ErrTopUpChanged is returned by Transit when the order was changed since it
+// was read, e.g. by a concurrent callback for the same payment.
+var ErrTopUpChanged = errors.New("top up was changed concurrently")
+
+// Transit moves the order to status, sets the columns in updates and logs the
+// change, apply runs in the same transaction. The update only matches while
+// the order has the status and refunded money it was read with, so of
+// concurrent callbacks one settles the order and the others get
+// ErrTopUpChanged with nothing applied. Moving to the same status only saves
+// the other columns.
+func (topUp *TopUp) Transit(status string, updates map[string]interface{}, source string, message string, apply func(tx *gorm.DB) error) error {
+	from := topUp.Status
+	if from != status && !CanTransitTopUp(from, status) {
+		return fmt.Errorf("top up %s cannot change from %s to %s", topUp.TradeNo, from, status)
+	}
+	if updates == nil {
+		updates = make(map[string]interface{})
+	}
+	updates["status"] = status
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&TopUp{}).Where("id = ? AND status = ? AND refunded_money = ?", topUp.Id, from, topUp.RefundedMoney).
+			Updates(updates)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrTopUpChanged
+		}
+		err := tx.Create(&TopUpStatusLog{
+			TopUpId:    topUp.Id,
+			FromStatus: from,
+			ToStatus:   status,
+			Source:     source,
+			Message:    message,
+			CreatedAt:  time.Now().Unix(),
+		}).Error
+		if err != nil {
+			return err
+		}
+		if apply != nil {
+			return apply(tx)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	topUp.Status = status
+	return nil
+}
+
+// Complete marks the order paid and in the same transaction credits quota to
+// the user, or starts the plan of a plan order. Of concurrent callbacks only
+// one completes the order, the others get ErrTopUpChanged.
+func (topUp *TopUp) Complete(paymentId string, quota int, source string) (*Subscription, error) {
+	updates := make(map[string]interface{})
+	if paymentId != "" {
+		updates["payment_id"] = paymentId
+	}
+	var subscription *Subscription
+	err := topUp.Transit(TopUpStatusSuccess, updates, source, "", func(tx *gorm.DB) error {
+		if topUp.PlanId != 0 {
+			var err error
+			subscription, err = StartSubscription(tx, topUp.UserId, topUp.PlanId, topUp.PlanPeriods)
+			return err
+		}
+		return deltaUpdateUserQuotaTx(tx, topUp.UserId, quota, QuotaRef{Source: LedgerSourceTopUp, Reference: topUp.TradeNo})
+	})
+	if err != nil {
+		return nil, err
+	}
+	if paymentId != "" {
+		topUp.PaymentId = paymentId
+	}
+	if topUp.PlanId != 0 {
+		// the subscription cache may have been refilled before the commit
+		invalidateSubscriptionCache(topUp.UserId)
+	} else {
+		cacheDeltaUserQuota(topUp.UserId, quota)
+	}
+	return subscription, nil
+}
+
+// Refund raises the refunded money of the order to refundedMoney and in the
+// same transaction deducts quota from the user, a fully refunded plan order
+// also has its periods taken off the subscription. Like Complete it returns
+// ErrTopUpChanged when a concurrent callback changed the order first.
+func (topUp *TopUp) Refund(refundedMoney float64, quota int, source string, message string) error {
+	status := topUp.Status
+	if refundedMoney >= topUp.Money {
+		status = TopUpStatusRefunded
+	}
+	updates := map[string]interface{}{
+		"refunded_money": refundedMoney,
+	}
+	err := topUp.Transit(status, updates, source, message, func(tx *gorm.DB) error {
+		err := deltaUpdateUserQuotaTx(tx, topUp.UserId, -quota, QuotaRef{Source: LedgerSourceRefund, Reference: topUp.TradeNo})
+		if err != nil {
+			return err
+		}
+		if topUp.PlanId != 0 && status == TopUpStatusRefunded {
+			return ShortenSubscription(tx, topUp.UserId, topUp.PlanId, topUp.PlanPeriods)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	topUp.RefundedMoney = refundedMoney
+	cacheDeltaUserQuota(topUp.UserId, -quota)
+	if topUp.PlanId != 0 {
+		invalidateSubscriptionCache(topUp.UserId)
+	}
+	return nil
+}
+
 func GetTopUpById(id int) *TopUp {
 	var topUp *TopUp
 	var err error
@@ -41,3 +210,51 @@ func GetTopUpByTradeNo(tradeNo string) *TopUp {
 	}
 	return topUp
 }
+
+func GetTopUpByPaymentId(paymentId string) *TopUp {
+	var topUp *TopUp
+	var err error
+	err = DB.Where("payment_id = ?", paymentId).First(&topUp).Error
+	if err != nil {
+		return nil
+	}
+	return topUp
+}
+
+func GetAllTopUps(userId int, status string, paymentMethod string, tradeNo string, startIdx int, num int) (topUps []*TopUp, total int64, err error) {
+	tx := DB.Model(&TopUp{})
+	if userId != 0 {
+		tx = tx.Where("user_id = ?", userId)
+	}
+	if status != "" {
+		tx = tx.Where("status = ?", status)
+	}
+	if paymentMethod != "" {
+		tx = tx.Where("payment_method = ?", paymentMethod)
+	}
+	if tradeNo != "" {
+		tx = tx.Where("trade_no = ?", tradeNo)
+	}
+	err = tx.Count(&total).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	err = tx.Order("id desc").Limit(num).Offset(startIdx).Find(&topUps).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	return topUps, total, nil
+}
+
+// GetPendingTopUps returns pending orders created before createdBefore in id
+// order, starting after afterId.
+func GetPendingTopUps(afterId int, createdBefore int64, limit int) (topUps []*TopUp, err error) {
+	err = DB.Where("id > ? AND status = ? AND create_time < ?", afterId, TopUpStatusPending, createdBefore).
+		Order("id asc").Limit(limit).Find(&topUps).Error
+	return topUps, err
+}
+
+func GetTopUpStatusLogs(topUpId int) (logs []*TopUpStatusLog, err error) {
+	err = DB.Where("top_up_id = ?", topUpId).Order("id asc").Find(&logs).Error
+	return logs, err
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/bytedance/gopkg/util/gopool"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // User if you add sensitive fields, don't forget to clean them in setupLogin function.
@@ -259,6 +260,9 @@ func (user *User) TransferAffQuotaToQuota(quota int) error {
 	if err := tx.Save(user).Error; err != nil {
 		return err
 	}
+	if err := recordQuotaLedger(tx, user.Id, UserLedgerAccount(user.Id), quota, QuotaRef{Source: LedgerSourceAffiliate}); err != nil {
+		return err
+	}
 
 	// 提交事务
 	return tx.Commit().Error
@@ -275,16 +279,21 @@ func (user *User) Insert(inviterId int) error {
 	user.Quota = common.QuotaForNewUser
 	//user.SetAccessToken(common.GetUUID())
 	user.AffCode = common.GetRandomString(4)
-	result := DB.Create(user)
-	if result.Error != nil {
-		return result.Error
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(user).Error; err != nil {
+			return err
+		}
+		return recordQuotaLedger(tx, user.Id, UserLedgerAccount(user.Id), user.Quota, QuotaRef{Source: LedgerSourceGift, Reference: "register"})
+	})
+	if err != nil {
+		return err
 	}
 	if common.QuotaForNewUser > 0 {
 		RecordLog(user.Id, LogTypeSystem, fmt.Sprintf("新用户注册赠送 %s", common.LogQuota(common.QuotaForNewUser)))
 	}
 	if inviterId != 0 {
 		if common.QuotaForInvitee > 0 {
-			_ = IncreaseUserQuota(user.Id, common.QuotaForInvitee)
+			_ = IncreaseUserQuota(user.Id, common.QuotaForInvitee, QuotaRef{Source: LedgerSourceGift, Reference: fmt.Sprintf("invited by %d", inviterId)})
 			RecordLog(user.Id, LogTypeSystem, fmt.Sprintf("使用邀请码赠送 %s", common.LogQuota(common.QuotaForInvitee)))
 		}
 		if common.QuotaForInviter > 0 {
@@ -314,7 +323,9 @@ func (user *User) Update(updatePassword bool) error {
 	return updateUserCache(user.Id, user.Username, user.Group, user.Quota, user.Status)
 }
 
-func (user *User) Edit(updatePassword bool) error {
+// Edit saves the profile and quota of the user, a change of the quota is
+// journaled under ref in the same transaction.
+func (user *User) Edit(updatePassword bool, ref QuotaRef) error {
 	var err error
 	if updatePassword {
 		user.Password, err = common.Password2Hash(user.Password)
@@ -334,8 +345,17 @@ func (user *User) Edit(updatePassword bool) error {
 		updates["password"] = newUser.Password
 	}
 
-	DB.First(&user, user.Id)
-	if err = DB.Model(user).Updates(updates).Error; err != nil {
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, user.Id).Error; err != nil {
+			return err
+		}
+		originQuota := user.Quota
+		if err := tx.Model(user).Updates(updates).Error; err != nil {
+			return err
+		}
+		return recordQuotaLedger(tx, user.Id, UserLedgerAccount(user.Id), newUser.Quota-originQuota, ref)
+	})
+	if err != nil {
 		return err
 	}
 
@@ -481,6 +501,7 @@ func IsUserEnabled(id int, fromDB bool) (status bool, err error) {
 	if !fromDB && common.RedisEnabled {
 		// Try Redis first
 		status, err := getUserStatusCache(id)
+		common.RecordCacheLookup("user_status", err == nil)
 		if err == nil {
 			return status == common.UserStatusEnabled, nil
 		}
@@ -522,6 +543,7 @@ func GetUserQuota(id int, fromDB bool) (quota int, err error) {
 	}()
 	if !fromDB && common.RedisEnabled {
 		quota, err := getUserQuotaCache(id)
+		common.RecordCacheLookup("user_quota", err == nil)
 		if err == nil {
 			return quota, nil
 		}
@@ -561,6 +583,7 @@ func GetUserGroup(id int, fromDB bool) (group string, err error) {
 	}()
 	if !fromDB && common.RedisEnabled {
 		group, err := getUserGroupCache(id)
+		common.RecordCacheLookup("user_group", err == nil)
 		if err == nil {
 			return group, nil
 		}
@@ -575,7 +598,9 @@ func GetUserGroup(id int, fromDB bool) (group string, err error) {
 	return group, nil
 }
 
-func IncreaseUserQuota(id int, quota int) (err error) {
+// IncreaseUserQuota credits the user quota and journals it in the quota
+// ledger under ref.
+func IncreaseUserQuota(id int, quota int, ref QuotaRef) (err error) {
 	if quota < 0 {
 		return errors.New("quota 不能为负数！")
 	}
@@ -585,22 +610,35 @@ func IncreaseUserQuota(id int, quota int) (err error) {
 			common.SysError("failed to increase user quota: " + err.Error())
 		}
 	})
+	return updateUserQuotaJournaled(id, quota, ref)
+}
+
+// updateUserQuotaJournaled writes a change of the user quota with its ledger
+// entry, in one transaction or in one batch.
+func updateUserQuotaJournaled(id int, delta int, ref QuotaRef) error {
+	if delta == 0 {
+		return nil
+	}
 	if common.BatchUpdateEnabled {
-		addNewRecord(BatchUpdateTypeUserQuota, id, quota)
+		addNewQuotaRecord(BatchUpdateTypeUserQuota, id, delta, newQuotaLedger(id, UserLedgerAccount(id), delta, ref))
 		return nil
 	}
-	return increaseUserQuota(id, quota)
+	return DB.Transaction(func(tx *gorm.DB) error {
+		return deltaUpdateUserQuotaTx(tx, id, delta, ref)
+	})
 }
 
-func increaseUserQuota(id int, quota int) (err error) {
-	err = DB.Model(&User{}).Where("id = ?", id).Update("quota", gorm.Expr("quota + ?", quota)).Error
+func increaseUserQuota(tx *gorm.DB, id int, quota int) (err error) {
+	err = tx.Model(&User{}).Where("id = ?", id).Update("quota", gorm.Expr("quota + ?", quota)).Error
 	if err != nil {
 		return err
 	}
 	return err
 }
 
-func DecreaseUserQuota(id int, quota int) (err error) {
+// DecreaseUserQuota debits the user quota and journals it in the quota
+// ledger under ref.
+func DecreaseUserQuota(id int, quota int, ref QuotaRef) (err error) {
 	if quota < 0 {
 		return errors.New("quota 不能为负数！")
 	}
@@ -610,30 +648,47 @@ func DecreaseUserQuota(id int, quota int) (err error) {
 			common.SysError("failed to decrease user quota: " + err.Error())
 		}
 	})
-	if common.BatchUpdateEnabled {
-		addNewRecord(BatchUpdateTypeUserQuota, id, -quota)
+	return updateUserQuotaJournaled(id, -quota, ref)
+}
+
+func DeltaUpdateUserQuota(id int, delta int, ref QuotaRef) (err error) {
+	if delta == 0 {
 		return nil
 	}
-	return decreaseUserQuota(id, quota)
+	if delta > 0 {
+		return IncreaseUserQuota(id, delta, ref)
+	} else {
+		return DecreaseUserQuota(id, -delta, ref)
+	}
 }
 
-func decreaseUserQuota(id int, quota int) (err error) {
-	err = DB.Model(&User{}).Where("id = ?", id).Update("quota", gorm.Expr("quota - ?", quota)).Error
+// deltaUpdateUserQuotaTx changes the user quota by delta and journals it
+// within tx, so both commit or roll back with the caller's transaction. The
+// batch updater and the cache are bypassed, call cacheDeltaUserQuota once tx
+// committed.
+func deltaUpdateUserQuotaTx(tx *gorm.DB, id int, delta int, ref QuotaRef) error {
+	if delta == 0 {
+		return nil
+	}
+	err := increaseUserQuota(tx, id, delta)
 	if err != nil {
 		return err
 	}
-	return err
+	return recordQuotaLedger(tx, id, UserLedgerAccount(id), delta, ref)
 }
 
-func DeltaUpdateUserQuota(id int, delta int) (err error) {
+// cacheDeltaUserQuota moves the cached user quota after a change made with
+// deltaUpdateUserQuotaTx was committed.
+func cacheDeltaUserQuota(id int, delta int) {
 	if delta == 0 {
-		return nil
-	}
-	if delta > 0 {
-		return IncreaseUserQuota(id, delta)
-	} else {
-		return DecreaseUserQuota(id, -delta)
+		return
 	}
+	gopool.Go(func() {
+		err := cacheIncrUserQuota(id, int64(delta))
+		if err != nil {
+			common.SysError("failed to update user quota cache: " + err.Error())
+		}
+	})
 }
 
 func GetRootUserEmail() (email string) {
@@ -700,6 +755,7 @@ func GetUsernameById(id int, fromDB bool) (username string, err error) {
 	}()
 	if !fromDB && common.RedisEnabled {
 		username, err := getUserNameCache(id)
+		common.RecordCacheLookup("user_name", err == nil)
 		if err == nil {
 			return username, nil
 		}
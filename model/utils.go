@@ -21,10 +21,15 @@ const (
 var batchUpdateStores []map[int]int
 var batchUpdateLocks []sync.Mutex
 
+// batchUpdateLedgers are the ledger entries of the pending quota changes,
+// they are guarded by the lock of their type.
+var batchUpdateLedgers []map[int][]*QuotaLedger
+
 func init() {
 	for i := 0; i < BatchUpdateTypeCount; i++ {
 		batchUpdateStores = append(batchUpdateStores, make(map[int]int))
 		batchUpdateLocks = append(batchUpdateLocks, sync.Mutex{})
+		batchUpdateLedgers = append(batchUpdateLedgers, make(map[int][]*QuotaLedger))
 	}
 }
 
@@ -47,23 +52,44 @@ func addNewRecord(type_ int, id int, value int) {
 	}
 }
 
+// addNewQuotaRecord batches a change of a user or token quota with its
+// ledger entry, batchUpdate writes them in one transaction.
+func addNewQuotaRecord(type_ int, id int, value int, entry *QuotaLedger) {
+	batchUpdateLocks[type_].Lock()
+	defer batchUpdateLocks[type_].Unlock()
+	batchUpdateStores[type_][id] += value
+	batchUpdateLedgers[type_][id] = append(batchUpdateLedgers[type_][id], entry)
+}
+
 func batchUpdate() {
 	common.SysLog("batch update started")
 	for i := 0; i < BatchUpdateTypeCount; i++ {
 		batchUpdateLocks[i].Lock()
 		store := batchUpdateStores[i]
 		batchUpdateStores[i] = make(map[int]int)
+		ledgers := batchUpdateLedgers[i]
+		batchUpdateLedgers[i] = make(map[int][]*QuotaLedger)
 		batchUpdateLocks[i].Unlock()
 		// TODO: maybe we can combine updates with same key?
 		for key, value := range store {
 			switch i {
 			case BatchUpdateTypeUserQuota:
-				err := increaseUserQuota(key, value)
+				err := DB.Transaction(func(tx *gorm.DB) error {
+					if err := increaseUserQuota(tx, key, value); err != nil {
+						return err
+					}
+					return recordQuotaLedgers(tx, ledgers[key])
+				})
 				if err != nil {
 					common.SysError("failed to batch update user quota: " + err.Error())
 				}
 			case BatchUpdateTypeTokenQuota:
-				err := increaseTokenQuota(key, value)
+				err := DB.Transaction(func(tx *gorm.DB) error {
+					if err := increaseTokenQuota(tx, key, value); err != nil {
+						return err
+					}
+					return recordQuotaLedgers(tx, ledgers[key])
+				})
 				if err != nil {
 					common.SysError("failed to batch update token quota: " + err.Error())
 				}
@@ -76,6 +102,7 @@ func batchUpdate() {
 			}
 		}
 	}
+	batchUpdateChannelKeyUsedQuota()
 	common.SysLog("batch update finished")
 }
 
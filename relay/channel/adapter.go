@@ -24,6 +24,24 @@ type Adaptor interface {
 	GetChannelName() string
 }
 
+// ClaudeAdaptor is implemented by adaptors whose upstream speaks the Anthropic
+// Messages API natively, so /v1/messages requests can be relayed without conversion.
+type ClaudeAdaptor interface {
+	ConvertClaudeRequest(c *gin.Context, info *relaycommon.RelayInfo, requestBody []byte) ([]byte, error)
+}
+
+// GeminiAdaptor is implemented by adaptors whose upstream speaks the Gemini
+// generateContent API natively.
+type GeminiAdaptor interface {
+	ConvertGeminiRequest(c *gin.Context, info *relaycommon.RelayInfo, requestBody []byte) ([]byte, error)
+}
+
+// ResponsesAdaptor is implemented by adaptors whose upstream serves the OpenAI
+// Responses API natively.
+type ResponsesAdaptor interface {
+	ConvertResponsesRequest(c *gin.Context, info *relaycommon.RelayInfo, requestBody []byte) ([]byte, error)
+}
+
 type TaskAdaptor interface {
 	Init(info *relaycommon.TaskRelayInfo)
 
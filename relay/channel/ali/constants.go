@@ -3,6 +3,7 @@ package ali
 var ModelList = []string{
 	"qwen-turbo", "qwen-plus", "qwen-max", "qwen-max-longcontext",
 	"text-embedding-v1",
+	"wanx2.1-imageedit",
 }
 
 var ChannelName = "ali"
@@ -1,6 +1,7 @@
 package ali
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -26,8 +27,67 @@ func oaiImage2Ali(request dto.ImageRequest) *AliImageRequest {
 	return &imageRequest
 }
 
+// oaiImageEdit2Ali maps a multipart image edit to a wanx image edit task. The
+// function defaults to an edit inside the mask when one is given and can be
+// overridden with an ali specific function form field.
+func oaiImageEdit2Ali(c *gin.Context, request dto.ImageRequest) (*AliImageEditRequest, error) {
+	var imageRequest AliImageEditRequest
+	imageRequest.Model = request.Model
+	imageRequest.Input.Prompt = request.Prompt
+	imageRequest.Parameters.N = request.N
+
+	baseImage, err := formImageDataUrl(c, "image", "image[]")
+	if err != nil {
+		return nil, err
+	}
+	if baseImage == "" {
+		return nil, errors.New("image is required")
+	}
+	imageRequest.Input.BaseImageUrl = baseImage
+	imageRequest.Input.MaskImageUrl, err = formImageDataUrl(c, "mask")
+	if err != nil {
+		return nil, err
+	}
+
+	imageRequest.Input.Function = c.Request.PostForm.Get("function")
+	if imageRequest.Input.Function == "" {
+		if imageRequest.Input.MaskImageUrl != "" {
+			imageRequest.Input.Function = "description_edit_with_mask"
+		} else {
+			imageRequest.Input.Function = "description_edit"
+		}
+	}
+	return &imageRequest, nil
+}
+
+// formImageDataUrl returns the first uploaded file of the given fields as a
+// base64 data url, or an empty string when none was uploaded.
+func formImageDataUrl(c *gin.Context, keys ...string) (string, error) {
+	for _, key := range keys {
+		headers := c.Request.MultipartForm.File[key]
+		if len(headers) == 0 {
+			continue
+		}
+		file, err := headers[0].Open()
+		if err != nil {
+			return "", fmt.Errorf("open %s failed: %w", key, err)
+		}
+		data, err := io.ReadAll(file)
+		file.Close()
+		if err != nil {
+			return "", fmt.Errorf("read %s failed: %w", key, err)
+		}
+		mimeType := headers[0].Header.Get("Content-Type")
+		if !strings.HasPrefix(mimeType, "image/") {
+			mimeType = http.DetectContentType(data)
+		}
+		return fmt.Sprintf("data:%s;base64,%s", mimeType, base64.StdEncoding.EncodeToString(data)), nil
+	}
+	return "", nil
+}
+
 func updateTask(info *relaycommon.RelayInfo, taskID string, key string) (*AliResponse, error, []byte) {
-	url := fmt.Sprintf("/api/v1/tasks/%s", taskID)
+	url := fmt.Sprintf("%s/api/v1/tasks/%s", info.BaseUrl, taskID)
 
 	var aliResponse AliResponse
 
@@ -114,9 +174,17 @@ func responseAli2OpenAIImage(c *gin.Context, response *AliResponse, info *relayc
 		} else {
 			b64Json = data.B64Image
 		}
+		url := data.Url
+		if responseFormat != "b64_json" {
+			var err error
+			url, err = service.StoreRemoteFile(c, data.Url, "images")
+			if err != nil {
+				common.LogError(c, "store image failed: "+err.Error())
+			}
+		}
 
 		imageResponse.Data = append(imageResponse.Data, dto.ImageData{
-			Url:           data.Url,
+			Url:           url,
 			B64Json:       b64Json,
 			RevisedPrompt: "",
 		})
@@ -125,8 +193,7 @@ func responseAli2OpenAIImage(c *gin.Context, response *AliResponse, info *relayc
 }
 
 func aliImageHandler(c *gin.Context, resp *http.Response, info *relaycommon.RelayInfo) (*dto.OpenAIErrorWithStatusCode, *dto.Usage) {
-	apiKey := c.Request.Header.Get("Authorization")
-	apiKey = strings.TrimPrefix(apiKey, "Bearer ")
+	apiKey := info.ApiKey
 	responseFormat := c.GetString("response_format")
 
 	var aliTaskResponse AliResponse
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	rawcommon "one-api/common"
 	"one-api/relay/common"
 	"one-api/relay/constant"
 	"one-api/service"
@@ -12,6 +13,8 @@ import (
 	"github.com/gorilla/websocket"
 
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 )
 
 func SetupApiRequestHeader(info *common.RelayInfo, c *gin.Context, req *http.Header) {
@@ -96,6 +99,12 @@ func doRequest(c *gin.Context, req *http.Request) (*http.Response, error) {
 	isStream := c.GetBool("stream")
 	var resp *http.Response
 	var err error
+	if c.GetBool("hedged") {
+		// a hedged request is cancelled as soon as the other one answered
+		req = req.WithContext(c.Request.Context())
+	}
+	span := rawcommon.StartUpstreamSpan(c, req)
+	defer span.End()
 	if isStream {
 		resp, err = service.GetStreamHttpClient().Do(req)
 	} else {
@@ -103,11 +112,13 @@ func doRequest(c *gin.Context, req *http.Request) (*http.Response, error) {
 	}
 
 	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
 		return nil, err
 	}
 	if resp == nil {
 		return nil, errors.New("resp is nil")
 	}
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
 	_ = req.Body.Close()
 	_ = c.Request.Body.Close()
 	return resp, nil
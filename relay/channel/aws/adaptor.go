@@ -3,6 +3,7 @@ package aws
 import (
 	"errors"
 	"github.com/gin-gonic/gin"
+	"github.com/tidwall/sjson"
 	"io"
 	"net/http"
 	"one-api/dto"
@@ -49,10 +50,33 @@ func (a *Adaptor) ConvertRequest(c *gin.Context, info *relaycommon.RelayInfo, re
 	var claudeReq *claude.ClaudeRequest
 	var err error
 	claudeReq, err = claude.RequestOpenAI2ClaudeMessage(*request)
+	if err != nil {
+		return nil, err
+	}
 
-	c.Set("request_model", request.Model)
+	// the claude request model has a -thinking suffix removed
+	c.Set("request_model", claudeReq.Model)
 	c.Set("converted_request", claudeReq)
-	return claudeReq, err
+	return claudeReq, nil
+}
+
+func (a *Adaptor) ConvertClaudeRequest(c *gin.Context, info *relaycommon.RelayInfo, requestBody []byte) ([]byte, error) {
+	// bedrock takes the model and stream mode from the api call instead of the body
+	requestBody, err := sjson.DeleteBytes(requestBody, "model")
+	if err != nil {
+		return nil, err
+	}
+	requestBody, err = sjson.DeleteBytes(requestBody, "stream")
+	if err != nil {
+		return nil, err
+	}
+	requestBody, err = sjson.SetBytes(requestBody, "anthropic_version", "bedrock-2023-05-31")
+	if err != nil {
+		return nil, err
+	}
+	c.Set("request_model", info.UpstreamModelName)
+	c.Set("converted_request", requestBody)
+	return requestBody, nil
 }
 
 func (a *Adaptor) ConvertRerankRequest(c *gin.Context, relayMode int, request dto.RerankRequest) (any, error) {
@@ -64,6 +88,14 @@ func (a *Adaptor) DoRequest(c *gin.Context, info *relaycommon.RelayInfo, request
 }
 
 func (a *Adaptor) DoResponse(c *gin.Context, resp *http.Response, info *relaycommon.RelayInfo) (usage any, err *dto.OpenAIErrorWithStatusCode) {
+	if info.RelayFormat == relaycommon.RelayFormatClaude {
+		if info.IsStream {
+			err, usage = awsClaudeNativeStreamHandler(c, info)
+		} else {
+			err, usage = awsClaudeNativeHandler(c, info)
+		}
+		return
+	}
 	if info.IsStream {
 		err, usage = awsStreamHandler(c, resp, info, a.RequestMode)
 	} else {
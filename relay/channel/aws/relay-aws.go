@@ -96,10 +96,10 @@ func awsHandler(c *gin.Context, info *relaycommon.RelayInfo, requestMode int) (*
 
 	openaiResp := claude.ResponseClaude2OpenAI(requestMode, claudeResponse)
 	usage := relaymodel.Usage{
-		PromptTokens:     claudeResponse.Usage.InputTokens,
 		CompletionTokens: claudeResponse.Usage.OutputTokens,
-		TotalTokens:      claudeResponse.Usage.InputTokens + claudeResponse.Usage.OutputTokens,
 	}
+	claude.SetPromptUsage(&usage, &claudeResponse.Usage)
+	usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
 	openaiResp.Usage = usage
 
 	c.JSON(http.StatusOK, openaiResp)
@@ -169,7 +169,9 @@ func awsStreamHandler(c *gin.Context, resp *http.Response, info *relaycommon.Rel
 
 			response, claudeUsage := claude.StreamResponseClaude2OpenAI(requestMode, claudeResp)
 			if claudeUsage != nil {
-				usage.PromptTokens += claudeUsage.InputTokens
+				usage.PromptTokens += claudeUsage.InputTokens + claudeUsage.CacheReadInputTokens + claudeUsage.CacheCreationInputTokens
+				usage.PromptTokensDetails.CachedTokens += claudeUsage.CacheReadInputTokens
+				usage.PromptTokensDetails.CachedCreationTokens += claudeUsage.CacheCreationInputTokens
 				usage.CompletionTokens += claudeUsage.OutputTokens
 			}
 
@@ -218,3 +220,113 @@ func awsStreamHandler(c *gin.Context, resp *http.Response, info *relaycommon.Rel
 	}
 	return nil, &usage
 }
+
+func awsClaudeNativeHandler(c *gin.Context, info *relaycommon.RelayInfo) (*relaymodel.OpenAIErrorWithStatusCode, *relaymodel.Usage) {
+	awsCli, err := newAwsClient(c, info)
+	if err != nil {
+		return wrapErr(errors.Wrap(err, "newAwsClient")), nil
+	}
+
+	awsModelId, err := awsModelID(c.GetString("request_model"))
+	if err != nil {
+		return wrapErr(errors.Wrap(err, "awsModelID")), nil
+	}
+
+	requestBody, ok := c.Get("converted_request")
+	if !ok {
+		return wrapErr(errors.New("request not found")), nil
+	}
+	awsReq := &bedrockruntime.InvokeModelInput{
+		ModelId:     aws.String(awsModelId),
+		Accept:      aws.String("application/json"),
+		ContentType: aws.String("application/json"),
+		Body:        requestBody.([]byte),
+	}
+
+	awsResp, err := awsCli.InvokeModel(c.Request.Context(), awsReq)
+	if err != nil {
+		return wrapErr(errors.Wrap(err, "InvokeModel")), nil
+	}
+	return claude.ClaudeNativeResponseBody(c, http.StatusOK, awsResp.Body)
+}
+
+func awsClaudeNativeStreamHandler(c *gin.Context, info *relaycommon.RelayInfo) (*relaymodel.OpenAIErrorWithStatusCode, *relaymodel.Usage) {
+	awsCli, err := newAwsClient(c, info)
+	if err != nil {
+		return wrapErr(errors.Wrap(err, "newAwsClient")), nil
+	}
+
+	awsModelId, err := awsModelID(c.GetString("request_model"))
+	if err != nil {
+		return wrapErr(errors.Wrap(err, "awsModelID")), nil
+	}
+
+	requestBody, ok := c.Get("converted_request")
+	if !ok {
+		return wrapErr(errors.New("request not found")), nil
+	}
+	awsReq := &bedrockruntime.InvokeModelWithResponseStreamInput{
+		ModelId:     aws.String(awsModelId),
+		Accept:      aws.String("application/json"),
+		ContentType: aws.String("application/json"),
+		Body:        requestBody.([]byte),
+	}
+
+	awsResp, err := awsCli.InvokeModelWithResponseStream(c.Request.Context(), awsReq)
+	if err != nil {
+		return wrapErr(errors.Wrap(err, "InvokeModelWithResponseStream")), nil
+	}
+	stream := awsResp.GetStream()
+	defer stream.Close()
+
+	service.SetEventStreamHeaders(c)
+	var usage relaymodel.Usage
+	responseText := ""
+	for event := range stream.Events() {
+		chunk, ok := event.(*types.ResponseStreamMemberChunk)
+		if !ok {
+			common.SysError(fmt.Sprintf("unexpected bedrock stream event: %T", event))
+			break
+		}
+		info.SetFirstResponseTime()
+		claudeResp := new(claude.ClaudeResponse)
+		err := json.Unmarshal(chunk.Value.Bytes, claudeResp)
+		if err != nil {
+			common.SysError("error unmarshalling stream response: " + err.Error())
+			continue
+		}
+		switch claudeResp.Type {
+		case "message_start":
+			if claudeResp.Message != nil {
+				claude.SetPromptUsage(&usage, &claudeResp.Message.Usage)
+			}
+		case "content_block_delta":
+			if claudeResp.Delta != nil {
+				responseText += claudeResp.Delta.Text + claudeResp.Delta.PartialJson
+			}
+		case "message_delta":
+			usage.CompletionTokens = claudeResp.Usage.OutputTokens
+		}
+		// bedrock only returns the event data, rebuild the sse framing of the messages api
+		err = service.RawLineData(c, "event: "+claudeResp.Type)
+		if err == nil {
+			err = service.RawLineData(c, "data: "+string(chunk.Value.Bytes))
+		}
+		if err == nil {
+			err = service.RawLineData(c, "")
+		}
+		if err != nil {
+			common.LogError(c, "send_stream_response_failed: "+err.Error())
+		}
+	}
+
+	if usage.PromptTokens == 0 {
+		usage.PromptTokens = info.PromptTokens
+	}
+	if usage.CompletionTokens == 0 {
+		textUsage, _ := service.ResponseText2Usage(responseText, info.UpstreamModelName, usage.PromptTokens)
+		usage.CompletionTokens = textUsage.CompletionTokens
+	}
+	usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	return nil, &usage
+}
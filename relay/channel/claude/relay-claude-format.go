@@ -0,0 +1,405 @@
+package claude
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"one-api/dto"
+	relaycommon "one-api/relay/common"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+func stopReasonOpenAI2Claude(reason string) string {
+	switch reason {
+	case "stop":
+		return "end_turn"
+	case "length", "max_tokens":
+		return "max_tokens"
+	case "tool_calls", "function_call":
+		return "tool_use"
+	default:
+		return "end_turn"
+	}
+}
+
+// claudeContentText joins the text of a string or content block array, as used
+// by system prompts and tool_result content.
+func claudeContentText(content any) string {
+	if content == nil {
+		return ""
+	}
+	if text, ok := content.(string); ok {
+		return text
+	}
+	blocks, err := parseClaudeContent(content)
+	if err != nil {
+		return ""
+	}
+	texts := make([]string, 0, len(blocks))
+	for _, block := range blocks {
+		if block.Type == "text" {
+			texts = append(texts, block.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+func parseClaudeContent(content any) ([]ClaudeMediaMessage, error) {
+	if text, ok := content.(string); ok {
+		return []ClaudeMediaMessage{{Type: "text", Text: text}}, nil
+	}
+	contentBytes, err := json.Marshal(content)
+	if err != nil {
+		return nil, err
+	}
+	var blocks []ClaudeMediaMessage
+	err = json.Unmarshal(contentBytes, &blocks)
+	return blocks, err
+}
+
+func toolChoiceClaude2OpenAI(toolChoice any) any {
+	choice, ok := toolChoice.(map[string]any)
+	if !ok {
+		return nil
+	}
+	switch choice["type"] {
+	case "auto":
+		return "auto"
+	case "any":
+		return "required"
+	case "none":
+		return "none"
+	case "tool":
+		return map[string]any{
+			"type": "function",
+			"function": map[string]any{
+				"name": choice["name"],
+			},
+		}
+	}
+	return nil
+}
+
+// RequestClaude2OpenAI converts a native Messages API request so it can be served
+// by adaptors that only understand OpenAI chat completions.
+func RequestClaude2OpenAI(claudeRequest ClaudeRequest) (*dto.GeneralOpenAIRequest, error) {
+	openAIRequest := dto.GeneralOpenAIRequest{
+		Model:       claudeRequest.Model,
+		MaxTokens:   claudeRequest.MaxTokens,
+		Temperature: claudeRequest.Temperature,
+		TopP:        claudeRequest.TopP,
+		TopK:        claudeRequest.TopK,
+		Stream:      claudeRequest.Stream,
+		ToolChoice:  toolChoiceClaude2OpenAI(claudeRequest.ToolChoice),
+	}
+	if len(claudeRequest.StopSequences) > 0 {
+		openAIRequest.Stop = claudeRequest.StopSequences
+	}
+	for _, tool := range claudeRequest.Tools {
+		if tool.InputSchema == nil {
+			// server side tools have no schema and cannot be expressed as functions
+			continue
+		}
+		openAIRequest.Tools = append(openAIRequest.Tools, dto.ToolCall{
+			Type: "function",
+			Function: dto.FunctionCall{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  tool.InputSchema,
+			},
+		})
+	}
+
+	messages := make([]dto.Message, 0, len(claudeRequest.Messages)+1)
+	if system := claudeContentText(claudeRequest.System); system != "" {
+		systemMessage := dto.Message{Role: "system"}
+		systemMessage.SetStringContent(system)
+		messages = append(messages, systemMessage)
+	}
+	for _, claudeMessage := range claudeRequest.Messages {
+		blocks, err := parseClaudeContent(claudeMessage.Content)
+		if err != nil {
+			return nil, fmt.Errorf("invalid content of %s message: %w", claudeMessage.Role, err)
+		}
+		mediaContents := make([]dto.MediaContent, 0, len(blocks))
+		toolCalls := make([]dto.ToolCall, 0)
+		isTextOnly := true
+		for _, block := range blocks {
+			switch block.Type {
+			case "text":
+				mediaContents = append(mediaContents, dto.MediaContent{
+					Type: dto.ContentTypeText,
+					Text: block.Text,
+				})
+			case "image":
+				if block.Source == nil {
+					continue
+				}
+				imageUrl := block.Source.Url
+				if block.Source.Type == "base64" {
+					imageUrl = fmt.Sprintf("data:%s;base64,%s", block.Source.MediaType, block.Source.Data)
+				}
+				isTextOnly = false
+				mediaContents = append(mediaContents, dto.MediaContent{
+					Type: dto.ContentTypeImageURL,
+					ImageUrl: dto.MessageImageUrl{
+						Url:    imageUrl,
+						Detail: "auto",
+					},
+				})
+			case "tool_use":
+				arguments, _ := json.Marshal(block.Input)
+				toolCalls = append(toolCalls, dto.ToolCall{
+					ID:   block.Id,
+					Type: "function",
+					Function: dto.FunctionCall{
+						Name:      block.Name,
+						Arguments: string(arguments),
+					},
+				})
+			case "tool_result":
+				// tool results must directly follow the assistant tool calls in OpenAI format
+				toolMessage := dto.Message{
+					Role:       "tool",
+					ToolCallId: block.ToolUseId,
+				}
+				toolMessage.SetStringContent(claudeContentText(block.Content))
+				messages = append(messages, toolMessage)
+			}
+		}
+		if len(mediaContents) == 0 && len(toolCalls) == 0 {
+			continue
+		}
+		message := dto.Message{Role: claudeMessage.Role}
+		if isTextOnly {
+			texts := make([]string, 0, len(mediaContents))
+			for _, mediaContent := range mediaContents {
+				texts = append(texts, mediaContent.Text)
+			}
+			message.SetStringContent(strings.Join(texts, "\n"))
+		} else {
+			content, _ := json.Marshal(mediaContents)
+			message.Content = content
+		}
+		if len(toolCalls) > 0 {
+			message.SetToolCalls(toolCalls)
+		}
+		messages = append(messages, message)
+	}
+	openAIRequest.Messages = messages
+	return &openAIRequest, nil
+}
+
+// ResponseOpenAI2Claude converts a chat completion into a Messages API response.
+func ResponseOpenAI2Claude(openAIResponse *dto.OpenAITextResponse) *ClaudeMessageResponse {
+	claudeResponse := ClaudeMessageResponse{
+		Id:      openAIResponse.Id,
+		Type:    "message",
+		Role:    "assistant",
+		Model:   openAIResponse.Model,
+		Content: make([]ClaudeMediaMessage, 0),
+		Usage: ClaudeUsage{
+			// claude counts cache reads apart from input_tokens
+			InputTokens:          openAIResponse.Usage.PromptTokens - openAIResponse.Usage.PromptTokensDetails.CachedTokens,
+			CacheReadInputTokens: openAIResponse.Usage.PromptTokensDetails.CachedTokens,
+			OutputTokens:         openAIResponse.Usage.CompletionTokens,
+		},
+	}
+	stopReason := "end_turn"
+	if len(openAIResponse.Choices) > 0 {
+		choice := openAIResponse.Choices[0]
+		if text := choice.Message.StringContent(); text != "" {
+			claudeResponse.Content = append(claudeResponse.Content, ClaudeMediaMessage{
+				Type: "text",
+				Text: text,
+			})
+		}
+		for _, toolCall := range choice.Message.ParseToolCalls() {
+			input := make(map[string]any)
+			if err := json.Unmarshal([]byte(toolCall.Function.Arguments), &input); err != nil {
+				common.SysError("tool call function arguments is not a map[string]any: " + toolCall.Function.Arguments)
+			}
+			claudeResponse.Content = append(claudeResponse.Content, ClaudeMediaMessage{
+				Type:  "tool_use",
+				Id:    toolCall.ID,
+				Name:  toolCall.Function.Name,
+				Input: input,
+			})
+		}
+		stopReason = stopReasonOpenAI2Claude(choice.FinishReason)
+	}
+	claudeResponse.StopReason = &stopReason
+	return &claudeResponse
+}
+
+// OpenAI2ClaudeConverter turns the chat completion output of OpenAI-format
+// adaptors into Messages API responses and events.
+type OpenAI2ClaudeConverter struct {
+	info   *relaycommon.RelayInfo
+	output strings.Builder
+
+	started    bool
+	nextIndex  int
+	blockIndex int
+	blockType  string
+	toolCallId string
+	stopReason string
+	usage      *dto.Usage
+}
+
+func NewOpenAI2ClaudeConverter(info *relaycommon.RelayInfo) *OpenAI2ClaudeConverter {
+	return &OpenAI2ClaudeConverter{
+		info: info,
+	}
+}
+
+func (a *OpenAI2ClaudeConverter) ConvertBody(statusCode int, body []byte) []byte {
+	var openAIResponse dto.OpenAITextResponse
+	if statusCode != http.StatusOK || json.Unmarshal(body, &openAIResponse) != nil {
+		return body
+	}
+	if openAIResponse.Model == "" {
+		openAIResponse.Model = a.info.UpstreamModelName
+	}
+	jsonResponse, err := json.Marshal(ResponseOpenAI2Claude(&openAIResponse))
+	if err != nil {
+		common.SysError("error marshalling claude response: " + err.Error())
+		return body
+	}
+	return jsonResponse
+}
+
+func (a *OpenAI2ClaudeConverter) ConvertStreamData(data string) string {
+	var streamResponse dto.ChatCompletionsStreamResponse
+	if err := json.Unmarshal([]byte(data), &streamResponse); err != nil {
+		common.SysError("error unmarshalling stream response: " + err.Error())
+		return ""
+	}
+	if !a.started {
+		a.startMessage(streamResponse.Id, streamResponse.Model)
+	}
+	if streamResponse.Usage != nil && streamResponse.Usage.TotalTokens != 0 {
+		a.usage = streamResponse.Usage
+	}
+	for _, choice := range streamResponse.Choices {
+		if text := choice.Delta.GetContentString(); text != "" {
+			if a.blockType != "text" {
+				a.stopBlock()
+				a.startBlock("text", gin.H{"type": "text", "text": ""})
+			}
+			a.event("content_block_delta", gin.H{
+				"type":  "content_block_delta",
+				"index": a.blockIndex,
+				"delta": gin.H{"type": "text_delta", "text": text},
+			})
+		}
+		for _, toolCall := range choice.Delta.ToolCalls {
+			if toolCall.ID != "" && toolCall.ID != a.toolCallId {
+				a.stopBlock()
+				a.toolCallId = toolCall.ID
+				a.startBlock("tool_use", gin.H{
+					"type":  "tool_use",
+					"id":    toolCall.ID,
+					"name":  toolCall.Function.Name,
+					"input": gin.H{},
+				})
+			}
+			if toolCall.Function.Arguments != "" && a.blockType == "tool_use" {
+				a.event("content_block_delta", gin.H{
+					"type":  "content_block_delta",
+					"index": a.blockIndex,
+					"delta": gin.H{"type": "input_json_delta", "partial_json": toolCall.Function.Arguments},
+				})
+			}
+		}
+		if choice.FinishReason != nil && *choice.FinishReason != "" {
+			a.stopReason = stopReasonOpenAI2Claude(*choice.FinishReason)
+		}
+	}
+	return a.flush()
+}
+
+func (a *OpenAI2ClaudeConverter) ConvertStreamDone() string {
+	if !a.started {
+		a.startMessage("", "")
+	}
+	a.stopBlock()
+	stopReason := a.stopReason
+	if stopReason == "" {
+		stopReason = "end_turn"
+	}
+	outputTokens := 0
+	if a.usage != nil {
+		outputTokens = a.usage.CompletionTokens
+	}
+	a.event("message_delta", gin.H{
+		"type":  "message_delta",
+		"delta": gin.H{"stop_reason": stopReason, "stop_sequence": nil},
+		"usage": gin.H{"output_tokens": outputTokens},
+	})
+	a.event("message_stop", gin.H{"type": "message_stop"})
+	return a.flush()
+}
+
+func (a *OpenAI2ClaudeConverter) startMessage(id string, model string) {
+	a.started = true
+	if id == "" {
+		id = fmt.Sprintf("msg_%s", common.GetUUID())
+	}
+	if model == "" {
+		model = a.info.UpstreamModelName
+	}
+	a.event("message_start", gin.H{
+		"type": "message_start",
+		"message": ClaudeMessageResponse{
+			Id:      id,
+			Type:    "message",
+			Role:    "assistant",
+			Model:   model,
+			Content: make([]ClaudeMediaMessage, 0),
+			Usage: ClaudeUsage{
+				InputTokens: a.info.PromptTokens,
+			},
+		},
+	})
+}
+
+func (a *OpenAI2ClaudeConverter) startBlock(blockType string, contentBlock gin.H) {
+	a.blockIndex = a.nextIndex
+	a.nextIndex++
+	a.blockType = blockType
+	a.event("content_block_start", gin.H{
+		"type":          "content_block_start",
+		"index":         a.blockIndex,
+		"content_block": contentBlock,
+	})
+}
+
+func (a *OpenAI2ClaudeConverter) stopBlock() {
+	if a.blockType == "" {
+		return
+	}
+	a.event("content_block_stop", gin.H{
+		"type":  "content_block_stop",
+		"index": a.blockIndex,
+	})
+	a.blockType = ""
+}
+
+func (a *OpenAI2ClaudeConverter) event(eventType string, event any) {
+	jsonData, err := json.Marshal(event)
+	if err != nil {
+		common.SysError("error marshalling claude stream event: " + err.Error())
+		return
+	}
+	a.output.WriteString(fmt.Sprintf("event: %s\ndata: %s\n\n", eventType, jsonData))
+}
+
+func (a *OpenAI2ClaudeConverter) flush() string {
+	output := a.output.String()
+	a.output.Reset()
+	return output
+}
@@ -0,0 +1,124 @@
+package claude
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"one-api/common"
+	"one-api/dto"
+	relaycommon "one-api/relay/common"
+	"one-api/service"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/sjson"
+)
+
+// ConvertClaudeNativeRequest prepares a client Messages API body for an Anthropic
+// compatible upstream, only replacing the model with the mapped upstream name.
+func ConvertClaudeNativeRequest(info *relaycommon.RelayInfo, requestBody []byte) ([]byte, error) {
+	return sjson.SetBytes(requestBody, "model", info.UpstreamModelName)
+}
+
+// ClaudeNativeStreamHandler forwards upstream Messages API events to the client
+// unchanged, collecting usage from message_start and message_delta.
+func ClaudeNativeStreamHandler(c *gin.Context, resp *http.Response, info *relaycommon.RelayInfo) (*dto.OpenAIErrorWithStatusCode, *dto.Usage) {
+	usage := &dto.Usage{}
+	responseText := ""
+	scanner := bufio.NewScanner(resp.Body)
+	// single events, e.g. inline images, go far beyond the default 64KB
+	scanner.Buffer(make([]byte, 64*1024), 32<<20)
+	scanner.Split(bufio.ScanLines)
+	service.SetEventStreamHeaders(c)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		info.SetFirstResponseTime()
+		if strings.HasPrefix(line, "data:") {
+			data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+			var claudeResponse ClaudeResponse
+			if err := json.Unmarshal([]byte(data), &claudeResponse); err != nil {
+				common.SysError("error unmarshalling stream response: " + err.Error())
+			} else {
+				switch claudeResponse.Type {
+				case "message_start":
+					if claudeResponse.Message != nil {
+						SetPromptUsage(usage, &claudeResponse.Message.Usage)
+					}
+				case "content_block_delta":
+					if claudeResponse.Delta != nil {
+						responseText += claudeResponse.Delta.Text + claudeResponse.Delta.PartialJson
+					}
+				case "message_delta":
+					usage.CompletionTokens = claudeResponse.Usage.OutputTokens
+				}
+			}
+		}
+		err := service.RawLineData(c, line)
+		if err != nil {
+			common.LogError(c, "send_stream_response_failed: "+err.Error())
+		}
+	}
+	_ = resp.Body.Close()
+	if err := scanner.Err(); err != nil {
+		return service.OpenAIErrorWrapper(err, "read_stream_failed", http.StatusInternalServerError), nil
+	}
+
+	if usage.PromptTokens == 0 {
+		usage.PromptTokens = info.PromptTokens
+	}
+	if usage.CompletionTokens == 0 {
+		promptTokensDetails := usage.PromptTokensDetails
+		usage, _ = service.ResponseText2Usage(responseText, info.UpstreamModelName, usage.PromptTokens)
+		usage.PromptTokensDetails = promptTokensDetails
+	}
+	usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	return nil, usage
+}
+
+// ClaudeNativeHandler returns the upstream Messages API response body unchanged.
+func ClaudeNativeHandler(c *gin.Context, resp *http.Response, info *relaycommon.RelayInfo) (*dto.OpenAIErrorWithStatusCode, *dto.Usage) {
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return service.OpenAIErrorWrapper(err, "read_response_body_failed", http.StatusInternalServerError), nil
+	}
+	err = resp.Body.Close()
+	if err != nil {
+		return service.OpenAIErrorWrapper(err, "close_response_body_failed", http.StatusInternalServerError), nil
+	}
+	return ClaudeNativeResponseBody(c, resp.StatusCode, responseBody)
+}
+
+// ClaudeNativeResponseBody writes a complete Messages API response body and
+// returns its usage, shared by upstreams that do not return an *http.Response.
+func ClaudeNativeResponseBody(c *gin.Context, statusCode int, responseBody []byte) (*dto.OpenAIErrorWithStatusCode, *dto.Usage) {
+	var claudeResponse ClaudeResponse
+	err := json.Unmarshal(responseBody, &claudeResponse)
+	if err != nil {
+		return service.OpenAIErrorWrapper(err, "unmarshal_response_body_failed", http.StatusInternalServerError), nil
+	}
+	if claudeResponse.Error.Type != "" {
+		return &dto.OpenAIErrorWithStatusCode{
+			Error: dto.OpenAIError{
+				Message: claudeResponse.Error.Message,
+				Type:    claudeResponse.Error.Type,
+				Param:   "",
+				Code:    claudeResponse.Error.Type,
+			},
+			StatusCode: statusCode,
+		}, nil
+	}
+	usage := dto.Usage{
+		CompletionTokens: claudeResponse.Usage.OutputTokens,
+	}
+	SetPromptUsage(&usage, &claudeResponse.Usage)
+	usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	c.Writer.Header().Set("Content-Type", "application/json")
+	c.Writer.WriteHeader(statusCode)
+	_, err = c.Writer.Write(responseBody)
+	if err != nil {
+		common.SysError("error writing response body: " + err.Error())
+	}
+	return nil, &usage
+}
@@ -28,6 +28,44 @@ func stopReasonClaude2OpenAI(reason string) string {
 	}
 }
 
+// thinkingModelSuffix enables extended thinking, e.g. claude-3-7-sonnet-20250219-thinking
+// is sent upstream as claude-3-7-sonnet-20250219 with a thinking budget.
+const thinkingModelSuffix = "-thinking"
+
+// minThinkingBudget is the smallest budget_tokens accepted by Anthropic.
+const minThinkingBudget = 1024
+
+// applyThinking enables extended thinking with a budget taken from max_tokens,
+// the share depends on reasoning_effort.
+func applyThinking(claudeRequest *ClaudeRequest, reasoningEffort string) {
+	if claudeRequest.MaxTokens == 0 {
+		claudeRequest.MaxTokens = 8192
+	}
+	ratio := 0.8
+	switch reasoningEffort {
+	case "low":
+		ratio = 0.2
+	case "medium":
+		ratio = 0.5
+	}
+	budget := int(float64(claudeRequest.MaxTokens) * ratio)
+	if budget < minThinkingBudget {
+		budget = minThinkingBudget
+	}
+	if int(claudeRequest.MaxTokens) <= budget {
+		// budget_tokens must be lower than max_tokens
+		claudeRequest.MaxTokens = uint(budget + minThinkingBudget)
+	}
+	claudeRequest.Thinking = &Thinking{
+		Type:         "enabled",
+		BudgetTokens: budget,
+	}
+	// thinking does not allow changing temperature, top_p or top_k
+	claudeRequest.Temperature = 0
+	claudeRequest.TopP = 0
+	claudeRequest.TopK = 0
+}
+
 func RequestOpenAI2ClaudeComplete(textRequest dto.GeneralOpenAIRequest) *ClaudeRequest {
 
 	claudeRequest := ClaudeRequest{
@@ -92,6 +130,10 @@ func RequestOpenAI2ClaudeMessage(textRequest dto.GeneralOpenAIRequest) (*ClaudeR
 		Stream:        textRequest.Stream,
 		Tools:         claudeTools,
 	}
+	if strings.HasSuffix(textRequest.Model, thinkingModelSuffix) || textRequest.ReasoningEffort != "" {
+		claudeRequest.Model = strings.TrimSuffix(textRequest.Model, thinkingModelSuffix)
+		applyThinking(&claudeRequest, textRequest.ReasoningEffort)
+	}
 	if claudeRequest.MaxTokens == 0 {
 		claudeRequest.MaxTokens = 4096
 	}
@@ -151,12 +193,27 @@ func RequestOpenAI2ClaudeMessage(textRequest dto.GeneralOpenAIRequest) (*ClaudeR
 			} else {
 				contents := message.ParseContent()
 				content := ""
+				systemMessages := make([]ClaudeMediaMessage, 0, len(contents))
+				cached := false
 				for _, ctx := range contents {
 					if ctx.Type == "text" {
 						content += ctx.Text
+						systemMessages = append(systemMessages, ClaudeMediaMessage{
+							Type:         "text",
+							Text:         ctx.Text,
+							CacheControl: ctx.CacheControl,
+						})
+						if len(ctx.CacheControl) > 0 {
+							cached = true
+						}
 					}
 				}
-				claudeRequest.System = content
+				if cached {
+					// keep the blocks so the cache breakpoints reach the upstream
+					claudeRequest.System = systemMessages
+				} else {
+					claudeRequest.System = content
+				}
 			}
 		} else {
 			if isFirstMessage {
@@ -212,7 +269,8 @@ func RequestOpenAI2ClaudeMessage(textRequest dto.GeneralOpenAIRequest) (*ClaudeR
 				claudeMediaMessages := make([]ClaudeMediaMessage, 0)
 				for _, mediaMessage := range message.ParseContent() {
 					claudeMediaMessage := ClaudeMediaMessage{
-						Type: mediaMessage.Type,
+						Type:         mediaMessage.Type,
+						CacheControl: mediaMessage.CacheControl,
 					}
 					if mediaMessage.Type == "text" {
 						claudeMediaMessage.Text = mediaMessage.Text
@@ -307,7 +365,14 @@ func StreamResponseClaude2OpenAI(reqMode int, claudeResponse *ClaudeResponse) (*
 		} else if claudeResponse.Type == "content_block_delta" {
 			if claudeResponse.Delta != nil {
 				choice.Index = claudeResponse.Index
-				choice.Delta.SetContentString(claudeResponse.Delta.Text)
+				if claudeResponse.Delta.Type == "signature_delta" {
+					return nil, nil
+				}
+				if claudeResponse.Delta.Type == "thinking_delta" {
+					choice.Delta.ReasoningContent = &claudeResponse.Delta.Thinking
+				} else {
+					choice.Delta.SetContentString(claudeResponse.Delta.Text)
+				}
 				if claudeResponse.Delta.Type == "input_json_delta" {
 					tools = append(tools, dto.ToolCall{
 						Function: dto.FunctionCall{
@@ -340,6 +405,15 @@ func StreamResponseClaude2OpenAI(reqMode int, claudeResponse *ClaudeResponse) (*
 	return &response, claudeUsage
 }
 
+// SetPromptUsage fills the prompt side of usage. Claude reports cache reads and
+// writes apart from input_tokens, they are added back so PromptTokens keeps the
+// OpenAI meaning and the cached share is kept in PromptTokensDetails.
+func SetPromptUsage(usage *dto.Usage, claudeUsage *ClaudeUsage) {
+	usage.PromptTokens = claudeUsage.InputTokens + claudeUsage.CacheReadInputTokens + claudeUsage.CacheCreationInputTokens
+	usage.PromptTokensDetails.CachedTokens = claudeUsage.CacheReadInputTokens
+	usage.PromptTokensDetails.CachedCreationTokens = claudeUsage.CacheCreationInputTokens
+}
+
 func ResponseClaude2OpenAI(reqMode int, claudeResponse *ClaudeResponse) *dto.OpenAITextResponse {
 	choices := make([]dto.OpenAITextResponseChoice, 0)
 	fullTextResponse := dto.OpenAITextResponse{
@@ -348,6 +422,7 @@ func ResponseClaude2OpenAI(reqMode int, claudeResponse *ClaudeResponse) *dto.Ope
 		Created: common.GetTimestamp(),
 	}
 	var responseText string
+	var thinkingText string
 	if len(claudeResponse.Content) > 0 {
 		responseText = claudeResponse.Content[0].Text
 	}
@@ -366,8 +441,13 @@ func ResponseClaude2OpenAI(reqMode int, claudeResponse *ClaudeResponse) *dto.Ope
 		choices = append(choices, choice)
 	} else {
 		fullTextResponse.Id = claudeResponse.Id
+		texts := make([]string, 0, len(claudeResponse.Content))
 		for _, message := range claudeResponse.Content {
-			if message.Type == "tool_use" {
+			if message.Type == "text" {
+				texts = append(texts, message.Text)
+			} else if message.Type == "thinking" {
+				thinkingText += message.Thinking
+			} else if message.Type == "tool_use" {
 				args, _ := json.Marshal(message.Input)
 				tools = append(tools, dto.ToolCall{
 					ID:   message.Id,
@@ -379,6 +459,7 @@ func ResponseClaude2OpenAI(reqMode int, claudeResponse *ClaudeResponse) *dto.Ope
 				})
 			}
 		}
+		responseText = strings.Join(texts, "")
 	}
 	choice := dto.OpenAITextResponseChoice{
 		Index: 0,
@@ -388,6 +469,7 @@ func ResponseClaude2OpenAI(reqMode int, claudeResponse *ClaudeResponse) *dto.Ope
 		FinishReason: stopReasonClaude2OpenAI(claudeResponse.StopReason),
 	}
 	choice.SetStringContent(responseText)
+	choice.Message.ReasoningContent = thinkingText
 	if len(tools) > 0 {
 		choice.Message.SetToolCalls(tools)
 	}
@@ -402,6 +484,7 @@ func ClaudeStreamHandler(c *gin.Context, resp *http.Response, info *relaycommon.
 	var usage *dto.Usage
 	usage = &dto.Usage{}
 	responseText := ""
+	thinkingText := ""
 	createdTime := common.GetTimestamp()
 	scanner := bufio.NewScanner(resp.Body)
 	scanner.Split(bufio.ScanLines)
@@ -434,12 +517,13 @@ func ClaudeStreamHandler(c *gin.Context, resp *http.Response, info *relaycommon.
 				// message_start, 获取usage
 				responseId = claudeResponse.Message.Id
 				info.UpstreamModelName = claudeResponse.Message.Model
-				usage.PromptTokens = claudeUsage.InputTokens
+				SetPromptUsage(usage, claudeUsage)
 			} else if claudeResponse.Type == "content_block_delta" {
 				responseText += claudeResponse.Delta.Text
+				thinkingText += claudeResponse.Delta.Thinking
 			} else if claudeResponse.Type == "message_delta" {
 				usage.CompletionTokens = claudeUsage.OutputTokens
-				usage.TotalTokens = claudeUsage.InputTokens + claudeUsage.OutputTokens
+				usage.TotalTokens = usage.PromptTokens + claudeUsage.OutputTokens
 			} else if claudeResponse.Type == "content_block_start" {
 
 			} else {
@@ -464,7 +548,13 @@ func ClaudeStreamHandler(c *gin.Context, resp *http.Response, info *relaycommon.
 			usage.PromptTokens = info.PromptTokens
 		}
 		if usage.CompletionTokens == 0 {
-			usage, _ = service.ResponseText2Usage(responseText, info.UpstreamModelName, usage.PromptTokens)
+			// thinking tokens are billed as completion tokens
+			promptTokensDetails := usage.PromptTokensDetails
+			usage, _ = service.ResponseText2Usage(thinkingText+responseText, info.UpstreamModelName, usage.PromptTokens)
+			usage.PromptTokensDetails = promptTokensDetails
+		}
+		if thinkingText != "" {
+			usage.CompletionTokenDetails.ReasoningTokens, _ = service.CountTextToken(thinkingText, info.UpstreamModelName)
 		}
 	}
 	if info.ShouldIncludeUsage {
@@ -515,9 +605,13 @@ func ClaudeHandler(c *gin.Context, resp *http.Response, requestMode int, info *r
 		usage.CompletionTokens = completionTokens
 		usage.TotalTokens = info.PromptTokens + completionTokens
 	} else {
-		usage.PromptTokens = claudeResponse.Usage.InputTokens
+		SetPromptUsage(&usage, &claudeResponse.Usage)
 		usage.CompletionTokens = claudeResponse.Usage.OutputTokens
-		usage.TotalTokens = claudeResponse.Usage.InputTokens + claudeResponse.Usage.OutputTokens
+		usage.TotalTokens = usage.PromptTokens + claudeResponse.Usage.OutputTokens
+		// output_tokens already includes thinking, the split is only informational
+		if reasoningContent := fullTextResponse.Choices[len(fullTextResponse.Choices)-1].Message.ReasoningContent; reasoningContent != "" {
+			usage.CompletionTokenDetails.ReasoningTokens, _ = service.CountTextToken(reasoningContent, info.UpstreamModelName)
+		}
 	}
 	fullTextResponse.Usage = usage
 	jsonResponse, err := json.Marshal(fullTextResponse)
@@ -10,9 +10,11 @@ import (
 	"one-api/dto"
 	"one-api/relay/channel"
 	relaycommon "one-api/relay/common"
+	relayconstant "one-api/relay/constant"
 )
 
 type Adaptor struct {
+	EncodingFormat string
 }
 
 func (a *Adaptor) ConvertAudioRequest(c *gin.Context, info *relaycommon.RelayInfo, request dto.AudioRequest) (io.Reader, error) {
@@ -41,7 +43,9 @@ func (a *Adaptor) GetRequestURL(info *relaycommon.RelayInfo) (string, error) {
 	}
 
 	action := "generateContent"
-	if info.IsStream {
+	if info.RelayMode == relayconstant.RelayModeEmbeddings {
+		action = "batchEmbedContents"
+	} else if info.IsStream {
 		action = "streamGenerateContent?alt=sse"
 	}
 	return fmt.Sprintf("%s/%s/models/%s:%s", info.BaseUrl, version, info.UpstreamModelName, action), nil
@@ -57,6 +61,10 @@ func (a *Adaptor) ConvertRequest(c *gin.Context, info *relaycommon.RelayInfo, re
 	if request == nil {
 		return nil, errors.New("request is nil")
 	}
+	if info.RelayMode == relayconstant.RelayModeEmbeddings {
+		a.EncodingFormat, _ = request.EncodingFormat.(string)
+		return EmbeddingRequestOpenAI2Gemini(*request, info.UpstreamModelName)
+	}
 	ai, err := CovertGemini2OpenAI(*request)
 	if err != nil {
 		return nil, err
@@ -64,6 +72,11 @@ func (a *Adaptor) ConvertRequest(c *gin.Context, info *relaycommon.RelayInfo, re
 	return ai, nil
 }
 
+func (a *Adaptor) ConvertGeminiRequest(c *gin.Context, info *relaycommon.RelayInfo, requestBody []byte) ([]byte, error) {
+	// the model is part of the request url, the body is sent as is
+	return requestBody, nil
+}
+
 func (a *Adaptor) ConvertRerankRequest(c *gin.Context, relayMode int, request dto.RerankRequest) (any, error) {
 	return nil, nil
 }
@@ -73,6 +86,18 @@ func (a *Adaptor) DoRequest(c *gin.Context, info *relaycommon.RelayInfo, request
 }
 
 func (a *Adaptor) DoResponse(c *gin.Context, resp *http.Response, info *relaycommon.RelayInfo) (usage any, err *dto.OpenAIErrorWithStatusCode) {
+	if info.RelayFormat == relaycommon.RelayFormatGemini {
+		if info.IsStream {
+			err, usage = GeminiNativeStreamHandler(c, resp, info)
+		} else {
+			err, usage = GeminiNativeHandler(c, resp, info)
+		}
+		return
+	}
+	if info.RelayMode == relayconstant.RelayModeEmbeddings {
+		err, usage = GeminiEmbeddingHandler(c, resp, info, a.EncodingFormat)
+		return
+	}
 	if info.IsStream {
 		err, usage = GeminiChatStreamHandler(c, resp, info)
 	} else {
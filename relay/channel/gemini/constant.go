@@ -14,6 +14,9 @@ var ModelList = []string{
 	// thinking exp
 	"gemini-2.0-flash-thinking-exp",
 	"gemini-2.0-flash-thinking-exp-1219",
+	// embedding
+	"text-embedding-004",
+	"gemini-embedding-exp-03-07",
 }
 
 var ChannelName = "google gemini"
@@ -8,6 +8,17 @@ type GeminiChatRequest struct {
 	SystemInstructions *GeminiChatContent         `json:"system_instruction,omitempty"`
 }
 
+// GeminiNativeRequest is a generateContent body as sent by clients. Google SDKs
+// use camelCase field names while the REST docs also accept snake_case.
+type GeminiNativeRequest struct {
+	Contents               []GeminiChatContent         `json:"contents"`
+	SystemInstruction      *GeminiChatContent          `json:"systemInstruction,omitempty"`
+	SystemInstructionSnake *GeminiChatContent          `json:"system_instruction,omitempty"`
+	GenerationConfig       *GeminiChatGenerationConfig `json:"generationConfig,omitempty"`
+	GenerationConfigSnake  *GeminiChatGenerationConfig `json:"generation_config,omitempty"`
+	Tools                  []GeminiChatTool            `json:"tools,omitempty"`
+}
+
 type GeminiInlineData struct {
 	MimeType string `json:"mimeType"`
 	Data     string `json:"data"`
@@ -24,8 +35,8 @@ type GeminiFunctionResponseContent struct {
 }
 
 type FunctionResponse struct {
-	Name     string                        `json:"name"`
-	Response GeminiFunctionResponseContent `json:"response"`
+	Name     string `json:"name"`
+	Response any    `json:"response"`
 }
 
 type GeminiPartExecutableCode struct {
@@ -84,9 +95,9 @@ type GeminiChatGenerationConfig struct {
 
 type GeminiChatCandidate struct {
 	Content       GeminiChatContent        `json:"content"`
-	FinishReason  *string                  `json:"finishReason"`
+	FinishReason  *string                  `json:"finishReason,omitempty"`
 	Index         int64                    `json:"index"`
-	SafetyRatings []GeminiChatSafetyRating `json:"safetyRatings"`
+	SafetyRatings []GeminiChatSafetyRating `json:"safetyRatings,omitempty"`
 }
 
 type GeminiChatSafetyRating struct {
@@ -99,9 +110,9 @@ type GeminiChatPromptFeedback struct {
 }
 
 type GeminiChatResponse struct {
-	Candidates     []GeminiChatCandidate    `json:"candidates"`
-	PromptFeedback GeminiChatPromptFeedback `json:"promptFeedback"`
-	UsageMetadata  GeminiUsageMetadata      `json:"usageMetadata"`
+	Candidates     []GeminiChatCandidate     `json:"candidates"`
+	PromptFeedback *GeminiChatPromptFeedback `json:"promptFeedback,omitempty"`
+	UsageMetadata  GeminiUsageMetadata       `json:"usageMetadata"`
 }
 
 type GeminiUsageMetadata struct {
@@ -109,3 +120,22 @@ type GeminiUsageMetadata struct {
 	CandidatesTokenCount int `json:"candidatesTokenCount"`
 	TotalTokenCount      int `json:"totalTokenCount"`
 }
+
+type GeminiEmbeddingRequest struct {
+	Model                string            `json:"model"`
+	Content              GeminiChatContent `json:"content"`
+	TaskType             string            `json:"taskType,omitempty"`
+	OutputDimensionality int               `json:"outputDimensionality,omitempty"`
+}
+
+type GeminiBatchEmbeddingRequest struct {
+	Requests []GeminiEmbeddingRequest `json:"requests"`
+}
+
+type GeminiEmbedding struct {
+	Values []float64 `json:"values"`
+}
+
+type GeminiBatchEmbeddingResponse struct {
+	Embeddings []GeminiEmbedding `json:"embeddings"`
+}
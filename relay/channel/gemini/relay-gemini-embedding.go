@@ -0,0 +1,109 @@
+package gemini
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"one-api/dto"
+	relaycommon "one-api/relay/common"
+	"one-api/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// EmbeddingRequestOpenAI2Gemini maps an OpenAI embedding request to a
+// batchEmbedContents body, one request per input string.
+func EmbeddingRequestOpenAI2Gemini(request dto.GeneralOpenAIRequest, model string) (*GeminiBatchEmbeddingRequest, error) {
+	inputs := request.ParseInput()
+	if len(inputs) == 0 {
+		return nil, errors.New("input is empty")
+	}
+	geminiRequest := &GeminiBatchEmbeddingRequest{
+		Requests: make([]GeminiEmbeddingRequest, 0, len(inputs)),
+	}
+	for _, input := range inputs {
+		geminiRequest.Requests = append(geminiRequest.Requests, GeminiEmbeddingRequest{
+			Model: "models/" + model,
+			Content: GeminiChatContent{
+				Parts: []GeminiPart{
+					{
+						Text: input,
+					},
+				},
+			},
+			OutputDimensionality: request.Dimensions,
+		})
+	}
+	return geminiRequest, nil
+}
+
+func GeminiEmbeddingHandler(c *gin.Context, resp *http.Response, info *relaycommon.RelayInfo, encodingFormat string) (*dto.OpenAIErrorWithStatusCode, *dto.Usage) {
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return service.OpenAIErrorWrapper(err, "read_response_body_failed", http.StatusInternalServerError), nil
+	}
+	err = resp.Body.Close()
+	if err != nil {
+		return service.OpenAIErrorWrapper(err, "close_response_body_failed", http.StatusInternalServerError), nil
+	}
+	var geminiResponse GeminiBatchEmbeddingResponse
+	err = json.Unmarshal(responseBody, &geminiResponse)
+	if err != nil {
+		return service.OpenAIErrorWrapper(err, "unmarshal_response_body_failed", http.StatusInternalServerError), nil
+	}
+	embeddings := make([][]float64, 0, len(geminiResponse.Embeddings))
+	for _, embedding := range geminiResponse.Embeddings {
+		embeddings = append(embeddings, embedding.Values)
+	}
+	// batchEmbedContents reports no usage, the prompt tokens counted on request are used
+	usage := dto.Usage{
+		PromptTokens: info.PromptTokens,
+		TotalTokens:  info.PromptTokens,
+	}
+	return WriteEmbeddingResponse(c, resp.StatusCode, embeddings, encodingFormat, info, usage)
+}
+
+// WriteEmbeddingResponse writes the vectors as an OpenAI embedding response,
+// base64 encoded as little endian float32 when the client asked for it.
+func WriteEmbeddingResponse(c *gin.Context, statusCode int, embeddings [][]float64, encodingFormat string,
+	info *relaycommon.RelayInfo, usage dto.Usage) (*dto.OpenAIErrorWithStatusCode, *dto.Usage) {
+	openAIEmbeddingResponse := dto.OpenAIEmbeddingResponse{
+		Object: "list",
+		Data:   make([]dto.OpenAIEmbeddingResponseItem, 0, len(embeddings)),
+		Model:  info.UpstreamModelName,
+		Usage:  usage,
+	}
+	for i, embedding := range embeddings {
+		item := dto.OpenAIEmbeddingResponseItem{
+			Object:    "embedding",
+			Index:     i,
+			Embedding: embedding,
+		}
+		if encodingFormat == "base64" {
+			item.Embedding = encodeEmbeddingBase64(embedding)
+		}
+		openAIEmbeddingResponse.Data = append(openAIEmbeddingResponse.Data, item)
+	}
+	jsonResponse, err := json.Marshal(openAIEmbeddingResponse)
+	if err != nil {
+		return service.OpenAIErrorWrapper(err, "marshal_response_body_failed", http.StatusInternalServerError), nil
+	}
+	c.Writer.Header().Set("Content-Type", "application/json")
+	c.Writer.WriteHeader(statusCode)
+	_, _ = c.Writer.Write(jsonResponse)
+	return nil, &usage
+}
+
+func encodeEmbeddingBase64(embedding []float64) string {
+	var buf bytes.Buffer
+	buf.Grow(len(embedding) * 4)
+	for _, value := range embedding {
+		_ = binary.Write(&buf, binary.LittleEndian, math.Float32bits(float32(value)))
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes())
+}
@@ -0,0 +1,324 @@
+package gemini
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"one-api/constant"
+	"one-api/dto"
+	relaycommon "one-api/relay/common"
+	"strings"
+)
+
+func finishReasonOpenAI2Gemini(reason string) string {
+	switch reason {
+	case constant.FinishReasonLength:
+		return "MAX_TOKENS"
+	case constant.FinishReasonContentFilter:
+		return "SAFETY"
+	default:
+		return "STOP"
+	}
+}
+
+// RequestGemini2OpenAI converts a generateContent request into a chat completion
+// request. The model and stream flag come from the request path and are left to
+// the caller.
+func RequestGemini2OpenAI(geminiRequest GeminiNativeRequest) (*dto.GeneralOpenAIRequest, error) {
+	openAIRequest := dto.GeneralOpenAIRequest{}
+	generationConfig := geminiRequest.GenerationConfig
+	if generationConfig == nil {
+		generationConfig = geminiRequest.GenerationConfigSnake
+	}
+	if generationConfig != nil {
+		openAIRequest.Temperature = generationConfig.Temperature
+		openAIRequest.TopP = generationConfig.TopP
+		openAIRequest.TopK = int(generationConfig.TopK)
+		openAIRequest.MaxTokens = generationConfig.MaxOutputTokens
+		openAIRequest.N = generationConfig.CandidateCount
+		openAIRequest.Seed = float64(generationConfig.Seed)
+		if len(generationConfig.StopSequences) > 0 {
+			openAIRequest.Stop = generationConfig.StopSequences
+		}
+		if generationConfig.ResponseMimeType == "application/json" {
+			openAIRequest.ResponseFormat = &dto.ResponseFormat{Type: "json_object"}
+		}
+	}
+	for _, tool := range geminiRequest.Tools {
+		if tool.FunctionDeclarations == nil {
+			// built-in tools such as googleSearch have no OpenAI counterpart
+			continue
+		}
+		declarationsBytes, err := json.Marshal(tool.FunctionDeclarations)
+		if err != nil {
+			return nil, err
+		}
+		var declarations []dto.FunctionCall
+		if err = json.Unmarshal(declarationsBytes, &declarations); err != nil {
+			return nil, fmt.Errorf("invalid functionDeclarations: %w", err)
+		}
+		for _, declaration := range declarations {
+			openAIRequest.Tools = append(openAIRequest.Tools, dto.ToolCall{
+				Type:     "function",
+				Function: declaration,
+			})
+		}
+	}
+
+	messages := make([]dto.Message, 0, len(geminiRequest.Contents)+1)
+	systemInstruction := geminiRequest.SystemInstruction
+	if systemInstruction == nil {
+		systemInstruction = geminiRequest.SystemInstructionSnake
+	}
+	if systemInstruction != nil {
+		texts := make([]string, 0, len(systemInstruction.Parts))
+		for _, part := range systemInstruction.Parts {
+			if part.Text != "" {
+				texts = append(texts, part.Text)
+			}
+		}
+		if len(texts) > 0 {
+			systemMessage := dto.Message{Role: "system"}
+			systemMessage.SetStringContent(strings.Join(texts, "\n"))
+			messages = append(messages, systemMessage)
+		}
+	}
+	// gemini function calls carry no id, responses are matched to calls by name
+	toolCallIds := make(map[string][]string)
+	for _, content := range geminiRequest.Contents {
+		role := "user"
+		if content.Role == "model" {
+			role = "assistant"
+		}
+		mediaContents := make([]dto.MediaContent, 0, len(content.Parts))
+		toolCalls := make([]dto.ToolCall, 0)
+		isTextOnly := true
+		for _, part := range content.Parts {
+			switch {
+			case part.FunctionCall != nil:
+				arguments, _ := json.Marshal(part.FunctionCall.Arguments)
+				id := fmt.Sprintf("call_%s", common.GetUUID())
+				toolCallIds[part.FunctionCall.FunctionName] = append(toolCallIds[part.FunctionCall.FunctionName], id)
+				toolCalls = append(toolCalls, dto.ToolCall{
+					ID:   id,
+					Type: "function",
+					Function: dto.FunctionCall{
+						Name:      part.FunctionCall.FunctionName,
+						Arguments: string(arguments),
+					},
+				})
+			case part.FunctionResponse != nil:
+				name := part.FunctionResponse.Name
+				toolMessage := dto.Message{Role: "tool", Name: &name}
+				if ids := toolCallIds[name]; len(ids) > 0 {
+					toolMessage.ToolCallId = ids[0]
+					toolCallIds[name] = ids[1:]
+				}
+				response, _ := json.Marshal(part.FunctionResponse.Response)
+				toolMessage.SetStringContent(string(response))
+				messages = append(messages, toolMessage)
+			case part.InlineData != nil:
+				isTextOnly = false
+				mediaContents = append(mediaContents, dto.MediaContent{
+					Type: dto.ContentTypeImageURL,
+					ImageUrl: dto.MessageImageUrl{
+						Url:    fmt.Sprintf("data:%s;base64,%s", part.InlineData.MimeType, part.InlineData.Data),
+						Detail: "auto",
+					},
+				})
+			case part.FileData != nil:
+				isTextOnly = false
+				mediaContents = append(mediaContents, dto.MediaContent{
+					Type: dto.ContentTypeImageURL,
+					ImageUrl: dto.MessageImageUrl{
+						Url:    part.FileData.FileUri,
+						Detail: "auto",
+					},
+				})
+			case part.Text != "":
+				mediaContents = append(mediaContents, dto.MediaContent{
+					Type: dto.ContentTypeText,
+					Text: part.Text,
+				})
+			}
+		}
+		if len(mediaContents) == 0 && len(toolCalls) == 0 {
+			continue
+		}
+		message := dto.Message{Role: role}
+		if isTextOnly {
+			texts := make([]string, 0, len(mediaContents))
+			for _, mediaContent := range mediaContents {
+				texts = append(texts, mediaContent.Text)
+			}
+			message.SetStringContent(strings.Join(texts, "\n"))
+		} else {
+			content, _ := json.Marshal(mediaContents)
+			message.Content = content
+		}
+		if len(toolCalls) > 0 {
+			message.SetToolCalls(toolCalls)
+		}
+		messages = append(messages, message)
+	}
+	openAIRequest.Messages = messages
+	return &openAIRequest, nil
+}
+
+func functionCallPart(toolCall dto.ToolCall) GeminiPart {
+	args := make(map[string]any)
+	if toolCall.Function.Arguments != "" {
+		if err := json.Unmarshal([]byte(toolCall.Function.Arguments), &args); err != nil {
+			common.SysError("tool call function arguments is not a map[string]any: " + toolCall.Function.Arguments)
+		}
+	}
+	return GeminiPart{
+		FunctionCall: &FunctionCall{
+			FunctionName: toolCall.Function.Name,
+			Arguments:    args,
+		},
+	}
+}
+
+func usageOpenAI2Gemini(usage dto.Usage) GeminiUsageMetadata {
+	return GeminiUsageMetadata{
+		PromptTokenCount:     usage.PromptTokens,
+		CandidatesTokenCount: usage.CompletionTokens,
+		TotalTokenCount:      usage.PromptTokens + usage.CompletionTokens,
+	}
+}
+
+// ResponseOpenAI2Gemini converts a chat completion into a generateContent response.
+func ResponseOpenAI2Gemini(openAIResponse *dto.OpenAITextResponse) *GeminiChatResponse {
+	geminiResponse := GeminiChatResponse{
+		Candidates:    make([]GeminiChatCandidate, 0, len(openAIResponse.Choices)),
+		UsageMetadata: usageOpenAI2Gemini(openAIResponse.Usage),
+	}
+	for _, choice := range openAIResponse.Choices {
+		parts := make([]GeminiPart, 0)
+		if text := choice.Message.StringContent(); text != "" {
+			parts = append(parts, GeminiPart{Text: text})
+		}
+		for _, toolCall := range choice.Message.ParseToolCalls() {
+			parts = append(parts, functionCallPart(toolCall))
+		}
+		finishReason := finishReasonOpenAI2Gemini(choice.FinishReason)
+		geminiResponse.Candidates = append(geminiResponse.Candidates, GeminiChatCandidate{
+			Content: GeminiChatContent{
+				Role:  "model",
+				Parts: parts,
+			},
+			FinishReason: &finishReason,
+			Index:        int64(choice.Index),
+		})
+	}
+	return &geminiResponse
+}
+
+// OpenAI2GeminiConverter turns the chat completion output of OpenAI-format
+// adaptors into generateContent responses and stream chunks.
+type OpenAI2GeminiConverter struct {
+	info *relaycommon.RelayInfo
+
+	toolCalls    []dto.ToolCall
+	finishReason string
+	usage        *dto.Usage
+}
+
+func NewOpenAI2GeminiConverter(info *relaycommon.RelayInfo) *OpenAI2GeminiConverter {
+	return &OpenAI2GeminiConverter{
+		info: info,
+	}
+}
+
+func (a *OpenAI2GeminiConverter) ConvertBody(statusCode int, body []byte) []byte {
+	var openAIResponse dto.OpenAITextResponse
+	if statusCode != http.StatusOK || json.Unmarshal(body, &openAIResponse) != nil {
+		return body
+	}
+	jsonResponse, err := json.Marshal(ResponseOpenAI2Gemini(&openAIResponse))
+	if err != nil {
+		common.SysError("error marshalling gemini response: " + err.Error())
+		return body
+	}
+	return jsonResponse
+}
+
+func (a *OpenAI2GeminiConverter) ConvertStreamData(data string) string {
+	var streamResponse dto.ChatCompletionsStreamResponse
+	if err := json.Unmarshal([]byte(data), &streamResponse); err != nil {
+		common.SysError("error unmarshalling stream response: " + err.Error())
+		return ""
+	}
+	if streamResponse.Usage != nil && streamResponse.Usage.TotalTokens != 0 {
+		a.usage = streamResponse.Usage
+	}
+	text := ""
+	for _, choice := range streamResponse.Choices {
+		text += choice.Delta.GetContentString()
+		// tool call arguments arrive in fragments and are sent whole at the end
+		for _, toolCall := range choice.Delta.ToolCalls {
+			if toolCall.ID != "" || len(a.toolCalls) == 0 {
+				a.toolCalls = append(a.toolCalls, dto.ToolCall{
+					ID:   toolCall.ID,
+					Type: "function",
+				})
+			}
+			last := &a.toolCalls[len(a.toolCalls)-1]
+			if toolCall.Function.Name != "" {
+				last.Function.Name = toolCall.Function.Name
+			}
+			last.Function.Arguments += toolCall.Function.Arguments
+		}
+		if choice.FinishReason != nil && *choice.FinishReason != "" {
+			a.finishReason = *choice.FinishReason
+		}
+	}
+	if text == "" {
+		return ""
+	}
+	return a.chunk(GeminiChatResponse{
+		Candidates: []GeminiChatCandidate{
+			{
+				Content: GeminiChatContent{
+					Role:  "model",
+					Parts: []GeminiPart{{Text: text}},
+				},
+			},
+		},
+	})
+}
+
+func (a *OpenAI2GeminiConverter) ConvertStreamDone() string {
+	parts := make([]GeminiPart, 0, len(a.toolCalls))
+	for _, toolCall := range a.toolCalls {
+		parts = append(parts, functionCallPart(toolCall))
+	}
+	finishReason := finishReasonOpenAI2Gemini(a.finishReason)
+	usage := dto.Usage{PromptTokens: a.info.PromptTokens}
+	if a.usage != nil {
+		usage = *a.usage
+	}
+	return a.chunk(GeminiChatResponse{
+		Candidates: []GeminiChatCandidate{
+			{
+				Content: GeminiChatContent{
+					Role:  "model",
+					Parts: parts,
+				},
+				FinishReason: &finishReason,
+			},
+		},
+		UsageMetadata: usageOpenAI2Gemini(usage),
+	})
+}
+
+func (a *OpenAI2GeminiConverter) chunk(response GeminiChatResponse) string {
+	jsonData, err := json.Marshal(response)
+	if err != nil {
+		common.SysError("error marshalling gemini stream response: " + err.Error())
+		return ""
+	}
+	return fmt.Sprintf("data: %s\n\n", jsonData)
+}
@@ -0,0 +1,99 @@
+package gemini
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"one-api/common"
+	"one-api/dto"
+	relaycommon "one-api/relay/common"
+	"one-api/service"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GeminiNativeStreamHandler forwards upstream streamGenerateContent events to the
+// client unchanged, collecting usage from usageMetadata.
+func GeminiNativeStreamHandler(c *gin.Context, resp *http.Response, info *relaycommon.RelayInfo) (*dto.OpenAIErrorWithStatusCode, *dto.Usage) {
+	usage := &dto.Usage{}
+	responseText := ""
+	scanner := bufio.NewScanner(resp.Body)
+	// single events, e.g. inline images, go far beyond the default 64KB
+	scanner.Buffer(make([]byte, 64*1024), 32<<20)
+	scanner.Split(bufio.ScanLines)
+	service.SetEventStreamHeaders(c)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		info.SetFirstResponseTime()
+		if strings.HasPrefix(line, "data:") {
+			data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+			var geminiResponse GeminiChatResponse
+			if err := json.Unmarshal([]byte(data), &geminiResponse); err != nil {
+				common.SysError("error unmarshalling stream response: " + err.Error())
+			} else {
+				for _, candidate := range geminiResponse.Candidates {
+					for _, part := range candidate.Content.Parts {
+						responseText += part.Text
+					}
+				}
+				if geminiResponse.UsageMetadata.TotalTokenCount != 0 {
+					usage.PromptTokens = geminiResponse.UsageMetadata.PromptTokenCount
+					usage.CompletionTokens = geminiResponse.UsageMetadata.CandidatesTokenCount
+				}
+			}
+		}
+		err := service.RawLineData(c, line)
+		if err != nil {
+			common.LogError(c, "send_stream_response_failed: "+err.Error())
+		}
+	}
+	_ = resp.Body.Close()
+	if err := scanner.Err(); err != nil {
+		return service.OpenAIErrorWrapper(err, "read_stream_failed", http.StatusInternalServerError), nil
+	}
+
+	if usage.PromptTokens == 0 {
+		usage.PromptTokens = info.PromptTokens
+	}
+	if usage.CompletionTokens == 0 {
+		usage, _ = service.ResponseText2Usage(responseText, info.UpstreamModelName, usage.PromptTokens)
+	}
+	usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	return nil, usage
+}
+
+// GeminiNativeHandler returns the upstream generateContent response body unchanged.
+func GeminiNativeHandler(c *gin.Context, resp *http.Response, info *relaycommon.RelayInfo) (*dto.OpenAIErrorWithStatusCode, *dto.Usage) {
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return service.OpenAIErrorWrapper(err, "read_response_body_failed", http.StatusInternalServerError), nil
+	}
+	err = resp.Body.Close()
+	if err != nil {
+		return service.OpenAIErrorWrapper(err, "close_response_body_failed", http.StatusInternalServerError), nil
+	}
+	var geminiResponse GeminiChatResponse
+	err = json.Unmarshal(responseBody, &geminiResponse)
+	if err != nil {
+		return service.OpenAIErrorWrapper(err, "unmarshal_response_body_failed", http.StatusInternalServerError), nil
+	}
+	usage := dto.Usage{
+		PromptTokens:     geminiResponse.UsageMetadata.PromptTokenCount,
+		CompletionTokens: geminiResponse.UsageMetadata.CandidatesTokenCount,
+		TotalTokens:      geminiResponse.UsageMetadata.TotalTokenCount,
+	}
+	if usage.PromptTokens == 0 {
+		usage.PromptTokens = info.PromptTokens
+		usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	}
+	c.Writer.Header().Set("Content-Type", "application/json")
+	c.Writer.WriteHeader(resp.StatusCode)
+	_, err = c.Writer.Write(responseBody)
+	if err != nil {
+		common.SysError("error writing response body: " + err.Error())
+	}
+	return nil, &usage
+}
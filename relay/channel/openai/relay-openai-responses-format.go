@@ -0,0 +1,504 @@
+package openai
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"one-api/constant"
+	"one-api/dto"
+	relaycommon "one-api/relay/common"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+func toolChoiceResponses2OpenAI(toolChoice any) any {
+	choice, ok := toolChoice.(map[string]any)
+	if !ok {
+		// auto, none and required are the same in both apis
+		return toolChoice
+	}
+	if choice["type"] != "function" {
+		return nil
+	}
+	return map[string]any{
+		"type": "function",
+		"function": map[string]any{
+			"name": choice["name"],
+		},
+	}
+}
+
+// RequestResponses2OpenAI converts a Responses API request into a chat completion
+// request for adaptors that only speak chat completions.
+func RequestResponses2OpenAI(responsesRequest dto.OpenAIResponsesRequest) (*dto.GeneralOpenAIRequest, error) {
+	openAIRequest := dto.GeneralOpenAIRequest{
+		Model:       responsesRequest.Model,
+		MaxTokens:   responsesRequest.MaxOutputTokens,
+		Temperature: responsesRequest.Temperature,
+		TopP:        responsesRequest.TopP,
+		Stream:      responsesRequest.Stream,
+		ToolChoice:  toolChoiceResponses2OpenAI(responsesRequest.ToolChoice),
+		User:        responsesRequest.User,
+	}
+	if responsesRequest.Reasoning != nil {
+		openAIRequest.ReasoningEffort = responsesRequest.Reasoning.Effort
+	}
+	if responsesRequest.Text != nil && responsesRequest.Text.Format != nil {
+		format := responsesRequest.Text.Format
+		switch format.Type {
+		case "json_object":
+			openAIRequest.ResponseFormat = &dto.ResponseFormat{Type: "json_object"}
+		case "json_schema":
+			openAIRequest.ResponseFormat = &dto.ResponseFormat{
+				Type: "json_schema",
+				JsonSchema: &dto.FormatJsonSchema{
+					Description: format.Description,
+					Name:        format.Name,
+					Schema:      format.Schema,
+					Strict:      format.Strict,
+				},
+			}
+		}
+	}
+	for _, tool := range responsesRequest.Tools {
+		if tool.Type != "function" {
+			// hosted tools such as web_search only exist upstream at openai
+			continue
+		}
+		openAIRequest.Tools = append(openAIRequest.Tools, dto.ToolCall{
+			Type: "function",
+			Function: dto.FunctionCall{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  tool.Parameters,
+			},
+		})
+	}
+
+	items, err := responsesRequest.ParseInput()
+	if err != nil {
+		return nil, fmt.Errorf("invalid input: %w", err)
+	}
+	messages := make([]dto.Message, 0, len(items)+1)
+	if responsesRequest.Instructions != "" {
+		systemMessage := dto.Message{Role: "system"}
+		systemMessage.SetStringContent(responsesRequest.Instructions)
+		messages = append(messages, systemMessage)
+	}
+	var toolCalls []dto.ToolCall
+	flushToolCalls := func() {
+		if len(toolCalls) == 0 {
+			return
+		}
+		message := dto.Message{Role: "assistant"}
+		message.SetStringContent("")
+		message.SetToolCalls(toolCalls)
+		messages = append(messages, message)
+		toolCalls = nil
+	}
+	for _, item := range items {
+		switch item.Type {
+		case "function_call":
+			toolCalls = append(toolCalls, dto.ToolCall{
+				ID:   item.CallId,
+				Type: "function",
+				Function: dto.FunctionCall{
+					Name:      item.Name,
+					Arguments: item.Arguments,
+				},
+			})
+		case "function_call_output":
+			flushToolCalls()
+			toolMessage := dto.Message{
+				Role:       "tool",
+				ToolCallId: item.CallId,
+			}
+			if output, ok := item.Output.(string); ok {
+				toolMessage.SetStringContent(output)
+			} else {
+				output, _ := json.Marshal(item.Output)
+				toolMessage.SetStringContent(string(output))
+			}
+			messages = append(messages, toolMessage)
+		case "message", "":
+			flushToolCalls()
+			message, err := responsesMessage2OpenAI(item)
+			if err != nil {
+				return nil, err
+			}
+			messages = append(messages, *message)
+		default:
+			// reasoning and hosted tool items are upstream state that cannot be replayed
+		}
+	}
+	flushToolCalls()
+	openAIRequest.Messages = messages
+	return &openAIRequest, nil
+}
+
+func responsesMessage2OpenAI(item dto.ResponsesInputItem) (*dto.Message, error) {
+	role := item.Role
+	if role == "developer" {
+		role = "system"
+	}
+	message := dto.Message{Role: role}
+	contents := item.ParseContent()
+	mediaContents := make([]dto.MediaContent, 0, len(contents))
+	isTextOnly := true
+	for _, content := range contents {
+		switch content.Type {
+		case "input_text", "output_text":
+			mediaContents = append(mediaContents, dto.MediaContent{
+				Type: dto.ContentTypeText,
+				Text: content.Text,
+			})
+		case "input_image":
+			if content.ImageUrl == "" {
+				return nil, fmt.Errorf("input_image without image_url is not supported")
+			}
+			isTextOnly = false
+			detail := content.Detail
+			if detail == "" {
+				detail = "auto"
+			}
+			mediaContents = append(mediaContents, dto.MediaContent{
+				Type: dto.ContentTypeImageURL,
+				ImageUrl: dto.MessageImageUrl{
+					Url:    content.ImageUrl,
+					Detail: detail,
+				},
+			})
+		}
+	}
+	if isTextOnly {
+		texts := make([]string, 0, len(mediaContents))
+		for _, mediaContent := range mediaContents {
+			texts = append(texts, mediaContent.Text)
+		}
+		message.SetStringContent(strings.Join(texts, "\n"))
+	} else {
+		content, _ := json.Marshal(mediaContents)
+		message.Content = content
+	}
+	return &message, nil
+}
+
+func usageOpenAI2Responses(usage dto.Usage) *dto.ResponsesUsage {
+	responsesUsage := &dto.ResponsesUsage{
+		InputTokens:  usage.PromptTokens,
+		OutputTokens: usage.CompletionTokens,
+		TotalTokens:  usage.PromptTokens + usage.CompletionTokens,
+	}
+	responsesUsage.InputTokensDetails.CachedTokens = usage.PromptTokensDetails.CachedTokens
+	responsesUsage.OutputTokensDetails.ReasoningTokens = usage.CompletionTokenDetails.ReasoningTokens
+	return responsesUsage
+}
+
+func responseStatus(response *dto.OpenAIResponsesResponse, finishReason string) {
+	response.Status = "completed"
+	if finishReason == constant.FinishReasonLength {
+		response.Status = "incomplete"
+		response.IncompleteDetails = &dto.ResponsesIncomplete{Reason: "max_output_tokens"}
+	} else if finishReason == constant.FinishReasonContentFilter {
+		response.Status = "incomplete"
+		response.IncompleteDetails = &dto.ResponsesIncomplete{Reason: "content_filter"}
+	}
+}
+
+// ResponseOpenAI2Responses converts a chat completion into a Responses API response.
+func ResponseOpenAI2Responses(openAIResponse *dto.OpenAITextResponse) *dto.OpenAIResponsesResponse {
+	response := dto.OpenAIResponsesResponse{
+		Id:        fmt.Sprintf("resp_%s", common.GetUUID()),
+		Object:    "response",
+		CreatedAt: openAIResponse.Created,
+		Model:     openAIResponse.Model,
+		Output:    make([]dto.ResponsesOutput, 0),
+		Usage:     usageOpenAI2Responses(openAIResponse.Usage),
+	}
+	if response.CreatedAt == 0 {
+		response.CreatedAt = common.GetTimestamp()
+	}
+	finishReason := ""
+	if len(openAIResponse.Choices) > 0 {
+		choice := openAIResponse.Choices[0]
+		finishReason = choice.FinishReason
+		if choice.Message.ReasoningContent != "" {
+			response.Output = append(response.Output, dto.ResponsesOutput{
+				Type: "reasoning",
+				Id:   fmt.Sprintf("rs_%s", common.GetUUID()),
+				Summary: []dto.ResponsesOutputContent{
+					{Type: "summary_text", Text: choice.Message.ReasoningContent},
+				},
+			})
+		}
+		if text := choice.Message.StringContent(); text != "" {
+			response.Output = append(response.Output, dto.ResponsesOutput{
+				Type:   "message",
+				Id:     fmt.Sprintf("msg_%s", common.GetUUID()),
+				Status: "completed",
+				Role:   "assistant",
+				Content: []dto.ResponsesOutputContent{
+					{Type: "output_text", Text: text, Annotations: []any{}},
+				},
+			})
+		}
+		for _, toolCall := range choice.Message.ParseToolCalls() {
+			arguments := toolCall.Function.Arguments
+			response.Output = append(response.Output, dto.ResponsesOutput{
+				Type:      "function_call",
+				Id:        fmt.Sprintf("fc_%s", common.GetUUID()),
+				Status:    "completed",
+				CallId:    toolCall.ID,
+				Name:      toolCall.Function.Name,
+				Arguments: &arguments,
+			})
+		}
+	}
+	responseStatus(&response, finishReason)
+	return &response
+}
+
+// OpenAI2ResponsesConverter turns the chat completion output of OpenAI-format
+// adaptors into Responses API responses and events.
+type OpenAI2ResponsesConverter struct {
+	info   *relaycommon.RelayInfo
+	output strings.Builder
+
+	response     *dto.OpenAIResponsesResponse
+	sequence     int
+	item         *dto.ResponsesOutput
+	itemText     strings.Builder
+	toolCallId   string
+	finishReason string
+	usage        *dto.Usage
+}
+
+func NewOpenAI2ResponsesConverter(info *relaycommon.RelayInfo) *OpenAI2ResponsesConverter {
+	return &OpenAI2ResponsesConverter{
+		info: info,
+	}
+}
+
+func (a *OpenAI2ResponsesConverter) ConvertBody(statusCode int, body []byte) []byte {
+	var openAIResponse dto.OpenAITextResponse
+	if statusCode != http.StatusOK || json.Unmarshal(body, &openAIResponse) != nil {
+		return body
+	}
+	if openAIResponse.Model == "" {
+		openAIResponse.Model = a.info.UpstreamModelName
+	}
+	jsonResponse, err := json.Marshal(ResponseOpenAI2Responses(&openAIResponse))
+	if err != nil {
+		common.SysError("error marshalling responses response: " + err.Error())
+		return body
+	}
+	return jsonResponse
+}
+
+func (a *OpenAI2ResponsesConverter) ConvertStreamData(data string) string {
+	var streamResponse dto.ChatCompletionsStreamResponse
+	if err := json.Unmarshal([]byte(data), &streamResponse); err != nil {
+		common.SysError("error unmarshalling stream response: " + err.Error())
+		return ""
+	}
+	if a.response == nil {
+		a.startResponse(streamResponse.Model)
+	}
+	if streamResponse.Usage != nil && streamResponse.Usage.TotalTokens != 0 {
+		a.usage = streamResponse.Usage
+	}
+	for _, choice := range streamResponse.Choices {
+		if choice.Index != 0 {
+			continue
+		}
+		if choice.Delta.ReasoningContent != nil && *choice.Delta.ReasoningContent != "" {
+			if a.item == nil || a.item.Type != "reasoning" {
+				a.stopItem()
+				a.startItem(dto.ResponsesOutput{
+					Type:    "reasoning",
+					Id:      fmt.Sprintf("rs_%s", common.GetUUID()),
+					Summary: []dto.ResponsesOutputContent{},
+				})
+				a.event("response.reasoning_summary_part.added", gin.H{
+					"item_id":       a.item.Id,
+					"output_index":  a.outputIndex(),
+					"summary_index": 0,
+					"part":          dto.ResponsesOutputContent{Type: "summary_text"},
+				})
+			}
+			a.itemText.WriteString(*choice.Delta.ReasoningContent)
+			a.event("response.reasoning_summary_text.delta", gin.H{
+				"item_id":       a.item.Id,
+				"output_index":  a.outputIndex(),
+				"summary_index": 0,
+				"delta":         *choice.Delta.ReasoningContent,
+			})
+		}
+		if text := choice.Delta.GetContentString(); text != "" {
+			if a.item == nil || a.item.Type != "message" {
+				a.stopItem()
+				a.startItem(dto.ResponsesOutput{
+					Type:    "message",
+					Id:      fmt.Sprintf("msg_%s", common.GetUUID()),
+					Status:  "in_progress",
+					Role:    "assistant",
+					Content: []dto.ResponsesOutputContent{},
+				})
+				a.event("response.content_part.added", gin.H{
+					"item_id":       a.item.Id,
+					"output_index":  a.outputIndex(),
+					"content_index": 0,
+					"part":          dto.ResponsesOutputContent{Type: "output_text", Annotations: []any{}},
+				})
+			}
+			a.itemText.WriteString(text)
+			a.event("response.output_text.delta", gin.H{
+				"item_id":       a.item.Id,
+				"output_index":  a.outputIndex(),
+				"content_index": 0,
+				"delta":         text,
+			})
+		}
+		for _, toolCall := range choice.Delta.ToolCalls {
+			if toolCall.ID != "" && toolCall.ID != a.toolCallId {
+				a.stopItem()
+				a.toolCallId = toolCall.ID
+				arguments := ""
+				a.startItem(dto.ResponsesOutput{
+					Type:      "function_call",
+					Id:        fmt.Sprintf("fc_%s", common.GetUUID()),
+					Status:    "in_progress",
+					CallId:    toolCall.ID,
+					Name:      toolCall.Function.Name,
+					Arguments: &arguments,
+				})
+			}
+			if toolCall.Function.Arguments != "" && a.item != nil && a.item.Type == "function_call" {
+				a.itemText.WriteString(toolCall.Function.Arguments)
+				a.event("response.function_call_arguments.delta", gin.H{
+					"item_id":      a.item.Id,
+					"output_index": a.outputIndex(),
+					"delta":        toolCall.Function.Arguments,
+				})
+			}
+		}
+		if choice.FinishReason != nil && *choice.FinishReason != "" {
+			a.finishReason = *choice.FinishReason
+		}
+	}
+	return a.flush()
+}
+
+func (a *OpenAI2ResponsesConverter) ConvertStreamDone() string {
+	if a.response == nil {
+		a.startResponse("")
+	}
+	a.stopItem()
+	usage := dto.Usage{PromptTokens: a.info.PromptTokens}
+	if a.usage != nil {
+		usage = *a.usage
+	}
+	a.response.Usage = usageOpenAI2Responses(usage)
+	responseStatus(a.response, a.finishReason)
+	eventType := "response.completed"
+	if a.response.Status == "incomplete" {
+		eventType = "response.incomplete"
+	}
+	a.event(eventType, gin.H{"response": a.response})
+	return a.flush()
+}
+
+func (a *OpenAI2ResponsesConverter) startResponse(model string) {
+	if model == "" {
+		model = a.info.UpstreamModelName
+	}
+	a.response = &dto.OpenAIResponsesResponse{
+		Id:        fmt.Sprintf("resp_%s", common.GetUUID()),
+		Object:    "response",
+		CreatedAt: common.GetTimestamp(),
+		Status:    "in_progress",
+		Model:     model,
+		Output:    make([]dto.ResponsesOutput, 0),
+	}
+	a.event("response.created", gin.H{"response": a.response})
+	a.event("response.in_progress", gin.H{"response": a.response})
+}
+
+func (a *OpenAI2ResponsesConverter) outputIndex() int {
+	return len(a.response.Output)
+}
+
+func (a *OpenAI2ResponsesConverter) startItem(item dto.ResponsesOutput) {
+	a.item = &item
+	a.itemText.Reset()
+	a.event("response.output_item.added", gin.H{
+		"output_index": a.outputIndex(),
+		"item":         a.item,
+	})
+}
+
+func (a *OpenAI2ResponsesConverter) stopItem() {
+	if a.item == nil {
+		return
+	}
+	item := a.item
+	text := a.itemText.String()
+	base := gin.H{
+		"item_id":      item.Id,
+		"output_index": a.outputIndex(),
+	}
+	switch item.Type {
+	case "reasoning":
+		part := dto.ResponsesOutputContent{Type: "summary_text", Text: text}
+		item.Summary = []dto.ResponsesOutputContent{part}
+		a.event("response.reasoning_summary_text.done", mergeEvent(base, gin.H{"summary_index": 0, "text": text}))
+		a.event("response.reasoning_summary_part.done", mergeEvent(base, gin.H{"summary_index": 0, "part": part}))
+	case "message":
+		part := dto.ResponsesOutputContent{Type: "output_text", Text: text, Annotations: []any{}}
+		item.Content = []dto.ResponsesOutputContent{part}
+		item.Status = "completed"
+		a.event("response.output_text.done", mergeEvent(base, gin.H{"content_index": 0, "text": text}))
+		a.event("response.content_part.done", mergeEvent(base, gin.H{"content_index": 0, "part": part}))
+	case "function_call":
+		item.Arguments = &text
+		item.Status = "completed"
+		a.event("response.function_call_arguments.done", mergeEvent(base, gin.H{"arguments": text}))
+	}
+	a.event("response.output_item.done", gin.H{
+		"output_index": a.outputIndex(),
+		"item":         item,
+	})
+	a.response.Output = append(a.response.Output, *item)
+	a.item = nil
+}
+
+func mergeEvent(base gin.H, extra gin.H) gin.H {
+	event := gin.H{}
+	for k, v := range base {
+		event[k] = v
+	}
+	for k, v := range extra {
+		event[k] = v
+	}
+	return event
+}
+
+func (a *OpenAI2ResponsesConverter) event(eventType string, event gin.H) {
+	event["type"] = eventType
+	event["sequence_number"] = a.sequence
+	a.sequence++
+	jsonData, err := json.Marshal(event)
+	if err != nil {
+		common.SysError("error marshalling responses stream event: " + err.Error())
+		return
+	}
+	a.output.WriteString(fmt.Sprintf("event: %s\ndata: %s\n\n", eventType, jsonData))
+}
+
+func (a *OpenAI2ResponsesConverter) flush() string {
+	output := a.output.String()
+	a.output.Reset()
+	return output
+}
@@ -0,0 +1,111 @@
+package openai
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"one-api/common"
+	"one-api/dto"
+	relaycommon "one-api/relay/common"
+	"one-api/service"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/sjson"
+)
+
+// ConvertResponsesNativeRequest prepares a client Responses API body for an
+// upstream serving /v1/responses, only replacing the model with the mapped
+// upstream name.
+func ConvertResponsesNativeRequest(info *relaycommon.RelayInfo, requestBody []byte) ([]byte, error) {
+	model := info.UpstreamModelName
+	if info.ChannelType == common.ChannelTypeAzure {
+		// azure expects the deployment name, see GetRequestURL
+		model = strings.Replace(model, ".", "", -1)
+	}
+	return sjson.SetBytes(requestBody, "model", model)
+}
+
+// OaiResponsesStreamHandler forwards upstream Responses API events to the client
+// unchanged, taking usage from the final response event.
+func OaiResponsesStreamHandler(c *gin.Context, resp *http.Response, info *relaycommon.RelayInfo) (*dto.OpenAIErrorWithStatusCode, *dto.Usage) {
+	var usage *dto.Usage
+	responseText := ""
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Split(bufio.ScanLines)
+	service.SetEventStreamHeaders(c)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		info.SetFirstResponseTime()
+		if strings.HasPrefix(line, "data:") {
+			data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+			var streamResponse dto.ResponsesStreamResponse
+			if err := json.Unmarshal([]byte(data), &streamResponse); err != nil {
+				common.SysError("error unmarshalling stream response: " + err.Error())
+			} else {
+				switch streamResponse.Type {
+				case "response.output_text.delta", "response.function_call_arguments.delta":
+					responseText += streamResponse.Delta
+				case "response.completed", "response.incomplete", "response.failed":
+					if streamResponse.Response != nil && streamResponse.Response.Usage != nil {
+						usage = streamResponse.Response.Usage.ToUsage()
+					}
+				}
+			}
+		}
+		err := service.RawLineData(c, line)
+		if err != nil {
+			common.LogError(c, "send_stream_response_failed: "+err.Error())
+		}
+	}
+	_ = resp.Body.Close()
+
+	if usage == nil || usage.TotalTokens == 0 {
+		usage, _ = service.ResponseText2Usage(responseText, info.UpstreamModelName, info.PromptTokens)
+	}
+	return nil, usage
+}
+
+// OpenaiResponsesHandler returns the upstream Responses API body unchanged.
+func OpenaiResponsesHandler(c *gin.Context, resp *http.Response, info *relaycommon.RelayInfo) (*dto.OpenAIErrorWithStatusCode, *dto.Usage) {
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return service.OpenAIErrorWrapper(err, "read_response_body_failed", http.StatusInternalServerError), nil
+	}
+	err = resp.Body.Close()
+	if err != nil {
+		return service.OpenAIErrorWrapper(err, "close_response_body_failed", http.StatusInternalServerError), nil
+	}
+	var responsesResponse dto.OpenAIResponsesResponse
+	err = json.Unmarshal(responseBody, &responsesResponse)
+	if err != nil {
+		return service.OpenAIErrorWrapper(err, "unmarshal_response_body_failed", http.StatusInternalServerError), nil
+	}
+	if responsesResponse.Error != nil && responsesResponse.Error.Message != "" {
+		return &dto.OpenAIErrorWithStatusCode{
+			Error: dto.OpenAIError{
+				Message: responsesResponse.Error.Message,
+				Type:    "upstream_error",
+				Param:   "",
+				Code:    responsesResponse.Error.Code,
+			},
+			StatusCode: resp.StatusCode,
+		}, nil
+	}
+	usage := &dto.Usage{
+		PromptTokens: info.PromptTokens,
+		TotalTokens:  info.PromptTokens,
+	}
+	if responsesResponse.Usage != nil {
+		usage = responsesResponse.Usage.ToUsage()
+	}
+	c.Writer.Header().Set("Content-Type", "application/json")
+	c.Writer.WriteHeader(resp.StatusCode)
+	_, err = c.Writer.Write(responseBody)
+	if err != nil {
+		common.SysError("error writing response body: " + err.Error())
+	}
+	return nil, usage
+}
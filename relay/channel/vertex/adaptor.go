@@ -12,10 +12,12 @@ import (
 	"one-api/relay/channel/gemini"
 	"one-api/relay/channel/openai"
 	relaycommon "one-api/relay/common"
+	relayconstant "one-api/relay/constant"
 	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/jinzhu/copier"
+	"github.com/tidwall/sjson"
 )
 
 const (
@@ -29,6 +31,8 @@ var claudeModelMap = map[string]string{
 	"claude-3-opus-20240229":     "claude-3-opus@20240229",
 	"claude-3-haiku-20240307":    "claude-3-haiku@20240307",
 	"claude-3-5-sonnet-20240620": "claude-3-5-sonnet@20240620",
+	"claude-3-5-sonnet-20241022": "claude-3-5-sonnet-v2@20241022",
+	"claude-3-7-sonnet-20250219": "claude-3-7-sonnet@20250219",
 }
 
 const anthropicVersion = "vertex-2023-10-16"
@@ -36,6 +40,7 @@ const anthropicVersion = "vertex-2023-10-16"
 type Adaptor struct {
 	RequestMode        int
 	AccountCredentials Credentials
+	EncodingFormat     string
 }
 
 func (a *Adaptor) ConvertAudioRequest(c *gin.Context, info *relaycommon.RelayInfo, request dto.AudioRequest) (io.Reader, error) {
@@ -66,7 +71,16 @@ func (a *Adaptor) GetRequestURL(info *relaycommon.RelayInfo) (string, error) {
 	region := GetModelRegion(info.ApiVersion, info.OriginModelName)
 	a.AccountCredentials = *adc
 	suffix := ""
-	if a.RequestMode == RequestModeGemini {
+	if info.RelayMode == relayconstant.RelayModeEmbeddings {
+		// text-embedding-* and gemini-embedding-* are served by the predict endpoint
+		return fmt.Sprintf(
+			"https://%s-aiplatform.googleapis.com/v1/projects/%s/locations/%s/publishers/google/models/%s:predict",
+			region,
+			adc.ProjectID,
+			region,
+			info.UpstreamModelName,
+		), nil
+	} else if a.RequestMode == RequestModeGemini {
 		if info.IsStream {
 			suffix = "streamGenerateContent?alt=sse"
 		} else {
@@ -122,6 +136,10 @@ func (a *Adaptor) ConvertRequest(c *gin.Context, info *relaycommon.RelayInfo, re
 	if request == nil {
 		return nil, errors.New("request is nil")
 	}
+	if info.RelayMode == relayconstant.RelayModeEmbeddings {
+		a.EncodingFormat, _ = request.EncodingFormat.(string)
+		return embeddingRequestOpenAI2Vertex(*request)
+	}
 	if a.RequestMode == RequestModeClaude {
 		claudeReq, err := claude.RequestOpenAI2ClaudeMessage(*request)
 		if err != nil {
@@ -133,7 +151,9 @@ func (a *Adaptor) ConvertRequest(c *gin.Context, info *relaycommon.RelayInfo, re
 		if err = copier.Copy(vertexClaudeReq, claudeReq); err != nil {
 			return nil, errors.New("failed to copy claude request")
 		}
-		c.Set("request_model", request.Model)
+		// the claude request model has a -thinking suffix removed
+		info.UpstreamModelName = claudeReq.Model
+		c.Set("request_model", claudeReq.Model)
 		return vertexClaudeReq, nil
 	} else if a.RequestMode == RequestModeGemini {
 		geminiRequest, err := gemini.CovertGemini2OpenAI(*request)
@@ -148,6 +168,25 @@ func (a *Adaptor) ConvertRequest(c *gin.Context, info *relaycommon.RelayInfo, re
 	return nil, errors.New("unsupported request mode")
 }
 
+func (a *Adaptor) ConvertClaudeRequest(c *gin.Context, info *relaycommon.RelayInfo, requestBody []byte) ([]byte, error) {
+	if a.RequestMode != RequestModeClaude {
+		return nil, errors.New("unsupported request mode")
+	}
+	// vertex takes the model from the url and requires its own anthropic_version
+	requestBody, err := sjson.DeleteBytes(requestBody, "model")
+	if err != nil {
+		return nil, err
+	}
+	return sjson.SetBytes(requestBody, "anthropic_version", anthropicVersion)
+}
+
+func (a *Adaptor) ConvertGeminiRequest(c *gin.Context, info *relaycommon.RelayInfo, requestBody []byte) ([]byte, error) {
+	if a.RequestMode != RequestModeGemini {
+		return nil, errors.New("unsupported request mode")
+	}
+	return requestBody, nil
+}
+
 func (a *Adaptor) ConvertRerankRequest(c *gin.Context, relayMode int, request dto.RerankRequest) (any, error) {
 	return nil, nil
 }
@@ -157,6 +196,26 @@ func (a *Adaptor) DoRequest(c *gin.Context, info *relaycommon.RelayInfo, request
 }
 
 func (a *Adaptor) DoResponse(c *gin.Context, resp *http.Response, info *relaycommon.RelayInfo) (usage any, err *dto.OpenAIErrorWithStatusCode) {
+	if info.RelayMode == relayconstant.RelayModeEmbeddings {
+		err, usage = vertexEmbeddingHandler(c, resp, info, a.EncodingFormat)
+		return
+	}
+	if info.RelayFormat == relaycommon.RelayFormatClaude && a.RequestMode == RequestModeClaude {
+		if info.IsStream {
+			err, usage = claude.ClaudeNativeStreamHandler(c, resp, info)
+		} else {
+			err, usage = claude.ClaudeNativeHandler(c, resp, info)
+		}
+		return
+	}
+	if info.RelayFormat == relaycommon.RelayFormatGemini && a.RequestMode == RequestModeGemini {
+		if info.IsStream {
+			err, usage = gemini.GeminiNativeStreamHandler(c, resp, info)
+		} else {
+			err, usage = gemini.GeminiNativeHandler(c, resp, info)
+		}
+		return
+	}
 	if info.IsStream {
 		switch a.RequestMode {
 		case RequestModeClaude:
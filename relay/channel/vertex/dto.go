@@ -5,7 +5,7 @@ import "one-api/relay/channel/claude"
 type VertexAIClaudeRequest struct {
 	AnthropicVersion string                 `json:"anthropic_version"`
 	Messages         []claude.ClaudeMessage `json:"messages"`
-	System           string                 `json:"system,omitempty"`
+	System           any                    `json:"system,omitempty"`
 	MaxTokens        int                    `json:"max_tokens,omitempty"`
 	StopSequences    []string               `json:"stop_sequences,omitempty"`
 	Stream           bool                   `json:"stream,omitempty"`
@@ -14,4 +14,32 @@ type VertexAIClaudeRequest struct {
 	TopK             int                    `json:"top_k,omitempty"`
 	Tools            []claude.Tool          `json:"tools,omitempty"`
 	ToolChoice       any                    `json:"tool_choice,omitempty"`
+	Thinking         *claude.Thinking       `json:"thinking,omitempty"`
+}
+
+type VertexEmbeddingInstance struct {
+	Content  string `json:"content"`
+	TaskType string `json:"task_type,omitempty"`
+}
+
+type VertexEmbeddingParameters struct {
+	OutputDimensionality int  `json:"outputDimensionality,omitempty"`
+	AutoTruncate         bool `json:"autoTruncate,omitempty"`
+}
+
+type VertexEmbeddingRequest struct {
+	Instances  []VertexEmbeddingInstance  `json:"instances"`
+	Parameters *VertexEmbeddingParameters `json:"parameters,omitempty"`
+}
+
+type VertexEmbeddingResponse struct {
+	Predictions []struct {
+		Embeddings struct {
+			Values     []float64 `json:"values"`
+			Statistics struct {
+				TokenCount float64 `json:"token_count"`
+				Truncated  bool    `json:"truncated"`
+			} `json:"statistics"`
+		} `json:"embeddings"`
+	} `json:"predictions"`
 }
@@ -0,0 +1,67 @@
+package vertex
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"one-api/dto"
+	"one-api/relay/channel/gemini"
+	relaycommon "one-api/relay/common"
+	"one-api/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// embeddingRequestOpenAI2Vertex maps an OpenAI embedding request to a predict
+// body of the vertex text embedding models.
+func embeddingRequestOpenAI2Vertex(request dto.GeneralOpenAIRequest) (*VertexEmbeddingRequest, error) {
+	inputs := request.ParseInput()
+	if len(inputs) == 0 {
+		return nil, errors.New("input is empty")
+	}
+	vertexRequest := &VertexEmbeddingRequest{
+		Instances: make([]VertexEmbeddingInstance, 0, len(inputs)),
+	}
+	for _, input := range inputs {
+		vertexRequest.Instances = append(vertexRequest.Instances, VertexEmbeddingInstance{
+			Content: input,
+		})
+	}
+	if request.Dimensions > 0 {
+		vertexRequest.Parameters = &VertexEmbeddingParameters{
+			OutputDimensionality: request.Dimensions,
+		}
+	}
+	return vertexRequest, nil
+}
+
+func vertexEmbeddingHandler(c *gin.Context, resp *http.Response, info *relaycommon.RelayInfo, encodingFormat string) (*dto.OpenAIErrorWithStatusCode, *dto.Usage) {
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return service.OpenAIErrorWrapper(err, "read_response_body_failed", http.StatusInternalServerError), nil
+	}
+	err = resp.Body.Close()
+	if err != nil {
+		return service.OpenAIErrorWrapper(err, "close_response_body_failed", http.StatusInternalServerError), nil
+	}
+	var vertexResponse VertexEmbeddingResponse
+	err = json.Unmarshal(responseBody, &vertexResponse)
+	if err != nil {
+		return service.OpenAIErrorWrapper(err, "unmarshal_response_body_failed", http.StatusInternalServerError), nil
+	}
+	embeddings := make([][]float64, 0, len(vertexResponse.Predictions))
+	promptTokens := 0
+	for _, prediction := range vertexResponse.Predictions {
+		embeddings = append(embeddings, prediction.Embeddings.Values)
+		promptTokens += int(prediction.Embeddings.Statistics.TokenCount)
+	}
+	if promptTokens == 0 {
+		promptTokens = info.PromptTokens
+	}
+	usage := dto.Usage{
+		PromptTokens: promptTokens,
+		TotalTokens:  promptTokens,
+	}
+	return gemini.WriteEmbeddingResponse(c, resp.StatusCode, embeddings, encodingFormat, info, usage)
+}
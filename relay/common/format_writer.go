@@ -0,0 +1,134 @@
+package common
+
+import (
+	"bytes"
+	"net/http"
+	"one-api/common"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ResponseConverter rewrites the OpenAI chat completion output of an adaptor into
+// the API format the client spoke, see FormatConvertWriter.
+type ResponseConverter interface {
+	// ConvertStreamData converts the payload of one "data:" line and returns the raw
+	// event stream text to send, which may be empty.
+	ConvertStreamData(data string) string
+	// ConvertStreamDone returns the text closing the stream, called exactly once.
+	ConvertStreamDone() string
+	// ConvertBody converts a complete non-stream response body.
+	ConvertBody(statusCode int, body []byte) []byte
+}
+
+// FormatConvertWriter replaces the gin response writer while an OpenAI-format
+// adaptor serves a request that arrived in another format. Stream output is
+// converted line by line, non-stream output is buffered until Finish.
+type FormatConvertWriter struct {
+	gin.ResponseWriter
+	converter  ResponseConverter
+	statusCode int
+	decided    bool
+	isStream   bool
+	streamDone bool
+	buffer     bytes.Buffer
+}
+
+func NewFormatConvertWriter(writer gin.ResponseWriter, converter ResponseConverter) *FormatConvertWriter {
+	return &FormatConvertWriter{
+		ResponseWriter: writer,
+		converter:      converter,
+		statusCode:     http.StatusOK,
+	}
+}
+
+func (w *FormatConvertWriter) WriteHeader(code int) {
+	w.statusCode = code
+}
+
+func (w *FormatConvertWriter) WriteHeaderNow() {
+}
+
+func (w *FormatConvertWriter) Flush() {
+	if w.isStream {
+		w.ResponseWriter.Flush()
+	}
+}
+
+func (w *FormatConvertWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *FormatConvertWriter) Write(data []byte) (int, error) {
+	if !w.decided {
+		w.decided = true
+		w.isStream = strings.HasPrefix(w.Header().Get("Content-Type"), "text/event-stream")
+		w.Header().Del("Content-Length")
+		if w.isStream {
+			w.ResponseWriter.WriteHeader(w.statusCode)
+		}
+	}
+	w.buffer.Write(data)
+	if w.isStream {
+		for {
+			line, err := w.buffer.ReadString('\n')
+			if err != nil {
+				// keep the incomplete line for the next write
+				w.buffer.Reset()
+				w.buffer.WriteString(line)
+				break
+			}
+			w.handleStreamLine(strings.TrimSpace(line))
+		}
+	}
+	return len(data), nil
+}
+
+// Finish sends whatever is still pending, it must be called once DoResponse returns.
+func (w *FormatConvertWriter) Finish() {
+	if w.isStream {
+		w.handleStreamLine(strings.TrimSpace(w.buffer.String()))
+		w.buffer.Reset()
+		w.finishStream()
+		return
+	}
+	responseBody := w.converter.ConvertBody(w.statusCode, w.buffer.Bytes())
+	w.Header().Set("Content-Type", "application/json")
+	w.ResponseWriter.WriteHeader(w.statusCode)
+	_, err := w.ResponseWriter.Write(responseBody)
+	if err != nil {
+		common.SysError("error writing converted response: " + err.Error())
+	}
+}
+
+func (w *FormatConvertWriter) handleStreamLine(line string) {
+	if w.streamDone || !strings.HasPrefix(line, "data:") {
+		return
+	}
+	data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+	if data == "[DONE]" {
+		w.finishStream()
+		return
+	}
+	w.send(w.converter.ConvertStreamData(data))
+}
+
+func (w *FormatConvertWriter) finishStream() {
+	if w.streamDone {
+		return
+	}
+	w.streamDone = true
+	w.send(w.converter.ConvertStreamDone())
+}
+
+func (w *FormatConvertWriter) send(text string) {
+	if text == "" {
+		return
+	}
+	_, err := w.ResponseWriter.WriteString(text)
+	if err != nil {
+		common.SysError("error writing converted stream: " + err.Error())
+		return
+	}
+	w.ResponseWriter.Flush()
+}
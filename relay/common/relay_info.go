@@ -12,9 +12,18 @@ import (
 	"github.com/gorilla/websocket"
 )
 
+const (
+	RelayFormatOpenAI = "openai"
+	RelayFormatClaude = "claude"
+	RelayFormatGemini = "gemini"
+
+	RelayFormatOpenAIResponses = "openai_responses"
+)
+
 type RelayInfo struct {
 	ChannelType          int
 	ChannelId            int
+	ChannelKeyHash       string
 	TokenId              int
 	TokenKey             string
 	UserId               int
@@ -28,6 +37,7 @@ type RelayInfo struct {
 	IsPlayground         bool
 	UsePrice             bool
 	RelayMode            int
+	RelayFormat          string
 	UpstreamModelName    string
 	OriginModelName      string
 	RequestURLPath       string
@@ -46,6 +56,10 @@ type RelayInfo struct {
 	IsFirstRequest       bool
 	AudioUsage           bool
 	ChannelSetting       map[string]interface{}
+	RequestId            string
+	// PlanQuota is the part of the quota subscription plans paid for the
+	// request, model.PostConsumeQuota adds to it each time it settles.
+	PlanQuota int
 }
 
 func GenRelayInfoWs(c *gin.Context, ws *websocket.Conn) *RelayInfo {
@@ -74,10 +88,12 @@ func GenRelayInfo(c *gin.Context) *RelayInfo {
 
 	info := &RelayInfo{
 		RelayMode:         relayconstant.Path2RelayMode(c.Request.URL.Path),
+		RelayFormat:       RelayFormatOpenAI,
 		BaseUrl:           c.GetString("base_url"),
 		RequestURLPath:    c.Request.URL.String(),
 		ChannelType:       channelType,
 		ChannelId:         channelId,
+		ChannelKeyHash:    c.GetString("channel_key_hash"),
 		TokenId:           tokenId,
 		TokenKey:          tokenKey,
 		UserId:            userId,
@@ -92,6 +108,7 @@ func GenRelayInfo(c *gin.Context) *RelayInfo {
 		ApiKey:            strings.TrimPrefix(c.Request.Header.Get("Authorization"), "Bearer "),
 		Organization:      c.GetString("channel_organization"),
 		ChannelSetting:    channelSetting,
+		RequestId:         c.GetString(common.RequestIdKey),
 	}
 	if strings.HasPrefix(c.Request.URL.Path, "/pg") {
 		info.IsPlayground = true
@@ -133,6 +150,7 @@ func (info *RelayInfo) SetFirstResponseTime() {
 type TaskRelayInfo struct {
 	ChannelType       int
 	ChannelId         int
+	ChannelKeyHash    string
 	TokenId           int
 	UserId            int
 	Group             string
@@ -140,9 +158,11 @@ type TaskRelayInfo struct {
 	ApiType           int
 	RelayMode         int
 	UpstreamModelName string
+	OriginModelName   string
 	RequestURLPath    string
 	ApiKey            string
 	BaseUrl           string
+	RequestId         string
 
 	Action       string
 	OriginTaskID string
@@ -162,17 +182,20 @@ func GenTaskRelayInfo(c *gin.Context) *TaskRelayInfo {
 	apiType, _ := relayconstant.ChannelType2APIType(channelType)
 
 	info := &TaskRelayInfo{
-		RelayMode:      relayconstant.Path2RelayMode(c.Request.URL.Path),
-		BaseUrl:        c.GetString("base_url"),
-		RequestURLPath: c.Request.URL.String(),
-		ChannelType:    channelType,
-		ChannelId:      channelId,
-		TokenId:        tokenId,
-		UserId:         userId,
-		Group:          group,
-		StartTime:      startTime,
-		ApiType:        apiType,
-		ApiKey:         strings.TrimPrefix(c.Request.Header.Get("Authorization"), "Bearer "),
+		RelayMode:       relayconstant.Path2RelayMode(c.Request.URL.Path),
+		BaseUrl:         c.GetString("base_url"),
+		RequestURLPath:  c.Request.URL.String(),
+		ChannelType:     channelType,
+		ChannelId:       channelId,
+		ChannelKeyHash:  c.GetString("channel_key_hash"),
+		TokenId:         tokenId,
+		UserId:          userId,
+		Group:           group,
+		StartTime:       startTime,
+		ApiType:         apiType,
+		OriginModelName: c.GetString("original_model"),
+		ApiKey:          strings.TrimPrefix(c.Request.Header.Get("Authorization"), "Bearer "),
+		RequestId:       c.GetString(common.RequestIdKey),
 	}
 	if info.BaseUrl == "" {
 		info.BaseUrl = common.ChannelBaseURLs[channelType]
@@ -191,8 +214,10 @@ func (info *TaskRelayInfo) ToRelayInfo() *RelayInfo {
 		ApiType:           info.ApiType,
 		RelayMode:         info.RelayMode,
 		UpstreamModelName: info.UpstreamModelName,
+		OriginModelName:   info.OriginModelName,
 		RequestURLPath:    info.RequestURLPath,
 		ApiKey:            info.ApiKey,
 		BaseUrl:           info.BaseUrl,
+		RequestId:         info.RequestId,
 	}
 }
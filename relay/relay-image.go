@@ -12,19 +12,49 @@ import (
 	"one-api/dto"
 	"one-api/model"
 	relaycommon "one-api/relay/common"
+	relayconstant "one-api/relay/constant"
 	"one-api/service"
 	"one-api/setting"
+	"strconv"
 	"strings"
 )
 
 func getAndValidImageRequest(c *gin.Context, info *relaycommon.RelayInfo) (*dto.ImageRequest, error) {
 	imageRequest := &dto.ImageRequest{}
-	err := common.UnmarshalBodyReusable(c, imageRequest)
-	if err != nil {
-		return nil, err
-	}
-	if imageRequest.Prompt == "" {
-		return nil, errors.New("prompt is required")
+	switch info.RelayMode {
+	case relayconstant.RelayModeImagesEdits, relayconstant.RelayModeImagesVariations:
+		err := c.Request.ParseMultipartForm(32 << 20)
+		if err != nil {
+			return nil, err
+		}
+		formData := c.Request.PostForm
+		imageRequest.Model = formData.Get("model")
+		imageRequest.Prompt = formData.Get("prompt")
+		imageRequest.Size = formData.Get("size")
+		imageRequest.Quality = formData.Get("quality")
+		imageRequest.ResponseFormat = formData.Get("response_format")
+		imageRequest.User = formData.Get("user")
+		if n := formData.Get("n"); n != "" {
+			imageRequest.N, err = strconv.Atoi(n)
+			if err != nil {
+				return nil, errors.New("n must be an integer")
+			}
+		}
+		files := c.Request.MultipartForm.File
+		if len(files["image"]) == 0 && len(files["image[]"]) == 0 {
+			return nil, errors.New("image is required")
+		}
+		if info.RelayMode == relayconstant.RelayModeImagesEdits && imageRequest.Prompt == "" {
+			return nil, errors.New("prompt is required")
+		}
+	default:
+		err := common.UnmarshalBodyReusable(c, imageRequest)
+		if err != nil {
+			return nil, err
+		}
+		if imageRequest.Prompt == "" {
+			return nil, errors.New("prompt is required")
+		}
 	}
 	if strings.Contains(imageRequest.Size, "×") {
 		return nil, errors.New("size an unexpected error occurred in the parameter, please use 'x' instead of the multiplication sign '×'")
@@ -100,7 +130,7 @@ func ImageHelper(c *gin.Context, relayMode int) *dto.OpenAIErrorWithStatusCode {
 	}
 
 	groupRatio := setting.GetGroupRatio(relayInfo.Group)
-	userQuota, err := model.GetUserQuota(relayInfo.UserId, false)
+	userQuota, err := model.GetUserQuotaWithPlan(relayInfo.UserId, relayInfo.Group, relayInfo.OriginModelName)
 
 	sizeRatio := 1.0
 	// Size
@@ -142,11 +172,16 @@ func ImageHelper(c *gin.Context, relayMode int) *dto.OpenAIErrorWithStatusCode {
 		return service.OpenAIErrorWrapperLocal(err, "convert_request_failed", http.StatusInternalServerError)
 	}
 
-	jsonData, err := json.Marshal(convertedRequest)
-	if err != nil {
-		return service.OpenAIErrorWrapperLocal(err, "json_marshal_failed", http.StatusInternalServerError)
+	if reader, ok := convertedRequest.(io.Reader); ok {
+		// edits and variations are multipart, the adaptor already built the body
+		requestBody = reader
+	} else {
+		jsonData, err := json.Marshal(convertedRequest)
+		if err != nil {
+			return service.OpenAIErrorWrapperLocal(err, "json_marshal_failed", http.StatusInternalServerError)
+		}
+		requestBody = bytes.NewBuffer(jsonData)
 	}
-	requestBody = bytes.NewBuffer(jsonData)
 
 	statusCodeMappingStr := c.GetString("status_code_mapping")
 
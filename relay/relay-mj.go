@@ -6,7 +6,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
 	"one-api/common"
 	"one-api/constant"
@@ -32,6 +31,10 @@ func RelayMidjourneyImage(c *gin.Context) {
 		})
 		return
 	}
+	if service.IsStorageUrl(midjourneyTask.ImageUrl) {
+		service.ServeStoredFile(c, strings.TrimPrefix(midjourneyTask.ImageUrl, service.GetStorageUrl("")))
+		return
+	}
 	resp, err := http.Get(midjourneyTask.ImageUrl)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -58,7 +61,7 @@ func RelayMidjourneyImage(c *gin.Context) {
 	// 将图片流式传输到响应体
 	_, err = io.Copy(c.Writer, resp.Body)
 	if err != nil {
-		log.Println("Failed to stream image:", err)
+		common.LogError(c, "Failed to stream image: "+err.Error())
 	}
 	return
 }
@@ -90,6 +93,12 @@ func RelayMidjourneyNotify(c *gin.Context) *dto.MidjourneyResponse {
 	midjourneyTask.StartTime = midjRequest.StartTime
 	midjourneyTask.FinishTime = midjRequest.FinishTime
 	midjourneyTask.ImageUrl = midjRequest.ImageUrl
+	if midjRequest.Status == "SUCCESS" && midjRequest.ImageUrl != "" {
+		midjourneyTask.ImageUrl, err = service.StoreRemoteFile(c, midjRequest.ImageUrl, "mj")
+		if err != nil {
+			common.LogError(c, "store midjourney image failed: "+err.Error())
+		}
+	}
 	midjourneyTask.Status = midjRequest.Status
 	midjourneyTask.FailReason = midjRequest.FailReason
 	err = midjourneyTask.Update()
@@ -112,7 +121,7 @@ func coverMidjourneyTaskDto(c *gin.Context, originTask *model.Midjourney) (midjo
 	midjourneyTask.StartTime = originTask.StartTime
 	midjourneyTask.FinishTime = originTask.FinishTime
 	midjourneyTask.ImageUrl = ""
-	if originTask.ImageUrl != "" && setting.MjForwardUrlEnabled {
+	if originTask.ImageUrl != "" && setting.MjForwardUrlEnabled && !service.IsStorageUrl(originTask.ImageUrl) {
 		midjourneyTask.ImageUrl = setting.ServerAddress + "/mj/image/" + originTask.MjId
 		if originTask.Status != "SUCCESS" {
 			midjourneyTask.ImageUrl += "?rand=" + strconv.FormatInt(time.Now().UnixNano(), 10)
@@ -170,7 +179,7 @@ func RelaySwapFace(c *gin.Context) *dto.MidjourneyResponse {
 	}
 	groupRatio := setting.GetGroupRatio(group)
 	ratio := modelPrice * groupRatio
-	userQuota, err := model.GetUserQuota(userId, false)
+	userQuota, err := model.GetUserQuotaWithPlan(userId, group, relayInfo.OriginModelName)
 	if err != nil {
 		return &dto.MidjourneyResponse{
 			Code:        4,
@@ -192,12 +201,19 @@ func RelaySwapFace(c *gin.Context) *dto.MidjourneyResponse {
 	if err != nil {
 		return &mjResp.Response
 	}
+	var midjourneyTask *model.Midjourney
 	defer func(ctx context.Context) {
 		if mjResp.StatusCode == 200 && mjResp.Response.Code == 1 {
 			err := model.PostConsumeQuota(relayInfo, userQuota, quota, 0, true)
 			if err != nil {
 				common.SysError("error consuming token remain quota: " + err.Error())
 			}
+			if relayInfo.PlanQuota > 0 && midjourneyTask != nil && midjourneyTask.Id != 0 {
+				err = midjourneyTask.UpdatePlanQuota(relayInfo.PlanQuota)
+				if err != nil {
+					common.SysError("error update midjourney plan quota: " + err.Error())
+				}
+			}
 			//err = model.CacheUpdateUserQuota(userId)
 			if err != nil {
 				common.SysError("error update user quota cache: " + err.Error())
@@ -209,7 +225,7 @@ func RelaySwapFace(c *gin.Context) *dto.MidjourneyResponse {
 				other["model_price"] = modelPrice
 				other["group_ratio"] = groupRatio
 				model.RecordConsumeLog(ctx, userId, channelId, 0, 0, modelName, tokenName,
-					quota, logContent, tokenId, userQuota, 0, false, group, other)
+					quota, relayInfo.PlanQuota, logContent, tokenId, userQuota, 0, false, group, other)
 				model.UpdateUserUsedQuotaAndRequestCount(userId, quota)
 				channelId := c.GetInt("channel_id")
 				model.UpdateChannelUsedQuota(channelId, quota)
@@ -217,7 +233,7 @@ func RelaySwapFace(c *gin.Context) *dto.MidjourneyResponse {
 		}
 	}(c.Request.Context())
 	midjResponse := &mjResp.Response
-	midjourneyTask := &model.Midjourney{
+	midjourneyTask = &model.Midjourney{
 		UserId:      userId,
 		Code:        midjResponse.Code,
 		Action:      constant.MjActionSwapFace,
@@ -267,7 +283,9 @@ func RelayMidjourneyTaskImageSeed(c *gin.Context) *dto.MidjourneyResponse {
 		return service.MidjourneyErrorWrapper(constant.MjRequestError, "该任务所属渠道已被禁用")
 	}
 	c.Set("channel_id", originTask.ChannelId)
-	c.Request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", channel.Key))
+	key, keyHash := channel.SelectKey()
+	c.Set("channel_key_hash", keyHash)
+	c.Request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", key))
 
 	requestURL := getMjRequestPath(c.Request.URL.String())
 	fullRequestURL := fmt.Sprintf("%s%s", channel.GetBaseURL(), requestURL)
@@ -437,8 +455,10 @@ func RelayMidjourneySubmit(c *gin.Context, relayMode int) *dto.MidjourneyRespons
 			}
 			c.Set("base_url", channel.GetBaseURL())
 			c.Set("channel_id", originTask.ChannelId)
-			c.Request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", channel.Key))
-			log.Printf("检测到此操作为放大、变换、重绘，获取原channel信息: %s,%s", strconv.Itoa(originTask.ChannelId), channel.GetBaseURL())
+			key, keyHash := channel.SelectKey()
+			c.Set("channel_key_hash", keyHash)
+			c.Request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", key))
+			common.LogInfo(c, fmt.Sprintf("检测到此操作为放大、变换、重绘，获取原channel信息: %s,%s", strconv.Itoa(originTask.ChannelId), channel.GetBaseURL()))
 		}
 		midjRequest.Prompt = originTask.Prompt
 
@@ -476,7 +496,7 @@ func RelayMidjourneySubmit(c *gin.Context, relayMode int) *dto.MidjourneyRespons
 	}
 	groupRatio := setting.GetGroupRatio(group)
 	ratio := modelPrice * groupRatio
-	userQuota, err := model.GetUserQuota(userId, false)
+	userQuota, err := model.GetUserQuotaWithPlan(userId, group, relayInfo.OriginModelName)
 	if err != nil {
 		return &dto.MidjourneyResponse{
 			Code:        4,
@@ -498,12 +518,19 @@ func RelayMidjourneySubmit(c *gin.Context, relayMode int) *dto.MidjourneyRespons
 	}
 	midjResponse := &midjResponseWithStatus.Response
 
+	var midjourneyTask *model.Midjourney
 	defer func(ctx context.Context) {
 		if consumeQuota && midjResponseWithStatus.StatusCode == 200 {
 			err := model.PostConsumeQuota(relayInfo, userQuota, quota, 0, true)
 			if err != nil {
 				common.SysError("error consuming token remain quota: " + err.Error())
 			}
+			if relayInfo.PlanQuota > 0 && midjourneyTask != nil && midjourneyTask.Id != 0 {
+				err = midjourneyTask.UpdatePlanQuota(relayInfo.PlanQuota)
+				if err != nil {
+					common.SysError("error update midjourney plan quota: " + err.Error())
+				}
+			}
 			if quota != 0 {
 				tokenName := c.GetString("token_name")
 				logContent := fmt.Sprintf("模型固定价格 %.2f，分组倍率 %.2f，操作 %s，ID %s", modelPrice, groupRatio, midjRequest.Action, midjResponse.Result)
@@ -511,7 +538,7 @@ func RelayMidjourneySubmit(c *gin.Context, relayMode int) *dto.MidjourneyRespons
 				other["model_price"] = modelPrice
 				other["group_ratio"] = groupRatio
 				model.RecordConsumeLog(ctx, userId, channelId, 0, 0, modelName, tokenName,
-					quota, logContent, tokenId, userQuota, 0, false, group, other)
+					quota, relayInfo.PlanQuota, logContent, tokenId, userQuota, 0, false, group, other)
 				model.UpdateUserUsedQuotaAndRequestCount(userId, quota)
 				channelId := c.GetInt("channel_id")
 				model.UpdateChannelUsedQuota(channelId, quota)
@@ -526,7 +553,7 @@ func RelayMidjourneySubmit(c *gin.Context, relayMode int) *dto.MidjourneyRespons
 	// 23-队列已满，请稍后再试 {"code":23,"description":"队列已满，请稍后尝试","result":"14001929738841620","properties":{"discordInstanceId":"1118138338562560102"}}
 	// 24-prompt包含敏感词 {"code":24,"description":"可能包含敏感词","properties":{"promptEn":"nude body","bannedWord":"nude"}}
 	// other: 提交错误，description为错误描述
-	midjourneyTask := &model.Midjourney{
+	midjourneyTask = &model.Midjourney{
 		UserId:      userId,
 		Code:        midjResponse.Code,
 		Action:      midjRequest.Action,
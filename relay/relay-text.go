@@ -87,9 +87,11 @@ func TextHelper(c *gin.Context) (openaiErr *dto.OpenAIErrorWithStatusCode) {
 		}
 		// 将消息中的图片链接转为base64
 		if common.ConvertImageUrlsToBase64 {
+			_, endSpan := common.StartSpan(c, "convert image urls")
 			for i := range textRequest.Messages {
 				service.ConvertImageUrlsToBase64(&textRequest.Messages[i], relayInfo.UserId)
 			}
+			endSpan()
 		}
 		c.Set("textRequest", textRequest)
 	}
@@ -97,21 +99,9 @@ func TextHelper(c *gin.Context) (openaiErr *dto.OpenAIErrorWithStatusCode) {
 	c.Set("originalModel", textRequest.Model)
 
 	// map model name
-	//isModelMapped := false
-	modelMapping := c.GetString("model_mapping")
-	//isModelMapped := false
-	if modelMapping != "" && modelMapping != "{}" {
-		modelMap := make(map[string]string)
-		err := json.Unmarshal([]byte(modelMapping), &modelMap)
-		if err != nil {
-			return service.OpenAIErrorWrapperLocal(err, "unmarshal_model_mapping_failed", http.StatusInternalServerError)
-		}
-		if modelMap[textRequest.Model] != "" {
-			//isModelMapped = true
-			textRequest.Model = modelMap[textRequest.Model]
-			// set upstream model name
-			//isModelMapped = true
-		}
+	textRequest.Model, err = getMappedModelName(c, textRequest.Model)
+	if err != nil {
+		return service.OpenAIErrorWrapperLocal(err, "unmarshal_model_mapping_failed", http.StatusInternalServerError)
 	}
 	relayInfo.UpstreamModelName = textRequest.Model
 	modelPrice, getModelPriceSuccess := common.GetModelPrice(textRequest.Model, false)
@@ -139,7 +129,9 @@ func TextHelper(c *gin.Context) (openaiErr *dto.OpenAIErrorWithStatusCode) {
 			promptTokens = 1000 // 默认值
 			relayInfo.PromptTokens = promptTokens
 		} else {
+			_, endSpan := common.StartSpan(c, "count prompt tokens")
 			promptTokens, err = getPromptTokens(textRequest, relayInfo)
+			endSpan()
 			// count messages token error 计算promptTokens错误
 			if err != nil {
 				return service.OpenAIErrorWrapper(err, "count_token_messages_failed", http.StatusInternalServerError)
@@ -160,8 +152,24 @@ func TextHelper(c *gin.Context) (openaiErr *dto.OpenAIErrorWithStatusCode) {
 		preConsumedQuota = int(modelPrice * common.QuotaPerUnit * groupRatio)
 	}
 
+	// replay a cached response without calling the upstream
+	responseCacheKey := ""
+	if c.GetBool("token_response_cache") && (relayInfo.RelayMode == relayconstant.RelayModeChatCompletions ||
+		relayInfo.RelayMode == relayconstant.RelayModeCompletions || relayInfo.RelayMode == relayconstant.RelayModeEmbeddings) {
+		body, err := common.GetRequestBody(c)
+		if err != nil {
+			return service.OpenAIErrorWrapperLocal(err, "get_request_body_failed", http.StatusInternalServerError)
+		}
+		responseCacheKey = service.GetResponseCacheKey(relayInfo, body)
+		if cache, ok := service.GetResponseCache(responseCacheKey); ok {
+			return replayResponseCache(c, relayInfo, textRequest.Model, cache, ratio, modelRatio, groupRatio, modelPrice, getModelPriceSuccess)
+		}
+	}
+
 	// pre-consume quota 预消耗配额
+	_, endSpan := common.StartSpan(c, "pre-consume quota")
 	preConsumedQuota, userQuota, openaiErr := preConsumeQuota(c, preConsumedQuota, relayInfo)
+	endSpan()
 	if openaiErr != nil {
 		return openaiErr
 	}
@@ -209,11 +217,14 @@ func TextHelper(c *gin.Context) (openaiErr *dto.OpenAIErrorWithStatusCode) {
 	//
 	//}
 
+	_, endSpan = common.StartSpan(c, "convert request")
 	convertedRequest, err := adaptor.ConvertRequest(c, relayInfo, textRequest)
 	if err != nil {
+		endSpan()
 		return service.OpenAIErrorWrapperLocal(err, "convert_request_failed", http.StatusInternalServerError)
 	}
 	jsonData, err := json.Marshal(convertedRequest)
+	endSpan()
 	if err != nil {
 		return service.OpenAIErrorWrapperLocal(err, "json_marshal_failed", http.StatusInternalServerError)
 	}
@@ -237,12 +248,30 @@ func TextHelper(c *gin.Context) (openaiErr *dto.OpenAIErrorWithStatusCode) {
 		}
 	}
 
+	var responseCacheWriter *service.ResponseCacheWriter
+	if responseCacheKey != "" {
+		responseCacheWriter = service.NewResponseCacheWriter(c.Writer)
+		c.Writer = responseCacheWriter
+		defer func() {
+			c.Writer = responseCacheWriter.ResponseWriter
+		}()
+	}
+	_, endSpan = common.StartSpan(c, "upstream response")
 	usage, openaiErr := adaptor.DoResponse(c, httpResp, relayInfo)
+	endSpan()
+	if c.GetBool("hedge_lost") {
+		// the other hedged request answered first, only that one is billed
+		return service.OpenAIErrorWrapperLocal(errors.New("hedged request cancelled"), "hedge_cancelled", http.StatusInternalServerError)
+	}
 	if openaiErr != nil {
 		// reset status code 重置状态码
 		service.ResetStatusCode(openaiErr, statusCodeMappingStr)
 		return openaiErr
 	}
+	if responseCacheWriter != nil {
+		cacheUsage, _ := usage.(*dto.Usage)
+		responseCacheWriter.Save(responseCacheKey, relayInfo.IsStream, cacheUsage, c.GetInt("token_response_cache_ttl"))
+	}
 
 	if strings.HasPrefix(relayInfo.UpstreamModelName, "gpt-4o-audio") {
 		service.PostAudioConsumeQuota(c, relayInfo, usage.(*dto.Usage), ratio, preConsumedQuota, userQuota, modelRatio, groupRatio, modelPrice, getModelPriceSuccess, "")
@@ -252,6 +281,43 @@ func TextHelper(c *gin.Context) (openaiErr *dto.OpenAIErrorWithStatusCode) {
 	return nil
 }
 
+// replayResponseCache serves a cache hit, it is charged from the cached usage
+// with setting.ResponseCacheRatio applied on top of the usual ratios.
+func replayResponseCache(c *gin.Context, relayInfo *relaycommon.RelayInfo, modelName string, cache *service.ResponseCache,
+	ratio float64, modelRatio float64, groupRatio float64, modelPrice float64, usePrice bool) *dto.OpenAIErrorWithStatusCode {
+	userQuota, err := model.GetUserQuotaWithPlan(relayInfo.UserId, relayInfo.Group, relayInfo.OriginModelName)
+	if err != nil {
+		return service.OpenAIErrorWrapperLocal(err, "get_user_quota_failed", http.StatusInternalServerError)
+	}
+	if userQuota <= 0 && setting.ResponseCacheRatio > 0 {
+		return service.OpenAIErrorWrapperLocal(errors.New("user quota is not enough"), "insufficient_user_quota", http.StatusForbidden)
+	}
+	relayInfo.IsStream = cache.IsStream
+	service.ReplayResponseCache(c, cache)
+	c.Set("response_cache_hit", true)
+	extraContent := fmt.Sprintf("响应缓存命中，缓存计费倍率 %.2f", setting.ResponseCacheRatio)
+	go postConsumeQuota(c, relayInfo, modelName, cache.Usage, ratio*setting.ResponseCacheRatio, 0, userQuota, modelRatio, groupRatio,
+		modelPrice*setting.ResponseCacheRatio, usePrice, extraContent)
+	return nil
+}
+
+// getMappedModelName applies the channel model mapping to the requested model
+func getMappedModelName(c *gin.Context, modelName string) (string, error) {
+	modelMapping := c.GetString("model_mapping")
+	if modelMapping == "" || modelMapping == "{}" {
+		return modelName, nil
+	}
+	modelMap := make(map[string]string)
+	err := json.Unmarshal([]byte(modelMapping), &modelMap)
+	if err != nil {
+		return modelName, err
+	}
+	if modelMap[modelName] != "" {
+		return modelMap[modelName], nil
+	}
+	return modelName, nil
+}
+
 func getPromptTokens(textRequest *dto.GeneralOpenAIRequest, info *relaycommon.RelayInfo) (int, error) {
 	var promptTokens int
 	var err error
@@ -289,7 +355,7 @@ func checkRequestSensitive(textRequest *dto.GeneralOpenAIRequest, info *relaycom
 
 // 预扣费并返回用户剩余配额
 func preConsumeQuota(c *gin.Context, preConsumedQuota int, relayInfo *relaycommon.RelayInfo) (int, int, *dto.OpenAIErrorWithStatusCode) {
-	userQuota, err := model.GetUserQuota(relayInfo.UserId, false)
+	userQuota, err := model.GetUserQuotaWithPlan(relayInfo.UserId, relayInfo.Group, relayInfo.OriginModelName)
 	if err != nil {
 		return 0, 0, service.OpenAIErrorWrapperLocal(err, "get_user_quota_failed", http.StatusInternalServerError)
 	}
@@ -324,7 +390,7 @@ func preConsumeQuota(c *gin.Context, preConsumedQuota int, relayInfo *relaycommo
 		if err != nil {
 			return 0, 0, service.OpenAIErrorWrapperLocal(err, "pre_consume_token_quota_failed", http.StatusForbidden)
 		}
-		err = model.DecreaseUserQuota(relayInfo.UserId, preConsumedQuota)
+		err = model.DecreaseUserQuota(relayInfo.UserId, preConsumedQuota, model.RelayQuotaRef(relayInfo))
 		if err != nil {
 			return 0, 0, service.OpenAIErrorWrapperLocal(err, "decrease_user_quota_failed", http.StatusInternalServerError)
 		}
@@ -356,16 +422,40 @@ func postConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, modelN
 		}
 		extraContent += "  ，（可能是请求出错）"
 	}
+	span := common.StartLeafSpan(ctx, "settle quota")
+	defer span.End()
 	useTimeSeconds := time.Now().Unix() - relayInfo.StartTime.Unix()
+	if !ctx.GetBool("response_cache_hit") {
+		// non stream responses arrive at once, their first token is the whole response
+		firstResponseTime := relayInfo.FirstResponseTime
+		if !firstResponseTime.After(relayInfo.StartTime) {
+			firstResponseTime = time.Now()
+		}
+		model.RecordChannelFirstResponse(relayInfo.ChannelId, firstResponseTime.Sub(relayInfo.StartTime))
+		common.RecordRelayFirstToken(relayInfo.ChannelId, modelName, relayInfo.Group, firstResponseTime.Sub(relayInfo.StartTime))
+	}
 	promptTokens := usage.PromptTokens
 	completionTokens := usage.CompletionTokens
+	service.RecordRateLimitTokens(ctx, promptTokens+completionTokens)
 
 	tokenName := ctx.GetString("token_name")
 	completionRatio := common.GetCompletionRatio(modelName)
+	cacheTokens := usage.PromptTokensDetails.CachedTokens
+	cacheRatio := common.GetCacheRatio(modelName)
+	cacheCreationTokens := usage.PromptTokensDetails.CachedCreationTokens
+	cacheCreationRatio := common.GetCreateCacheRatio(modelName)
+	if cacheTokens+cacheCreationTokens > promptTokens {
+		// inconsistent upstream usage, bill everything as normal prompt tokens
+		cacheTokens = 0
+		cacheCreationTokens = 0
+	}
 
 	quota := 0
 	if !usePrice {
-		quota = promptTokens + int(math.Round(float64(completionTokens)*completionRatio))
+		// cache reads and writes are part of the prompt but priced by their own ratio
+		quota = promptTokens - cacheTokens - cacheCreationTokens
+		quota += int(math.Round(float64(cacheTokens)*cacheRatio + float64(cacheCreationTokens)*cacheCreationRatio))
+		quota += int(math.Round(float64(completionTokens) * completionRatio))
 		quota = int(math.Round(float64(quota) * ratio))
 		if ratio != 0 && quota <= 0 {
 			quota = 1
@@ -377,6 +467,12 @@ func postConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, modelN
 	var logContent string
 	if !usePrice {
 		logContent = fmt.Sprintf("模型倍率 %.2f，补全倍率 %.2f，分组倍率 %.2f", modelRatio, completionRatio, groupRatio)
+		if cacheTokens != 0 {
+			logContent += fmt.Sprintf("，缓存倍率 %.2f", cacheRatio)
+		}
+		if cacheCreationTokens != 0 {
+			logContent += fmt.Sprintf("，缓存创建倍率 %.2f", cacheCreationRatio)
+		}
 	} else {
 		logContent = fmt.Sprintf("模型价格 %.2f，分组倍率 %.2f", modelPrice, groupRatio)
 	}
@@ -394,7 +490,7 @@ func postConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, modelN
 		//	logContent += fmt.Sprintf("，敏感词：%s", strings.Join(sensitiveResp.SensitiveWords, ", "))
 		//}
 		quotaDelta := quota - preConsumedQuota
-		if quotaDelta != 0 {
+		if quotaDelta != 0 || preConsumedQuota != 0 {
 			err := model.PostConsumeQuota(relayInfo, userQuota, quotaDelta, preConsumedQuota, true)
 			if err != nil {
 				common.LogError(ctx, "error consuming token remain quota: "+err.Error())
@@ -410,6 +506,7 @@ func postConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, modelN
 		// }
 		model.UpdateUserUsedQuotaAndRequestCount(relayInfo.UserId, quota)
 		model.UpdateChannelUsedQuota(relayInfo.ChannelId, quota)
+		model.UpdateChannelKeyUsedQuota(relayInfo.ChannelId, relayInfo.ChannelKeyHash, quota)
 	}
 
 	logModel := modelName
@@ -428,8 +525,28 @@ func postConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, modelN
 		logModel = "gpt-4o-2024-08-06"
 	}
 	other := service.GenerateTextOtherInfo(ctx, relayInfo, modelRatio, groupRatio, completionRatio, modelPrice)
+	if usage.CompletionTokenDetails.ReasoningTokens != 0 {
+		other["reasoning_tokens"] = usage.CompletionTokenDetails.ReasoningTokens
+	}
+	if cacheTokens != 0 {
+		other["cache_tokens"] = cacheTokens
+		other["cache_ratio"] = cacheRatio
+	}
+	if cacheCreationTokens != 0 {
+		other["cache_creation_tokens"] = cacheCreationTokens
+		other["cache_creation_ratio"] = cacheCreationRatio
+	}
+	if relayInfo.RelayFormat != "" && relayInfo.RelayFormat != relaycommon.RelayFormatOpenAI {
+		other["relay_format"] = relayInfo.RelayFormat
+	}
+	if fallbackFrom := ctx.GetString("fallback_from"); fallbackFrom != "" {
+		other["fallback_from"] = fallbackFrom
+	}
+	if ctx.GetBool("response_cache_hit") {
+		other["response_cache_hit"] = true
+	}
 	model.RecordConsumeLog(ctx, relayInfo.UserId, relayInfo.ChannelId, promptTokens, completionTokens, logModel,
-		tokenName, quota, logContent, relayInfo.TokenId, userQuota, int(useTimeSeconds), relayInfo.IsStream, relayInfo.Group, other)
+		tokenName, quota, relayInfo.PlanQuota, logContent, relayInfo.TokenId, userQuota, int(useTimeSeconds), relayInfo.IsStream, relayInfo.Group, other)
 
 	//if quota != 0 {
 	//
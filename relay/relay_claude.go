@@ -0,0 +1,85 @@
+package relay
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"one-api/common"
+	"one-api/dto"
+	"one-api/relay/channel"
+	"one-api/relay/channel/claude"
+	relaycommon "one-api/relay/common"
+	relayconstant "one-api/relay/constant"
+	"one-api/service"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+func getAndValidateClaudeRequest(c *gin.Context) (*claude.ClaudeRequest, error) {
+	claudeRequest := &claude.ClaudeRequest{}
+	err := common.UnmarshalBodyReusable(c, claudeRequest)
+	if err != nil {
+		return nil, err
+	}
+	if claudeRequest.Model == "" {
+		return nil, errors.New("model is required")
+	}
+	if len(claudeRequest.Messages) == 0 {
+		return nil, errors.New("field messages is required")
+	}
+	if claudeRequest.MaxTokens == 0 {
+		return nil, errors.New("field max_tokens is required")
+	}
+	if claudeRequest.MaxTokens > math.MaxInt32/2 {
+		return nil, errors.New("max_tokens is invalid")
+	}
+	return claudeRequest, nil
+}
+
+// isClaudeNativeChannel reports whether the selected channel can take an
+// Anthropic Messages request as is.
+func isClaudeNativeChannel(info *relaycommon.RelayInfo) bool {
+	switch info.ApiType {
+	case relayconstant.APITypeAnthropic, relayconstant.APITypeAws:
+		return true
+	case relayconstant.APITypeVertexAi:
+		return strings.HasPrefix(info.UpstreamModelName, "claude")
+	}
+	return false
+}
+
+// ClaudeHelper relays /v1/messages requests. Anthropic compatible channels get the
+// request body unchanged, other channels go through the OpenAI conversion and
+// their responses are translated back into Anthropic events.
+func ClaudeHelper(c *gin.Context) *dto.OpenAIErrorWithStatusCode {
+	relayInfo := relaycommon.GenRelayInfo(c)
+	relayInfo.RelayFormat = relaycommon.RelayFormatClaude
+	relayInfo.RelayMode = relayconstant.RelayModeChatCompletions
+
+	claudeRequest, err := getAndValidateClaudeRequest(c)
+	if err != nil {
+		common.LogError(c, fmt.Sprintf("getAndValidateClaudeRequest failed: %s", err.Error()))
+		return service.OpenAIErrorWrapperLocal(err, "invalid_claude_request", http.StatusBadRequest)
+	}
+	relayInfo.IsStream = claudeRequest.Stream
+	c.Set("stream", claudeRequest.Stream)
+
+	return relayConvertedFormat(c, relayInfo, convertedFormat{
+		invalidRequestCode: "invalid_claude_request",
+		toOpenAI: func() (*dto.GeneralOpenAIRequest, error) {
+			return claude.RequestClaude2OpenAI(*claudeRequest)
+		},
+		nativeConverter: func(adaptor channel.Adaptor, info *relaycommon.RelayInfo) (nativeRequestConverter, bool) {
+			claudeAdaptor, ok := adaptor.(channel.ClaudeAdaptor)
+			if !ok || !isClaudeNativeChannel(info) {
+				return nil, false
+			}
+			return claudeAdaptor.ConvertClaudeRequest, true
+		},
+		newResponseConverter: func(info *relaycommon.RelayInfo) relaycommon.ResponseConverter {
+			return claude.NewOpenAI2ClaudeConverter(info)
+		},
+	})
+}
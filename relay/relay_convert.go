@@ -0,0 +1,166 @@
+package relay
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"one-api/common"
+	"one-api/dto"
+	"one-api/relay/channel"
+	relaycommon "one-api/relay/common"
+	"one-api/service"
+	"one-api/setting"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// nativeRequestConverter prepares a request body in the ingress format for a
+// channel that speaks that format natively.
+type nativeRequestConverter func(c *gin.Context, info *relaycommon.RelayInfo, requestBody []byte) ([]byte, error)
+
+// convertedFormat describes an ingress format that is not OpenAI's. toOpenAI
+// converts the request for pricing and for the channels that only take chat
+// completions, whose responses newResponseConverter translates back.
+// nativeConverter returns how the adaptor takes the request as is when the
+// selected channel speaks the format natively.
+type convertedFormat struct {
+	invalidRequestCode   string
+	toOpenAI             func() (*dto.GeneralOpenAIRequest, error)
+	nativeConverter      func(adaptor channel.Adaptor, info *relaycommon.RelayInfo) (nativeRequestConverter, bool)
+	newResponseConverter func(info *relaycommon.RelayInfo) relaycommon.ResponseConverter
+}
+
+// relayConvertedFormat relays a request in another format than OpenAI's and
+// bills it like a chat completion.
+func relayConvertedFormat(c *gin.Context, relayInfo *relaycommon.RelayInfo, format convertedFormat) (openaiErr *dto.OpenAIErrorWithStatusCode) {
+	textRequest, err := format.toOpenAI()
+	if err != nil {
+		return service.OpenAIErrorWrapperLocal(err, format.invalidRequestCode, http.StatusBadRequest)
+	}
+	c.Set("originalModel", textRequest.Model)
+
+	textRequest.Model, err = getMappedModelName(c, textRequest.Model)
+	if err != nil {
+		return service.OpenAIErrorWrapperLocal(err, "unmarshal_model_mapping_failed", http.StatusInternalServerError)
+	}
+	relayInfo.UpstreamModelName = textRequest.Model
+	modelPrice, getModelPriceSuccess := common.GetModelPrice(textRequest.Model, false)
+	groupRatio := setting.GetGroupRatio(relayInfo.Group)
+
+	if setting.ShouldCheckPromptSensitive() {
+		err = service.CheckSensitiveMessages(textRequest.Messages)
+		if err != nil {
+			return service.OpenAIErrorWrapperLocal(err, "sensitive_words_detected", http.StatusBadRequest)
+		}
+	}
+
+	promptTokens, err := service.CountTokenChatRequest(relayInfo, *textRequest)
+	if err != nil {
+		return service.OpenAIErrorWrapper(err, "count_token_messages_failed", http.StatusInternalServerError)
+	}
+	relayInfo.PromptTokens = promptTokens
+	c.Set("prompt_tokens", promptTokens)
+
+	var preConsumedQuota int
+	var ratio float64
+	var modelRatio float64
+	if !getModelPriceSuccess {
+		preConsumedTokens := promptTokens + int(textRequest.MaxTokens)
+		modelRatio = common.GetModelRatio(textRequest.Model)
+		ratio = modelRatio * groupRatio
+		preConsumedQuota = int(float64(preConsumedTokens) * ratio)
+	} else {
+		preConsumedQuota = int(modelPrice * common.QuotaPerUnit * groupRatio)
+	}
+
+	preConsumedQuota, userQuota, openaiErr := preConsumeQuota(c, preConsumedQuota, relayInfo)
+	if openaiErr != nil {
+		return openaiErr
+	}
+	defer func() {
+		if openaiErr != nil {
+			returnPreConsumedQuota(c, relayInfo, userQuota, preConsumedQuota)
+		}
+	}()
+
+	adaptor := GetAdaptor(relayInfo.ApiType)
+	if adaptor == nil {
+		return service.OpenAIErrorWrapperLocal(fmt.Errorf("invalid api type: %d", relayInfo.ApiType), "invalid_api_type", http.StatusBadRequest)
+	}
+	adaptor.Init(relayInfo)
+
+	var requestBody io.Reader
+	var convertWriter *relaycommon.FormatConvertWriter
+	if convertNative, ok := format.nativeConverter(adaptor, relayInfo); ok {
+		body, err := common.GetRequestBody(c)
+		if err != nil {
+			return service.OpenAIErrorWrapperLocal(err, "get_request_body_failed", http.StatusInternalServerError)
+		}
+		convertedBody, err := convertNative(c, relayInfo, body)
+		if err != nil {
+			return service.OpenAIErrorWrapperLocal(err, "convert_request_failed", http.StatusInternalServerError)
+		}
+		requestBody = bytes.NewBuffer(convertedBody)
+	} else {
+		// the adaptor sees a regular chat completion request
+		relayInfo.RequestURLPath = "/v1/chat/completions"
+		if textRequest.Stream {
+			relayInfo.ShouldIncludeUsage = true
+			if relayInfo.SupportStreamOptions {
+				textRequest.StreamOptions = &dto.StreamOptions{
+					IncludeUsage: true,
+				}
+			}
+		}
+		convertedRequest, err := adaptor.ConvertRequest(c, relayInfo, textRequest)
+		if err != nil {
+			return service.OpenAIErrorWrapperLocal(err, "convert_request_failed", http.StatusInternalServerError)
+		}
+		jsonData, err := json.Marshal(convertedRequest)
+		if err != nil {
+			return service.OpenAIErrorWrapperLocal(err, "json_marshal_failed", http.StatusInternalServerError)
+		}
+		requestBody = bytes.NewBuffer(jsonData)
+		convertWriter = relaycommon.NewFormatConvertWriter(c.Writer, format.newResponseConverter(relayInfo))
+	}
+
+	statusCodeMappingStr := c.GetString("status_code_mapping")
+	var httpResp *http.Response
+	resp, err := adaptor.DoRequest(c, relayInfo, requestBody)
+	if err != nil {
+		return service.OpenAIErrorWrapper(err, "do_request_failed", http.StatusInternalServerError)
+	}
+
+	if resp != nil {
+		httpResp = resp.(*http.Response)
+		relayInfo.IsStream = relayInfo.IsStream || strings.HasPrefix(httpResp.Header.Get("Content-Type"), "text/event-stream")
+		if httpResp.StatusCode != http.StatusOK {
+			openaiErr = service.RelayErrorHandler(httpResp)
+			// reset status code 重置状态码
+			service.ResetStatusCode(openaiErr, statusCodeMappingStr)
+			return openaiErr
+		}
+	}
+
+	if convertWriter != nil {
+		c.Writer = convertWriter
+	}
+	usage, openaiErr := adaptor.DoResponse(c, httpResp, relayInfo)
+	if convertWriter != nil {
+		c.Writer = convertWriter.ResponseWriter
+		if openaiErr == nil {
+			convertWriter.Finish()
+		}
+	}
+	if openaiErr != nil {
+		// reset status code 重置状态码
+		service.ResetStatusCode(openaiErr, statusCodeMappingStr)
+		return openaiErr
+	}
+
+	go postConsumeQuota(c, relayInfo, textRequest.Model, usage.(*dto.Usage), ratio, preConsumedQuota, userQuota, modelRatio, groupRatio, modelPrice, getModelPriceSuccess, "")
+	return nil
+}
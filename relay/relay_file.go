@@ -0,0 +1,353 @@
+package relay
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"io"
+	"math"
+	"net/http"
+	"one-api/common"
+	"one-api/constant"
+	"one-api/dto"
+	"one-api/model"
+	relaycommon "one-api/relay/common"
+	"one-api/service"
+	"one-api/setting"
+	"strings"
+)
+
+// FileHelper relays the files and batches endpoints to the channel picked by
+// the distributor, every file or batch the upstream creates is recorded so
+// later requests on the id go back to the same channel.
+func FileHelper(c *gin.Context) *dto.OpenAIErrorWithStatusCode {
+	channelType := c.GetInt("channel_type")
+	if channelType != common.ChannelTypeOpenAI && channelType != common.ChannelTypeAzure {
+		return service.OpenAIErrorWrapperLocal(fmt.Errorf("channel type %d does not support files and batches", channelType), "invalid_api_type", http.StatusBadRequest)
+	}
+	if c.Request.Method == http.MethodPost && c.Request.URL.Path == "/v1/batches" {
+		// batches are billed once they complete, only make sure the user can pay
+		userQuota, err := model.GetUserQuota(c.GetInt("id"), false)
+		if err != nil {
+			return service.OpenAIErrorWrapperLocal(err, "get_user_quota_failed", http.StatusInternalServerError)
+		}
+		if userQuota <= 0 {
+			return service.OpenAIErrorWrapperLocal(errors.New("user quota is not enough"), "insufficient_user_quota", http.StatusForbidden)
+		}
+	}
+
+	var requestBody io.Reader
+	if c.Request.Method == http.MethodPost {
+		body, err := common.GetRequestBody(c)
+		if err != nil {
+			return service.OpenAIErrorWrapperLocal(err, "read_request_body_failed", http.StatusBadRequest)
+		}
+		requestBody = bytes.NewReader(body)
+	}
+	fullRequestURL := getFileRequestURL(channelType, c.GetString("base_url"), c.Request.URL.Path, relaycommon.GetAPIVersion(c))
+	req, err := http.NewRequest(c.Request.Method, fullRequestURL, requestBody)
+	if err != nil {
+		return service.OpenAIErrorWrapperLocal(err, "new_request_failed", http.StatusInternalServerError)
+	}
+	if requestBody != nil {
+		req.Header.Set("Content-Type", c.Request.Header.Get("Content-Type"))
+	}
+	apiKey := strings.TrimPrefix(c.Request.Header.Get("Authorization"), "Bearer ")
+	setFileRequestHeader(req, channelType, apiKey, c.GetString("channel_organization"))
+
+	resp, err := service.GetHttpClient().Do(req)
+	if err != nil {
+		return service.OpenAIErrorWrapper(err, "do_request_failed", http.StatusInternalServerError)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return service.RelayErrorHandler(resp)
+	}
+	defer resp.Body.Close()
+
+	if strings.HasSuffix(c.Request.URL.Path, "/content") {
+		// file content can be large, stream it instead of buffering
+		for _, key := range []string{"Content-Type", "Content-Length", "Content-Disposition"} {
+			if value := resp.Header.Get(key); value != "" {
+				c.Writer.Header().Set(key, value)
+			}
+		}
+		c.Writer.WriteHeader(resp.StatusCode)
+		_, err = io.Copy(c.Writer, resp.Body)
+		if err != nil {
+			common.LogError(c, "copy file content failed: "+err.Error())
+		}
+		return nil
+	}
+
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return service.OpenAIErrorWrapper(err, "read_response_body_failed", http.StatusInternalServerError)
+	}
+	err = recordRelayObject(c, responseBody)
+	if err != nil {
+		common.LogError(c, "record relay object failed: "+err.Error())
+	}
+	c.Data(resp.StatusCode, resp.Header.Get("Content-Type"), responseBody)
+	return nil
+}
+
+func recordRelayObject(c *gin.Context, responseBody []byte) error {
+	var object struct {
+		Id      string `json:"id"`
+		Object  string `json:"object"`
+		Deleted bool   `json:"deleted"`
+	}
+	err := json.Unmarshal(responseBody, &object)
+	if err != nil {
+		return err
+	}
+	if object.Id == "" {
+		return nil
+	}
+	relayObject, exist, err := model.GetRelayObject(object.Id)
+	if err != nil {
+		return err
+	}
+	if exist {
+		if object.Deleted {
+			return relayObject.Delete()
+		}
+		relayObject.Data = responseBody
+		return relayObject.Update()
+	}
+	if object.Deleted {
+		return nil
+	}
+
+	relayObject = &model.RelayObject{
+		ObjectId:  object.Id,
+		Object:    model.RelayObjectFile,
+		UserId:    c.GetInt("id"),
+		TokenId:   c.GetInt("token_id"),
+		ChannelId: c.GetInt("channel_id"),
+		Group:     c.GetString("group"),
+		CreatedAt: common.GetTimestamp(),
+		Data:      responseBody,
+	}
+	if object.Object == "batch" {
+		relayObject.Object = model.RelayObjectBatch
+	}
+	err = relayObject.Insert()
+	if err != nil {
+		return err
+	}
+	if relayObject.Object != model.RelayObjectBatch {
+		return nil
+	}
+
+	// the task polling loop follows the batch and bills it once it completes
+	var batch dto.OpenAIBatch
+	err = json.Unmarshal(responseBody, &batch)
+	if err != nil {
+		return err
+	}
+	task := &model.Task{
+		TaskID:     batch.Id,
+		Platform:   constant.TaskPlatformBatch,
+		UserId:     relayObject.UserId,
+		ChannelId:  relayObject.ChannelId,
+		Action:     batch.Endpoint,
+		Status:     model.TaskStatusSubmitted,
+		SubmitTime: common.GetTimestamp(),
+		Progress:   "0%",
+		Data:       responseBody,
+	}
+	return task.Insert()
+}
+
+func getFileRequestURL(channelType int, baseURL string, requestURL string, apiVersion string) string {
+	if baseURL == "" {
+		baseURL = common.ChannelBaseURLs[channelType]
+	}
+	if channelType == common.ChannelTypeAzure {
+		requestURL = fmt.Sprintf("/openai%s?api-version=%s", strings.TrimPrefix(requestURL, "/v1"), apiVersion)
+	}
+	return relaycommon.GetFullRequestURL(baseURL, requestURL, channelType)
+}
+
+func setFileRequestHeader(req *http.Request, channelType int, apiKey string, organization string) {
+	if channelType == common.ChannelTypeAzure {
+		req.Header.Set("api-key", apiKey)
+		return
+	}
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+	if organization != "" {
+		req.Header.Set("OpenAI-Organization", organization)
+	}
+}
+
+func doChannelFileRequest(channel *model.Channel, requestURL string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, getFileRequestURL(channel.Type, channel.GetBaseURL(), requestURL, channel.Other), nil)
+	if err != nil {
+		return nil, err
+	}
+	organization := ""
+	if channel.OpenAIOrganization != nil {
+		organization = *channel.OpenAIOrganization
+	}
+	key, _ := channel.SelectKey()
+	setFileRequestHeader(req, channel.Type, key, organization)
+	resp, err := service.GetHttpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(service.RelayErrorHandler(resp).Error.Message)
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+// FetchBatch retrieves a batch from the channel it was created on, the raw
+// body is returned as well so it can be stored as is.
+func FetchBatch(channel *model.Channel, batchId string) (*dto.OpenAIBatch, []byte, error) {
+	responseBody, err := doChannelFileRequest(channel, "/v1/batches/"+batchId)
+	if err != nil {
+		return nil, nil, err
+	}
+	var batch dto.OpenAIBatch
+	err = json.Unmarshal(responseBody, &batch)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &batch, responseBody, nil
+}
+
+func FetchFileContent(channel *model.Channel, fileId string) ([]byte, error) {
+	return doChannelFileRequest(channel, "/v1/files/"+fileId+"/content")
+}
+
+// SettleBatch bills the successful requests of a finished batch, usage is
+// summed per model from the output file and charged with the batch ratio on
+// top of the usual model and group ratios. It returns the quota consumed.
+func SettleBatch(ctx context.Context, channel *model.Channel, task *model.Task, batch *dto.OpenAIBatch) (int, error) {
+	if batch.OutputFileId == "" {
+		return 0, nil
+	}
+	relayObject, exist, err := model.GetRelayObject(batch.Id)
+	if err != nil {
+		return 0, err
+	}
+	if !exist {
+		return 0, fmt.Errorf("batch %s is not recorded", batch.Id)
+	}
+	content, err := FetchFileContent(channel, batch.OutputFileId)
+	if err != nil {
+		return 0, err
+	}
+
+	modelUsages := make(map[string]*dto.Usage)
+	modelNames := make([]string, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(make([]byte, 64*1024), 32<<20)
+	for scanner.Scan() {
+		var line dto.OpenAIBatchOutputLine
+		if json.Unmarshal(scanner.Bytes(), &line) != nil || line.Response == nil || line.Response.StatusCode != http.StatusOK {
+			continue
+		}
+		var body struct {
+			Model string          `json:"model"`
+			Usage json.RawMessage `json:"usage"`
+		}
+		if json.Unmarshal(line.Response.Body, &body) != nil || body.Model == "" || len(body.Usage) == 0 {
+			continue
+		}
+		usage := &dto.Usage{}
+		if batch.Endpoint == "/v1/responses" {
+			var responsesUsage dto.ResponsesUsage
+			if json.Unmarshal(body.Usage, &responsesUsage) != nil {
+				continue
+			}
+			usage = responsesUsage.ToUsage()
+		} else if json.Unmarshal(body.Usage, usage) != nil {
+			continue
+		}
+		modelUsage, ok := modelUsages[body.Model]
+		if !ok {
+			modelUsage = &dto.Usage{}
+			modelUsages[body.Model] = modelUsage
+			modelNames = append(modelNames, body.Model)
+		}
+		modelUsage.PromptTokens += usage.PromptTokens
+		modelUsage.CompletionTokens += usage.CompletionTokens
+		modelUsage.PromptTokensDetails.CachedTokens += usage.PromptTokensDetails.CachedTokens
+	}
+	if err = scanner.Err(); err != nil {
+		return 0, err
+	}
+
+	group := relayObject.Group
+	if group == "" {
+		group, _ = model.GetUserGroup(task.UserId, false)
+	}
+	groupRatio := setting.GetGroupRatio(group)
+	tokenName := ""
+	token, err := model.GetTokenById(relayObject.TokenId)
+	if err != nil {
+		common.LogError(ctx, fmt.Sprintf("batch %s token #%d not found: %s", batch.Id, relayObject.TokenId, err.Error()))
+		token = nil
+	} else {
+		tokenName = token.Name
+	}
+
+	totalQuota := 0
+	for _, modelName := range modelNames {
+		usage := modelUsages[modelName]
+		modelRatio := common.GetModelRatio(modelName)
+		completionRatio := common.GetCompletionRatio(modelName)
+		cacheRatio := common.GetCacheRatio(modelName)
+		cachedTokens := usage.PromptTokensDetails.CachedTokens
+		if cachedTokens > usage.PromptTokens {
+			cachedTokens = 0
+		}
+		tokens := float64(usage.PromptTokens-cachedTokens) + float64(cachedTokens)*cacheRatio + float64(usage.CompletionTokens)*completionRatio
+		quota := int(math.Round(tokens * modelRatio * groupRatio * setting.BatchRatio))
+		if modelRatio != 0 && quota <= 0 {
+			quota = 1
+		}
+		if quota == 0 {
+			continue
+		}
+		totalQuota += quota
+
+		userQuota, _ := model.GetUserQuota(task.UserId, false)
+		ref := model.QuotaRef{Source: model.LedgerSourceRelay, Reference: batch.Id}
+		err = model.DecreaseUserQuota(task.UserId, quota, ref)
+		if err != nil {
+			return totalQuota, err
+		}
+		if token != nil && !token.UnlimitedQuota {
+			err = model.DecreaseTokenQuota(token.UserId, token.Id, token.Key, quota, ref)
+			if err != nil {
+				common.LogError(ctx, "error decreasing token quota: "+err.Error())
+			}
+		}
+		model.UpdateUserUsedQuotaAndRequestCount(task.UserId, quota)
+		model.UpdateChannelUsedQuota(task.ChannelId, quota)
+
+		other := map[string]interface{}{
+			"batch_id":         batch.Id,
+			"batch_ratio":      setting.BatchRatio,
+			"model_ratio":      modelRatio,
+			"group_ratio":      groupRatio,
+			"completion_ratio": completionRatio,
+		}
+		if cachedTokens > 0 {
+			other["cache_tokens"] = cachedTokens
+			other["cache_ratio"] = cacheRatio
+		}
+		logContent := fmt.Sprintf("批量任务 %s，模型倍率 %.2f，补全倍率 %.2f，分组倍率 %.2f，批量倍率 %.2f", batch.Id, modelRatio, completionRatio, groupRatio, setting.BatchRatio)
+		model.RecordConsumeLog(ctx, task.UserId, task.ChannelId, usage.PromptTokens, usage.CompletionTokens, modelName,
+			tokenName, quota, 0, logContent, relayObject.TokenId, userQuota, 0, false, group, other)
+	}
+	return totalQuota, nil
+}
@@ -0,0 +1,88 @@
+package relay
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"one-api/dto"
+	"one-api/relay/channel"
+	"one-api/relay/channel/gemini"
+	relaycommon "one-api/relay/common"
+	relayconstant "one-api/relay/constant"
+	"one-api/service"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+func getAndValidateGeminiRequest(c *gin.Context) (*gemini.GeminiNativeRequest, error) {
+	geminiRequest := &gemini.GeminiNativeRequest{}
+	err := common.UnmarshalBodyReusable(c, geminiRequest)
+	if err != nil {
+		return nil, err
+	}
+	if len(geminiRequest.Contents) == 0 {
+		return nil, errors.New("field contents is required")
+	}
+	return geminiRequest, nil
+}
+
+// isGeminiNativeChannel reports whether the selected channel can take a
+// generateContent request as is.
+func isGeminiNativeChannel(info *relaycommon.RelayInfo) bool {
+	switch info.ApiType {
+	case relayconstant.APITypeGemini:
+		return true
+	case relayconstant.APITypeVertexAi:
+		return strings.HasPrefix(info.UpstreamModelName, "gemini")
+	}
+	return false
+}
+
+// GeminiHelper relays /v1beta/models/{model}:generateContent and
+// :streamGenerateContent requests. Gemini channels get the request body unchanged,
+// other channels go through the OpenAI conversion and their responses are
+// translated back into Gemini responses.
+func GeminiHelper(c *gin.Context) *dto.OpenAIErrorWithStatusCode {
+	relayInfo := relaycommon.GenRelayInfo(c)
+	relayInfo.RelayFormat = relaycommon.RelayFormatGemini
+	relayInfo.RelayMode = relayconstant.RelayModeChatCompletions
+
+	modelName, action, _ := strings.Cut(c.Param("model"), ":")
+	if action != "generateContent" && action != "streamGenerateContent" {
+		err := fmt.Errorf("unsupported action: %s", action)
+		return service.OpenAIErrorWrapperLocal(err, "invalid_gemini_request", http.StatusNotFound)
+	}
+	geminiRequest, err := getAndValidateGeminiRequest(c)
+	if err != nil {
+		common.LogError(c, fmt.Sprintf("getAndValidateGeminiRequest failed: %s", err.Error()))
+		return service.OpenAIErrorWrapperLocal(err, "invalid_gemini_request", http.StatusBadRequest)
+	}
+	isStream := action == "streamGenerateContent"
+	relayInfo.IsStream = isStream
+	c.Set("stream", isStream)
+
+	return relayConvertedFormat(c, relayInfo, convertedFormat{
+		invalidRequestCode: "invalid_gemini_request",
+		toOpenAI: func() (*dto.GeneralOpenAIRequest, error) {
+			textRequest, err := gemini.RequestGemini2OpenAI(*geminiRequest)
+			if err != nil {
+				return nil, err
+			}
+			textRequest.Model = modelName
+			textRequest.Stream = isStream
+			return textRequest, nil
+		},
+		nativeConverter: func(adaptor channel.Adaptor, info *relaycommon.RelayInfo) (nativeRequestConverter, bool) {
+			geminiAdaptor, ok := adaptor.(channel.GeminiAdaptor)
+			if !ok || !isGeminiNativeChannel(info) {
+				return nil, false
+			}
+			return geminiAdaptor.ConvertGeminiRequest, true
+		},
+		newResponseConverter: func(info *relaycommon.RelayInfo) relaycommon.ResponseConverter {
+			return gemini.NewOpenAI2GeminiConverter(info)
+		},
+	})
+}
@@ -0,0 +1,199 @@
+package relay
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"one-api/common"
+	"one-api/dto"
+	"one-api/relay/channel"
+	"one-api/relay/channel/openai"
+	relaycommon "one-api/relay/common"
+	relayconstant "one-api/relay/constant"
+	"one-api/service"
+	"one-api/setting"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+func getAndValidateResponsesRequest(c *gin.Context) (*dto.OpenAIResponsesRequest, error) {
+	responsesRequest := &dto.OpenAIResponsesRequest{}
+	err := common.UnmarshalBodyReusable(c, responsesRequest)
+	if err != nil {
+		return nil, err
+	}
+	if responsesRequest.Model == "" {
+		return nil, errors.New("model is required")
+	}
+	if len(responsesRequest.Input) == 0 {
+		return nil, errors.New("field input is required")
+	}
+	if responsesRequest.MaxOutputTokens > math.MaxInt32/2 {
+		return nil, errors.New("max_output_tokens is invalid")
+	}
+	return responsesRequest, nil
+}
+
+// isResponsesNativeChannel reports whether the selected channel serves the
+// Responses API itself.
+func isResponsesNativeChannel(info *relaycommon.RelayInfo) bool {
+	return info.ChannelType == common.ChannelTypeOpenAI || info.ChannelType == common.ChannelTypeAzure
+}
+
+// ResponsesHelper relays /v1/responses requests. OpenAI and Azure channels get the
+// request body unchanged, other channels go through the chat completion
+// conversion and their responses are translated back into Responses API events.
+func ResponsesHelper(c *gin.Context) (openaiErr *dto.OpenAIErrorWithStatusCode) {
+	relayInfo := relaycommon.GenRelayInfo(c)
+	relayInfo.RelayFormat = relaycommon.RelayFormatOpenAIResponses
+	relayInfo.RelayMode = relayconstant.RelayModeChatCompletions
+
+	responsesRequest, err := getAndValidateResponsesRequest(c)
+	if err != nil {
+		common.LogError(c, fmt.Sprintf("getAndValidateResponsesRequest failed: %s", err.Error()))
+		return service.OpenAIErrorWrapperLocal(err, "invalid_responses_request", http.StatusBadRequest)
+	}
+	relayInfo.IsStream = responsesRequest.Stream
+	c.Set("stream", responsesRequest.Stream)
+
+	textRequest, err := openai.RequestResponses2OpenAI(*responsesRequest)
+	if err != nil {
+		return service.OpenAIErrorWrapperLocal(err, "invalid_responses_request", http.StatusBadRequest)
+	}
+	c.Set("originalModel", textRequest.Model)
+
+	textRequest.Model, err = getMappedModelName(c, textRequest.Model)
+	if err != nil {
+		return service.OpenAIErrorWrapperLocal(err, "unmarshal_model_mapping_failed", http.StatusInternalServerError)
+	}
+	relayInfo.UpstreamModelName = textRequest.Model
+	modelPrice, getModelPriceSuccess := common.GetModelPrice(textRequest.Model, false)
+	groupRatio := setting.GetGroupRatio(relayInfo.Group)
+
+	if setting.ShouldCheckPromptSensitive() {
+		err = service.CheckSensitiveMessages(textRequest.Messages)
+		if err != nil {
+			return service.OpenAIErrorWrapperLocal(err, "sensitive_words_detected", http.StatusBadRequest)
+		}
+	}
+
+	promptTokens, err := service.CountTokenChatRequest(relayInfo, *textRequest)
+	if err != nil {
+		return service.OpenAIErrorWrapper(err, "count_token_messages_failed", http.StatusInternalServerError)
+	}
+	relayInfo.PromptTokens = promptTokens
+	c.Set("prompt_tokens", promptTokens)
+
+	var preConsumedQuota int
+	var ratio float64
+	var modelRatio float64
+	if !getModelPriceSuccess {
+		preConsumedTokens := promptTokens + int(textRequest.MaxTokens)
+		modelRatio = common.GetModelRatio(textRequest.Model)
+		ratio = modelRatio * groupRatio
+		preConsumedQuota = int(float64(preConsumedTokens) * ratio)
+	} else {
+		preConsumedQuota = int(modelPrice * common.QuotaPerUnit * groupRatio)
+	}
+
+	preConsumedQuota, userQuota, openaiErr := preConsumeQuota(c, preConsumedQuota, relayInfo)
+	if openaiErr != nil {
+		return openaiErr
+	}
+	defer func() {
+		if openaiErr != nil {
+			returnPreConsumedQuota(c, relayInfo, userQuota, preConsumedQuota)
+		}
+	}()
+
+	adaptor := GetAdaptor(relayInfo.ApiType)
+	if adaptor == nil {
+		return service.OpenAIErrorWrapperLocal(fmt.Errorf("invalid api type: %d", relayInfo.ApiType), "invalid_api_type", http.StatusBadRequest)
+	}
+	responsesAdaptor, isNative := adaptor.(channel.ResponsesAdaptor)
+	isNative = isNative && isResponsesNativeChannel(relayInfo)
+	if isNative {
+		relayInfo.RelayMode = relayconstant.RelayModeResponses
+	} else if responsesRequest.PreviousResponseId != "" {
+		err = errors.New("previous_response_id is only supported by openai channels")
+		return service.OpenAIErrorWrapperLocal(err, "invalid_responses_request", http.StatusBadRequest)
+	}
+	adaptor.Init(relayInfo)
+
+	var requestBody io.Reader
+	var convertWriter *relaycommon.FormatConvertWriter
+	if isNative {
+		body, err := common.GetRequestBody(c)
+		if err != nil {
+			return service.OpenAIErrorWrapperLocal(err, "get_request_body_failed", http.StatusInternalServerError)
+		}
+		convertedBody, err := responsesAdaptor.ConvertResponsesRequest(c, relayInfo, body)
+		if err != nil {
+			return service.OpenAIErrorWrapperLocal(err, "convert_request_failed", http.StatusInternalServerError)
+		}
+		requestBody = bytes.NewBuffer(convertedBody)
+	} else {
+		// the adaptor sees a regular chat completion request
+		relayInfo.RequestURLPath = "/v1/chat/completions"
+		if textRequest.Stream {
+			relayInfo.ShouldIncludeUsage = true
+			if relayInfo.SupportStreamOptions {
+				textRequest.StreamOptions = &dto.StreamOptions{
+					IncludeUsage: true,
+				}
+			}
+		}
+		convertedRequest, err := adaptor.ConvertRequest(c, relayInfo, textRequest)
+		if err != nil {
+			return service.OpenAIErrorWrapperLocal(err, "convert_request_failed", http.StatusInternalServerError)
+		}
+		jsonData, err := json.Marshal(convertedRequest)
+		if err != nil {
+			return service.OpenAIErrorWrapperLocal(err, "json_marshal_failed", http.StatusInternalServerError)
+		}
+		requestBody = bytes.NewBuffer(jsonData)
+		convertWriter = relaycommon.NewFormatConvertWriter(c.Writer, openai.NewOpenAI2ResponsesConverter(relayInfo))
+	}
+
+	statusCodeMappingStr := c.GetString("status_code_mapping")
+	var httpResp *http.Response
+	resp, err := adaptor.DoRequest(c, relayInfo, requestBody)
+	if err != nil {
+		return service.OpenAIErrorWrapper(err, "do_request_failed", http.StatusInternalServerError)
+	}
+
+	if resp != nil {
+		httpResp = resp.(*http.Response)
+		relayInfo.IsStream = relayInfo.IsStream || strings.HasPrefix(httpResp.Header.Get("Content-Type"), "text/event-stream")
+		if httpResp.StatusCode != http.StatusOK {
+			openaiErr = service.RelayErrorHandler(httpResp)
+			// reset status code 重置状态码
+			service.ResetStatusCode(openaiErr, statusCodeMappingStr)
+			return openaiErr
+		}
+	}
+
+	if convertWriter != nil {
+		c.Writer = convertWriter
+	}
+	usage, openaiErr := adaptor.DoResponse(c, httpResp, relayInfo)
+	if convertWriter != nil {
+		c.Writer = convertWriter.ResponseWriter
+		if openaiErr == nil {
+			convertWriter.Finish()
+		}
+	}
+	if openaiErr != nil {
+		// reset status code 重置状态码
+		service.ResetStatusCode(openaiErr, statusCodeMappingStr)
+		return openaiErr
+	}
+
+	go postConsumeQuota(c, relayInfo, textRequest.Model, usage.(*dto.Usage), ratio, preConsumedQuota, userQuota, modelRatio, groupRatio, modelPrice, getModelPriceSuccess, "")
+	return nil
+}
@@ -51,7 +51,7 @@ func RelayTaskSubmit(c *gin.Context, relayMode int) (taskErr *dto.TaskError) {
 	// 预扣
 	groupRatio := setting.GetGroupRatio(relayInfo.Group)
 	ratio := modelPrice * groupRatio
-	userQuota, err := model.GetUserQuota(relayInfo.UserId, false)
+	userQuota, err := model.GetUserQuotaWithPlan(relayInfo.UserId, relayInfo.Group, relayInfo.OriginModelName)
 	if err != nil {
 		taskErr = service.TaskErrorWrapper(err, "get_user_quota_failed", http.StatusInternalServerError)
 		return
@@ -83,10 +83,13 @@ func RelayTaskSubmit(c *gin.Context, relayMode int) (taskErr *dto.TaskError) {
 			}
 			c.Set("base_url", channel.GetBaseURL())
 			c.Set("channel_id", originTask.ChannelId)
-			c.Request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", channel.Key))
+			key, keyHash := channel.SelectKey()
+			c.Request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", key))
 
 			relayInfo.BaseUrl = channel.GetBaseURL()
 			relayInfo.ChannelId = originTask.ChannelId
+			relayInfo.ChannelKeyHash = keyHash
+			relayInfo.ApiKey = key
 		}
 	}
 
@@ -109,14 +112,22 @@ func RelayTaskSubmit(c *gin.Context, relayMode int) (taskErr *dto.TaskError) {
 		return
 	}
 
+	var task *model.Task
 	defer func(ctx context.Context) {
 		// release quota
 		if relayInfo.ConsumeQuota && taskErr == nil {
 
-			err := model.PostConsumeQuota(relayInfo.ToRelayInfo(), userQuota, quota, 0, true)
+			consumeInfo := relayInfo.ToRelayInfo()
+			err := model.PostConsumeQuota(consumeInfo, userQuota, quota, 0, true)
 			if err != nil {
 				common.SysError("error consuming token remain quota: " + err.Error())
 			}
+			if consumeInfo.PlanQuota > 0 && task != nil && task.ID != 0 {
+				err = task.UpdatePlanQuota(consumeInfo.PlanQuota)
+				if err != nil {
+					common.SysError("error update task plan quota: " + err.Error())
+				}
+			}
 			if quota != 0 {
 				tokenName := c.GetString("token_name")
 				logContent := fmt.Sprintf("模型固定价格 %.2f，分组倍率 %.2f，操作 %s", modelPrice, groupRatio, relayInfo.Action)
@@ -124,9 +135,10 @@ func RelayTaskSubmit(c *gin.Context, relayMode int) (taskErr *dto.TaskError) {
 				other["model_price"] = modelPrice
 				other["group_ratio"] = groupRatio
 				model.RecordConsumeLog(ctx, relayInfo.UserId, relayInfo.ChannelId, 0, 0,
-					modelName, tokenName, quota, logContent, relayInfo.TokenId, userQuota, 0, false, relayInfo.Group, other)
+					modelName, tokenName, quota, consumeInfo.PlanQuota, logContent, relayInfo.TokenId, userQuota, 0, false, relayInfo.Group, other)
 				model.UpdateUserUsedQuotaAndRequestCount(relayInfo.UserId, quota)
 				model.UpdateChannelUsedQuota(relayInfo.ChannelId, quota)
+				model.UpdateChannelKeyUsedQuota(relayInfo.ChannelId, relayInfo.ChannelKeyHash, quota)
 			}
 		}
 	}(c.Request.Context())
@@ -137,7 +149,7 @@ func RelayTaskSubmit(c *gin.Context, relayMode int) (taskErr *dto.TaskError) {
 	}
 	relayInfo.ConsumeQuota = true
 	// insert task
-	task := model.InitTask(constant.TaskPlatformSuno, relayInfo)
+	task = model.InitTask(constant.TaskPlatformSuno, relayInfo)
 	task.TaskID = taskID
 	task.Quota = quota
 	task.Data = taskData
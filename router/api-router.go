@@ -40,6 +40,9 @@ func SetApiRouter(router *gin.Engine) {
 			//userRoute.POST("/tokenlog", middleware.CriticalRateLimit(), controller.TokenLog)
 			userRoute.GET("/logout", controller.Logout)
 			userRoute.GET("/epay/notify", controller.EpayNotify)
+			userRoute.POST("/stripe/webhook", controller.StripeWebhook)
+			userRoute.GET("/payment/:provider/notify", controller.PaymentNotify)
+			userRoute.POST("/payment/:provider/notify", controller.PaymentNotify)
 			userRoute.GET("/groups", controller.GetUserGroups)
 
 			selfRoute := userRoute.Group("/")
@@ -54,6 +57,8 @@ func SetApiRouter(router *gin.Engine) {
 				selfRoute.GET("/aff", controller.GetAffCode)
 				selfRoute.POST("/topup", controller.TopUp)
 				selfRoute.POST("/pay", controller.RequestEpay)
+				selfRoute.POST("/stripe/pay", controller.RequestStripePay)
+				selfRoute.POST("/payment/:provider/pay", controller.RequestPayment)
 				selfRoute.POST("/amount", controller.RequestAmount)
 				selfRoute.POST("/aff_transfer", controller.TransferAffQuota)
 			}
@@ -70,6 +75,48 @@ func SetApiRouter(router *gin.Engine) {
 				adminRoute.DELETE("/:id", controller.DeleteUser)
 			}
 		}
+		topUpRoute := apiRouter.Group("/topup")
+		topUpRoute.Use(middleware.AdminAuth())
+		{
+			topUpRoute.GET("/", controller.GetAllTopUps)
+			topUpRoute.GET("/:id", controller.GetTopUp)
+			topUpRoute.POST("/:id/reconcile", controller.ReconcileTopUp)
+			topUpRoute.POST("/:id/refund", controller.RefundTopUp)
+		}
+		ledgerRoute := apiRouter.Group("/ledger")
+		ledgerRoute.Use(middleware.AdminAuth())
+		{
+			ledgerRoute.GET("/", controller.GetQuotaLedgers)
+			ledgerRoute.GET("/discrepancy", controller.GetQuotaDiscrepancies)
+			ledgerRoute.POST("/reconcile", controller.ReconcileQuotaLedger)
+		}
+		statementRoute := apiRouter.Group("/statement")
+		statementRoute.GET("/self", middleware.UserAuth(), controller.GetSelfStatement)
+		statementRoute.Use(middleware.AdminAuth())
+		{
+			statementRoute.GET("/export", controller.ExportStatements)
+			statementRoute.GET("/:id", controller.GetUserStatement)
+		}
+		planRoute := apiRouter.Group("/plan")
+		planRoute.GET("/available", middleware.UserAuth(), controller.GetAvailablePlans)
+		planRoute.Use(middleware.AdminAuth())
+		{
+			planRoute.GET("/", controller.GetAllPlans)
+			planRoute.GET("/:id", controller.GetPlan)
+			planRoute.POST("/", controller.AddPlan)
+			planRoute.PUT("/", controller.UpdatePlan)
+			planRoute.DELETE("/:id", controller.DeletePlan)
+		}
+		subscriptionRoute := apiRouter.Group("/subscription")
+		subscriptionRoute.GET("/self", middleware.UserAuth(), controller.GetSelfSubscription)
+		subscriptionRoute.POST("/self/pay", middleware.UserAuth(), controller.RequestPlanPay)
+		subscriptionRoute.POST("/self/cancel", middleware.UserAuth(), controller.CancelSelfSubscription)
+		subscriptionRoute.Use(middleware.AdminAuth())
+		{
+			subscriptionRoute.GET("/", controller.GetAllSubscriptions)
+			subscriptionRoute.POST("/", controller.AddSubscription)
+			subscriptionRoute.POST("/:id/cancel", controller.CancelSubscription)
+		}
 		optionRoute := apiRouter.Group("/option")
 		optionRoute.Use(middleware.RootAuth())
 		{
@@ -83,7 +130,10 @@ func SetApiRouter(router *gin.Engine) {
 			channelRoute.GET("/", controller.GetAllChannels)
 			channelRoute.GET("/search", controller.SearchChannels)
 			channelRoute.GET("/models", controller.ChannelListModels)
+			channelRoute.GET("/health", controller.GetChannelHealths)
 			channelRoute.GET("/:id", controller.GetChannel)
+			channelRoute.GET("/:id/keys", controller.GetChannelKeys)
+			channelRoute.POST("/:id/keys/status", controller.UpdateChannelKeyStatus)
 			channelRoute.GET("/test", controller.TestAllChannels)
 			channelRoute.GET("/test/:id", controller.TestChannel)
 			channelRoute.GET("/update_balance", controller.UpdateAllChannelsBalance)
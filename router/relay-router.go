@@ -22,34 +22,51 @@ func SetRelayRouter(router *gin.Engine) {
 	{
 		playgroundRouter.POST("/chat/completions", controller.Playground)
 	}
+	relayGeminiRouter := router.Group("/v1beta")
+	relayGeminiRouter.Use(middleware.TokenAuth())
+	relayGeminiRouter.Use(middleware.Distribute())
+	relayGeminiRouter.Use(middleware.ModelRequestRateLimit())
+	{
+		// /v1beta/models/{model}:generateContent and :streamGenerateContent
+		relayGeminiRouter.POST("/models/:model", controller.Relay)
+	}
 	relayV1Router := router.Group("/v1")
 	relayV1Router.Use(middleware.TokenAuth())
 	{
 		// WebSocket 路由
 		wsRouter := relayV1Router.Group("")
-		wsRouter.Use(middleware.Distribute())
+		wsRouter.Use(middleware.Distribute(), middleware.ModelRequestRateLimit())
 		wsRouter.GET("/realtime", controller.WssRelay)
 	}
+	{
+		// listed from the recorded objects, no channel is involved
+		relayV1Router.GET("/files", controller.ListFiles)
+		relayV1Router.GET("/batches", controller.ListBatches)
+	}
 	{
 		//http router
 		httpRouter := relayV1Router.Group("")
-		httpRouter.Use(middleware.Distribute())
+		httpRouter.Use(middleware.Distribute(), middleware.ModelRequestRateLimit())
 		httpRouter.POST("/completions", controller.Relay)
 		httpRouter.POST("/chat/completions", controller.Relay)
+		httpRouter.POST("/messages", controller.Relay)
+		httpRouter.POST("/responses", controller.Relay)
 		httpRouter.POST("/edits", controller.Relay)
 		httpRouter.POST("/images/generations", controller.Relay)
-		httpRouter.POST("/images/edits", controller.RelayNotImplemented)
-		httpRouter.POST("/images/variations", controller.RelayNotImplemented)
+		httpRouter.POST("/images/edits", controller.Relay)
+		httpRouter.POST("/images/variations", controller.Relay)
 		httpRouter.POST("/embeddings", controller.Relay)
 		httpRouter.POST("/engines/:model/embeddings", controller.Relay)
 		httpRouter.POST("/audio/transcriptions", controller.Relay)
 		httpRouter.POST("/audio/translations", controller.Relay)
 		httpRouter.POST("/audio/speech", controller.Relay)
-		httpRouter.GET("/files", controller.RelayNotImplemented)
-		httpRouter.POST("/files", controller.RelayNotImplemented)
-		httpRouter.DELETE("/files/:id", controller.RelayNotImplemented)
-		httpRouter.GET("/files/:id", controller.RelayNotImplemented)
-		httpRouter.GET("/files/:id/content", controller.RelayNotImplemented)
+		httpRouter.POST("/files", controller.RelayFile)
+		httpRouter.DELETE("/files/:id", controller.RelayFile)
+		httpRouter.GET("/files/:id", controller.RelayFile)
+		httpRouter.GET("/files/:id/content", controller.RelayFile)
+		httpRouter.POST("/batches", controller.RelayFile)
+		httpRouter.GET("/batches/:id", controller.RelayFile)
+		httpRouter.POST("/batches/:id/cancel", controller.RelayFile)
 		httpRouter.POST("/fine-tunes", controller.RelayNotImplemented)
 		httpRouter.GET("/fine-tunes", controller.RelayNotImplemented)
 		httpRouter.GET("/fine-tunes/:id", controller.RelayNotImplemented)
@@ -60,6 +77,9 @@ func SetRelayRouter(router *gin.Engine) {
 		httpRouter.POST("/rerank", controller.Relay)
 	}
 
+	// generated media kept by the storage backend, paths are unguessable
+	router.GET("/storage/*path", controller.GetStoredFile)
+
 	relayMjRouter := router.Group("/mj")
 	registerMjRouterGroup(relayMjRouter)
 
@@ -17,6 +17,22 @@ func DisableChannel(channelId int, channelName string, reason string) {
 	notifyRootUser(subject, content)
 }
 
+// DisableChannelKey disables one key of a multi key channel, the channel
+// itself is disabled once no key is left.
+func DisableChannelKey(channel *model.Channel, keyHash string, reason string) {
+	enabledCount, err := model.UpdateChannelKeyStatus(channel, keyHash, common.ChannelStatusAutoDisabled, reason)
+	if err != nil {
+		common.SysError(fmt.Sprintf("failed to disable key of channel #%d: %s", channel.Id, err.Error()))
+		return
+	}
+	subject := fmt.Sprintf("通道「%s」（#%d）的密钥 %s 已被禁用", channel.Name, channel.Id, keyHash[:8])
+	content := fmt.Sprintf("通道「%s」（#%d）的密钥 %s 已被禁用，原因：%s，剩余可用密钥 %d 个", channel.Name, channel.Id, keyHash[:8], reason, enabledCount)
+	notifyRootUser(subject, content)
+	if enabledCount == 0 {
+		DisableChannel(channel.Id, channel.Name, reason)
+	}
+}
+
 func EnableChannel(channelId int, channelName string) {
 	model.UpdateChannelStatusById(channelId, common.ChannelStatusEnabled, "")
 	subject := fmt.Sprintf("通道「%s」（#%d）已被启用", channelName, channelId)
@@ -24,6 +40,23 @@ func EnableChannel(channelId int, channelName string) {
 	notifyRootUser(subject, content)
 }
 
+// IsChannelFailure reports whether a relay error counts against the health
+// of the channel, errors caused by the request itself do not.
+func IsChannelFailure(err *relaymodel.OpenAIErrorWithStatusCode) bool {
+	if err == nil || err.LocalError {
+		return false
+	}
+	if err.Error.Code == "channel_concurrency_limit" {
+		// our own limit, the upstream was never asked
+		return false
+	}
+	switch err.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return true
+	}
+	return err.StatusCode >= 500
+}
+
 func ShouldDisableChannel(channelType int, err *relaymodel.OpenAIErrorWithStatusCode) bool {
 	if !common.AutomaticDisableChannelEnabled {
 		return false
@@ -0,0 +1,117 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"one-api/constant"
+	"one-api/dto"
+	"one-api/model"
+	"sync"
+	"time"
+)
+
+const channelQueuePollInterval = 100 * time.Millisecond
+
+// channelQueues counts the requests waiting for a slot on this node.
+var channelQueues = make(map[int]int)
+var channelQueuesLock sync.Mutex
+
+func joinChannelQueue(channelId int, queueSize int) bool {
+	channelQueuesLock.Lock()
+	defer channelQueuesLock.Unlock()
+	if channelQueues[channelId] >= queueSize {
+		return false
+	}
+	channelQueues[channelId]++
+	return true
+}
+
+func leaveChannelQueue(channelId int) {
+	channelQueuesLock.Lock()
+	defer channelQueuesLock.Unlock()
+	channelQueues[channelId]--
+	if channelQueues[channelId] <= 0 {
+		delete(channelQueues, channelId)
+	}
+}
+
+// AcquireChannelSlot enforces the max_concurrency setting of the selected
+// channel. When every slot is taken the request waits in the channel queue
+// for up to queue_timeout seconds. The request also counts as outstanding on
+// the channel until the returned release func is called.
+func AcquireChannelSlot(c *gin.Context, channelId int) (func(), *dto.OpenAIErrorWithStatusCode) {
+	setting := c.GetStringMap("channel_setting")
+	maxConcurrency := model.GetSettingInt(setting, constant.MaxConcurrency)
+	if maxConcurrency <= 0 {
+		model.AddChannelOutstanding(channelId, 1)
+		return func() {
+			model.AddChannelOutstanding(channelId, -1)
+		}, nil
+	}
+	slot, ok := model.TryAcquireChannelSlot(channelId, maxConcurrency)
+	if !ok {
+		queueSize := model.GetSettingInt(setting, constant.QueueSize)
+		queueTimeout := model.GetSettingInt(setting, constant.QueueTimeout)
+		if queueSize > 0 && queueTimeout > 0 && joinChannelQueue(channelId, queueSize) {
+			slot, ok = waitChannelSlot(c, channelId, maxConcurrency, time.Duration(queueTimeout)*time.Second)
+			leaveChannelQueue(channelId)
+		}
+	}
+	if !ok {
+		// not a local error so the request is retried on another channel
+		err := errors.New(fmt.Sprintf("渠道 #%d 并发请求数已达上限 %d", channelId, maxConcurrency))
+		return nil, OpenAIErrorWrapper(err, "channel_concurrency_limit", http.StatusTooManyRequests)
+	}
+	model.AddChannelOutstanding(channelId, 1)
+	stopRenew := renewChannelSlot(channelId, slot)
+	return func() {
+		stopRenew()
+		model.AddChannelOutstanding(channelId, -1)
+		model.ReleaseChannelSlot(channelId, slot)
+	}, nil
+}
+
+// renewChannelSlot keeps the lease of a redis slot alive until the returned
+// func is called, a long stream would otherwise lose its slot half way.
+func renewChannelSlot(channelId int, slot string) func() {
+	if slot == "" {
+		return func() {}
+	}
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(model.ChannelSlotRenewInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				model.RenewChannelSlot(channelId, slot)
+			}
+		}
+	}()
+	return func() {
+		close(done)
+	}
+}
+
+func waitChannelSlot(c *gin.Context, channelId int, maxConcurrency int, timeout time.Duration) (string, bool) {
+	ticker := time.NewTicker(channelQueuePollInterval)
+	defer ticker.Stop()
+	deadline := time.After(timeout)
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return "", false
+		case <-deadline:
+			return "", false
+		case <-ticker.C:
+			slot, ok := model.TryAcquireChannelSlot(channelId, maxConcurrency)
+			if ok {
+				return slot, true
+			}
+		}
+	}
+}
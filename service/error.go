@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"one-api/common"
 	"one-api/dto"
+	"one-api/setting"
 	"strconv"
 	"strings"
 )
@@ -127,3 +128,26 @@ func TaskErrorWrapper(err error, code string, statusCode int) *dto.TaskError {
 
 	return taskError
 }
+
+// GetFallbackErrorClass sorts a relay error into the classes a model fallback
+// chain can be limited to, it returns "" when the error fits none.
+func GetFallbackErrorClass(err *dto.OpenAIErrorWithStatusCode) string {
+	code := fmt.Sprintf("%v", err.Error.Code)
+	message := strings.ToLower(err.Error.Message)
+	switch {
+	case code == "get_channel_failed":
+		return setting.FallbackErrorUnavailable
+	case code == "content_filter":
+		return setting.FallbackErrorContentFilter
+	case code == "context_length_exceeded" || strings.Contains(message, "maximum context length") ||
+		strings.Contains(message, "prompt is too long"):
+		return setting.FallbackErrorContextLength
+	case err.StatusCode == http.StatusTooManyRequests:
+		return setting.FallbackErrorRateLimit
+	case err.StatusCode == http.StatusRequestTimeout || err.StatusCode == http.StatusGatewayTimeout || err.StatusCode == 524:
+		return setting.FallbackErrorTimeout
+	case err.StatusCode >= 500:
+		return setting.FallbackErrorServerError
+	}
+	return ""
+}
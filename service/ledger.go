@@ -0,0 +1,39 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"one-api/common"
+	"one-api/model"
+	"sync"
+	"time"
+)
+
+var quotaReconcileLock sync.Mutex
+
+// ReconcileQuotaLedger runs one reconciliation of the quota ledger, it fails
+// instead of waiting when another one is still running.
+func ReconcileQuotaLedger() (*model.QuotaReconcileResult, error) {
+	if !quotaReconcileLock.TryLock() {
+		return nil, errors.New("对账正在进行中")
+	}
+	defer quotaReconcileLock.Unlock()
+	start := time.Now()
+	result, err := model.ReconcileQuotaLedger()
+	if err != nil {
+		return nil, err
+	}
+	common.SysLog(fmt.Sprintf("quota ledger reconciled in %s: %d accounts checked, %d opened, %d discrepancies",
+		time.Since(start).Round(time.Millisecond), result.Checked, result.Opened, result.Discrepancies))
+	return result, nil
+}
+
+func ReconcileQuotaLedgers(frequency int) {
+	for {
+		_, err := ReconcileQuotaLedger()
+		if err != nil {
+			common.SysError("failed to reconcile quota ledger: " + err.Error())
+		}
+		time.Sleep(time.Duration(frequency) * time.Second)
+	}
+}
@@ -0,0 +1,328 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"math"
+	"one-api/common"
+	"one-api/model"
+	"one-api/setting"
+	"sync"
+	"time"
+)
+
+const (
+	PaymentEventPaid     = "paid"
+	PaymentEventRefunded = "refunded"
+	PaymentEventIgnored  = "ignored"
+)
+
+const (
+	PaymentStatusPending = "pending"
+	PaymentStatusPaid    = "paid"
+	PaymentStatusClosed  = "closed"
+)
+
+// sources recorded in the order status log
+const (
+	PaymentSourceNotify    = "notify"
+	PaymentSourceReconcile = "reconcile"
+	PaymentSourceAdmin     = "admin"
+)
+
+type PaymentOrderArgs struct {
+	// Method is the payment method inside the provider, e.g. alipay for Epay.
+	Method    string
+	Name      string
+	NotifyUrl string
+	ReturnUrl string
+}
+
+type PaymentOrder struct {
+	Url    string
+	Params map[string]string
+}
+
+// PaymentNotify is a verified callback, RefundedMoney is the total refunded
+// so far rather than the amount of this refund.
+type PaymentNotify struct {
+	Event         string
+	TradeNo       string
+	PaymentId     string
+	Money         float64
+	Currency      string
+	RefundedMoney float64
+}
+
+type PaymentQuery struct {
+	Status        string
+	PaymentId     string
+	Money         float64
+	Currency      string
+	RefundedMoney float64
+}
+
+// PaymentProvider is a payment gateway, orders are model.TopUp rows whose
+// PaymentMethod is the provider name.
+type PaymentProvider interface {
+	Name() string
+	Enabled() bool
+	// Price is what one unit of quota costs before the group ratio.
+	Price() float64
+	// CreateOrder starts a payment and may fill Currency and PaymentId of the
+	// order, which is inserted afterwards.
+	CreateOrder(topUp *model.TopUp, args *PaymentOrderArgs) (*PaymentOrder, error)
+	VerifyNotify(c *gin.Context) (*PaymentNotify, error)
+	// NotifyResponse answers the gateway, err is nil when the callback was
+	// handled.
+	NotifyResponse(c *gin.Context, err error)
+	QueryOrder(topUp *model.TopUp) (*PaymentQuery, error)
+	// Refund returns the money refunded by this call.
+	Refund(topUp *model.TopUp, money float64) (float64, error)
+}
+
+var paymentProviders = map[string]PaymentProvider{}
+
+func RegisterPaymentProvider(provider PaymentProvider) {
+	paymentProviders[provider.Name()] = provider
+}
+
+func init() {
+	RegisterPaymentProvider(&EpayProvider{})
+	RegisterPaymentProvider(&StripeProvider{})
+}
+
+func GetPaymentProvider(name string) PaymentProvider {
+	if name == "" {
+		name = model.PaymentMethodEpay
+	}
+	return paymentProviders[name]
+}
+
+func GetEnabledPaymentProviders() []string {
+	names := make([]string, 0, len(paymentProviders))
+	for name, provider := range paymentProviders {
+		if provider.Enabled() {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// tradeNo lock
+var orderLocks sync.Map
+var createLock sync.Mutex
+
+// LockOrder 尝试对给定订单号加锁
+func LockOrder(tradeNo string) {
+	lock, ok := orderLocks.Load(tradeNo)
+	if !ok {
+		createLock.Lock()
+		defer createLock.Unlock()
+		lock, ok = orderLocks.Load(tradeNo)
+		if !ok {
+			lock = new(sync.Mutex)
+			orderLocks.Store(tradeNo, lock)
+		}
+	}
+	lock.(*sync.Mutex).Lock()
+}
+
+// UnlockOrder 释放给定订单号的锁
+func UnlockOrder(tradeNo string) {
+	lock, ok := orderLocks.Load(tradeNo)
+	if ok {
+		lock.(*sync.Mutex).Unlock()
+	}
+}
+
+// HandlePaymentNotify settles the order a verified callback refers to.
+func HandlePaymentNotify(ctx context.Context, provider PaymentProvider, notify *PaymentNotify) error {
+	tradeNo := notify.TradeNo
+	if tradeNo == "" && notify.PaymentId != "" {
+		topUp := model.GetTopUpByPaymentId(notify.PaymentId)
+		if topUp != nil {
+			tradeNo = topUp.TradeNo
+		}
+	}
+	switch notify.Event {
+	case PaymentEventPaid:
+		return CompleteTopUp(ctx, provider, tradeNo, notify.PaymentId, notify.Money, notify.Currency, PaymentSourceNotify)
+	case PaymentEventRefunded:
+		return ApplyTopUpRefund(ctx, tradeNo, notify.RefundedMoney, PaymentSourceNotify)
+	}
+	return nil
+}
+
+// CompleteTopUp credits the order once, replayed callbacks find it already
+// settled and return without touching the quota. A zero money skips the
+// amount check for gateways that do not report it.
+func CompleteTopUp(ctx context.Context, provider PaymentProvider, tradeNo string, paymentId string, money float64, currency string, source string) error {
+	LockOrder(tradeNo)
+	defer UnlockOrder(tradeNo)
+	topUp := model.GetTopUpByTradeNo(tradeNo)
+	if topUp == nil {
+		common.LogError(ctx, fmt.Sprintf("支付回调未找到订单: %s", tradeNo))
+		return nil
+	}
+	if GetPaymentProvider(topUp.PaymentMethod) != provider {
+		common.LogError(ctx, fmt.Sprintf("支付回调渠道不符: %s %s", tradeNo, provider.Name()))
+		return nil
+	}
+	if !model.CanTransitTopUp(topUp.Status, model.TopUpStatusSuccess) {
+		return nil
+	}
+	if (money > 0 && math.Abs(money-topUp.Money) >= 0.01) || (currency != "" && currency != topUp.Currency) {
+		common.LogError(ctx, fmt.Sprintf("支付回调金额不符: %s %.2f %s", tradeNo, money, currency))
+		return nil
+	}
+	quota := 0
+	if topUp.PlanId == 0 {
+		quota = topUp.Amount * int(common.QuotaPerUnit)
+	}
+	subscription, err := topUp.Complete(paymentId, quota, source)
+	if errors.Is(err, model.ErrTopUpChanged) {
+		// settled by a concurrent callback
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("complete top up %s: %w", tradeNo, err)
+	}
+	if subscription != nil {
+		recordPlanSubscription(ctx, subscription, topUp.PlanPeriods, fmt.Sprintf("支付金额：%.2f %s", topUp.Money, topUp.Currency))
+		return nil
+	}
+	common.LogInfo(ctx, fmt.Sprintf("支付回调更新用户成功 %v", topUp))
+	model.RecordLog(topUp.UserId, model.LogTypeTopup, fmt.Sprintf("使用在线充值成功，充值金额: %v，支付金额：%.2f %s", common.LogQuota(quota), topUp.Money, topUp.Currency))
+	return nil
+}
+
+// ApplyTopUpRefund brings the order to refundedMoney in total and deducts the
+// matching share of the granted quota, only the difference to what was
+// already refunded is deducted so repeated callbacks are harmless.
+func ApplyTopUpRefund(ctx context.Context, tradeNo string, refundedMoney float64, source string) error {
+	LockOrder(tradeNo)
+	defer UnlockOrder(tradeNo)
+	topUp := model.GetTopUpByTradeNo(tradeNo)
+	if topUp == nil {
+		return fmt.Errorf("top up %s not found", tradeNo)
+	}
+	if topUp.Status != model.TopUpStatusSuccess || topUp.Money <= 0 {
+		return nil
+	}
+	refundedMoney = math.Min(refundedMoney, topUp.Money)
+	if refundedMoney <= topUp.RefundedMoney {
+		return nil
+	}
+	totalQuota := float64(topUp.Amount) * common.QuotaPerUnit
+	quota := int(math.Round(totalQuota*refundedMoney/topUp.Money)) - int(math.Round(totalQuota*topUp.RefundedMoney/topUp.Money))
+	delta := refundedMoney - topUp.RefundedMoney
+	err := topUp.Refund(refundedMoney, quota, source, fmt.Sprintf("refund %.2f", delta))
+	if errors.Is(err, model.ErrTopUpChanged) {
+		// applied by a concurrent callback
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("refund top up %s: %w", tradeNo, err)
+	}
+	common.LogInfo(ctx, fmt.Sprintf("订单 %s 退款 %.2f %s，扣除额度 %d", tradeNo, delta, topUp.Currency, quota))
+	model.RecordLog(topUp.UserId, model.LogTypeTopup, fmt.Sprintf("在线充值退款，扣除额度: %v，退款金额：%.2f %s", common.LogQuota(quota), delta, topUp.Currency))
+	return nil
+}
+
+// RefundTopUp refunds a paid order through its provider, money 0 refunds
+// what is left of it.
+func RefundTopUp(ctx context.Context, topUp *model.TopUp, money float64) error {
+	if topUp.Status != model.TopUpStatusSuccess {
+		return errors.New("只能对已支付的订单退款")
+	}
+	provider := GetPaymentProvider(topUp.PaymentMethod)
+	if provider == nil || !provider.Enabled() {
+		return errors.New("支付方式未配置")
+	}
+	remaining := topUp.Money - topUp.RefundedMoney
+	if money <= 0 || money > remaining {
+		money = remaining
+	}
+	refunded, err := provider.Refund(topUp, money)
+	if err != nil {
+		return err
+	}
+	return ApplyTopUpRefund(ctx, topUp.TradeNo, topUp.RefundedMoney+refunded, PaymentSourceAdmin)
+}
+
+// ReconcileTopUp asks the provider about the order and settles or expires it,
+// an order past the expiry window is expired even when the query fails since
+// a late payment can still complete it.
+func ReconcileTopUp(ctx context.Context, topUp *model.TopUp, source string) error {
+	provider := GetPaymentProvider(topUp.PaymentMethod)
+	if provider == nil {
+		return fmt.Errorf("unknown payment method %s", topUp.PaymentMethod)
+	}
+	expired := setting.TopUpOrderExpireMinutes > 0 &&
+		time.Since(time.Unix(topUp.CreateTime, 0)) > time.Duration(setting.TopUpOrderExpireMinutes)*time.Minute
+	var query *PaymentQuery
+	var err error
+	if provider.Enabled() {
+		query, err = provider.QueryOrder(topUp)
+	} else {
+		err = errors.New("payment provider is not configured")
+	}
+	if err == nil {
+		switch query.Status {
+		case PaymentStatusPaid:
+			err = CompleteTopUp(ctx, provider, topUp.TradeNo, query.PaymentId, query.Money, query.Currency, source)
+			if err == nil && query.RefundedMoney > 0 {
+				err = ApplyTopUpRefund(ctx, topUp.TradeNo, query.RefundedMoney, source)
+			}
+			return err
+		case PaymentStatusClosed:
+			expired = true
+		}
+	}
+	if !expired || topUp.Status != model.TopUpStatusPending {
+		return err
+	}
+	LockOrder(topUp.TradeNo)
+	defer UnlockOrder(topUp.TradeNo)
+	topUp = model.GetTopUpByTradeNo(topUp.TradeNo)
+	if topUp == nil || topUp.Status != model.TopUpStatusPending {
+		return nil
+	}
+	message := ""
+	if err != nil {
+		message = err.Error()
+	}
+	err = topUp.Transit(model.TopUpStatusExpired, nil, source, message, nil)
+	if errors.Is(err, model.ErrTopUpChanged) {
+		return nil
+	}
+	return err
+}
+
+// ReconcileTopUps periodically settles pending orders whose callback never
+// arrived, orders younger than a minute are left to the callback.
+func ReconcileTopUps(frequency int) {
+	for {
+		time.Sleep(time.Duration(frequency) * time.Second)
+		lastId := 0
+		for {
+			topUps, err := model.GetPendingTopUps(lastId, time.Now().Add(-time.Minute).Unix(), 100)
+			if err != nil || len(topUps) == 0 {
+				break
+			}
+			lastId = topUps[len(topUps)-1].Id
+			for _, topUp := range topUps {
+				err = ReconcileTopUp(context.Background(), topUp, PaymentSourceReconcile)
+				if err != nil {
+					common.SysError(fmt.Sprintf("failed to reconcile top up %s: %s", topUp.TradeNo, err.Error()))
+				}
+			}
+			if len(topUps) < 100 {
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Calcium-Ion/go-epay/epay"
+	"github.com/gin-gonic/gin"
+	"github.com/samber/lo"
+	"io"
+	"net/http"
+	"net/url"
+	"one-api/model"
+	"one-api/setting"
+	"strconv"
+	"strings"
+)
+
+type EpayProvider struct{}
+
+// epayApiResponse is the reply of the api.php query and refund actions.
+type epayApiResponse struct {
+	Code       int    `json:"code"`
+	Msg        string `json:"msg"`
+	TradeNo    string `json:"trade_no"`
+	OutTradeNo string `json:"out_trade_no"`
+	Money      string `json:"money"`
+	Status     int    `json:"status"`
+}
+
+func GetEpayClient() *epay.Client {
+	if setting.PayAddress == "" || setting.EpayId == "" || setting.EpayKey == "" {
+		return nil
+	}
+	withUrl, err := epay.NewClient(&epay.Config{
+		PartnerID: setting.EpayId,
+		Key:       setting.EpayKey,
+	}, setting.PayAddress)
+	if err != nil {
+		return nil
+	}
+	return withUrl
+}
+
+func (p *EpayProvider) Name() string {
+	return model.PaymentMethodEpay
+}
+
+func (p *EpayProvider) Enabled() bool {
+	return setting.PayAddress != "" && setting.EpayId != "" && setting.EpayKey != ""
+}
+
+func (p *EpayProvider) Price() float64 {
+	return setting.Price
+}
+
+func (p *EpayProvider) CreateOrder(topUp *model.TopUp, args *PaymentOrderArgs) (*PaymentOrder, error) {
+	client := GetEpayClient()
+	if client == nil {
+		return nil, errors.New("当前管理员未配置支付信息")
+	}
+	payType := "wxpay"
+	if args.Method == "zfb" || args.Method == "alipay" {
+		payType = "alipay"
+	}
+	notifyUrl, err := url.Parse(args.NotifyUrl)
+	if err != nil {
+		return nil, err
+	}
+	returnUrl, err := url.Parse(args.ReturnUrl)
+	if err != nil {
+		return nil, err
+	}
+	uri, params, err := client.Purchase(&epay.PurchaseArgs{
+		Type:           payType,
+		ServiceTradeNo: topUp.TradeNo,
+		Name:           args.Name,
+		Money:          strconv.FormatFloat(topUp.Money, 'f', 2, 64),
+		Device:         epay.PC,
+		NotifyUrl:      notifyUrl,
+		ReturnUrl:      returnUrl,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &PaymentOrder{Url: uri, Params: params}, nil
+}
+
+func (p *EpayProvider) VerifyNotify(c *gin.Context) (*PaymentNotify, error) {
+	params := lo.Reduce(lo.Keys(c.Request.URL.Query()), func(r map[string]string, t string, i int) map[string]string {
+		r[t] = c.Request.URL.Query().Get(t)
+		return r
+	}, map[string]string{})
+	client := GetEpayClient()
+	if client == nil {
+		return nil, errors.New("易支付回调失败 未找到配置信息")
+	}
+	verifyInfo, err := client.Verify(params)
+	if err != nil || !verifyInfo.VerifyStatus {
+		return nil, errors.New("易支付回调签名验证失败")
+	}
+	if verifyInfo.TradeStatus != epay.StatusTradeSuccess {
+		return &PaymentNotify{Event: PaymentEventIgnored, TradeNo: verifyInfo.ServiceTradeNo}, nil
+	}
+	money, _ := strconv.ParseFloat(verifyInfo.Money, 64)
+	return &PaymentNotify{
+		Event:     PaymentEventPaid,
+		TradeNo:   verifyInfo.ServiceTradeNo,
+		PaymentId: verifyInfo.TradeNo,
+		Money:     money,
+	}, nil
+}
+
+func (p *EpayProvider) NotifyResponse(c *gin.Context, err error) {
+	if err != nil {
+		c.String(http.StatusOK, "fail")
+		return
+	}
+	c.String(http.StatusOK, "success")
+}
+
+func epayApi(action string, params url.Values) (*epayApiResponse, error) {
+	params.Set("act", action)
+	params.Set("pid", setting.EpayId)
+	params.Set("key", setting.EpayKey)
+	apiUrl := strings.TrimRight(setting.PayAddress, "/") + "/api.php"
+	resp, err := GetImpatientHttpClient().PostForm(apiUrl, params)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	var result epayApiResponse
+	err = json.Unmarshal(body, &result)
+	if err != nil {
+		return nil, fmt.Errorf("epay %s: invalid response", action)
+	}
+	if result.Code != 1 {
+		return nil, fmt.Errorf("epay %s: %s", action, result.Msg)
+	}
+	return &result, nil
+}
+
+// QueryOrder uses the api.php order action, Epay has no closed state so an
+// unpaid order stays pending until it expires.
+func (p *EpayProvider) QueryOrder(topUp *model.TopUp) (*PaymentQuery, error) {
+	result, err := epayApi("order", url.Values{"out_trade_no": {topUp.TradeNo}})
+	if err != nil {
+		return nil, err
+	}
+	if result.Status != 1 {
+		return &PaymentQuery{Status: PaymentStatusPending}, nil
+	}
+	money, _ := strconv.ParseFloat(result.Money, 64)
+	return &PaymentQuery{
+		Status:    PaymentStatusPaid,
+		PaymentId: result.TradeNo,
+		Money:     money,
+	}, nil
+}
+
+func (p *EpayProvider) Refund(topUp *model.TopUp, money float64) (float64, error) {
+	_, err := epayApi("refund", url.Values{
+		"out_trade_no": {topUp.TradeNo},
+		"money":        {strconv.FormatFloat(money, 'f', 2, 64)},
+	})
+	if err != nil {
+		return 0, err
+	}
+	return money, nil
+}
@@ -0,0 +1,152 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"io"
+	"net/http"
+	"one-api/dto"
+	"one-api/model"
+	"one-api/setting"
+)
+
+// StripeProvider keeps the checkout session id in PaymentId while the order is
+// pending and replaces it with the payment intent once it is paid.
+type StripeProvider struct{}
+
+func (p *StripeProvider) Name() string {
+	return model.PaymentMethodStripe
+}
+
+func (p *StripeProvider) Enabled() bool {
+	return setting.StripeEnabled()
+}
+
+func (p *StripeProvider) Price() float64 {
+	return setting.StripePrice
+}
+
+func (p *StripeProvider) CreateOrder(topUp *model.TopUp, args *PaymentOrderArgs) (*PaymentOrder, error) {
+	if StripeAmount(topUp.Money, setting.StripeCurrency) < 1 {
+		return nil, errors.New("充值金额过低")
+	}
+	session, err := StripeCreateCheckoutSession(topUp.TradeNo, args.Name, topUp.Money, args.ReturnUrl, args.ReturnUrl)
+	if err != nil {
+		return nil, err
+	}
+	topUp.Currency = setting.StripeCurrency
+	topUp.PaymentId = session.Id
+	return &PaymentOrder{Url: session.Url}, nil
+}
+
+func (p *StripeProvider) VerifyNotify(c *gin.Context) (*PaymentNotify, error) {
+	payload, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		return nil, err
+	}
+	err = VerifyStripeSignature(payload, c.GetHeader("Stripe-Signature"), setting.StripeWebhookSecret)
+	if err != nil {
+		return nil, fmt.Errorf("Stripe 回调签名验证失败: %w", err)
+	}
+	var event dto.StripeEvent
+	err = json.Unmarshal(payload, &event)
+	if err != nil {
+		return nil, err
+	}
+	switch event.Type {
+	case "checkout.session.completed", "checkout.session.async_payment_succeeded":
+		var session dto.StripeCheckoutSession
+		err = json.Unmarshal(event.Data.Object, &session)
+		if err != nil {
+			return nil, err
+		}
+		if session.PaymentStatus != "paid" {
+			break
+		}
+		tradeNo := session.ClientReferenceId
+		if tradeNo == "" {
+			tradeNo = session.Metadata["trade_no"]
+		}
+		return &PaymentNotify{
+			Event:     PaymentEventPaid,
+			TradeNo:   tradeNo,
+			PaymentId: session.PaymentIntent,
+			Money:     StripeMoney(session.AmountTotal, session.Currency),
+			Currency:  session.Currency,
+		}, nil
+	case "charge.refunded":
+		var charge dto.StripeCharge
+		err = json.Unmarshal(event.Data.Object, &charge)
+		if err != nil {
+			return nil, err
+		}
+		return &PaymentNotify{
+			Event:         PaymentEventRefunded,
+			PaymentId:     charge.PaymentIntent,
+			Currency:      charge.Currency,
+			RefundedMoney: StripeMoney(charge.AmountRefunded, charge.Currency),
+		}, nil
+	}
+	return &PaymentNotify{Event: PaymentEventIgnored}, nil
+}
+
+// NotifyResponse answers errors with 500 so that Stripe retries the event.
+func (p *StripeProvider) NotifyResponse(c *gin.Context, err error) {
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"received": true})
+}
+
+func (p *StripeProvider) QueryOrder(topUp *model.TopUp) (*PaymentQuery, error) {
+	if topUp.PaymentId == "" {
+		return nil, errors.New("order has no stripe payment id")
+	}
+	if topUp.Status == model.TopUpStatusPending || topUp.Status == model.TopUpStatusExpired {
+		session, err := StripeGetCheckoutSession(topUp.PaymentId)
+		if err != nil {
+			return nil, err
+		}
+		query := &PaymentQuery{
+			Status:    PaymentStatusPending,
+			PaymentId: session.PaymentIntent,
+			Money:     StripeMoney(session.AmountTotal, session.Currency),
+			Currency:  session.Currency,
+		}
+		if session.PaymentStatus == "paid" {
+			query.Status = PaymentStatusPaid
+		} else if session.Status == "expired" {
+			query.Status = PaymentStatusClosed
+		}
+		return query, nil
+	}
+	paymentIntent, err := StripeGetPaymentIntent(topUp.PaymentId)
+	if err != nil {
+		return nil, err
+	}
+	query := &PaymentQuery{
+		Status:    PaymentStatusPending,
+		PaymentId: paymentIntent.Id,
+		Money:     StripeMoney(paymentIntent.Amount, paymentIntent.Currency),
+		Currency:  paymentIntent.Currency,
+	}
+	if paymentIntent.Status == "succeeded" {
+		query.Status = PaymentStatusPaid
+	}
+	if paymentIntent.LatestCharge != nil {
+		query.RefundedMoney = StripeMoney(paymentIntent.LatestCharge.AmountRefunded, paymentIntent.Currency)
+	}
+	return query, nil
+}
+
+func (p *StripeProvider) Refund(topUp *model.TopUp, money float64) (float64, error) {
+	idempotencyKey := fmt.Sprintf("refund-%s-%d", topUp.TradeNo, StripeAmount(topUp.RefundedMoney+money, topUp.Currency))
+	refund, err := StripeCreateRefund(topUp.PaymentId, money, topUp.Currency, idempotencyKey)
+	if err != nil {
+		return 0, err
+	}
+	return StripeMoney(refund.Amount, refund.Currency), nil
+}
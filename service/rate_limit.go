@@ -0,0 +1,238 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+	"one-api/common"
+	"one-api/setting"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// relay rate limits are counted over a sliding minute
+const rateLimitWindow = time.Minute
+
+var rateLimitWindows common.InMemorySlidingWindow
+
+type RateLimitStatus struct {
+	Limit     int
+	Remaining int
+	Reset     time.Duration
+}
+
+func newRateLimitStatus(limit int, used int64, oldest int64) *RateLimitStatus {
+	status := &RateLimitStatus{
+		Limit:     limit,
+		Remaining: limit - int(used),
+	}
+	if status.Remaining < 0 {
+		status.Remaining = 0
+	}
+	if oldest > 0 {
+		status.Reset = time.Until(time.UnixMilli(oldest).Add(rateLimitWindow))
+		if status.Reset < 0 {
+			status.Reset = 0
+		}
+	}
+	return status
+}
+
+// FormatRateLimitReset formats a reset duration like the x-ratelimit-reset-*
+// headers of openai, e.g. "1s" or "6m0s".
+func FormatRateLimitReset(reset time.Duration) string {
+	if reset < time.Second {
+		return reset.Round(time.Millisecond).String()
+	}
+	return reset.Round(time.Second).String()
+}
+
+func GetTokenRateLimitKey(tokenId int) string {
+	return fmt.Sprintf("relayRateLimit:token:%d", tokenId)
+}
+
+func GetUserRateLimitKey(userId int) string {
+	return fmt.Sprintf("relayRateLimit:user:%d", userId)
+}
+
+func GetPlanRateLimitKey(userId int) string {
+	return fmt.Sprintf("relayRateLimit:plan:%d", userId)
+}
+
+// redisWindowUsage drops the entries that left the window and sums the rest,
+// members are "<nanoseconds>:<value>" scored by their unix milliseconds.
+func redisWindowUsage(ctx context.Context, key string) (int64, int64, error) {
+	rdb := common.RDB
+	start := time.Now().Add(-rateLimitWindow).UnixMilli()
+	err := rdb.ZRemRangeByScore(ctx, key, "-inf", strconv.FormatInt(start, 10)).Err()
+	if err != nil {
+		return 0, 0, err
+	}
+	entries, err := rdb.ZRangeWithScores(ctx, key, 0, -1).Result()
+	if err != nil {
+		return 0, 0, err
+	}
+	var sum int64
+	for _, entry := range entries {
+		member, _ := entry.Member.(string)
+		if i := strings.IndexByte(member, ':'); i >= 0 {
+			value, _ := strconv.ParseInt(member[i+1:], 10, 64)
+			sum += value
+		}
+	}
+	if len(entries) == 0 {
+		return 0, 0, nil
+	}
+	return sum, int64(entries[0].Score), nil
+}
+
+func redisWindowAdd(ctx context.Context, key string, value int64) error {
+	now := time.Now()
+	rdb := common.RDB
+	err := rdb.ZAdd(ctx, key, &redis.Z{
+		Score:  float64(now.UnixMilli()),
+		Member: fmt.Sprintf("%d:%d", now.UnixNano(), value),
+	}).Err()
+	if err != nil {
+		return err
+	}
+	return rdb.Expire(ctx, key, rateLimitWindow).Err()
+}
+
+// requestRateLimitScript trims and sums every window and only adds the
+// request when none of them is at its limit, so a request rejected by one
+// limit is not counted against the others. ARGV holds the window start, the
+// request time and member, the expiry and then the limit of each key.
+var requestRateLimitScript = redis.NewScript(`
+local result = {0}
+local rejected = 0
+for i, key in ipairs(KEYS) do
+	redis.call('ZREMRANGEBYSCORE', key, '-inf', ARGV[1])
+	local entries = redis.call('ZRANGE', key, 0, -1, 'WITHSCORES')
+	local sum = 0
+	local oldest = 0
+	for j = 1, #entries, 2 do
+		local value = string.match(entries[j], ':(%d+)$')
+		if value then
+			sum = sum + tonumber(value)
+		end
+		if j == 1 then
+			oldest = tonumber(entries[j + 1])
+		end
+	end
+	table.insert(result, sum)
+	table.insert(result, oldest)
+	if rejected == 0 and sum >= tonumber(ARGV[4 + i]) then
+		rejected = i
+	end
+end
+if rejected == 0 then
+	for _, key in ipairs(KEYS) do
+		redis.call('ZADD', key, ARGV[2], ARGV[3])
+		redis.call('PEXPIRE', key, ARGV[4])
+	end
+end
+result[1] = rejected
+return result
+`)
+
+// RateLimitRequests counts one request against the requests per minute limit
+// of every key, the request is only counted when none of the limits is
+// reached. It returns the status of each limit and the index of the first
+// limit that was reached, -1 when the request is let through.
+func RateLimitRequests(keys []string, limits []int) ([]*RateLimitStatus, int) {
+	statuses := make([]*RateLimitStatus, len(keys))
+	if len(keys) == 0 {
+		return statuses, -1
+	}
+	windowKeys := make([]string, len(keys))
+	for i, key := range keys {
+		windowKeys[i] = key + ":rpm"
+	}
+	if !common.RedisEnabled {
+		rateLimitWindows.Init(common.RateLimitKeyExpirationDuration)
+		windowLimits := make([]int64, len(limits))
+		for i, limit := range limits {
+			windowLimits[i] = int64(limit)
+		}
+		sums, oldests, rejected := rateLimitWindows.Request(windowKeys, windowLimits, rateLimitWindow)
+		for i, limit := range limits {
+			statuses[i] = newRateLimitStatus(limit, sums[i], oldests[i])
+		}
+		return statuses, rejected
+	}
+	now := time.Now()
+	args := []interface{}{
+		now.Add(-rateLimitWindow).UnixMilli(),
+		now.UnixMilli(),
+		fmt.Sprintf("%d:%d", now.UnixNano(), 1),
+		rateLimitWindow.Milliseconds(),
+	}
+	for _, limit := range limits {
+		args = append(args, limit)
+	}
+	result, err := requestRateLimitScript.Run(context.Background(), common.RDB, windowKeys, args...).Int64Slice()
+	if err != nil || len(result) != 1+2*len(keys) {
+		// a broken redis must not take the relay down with it
+		if err != nil {
+			common.SysError("failed to check request rate limit: " + err.Error())
+		}
+		for i, limit := range limits {
+			statuses[i] = newRateLimitStatus(limit, 0, 0)
+		}
+		return statuses, -1
+	}
+	for i, limit := range limits {
+		statuses[i] = newRateLimitStatus(limit, result[1+2*i], result[2+2*i])
+	}
+	return statuses, int(result[0]) - 1
+}
+
+// RateLimitTokens checks the tokens per minute limit of key, the tokens of a
+// request are only known once it finished so they are added by
+// RecordRateLimitTokens afterwards.
+func RateLimitTokens(key string, limit int) (*RateLimitStatus, bool) {
+	key += ":tpm"
+	var used, oldest int64
+	if !common.RedisEnabled {
+		rateLimitWindows.Init(common.RateLimitKeyExpirationDuration)
+		used, oldest = rateLimitWindows.Usage(key, rateLimitWindow)
+	} else {
+		var err error
+		used, oldest, err = redisWindowUsage(context.Background(), key)
+		if err != nil {
+			common.SysError("failed to check token rate limit: " + err.Error())
+			return newRateLimitStatus(limit, 0, 0), true
+		}
+	}
+	return newRateLimitStatus(limit, used, oldest), used < int64(limit)
+}
+
+func addRateLimitTokens(key string, tokens int) {
+	key += ":tpm"
+	if !common.RedisEnabled {
+		rateLimitWindows.Init(common.RateLimitKeyExpirationDuration)
+		rateLimitWindows.Add(key, int64(tokens))
+		return
+	}
+	err := redisWindowAdd(context.Background(), key, int64(tokens))
+	if err != nil {
+		common.SysError("failed to record token rate limit: " + err.Error())
+	}
+}
+
+// RecordRateLimitTokens adds the tokens of a finished request to the token
+// and user windows that have a tokens per minute limit.
+func RecordRateLimitTokens(c *gin.Context, tokens int) {
+	if tokens <= 0 {
+		return
+	}
+	if c.GetInt("token_rate_limit_tpm") > 0 {
+		addRateLimitTokens(GetTokenRateLimitKey(c.GetInt("token_id")), tokens)
+	}
+	if setting.GetGroupRateLimit(c.GetString("group")).TPM > 0 {
+		addRateLimitTokens(GetUserRateLimitKey(c.GetInt("id")), tokens)
+	}
+}
@@ -39,6 +39,22 @@ func ObjectData(c *gin.Context, object interface{}) error {
 	return StringData(c, string(jsonData))
 }
 
+// RawLineData forwards one line of an upstream event stream as is, flushing at event boundaries.
+func RawLineData(c *gin.Context, line string) error {
+	_, err := c.Writer.WriteString(line + "\n")
+	if err != nil {
+		return err
+	}
+	if line == "" {
+		if flusher, ok := c.Writer.(http.Flusher); ok {
+			flusher.Flush()
+		} else {
+			return errors.New("streaming error: flusher not found")
+		}
+	}
+	return nil
+}
+
 func Done(c *gin.Context) {
 	_ = StringData(c, "[DONE]")
 }
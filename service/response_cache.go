@@ -0,0 +1,194 @@
+package service
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/sjson"
+	"net/http"
+	"one-api/common"
+	"one-api/dto"
+	relaycommon "one-api/relay/common"
+	"one-api/setting"
+	"sync"
+	"time"
+)
+
+// responseCacheMemoryLimit bounds the in memory cache used without redis.
+const responseCacheMemoryLimit = 1000
+
+type ResponseCache struct {
+	ContentType string     `json:"content_type"`
+	IsStream    bool       `json:"is_stream"`
+	Body        []byte     `json:"body"`
+	Usage       *dto.Usage `json:"usage"`
+	ExpiresAt   int64      `json:"expires_at"`
+}
+
+var responseCacheMemory = make(map[string]*ResponseCache)
+var responseCacheMemoryLock sync.Mutex
+
+// GetResponseCacheKey hashes the model the client asked for and the request
+// body as sent by the client together with the owner of the cache entry picked
+// by setting.ResponseCacheScope, so neither the channel model mapping nor the
+// request conversion change the key. The user field does not change the
+// response and is dropped first so it does not split the cache.
+func GetResponseCacheKey(relayInfo *relaycommon.RelayInfo, requestBody []byte) string {
+	var scope string
+	switch setting.ResponseCacheScope {
+	case setting.ResponseCacheScopeGroup:
+		scope = "group:" + relayInfo.Group
+	case setting.ResponseCacheScopeUser:
+		scope = fmt.Sprintf("user:%d", relayInfo.UserId)
+	default:
+		scope = fmt.Sprintf("token:%d", relayInfo.TokenId)
+	}
+	normalized, err := sjson.DeleteBytes(requestBody, "user")
+	if err != nil {
+		normalized = requestBody
+	}
+	hash := sha256.New()
+	hash.Write([]byte(scope + "\n" + relayInfo.OriginModelName + "\n"))
+	hash.Write(normalized)
+	return "response_cache:" + hex.EncodeToString(hash.Sum(nil))
+}
+
+func GetResponseCache(key string) (*ResponseCache, bool) {
+	if common.RedisEnabled {
+		value, err := common.RedisGet(key)
+		if err != nil {
+			return nil, false
+		}
+		var cache ResponseCache
+		err = json.Unmarshal([]byte(value), &cache)
+		if err != nil {
+			return nil, false
+		}
+		return &cache, true
+	}
+	responseCacheMemoryLock.Lock()
+	defer responseCacheMemoryLock.Unlock()
+	cache, ok := responseCacheMemory[key]
+	if !ok {
+		return nil, false
+	}
+	if cache.ExpiresAt <= time.Now().Unix() {
+		delete(responseCacheMemory, key)
+		return nil, false
+	}
+	return cache, true
+}
+
+func SetResponseCache(key string, cache *ResponseCache, ttl int) {
+	cache.ExpiresAt = time.Now().Unix() + int64(ttl)
+	if common.RedisEnabled {
+		value, err := json.Marshal(cache)
+		if err != nil {
+			return
+		}
+		err = common.RedisSet(key, string(value), time.Duration(ttl)*time.Second)
+		if err != nil {
+			common.SysError("failed to set response cache: " + err.Error())
+		}
+		return
+	}
+	responseCacheMemoryLock.Lock()
+	defer responseCacheMemoryLock.Unlock()
+	if len(responseCacheMemory) >= responseCacheMemoryLimit {
+		now := time.Now().Unix()
+		for k, v := range responseCacheMemory {
+			if v.ExpiresAt <= now {
+				delete(responseCacheMemory, k)
+			}
+		}
+		// still full, drop arbitrary entries rather than grow without bound
+		for k := range responseCacheMemory {
+			if len(responseCacheMemory) < responseCacheMemoryLimit {
+				break
+			}
+			delete(responseCacheMemory, k)
+		}
+	}
+	responseCacheMemory[key] = cache
+}
+
+// ReplayResponseCache writes a cached response, streamed responses are sent
+// back event by event as they were received.
+func ReplayResponseCache(c *gin.Context, cache *ResponseCache) {
+	c.Writer.Header().Set("Content-Type", cache.ContentType)
+	c.Writer.Header().Set("X-Response-Cache", "HIT")
+	if cache.IsStream {
+		c.Writer.Header().Set("Cache-Control", "no-cache")
+		c.Writer.WriteHeader(http.StatusOK)
+		for _, event := range bytes.SplitAfter(cache.Body, []byte("\n\n")) {
+			if len(event) == 0 {
+				continue
+			}
+			_, err := c.Writer.Write(event)
+			if err != nil {
+				return
+			}
+			c.Writer.Flush()
+		}
+		return
+	}
+	c.Writer.WriteHeader(http.StatusOK)
+	_, _ = c.Writer.Write(cache.Body)
+}
+
+// ResponseCacheWriter records what is written to the client so the response
+// can be cached once the relay succeeded. The caller swaps it in as c.Writer
+// and puts ResponseWriter back when the relay is done.
+type ResponseCacheWriter struct {
+	gin.ResponseWriter
+	body     bytes.Buffer
+	overflow bool
+}
+
+func NewResponseCacheWriter(writer gin.ResponseWriter) *ResponseCacheWriter {
+	return &ResponseCacheWriter{ResponseWriter: writer}
+}
+
+func (w *ResponseCacheWriter) record(data []byte) {
+	if w.overflow {
+		return
+	}
+	if w.body.Len()+len(data) > setting.ResponseCacheMaxSize*1024 {
+		w.overflow = true
+		w.body.Reset()
+		return
+	}
+	w.body.Write(data)
+}
+
+func (w *ResponseCacheWriter) Write(data []byte) (int, error) {
+	w.record(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *ResponseCacheWriter) WriteString(s string) (int, error) {
+	w.record([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+// Save caches the recorded response, failed or oversized responses are skipped.
+func (w *ResponseCacheWriter) Save(key string, isStream bool, usage *dto.Usage, ttl int) {
+	if w.overflow || w.body.Len() == 0 || w.Status() != http.StatusOK || usage == nil {
+		return
+	}
+	if ttl <= 0 {
+		ttl = setting.ResponseCacheTTL
+	}
+	if ttl <= 0 {
+		return
+	}
+	SetResponseCache(key, &ResponseCache{
+		ContentType: w.Header().Get("Content-Type"),
+		IsStream:    isStream,
+		Body:        bytes.Clone(w.body.Bytes()),
+		Usage:       usage,
+	}, ttl)
+}
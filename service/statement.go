@@ -0,0 +1,441 @@
+package service
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"one-api/common"
+	"one-api/model"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const StatementMonthLayout = "2006-01"
+
+type StatementTopUp struct {
+	TradeNo       string  `json:"trade_no"`
+	PaymentMethod string  `json:"payment_method"`
+	CreateTime    int64   `json:"create_time"`
+	Status        string  `json:"status"`
+	Money         float64 `json:"money"`
+	Currency      string  `json:"currency"`
+	RefundedMoney float64 `json:"refunded_money"`
+	Quota         int64   `json:"quota"`
+	PlanId        int     `json:"plan_id"`
+}
+
+type StatementRedemption struct {
+	Id           int    `json:"id"`
+	Name         string `json:"name"`
+	RedeemedTime int64  `json:"redeemed_time"`
+	Quota        int64  `json:"quota"`
+}
+
+// Statement is the account of a user over a calendar month. PlanQuota is the
+// part of ConsumedQuota subscription plans paid, it never left the balance.
+// OtherQuota is what moved the balance besides top-ups, redemptions and logged
+// consumption, e.g. admin changes, affiliate transfers and refunds. Estimated
+// is set when a balance had no snapshot and was derived from the activity
+// instead.
+type Statement struct {
+	UserId          int                    `json:"user_id"`
+	Username        string                 `json:"username"`
+	Month           string                 `json:"month"`
+	PeriodStart     int64                  `json:"period_start"`
+	PeriodEnd       int64                  `json:"period_end"`
+	OpeningBalance  int64                  `json:"opening_balance"`
+	TopUpQuota      int64                  `json:"top_up_quota"`
+	RedemptionQuota int64                  `json:"redemption_quota"`
+	ConsumedQuota   int64                  `json:"consumed_quota"`
+	PlanQuota       int64                  `json:"plan_quota"`
+	OtherQuota      int64                  `json:"other_quota"`
+	ClosingBalance  int64                  `json:"closing_balance"`
+	Estimated       bool                   `json:"estimated"`
+	TopUps          []*StatementTopUp      `json:"top_ups,omitempty"`
+	Redemptions     []*StatementRedemption `json:"redemptions,omitempty"`
+	Models          []*model.ModelUsage    `json:"models,omitempty"`
+}
+
+// ParseStatementMonth returns the bounds of month in local time, an empty
+// month is the current one.
+func ParseStatementMonth(month string) (time.Time, time.Time, error) {
+	now := time.Now()
+	if month == "" {
+		month = now.Format(StatementMonthLayout)
+	}
+	start, err := time.ParseInLocation(StatementMonthLayout, month, time.Local)
+	if err != nil {
+		return time.Time{}, time.Time{}, errors.New("月份格式应为 YYYY-MM")
+	}
+	if start.After(now) {
+		return time.Time{}, time.Time{}, errors.New("不能查询未来月份的账单")
+	}
+	return start, start.AddDate(0, 1, 0), nil
+}
+
+type statementTotals struct {
+	topUps      map[int]*model.UserQuotaTotal
+	redemptions map[int]*model.UserQuotaTotal
+	consumption map[int]*model.UserQuotaTotal
+}
+
+func sumStatementTotals(userId int, start int64, end int64) (*statementTotals, error) {
+	totals := &statementTotals{}
+	var err error
+	if totals.topUps, err = model.SumTopUpQuota(userId, start, end); err != nil {
+		return nil, err
+	}
+	if totals.redemptions, err = model.SumRedemptionQuota(userId, start, end); err != nil {
+		return nil, err
+	}
+	if totals.consumption, err = model.SumConsumeQuota(userId, start, end); err != nil {
+		return nil, err
+	}
+	return totals, nil
+}
+
+func quotaOf(totals map[int]*model.UserQuotaTotal, userId int) int64 {
+	if total, ok := totals[userId]; ok {
+		return total.Quota
+	}
+	return 0
+}
+
+func planQuotaOf(totals map[int]*model.UserQuotaTotal, userId int) int64 {
+	if total, ok := totals[userId]; ok {
+		return total.PlanQuota
+	}
+	return 0
+}
+
+// net is how much the activity moved the balance, consumption paid by a plan
+// did not touch it.
+func (t *statementTotals) net(userId int) int64 {
+	consumed := quotaOf(t.consumption, userId) - planQuotaOf(t.consumption, userId)
+	return quotaOf(t.topUps, userId) + quotaOf(t.redemptions, userId) - consumed
+}
+
+// BuildStatements builds the statement summaries of month, for one user or
+// for every user that had a balance or activity in the month when userId is 0.
+func BuildStatements(userId int, month string) ([]*Statement, error) {
+	startTime, endTime, err := ParseStatementMonth(month)
+	if err != nil {
+		return nil, err
+	}
+	month = startTime.Format(StatementMonthLayout)
+	start, end, now := startTime.Unix(), endTime.Unix(), time.Now().Unix()
+	openings, err := model.GetQuotaSnapshots(userId, month)
+	if err != nil {
+		return nil, err
+	}
+	closings, err := model.GetQuotaSnapshots(userId, endTime.Format(StatementMonthLayout))
+	if err != nil {
+		return nil, err
+	}
+	totals, err := sumStatementTotals(userId, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []int
+	if userId != 0 {
+		ids = []int{userId}
+	} else {
+		seen := make(map[int]bool)
+		collect := func(id int) {
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+		for _, balances := range []map[int]int64{openings, closings} {
+			for id := range balances {
+				collect(id)
+			}
+		}
+		for _, sums := range []map[int]*model.UserQuotaTotal{totals.topUps, totals.redemptions, totals.consumption} {
+			for id := range sums {
+				collect(id)
+			}
+		}
+		sort.Ints(ids)
+	}
+	users, err := model.GetUserQuotas(ids)
+	if err != nil {
+		return nil, err
+	}
+	if userId != 0 && len(users) == 0 {
+		return nil, errors.New("用户不存在")
+	}
+
+	// balances after the month are only needed when neither end of it was
+	// snapshotted, they are summed once for all users
+	var later *statementTotals
+	statements := make([]*Statement, 0, len(users))
+	for _, user := range users {
+		statement := &Statement{
+			UserId:          user.Id,
+			Username:        user.Username,
+			Month:           month,
+			PeriodStart:     start,
+			PeriodEnd:       end,
+			TopUpQuota:      quotaOf(totals.topUps, user.Id),
+			RedemptionQuota: quotaOf(totals.redemptions, user.Id),
+			ConsumedQuota:   quotaOf(totals.consumption, user.Id),
+			PlanQuota:       planQuotaOf(totals.consumption, user.Id),
+		}
+		net := totals.net(user.Id)
+		opening, openingKnown := openings[user.Id]
+		closing, closingKnown := closings[user.Id]
+		if !closingKnown && end > now {
+			closing, closingKnown = int64(user.Quota), true
+		}
+		switch {
+		case openingKnown && closingKnown:
+			statement.OtherQuota = closing - opening - net
+		case closingKnown:
+			opening = closing - net
+			statement.Estimated = true
+		case openingKnown:
+			closing = opening + net
+			statement.Estimated = true
+		default:
+			if later == nil {
+				later, err = sumStatementTotals(userId, end, now)
+				if err != nil {
+					return nil, err
+				}
+			}
+			closing = int64(user.Quota) - later.net(user.Id)
+			opening = closing - net
+			statement.Estimated = true
+		}
+		statement.OpeningBalance = opening
+		statement.ClosingBalance = closing
+		statements = append(statements, statement)
+	}
+	return statements, nil
+}
+
+// BuildStatement builds the itemised statement of a user for month.
+func BuildStatement(userId int, month string) (*Statement, error) {
+	if userId == 0 {
+		return nil, errors.New("用户不存在")
+	}
+	statements, err := BuildStatements(userId, month)
+	if err != nil {
+		return nil, err
+	}
+	err = addStatementDetails(statements[0])
+	if err != nil {
+		return nil, err
+	}
+	return statements[0], nil
+}
+
+func addStatementDetails(statement *Statement) error {
+	topUps, err := model.GetStatementTopUps(statement.UserId, statement.PeriodStart, statement.PeriodEnd)
+	if err != nil {
+		return err
+	}
+	statement.TopUps = make([]*StatementTopUp, 0, len(topUps))
+	for _, topUp := range topUps {
+		statement.TopUps = append(statement.TopUps, &StatementTopUp{
+			TradeNo:       topUp.TradeNo,
+			PaymentMethod: topUp.PaymentMethod,
+			CreateTime:    topUp.CreateTime,
+			Status:        topUp.Status,
+			Money:         topUp.Money,
+			Currency:      topUp.Currency,
+			RefundedMoney: topUp.RefundedMoney,
+			Quota:         int64(float64(topUp.Amount) * common.QuotaPerUnit),
+			PlanId:        topUp.PlanId,
+		})
+	}
+	redemptions, err := model.GetStatementRedemptions(statement.UserId, statement.PeriodStart, statement.PeriodEnd)
+	if err != nil {
+		return err
+	}
+	statement.Redemptions = make([]*StatementRedemption, 0, len(redemptions))
+	for _, redemption := range redemptions {
+		statement.Redemptions = append(statement.Redemptions, &StatementRedemption{
+			Id:           redemption.Id,
+			Name:         redemption.Name,
+			RedeemedTime: redemption.RedeemedTime,
+			Quota:        int64(redemption.Quota),
+		})
+	}
+	statement.Models, err = model.GetModelUsages(statement.UserId, statement.PeriodStart, statement.PeriodEnd)
+	return err
+}
+
+// SnapshotQuotas records the opening balances of a month during its first
+// day, a later snapshot would include part of the month's activity so a
+// month the node missed the start of is left to be estimated.
+func SnapshotQuotas(frequency int) {
+	lastMonth := ""
+	for {
+		now := time.Now()
+		month := now.Format(StatementMonthLayout)
+		if month != lastMonth && now.Day() == 1 {
+			created, err := model.SnapshotUserQuotas(month)
+			if err != nil {
+				common.SysError(fmt.Sprintf("failed to snapshot quotas of %s: %s", month, err.Error()))
+			} else {
+				lastMonth = month
+				common.SysLog(fmt.Sprintf("recorded %d quota snapshots for %s", created, month))
+			}
+		}
+		time.Sleep(time.Duration(frequency) * time.Second)
+	}
+}
+
+func formatStatementTime(timestamp int64) string {
+	if timestamp == 0 {
+		return ""
+	}
+	return time.Unix(timestamp, 0).Format("2006-01-02 15:04:05")
+}
+
+func formatStatementQuota(quota int64) string {
+	if common.DisplayInCurrencyEnabled {
+		return fmt.Sprintf("$%.4f", float64(quota)/common.QuotaPerUnit)
+	}
+	return strconv.FormatInt(quota, 10)
+}
+
+var statementSummaryHeader = []string{"user_id", "username", "month", "opening_balance", "top_up_quota",
+	"redemption_quota", "consumed_quota", "plan_quota", "other_quota", "closing_balance", "estimated"}
+
+func statementSummaryRecord(statement *Statement) []string {
+	return []string{
+		strconv.Itoa(statement.UserId),
+		statement.Username,
+		statement.Month,
+		strconv.FormatInt(statement.OpeningBalance, 10),
+		strconv.FormatInt(statement.TopUpQuota, 10),
+		strconv.FormatInt(statement.RedemptionQuota, 10),
+		strconv.FormatInt(statement.ConsumedQuota, 10),
+		strconv.FormatInt(statement.PlanQuota, 10),
+		strconv.FormatInt(statement.OtherQuota, 10),
+		strconv.FormatInt(statement.ClosingBalance, 10),
+		strconv.FormatBool(statement.Estimated),
+	}
+}
+
+// WriteStatementsCSV writes one summary row per statement.
+func WriteStatementsCSV(w io.Writer, statements []*Statement) error {
+	writer := csv.NewWriter(w)
+	_ = writer.Write(statementSummaryHeader)
+	for _, statement := range statements {
+		_ = writer.Write(statementSummaryRecord(statement))
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// WriteStatementCSV writes the summary followed by a section per item type,
+// sections are separated by an empty line and start with their own header.
+func WriteStatementCSV(w io.Writer, statement *Statement) error {
+	writer := csv.NewWriter(w)
+	_ = writer.Write(statementSummaryHeader)
+	_ = writer.Write(statementSummaryRecord(statement))
+	_ = writer.Write(nil)
+	_ = writer.Write([]string{"trade_no", "payment_method", "create_time", "status", "money", "currency", "refunded_money", "quota", "plan_id"})
+	for _, topUp := range statement.TopUps {
+		_ = writer.Write([]string{
+			topUp.TradeNo,
+			topUp.PaymentMethod,
+			formatStatementTime(topUp.CreateTime),
+			topUp.Status,
+			strconv.FormatFloat(topUp.Money, 'f', 2, 64),
+			topUp.Currency,
+			strconv.FormatFloat(topUp.RefundedMoney, 'f', 2, 64),
+			strconv.FormatInt(topUp.Quota, 10),
+			strconv.Itoa(topUp.PlanId),
+		})
+	}
+	_ = writer.Write(nil)
+	_ = writer.Write([]string{"redemption_id", "name", "redeemed_time", "quota"})
+	for _, redemption := range statement.Redemptions {
+		_ = writer.Write([]string{
+			strconv.Itoa(redemption.Id),
+			redemption.Name,
+			formatStatementTime(redemption.RedeemedTime),
+			strconv.FormatInt(redemption.Quota, 10),
+		})
+	}
+	_ = writer.Write(nil)
+	_ = writer.Write([]string{"model_name", "requests", "prompt_tokens", "completion_tokens", "quota", "plan_quota"})
+	for _, usage := range statement.Models {
+		_ = writer.Write([]string{
+			usage.ModelName,
+			strconv.FormatInt(usage.Requests, 10),
+			strconv.FormatInt(usage.PromptTokens, 10),
+			strconv.FormatInt(usage.CompletionTokens, 10),
+			strconv.FormatInt(usage.Quota, 10),
+			strconv.FormatInt(usage.PlanQuota, 10),
+		})
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// WriteStatementPDF renders the statement with English labels, names beyond
+// latin need the font configured by PDF_FONT_PATH.
+func WriteStatementPDF(w io.Writer, statement *Statement) error {
+	title := fmt.Sprintf("Statement %s", statement.Month)
+	lines := []common.PDFLine{
+		{Text: common.SystemName, Size: 18, Font: common.PDFFontBold},
+		{Text: title, Size: 14, Font: common.PDFFontBold},
+		{},
+		{Text: fmt.Sprintf("User: %s (#%d)", statement.Username, statement.UserId)},
+		{Text: fmt.Sprintf("Period: %s - %s", formatStatementTime(statement.PeriodStart), formatStatementTime(statement.PeriodEnd))},
+		{Text: fmt.Sprintf("Generated: %s", formatStatementTime(time.Now().Unix()))},
+		{},
+		{Text: "Summary", Size: 12, Font: common.PDFFontBold},
+	}
+	summary := [][2]string{
+		{"Opening balance", formatStatementQuota(statement.OpeningBalance)},
+		{"Top-ups", formatStatementQuota(statement.TopUpQuota)},
+		{"Redemptions", formatStatementQuota(statement.RedemptionQuota)},
+		{"Consumption", formatStatementQuota(-statement.ConsumedQuota)},
+		{"Paid by plan", formatStatementQuota(statement.PlanQuota)},
+		{"Other adjustments", formatStatementQuota(statement.OtherQuota)},
+		{"Closing balance", formatStatementQuota(statement.ClosingBalance)},
+	}
+	for _, row := range summary {
+		lines = append(lines, common.PDFLine{Text: fmt.Sprintf("%-20s %20s", row[0], row[1]), Font: common.PDFFontMono})
+	}
+	if statement.Estimated {
+		lines = append(lines, common.PDFLine{Text: "* Balances without a snapshot are estimated from the recorded activity.", Size: 8})
+	}
+
+	lines = append(lines, common.PDFLine{}, common.PDFLine{Text: "Top-ups", Size: 12, Font: common.PDFFontBold})
+	lines = append(lines, common.PDFLine{Text: fmt.Sprintf("%-19s %-8s %-9s %12s %12s %14s", "Date", "Method", "Status", "Paid", "Refunded", "Quota"), Font: common.PDFFontMono})
+	for _, topUp := range statement.TopUps {
+		lines = append(lines, common.PDFLine{Text: fmt.Sprintf("%-19s %-8s %-9s %12s %12s %14s",
+			formatStatementTime(topUp.CreateTime), topUp.PaymentMethod, topUp.Status,
+			fmt.Sprintf("%.2f %s", topUp.Money, topUp.Currency), fmt.Sprintf("%.2f", topUp.RefundedMoney),
+			formatStatementQuota(topUp.Quota)), Font: common.PDFFontMono})
+	}
+
+	lines = append(lines, common.PDFLine{}, common.PDFLine{Text: "Redemptions", Size: 12, Font: common.PDFFontBold})
+	lines = append(lines, common.PDFLine{Text: fmt.Sprintf("%-19s %-8s %-28s %14s", "Date", "Code", "Name", "Quota"), Font: common.PDFFontMono})
+	for _, redemption := range statement.Redemptions {
+		lines = append(lines, common.PDFLine{Text: fmt.Sprintf("%-19s %-8d %s %14s",
+			formatStatementTime(redemption.RedeemedTime), redemption.Id, common.PDFPad(redemption.Name, 28),
+			formatStatementQuota(redemption.Quota)), Font: common.PDFFontMono})
+	}
+
+	lines = append(lines, common.PDFLine{}, common.PDFLine{Text: "Consumption by model", Size: 12, Font: common.PDFFontBold})
+	lines = append(lines, common.PDFLine{Text: fmt.Sprintf("%-30s %9s %12s %12s %14s", "Model", "Requests", "Prompt", "Completion", "Quota"), Font: common.PDFFontMono})
+	for _, usage := range statement.Models {
+		lines = append(lines, common.PDFLine{Text: fmt.Sprintf("%s %9d %12d %12d %14s",
+			common.PDFPad(usage.ModelName, 30), usage.Requests, usage.PromptTokens, usage.CompletionTokens,
+			formatStatementQuota(usage.Quota)), Font: common.PDFFontMono})
+	}
+	return common.WritePDF(w, title, lines)
+}
@@ -0,0 +1,285 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+	"io"
+	"mime"
+	"net/http"
+	"one-api/common"
+	"one-api/model"
+	"one-api/setting"
+	"path"
+	"strings"
+	"time"
+)
+
+// maxStoredFileSize guards against upstreams returning unbounded bodies.
+const maxStoredFileSize = 256 << 20
+
+type StorageBackend interface {
+	Put(path string, data []byte, contentType string) error
+	Get(path string) (io.ReadCloser, error)
+	Delete(path string) error
+}
+
+func GetStorageBackend(storageType string) StorageBackend {
+	switch storageType {
+	case setting.StorageTypeLocal:
+		return &LocalStorage{}
+	case setting.StorageTypeS3:
+		return &S3Storage{}
+	}
+	return nil
+}
+
+func StorageEnabled() bool {
+	return GetStorageBackend(setting.StorageType) != nil
+}
+
+func GetStorageUrl(path string) string {
+	return setting.ServerAddress + "/storage/" + path
+}
+
+func IsStorageUrl(url string) bool {
+	return strings.HasPrefix(url, setting.ServerAddress+"/storage/")
+}
+
+// StoreRemoteFile downloads an upstream output into the storage backend and
+// returns the gateway url serving it. The url is returned unchanged when
+// storage is disabled or it is not a http url.
+func StoreRemoteFile(ctx context.Context, url string, prefix string) (string, error) {
+	backend := GetStorageBackend(setting.StorageType)
+	if backend == nil || !strings.HasPrefix(url, "http") || IsStorageUrl(url) {
+		return url, nil
+	}
+	sum := sha256.Sum256([]byte(url))
+	sourceHash := hex.EncodeToString(sum[:])
+	storedFile, exist, err := model.GetStoredFileBySource(sourceHash)
+	if err != nil {
+		return url, err
+	}
+	if exist {
+		return GetStorageUrl(storedFile.Path), nil
+	}
+
+	resp, err := GetHttpClient().Get(url)
+	if err != nil {
+		return url, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return url, fmt.Errorf("download %s failed with status code %d", url, resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxStoredFileSize+1))
+	if err != nil {
+		return url, err
+	}
+	if len(data) > maxStoredFileSize {
+		return url, fmt.Errorf("file %s is too large to store", url)
+	}
+	contentType := resp.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = http.DetectContentType(data)
+	}
+
+	storedFile = &model.StoredFile{
+		Path:        fmt.Sprintf("%s/%s/%s%s", prefix, time.Now().Format("20060102"), common.GetUUID(), storedFileExt(url, contentType)),
+		SourceHash:  sourceHash,
+		Backend:     setting.StorageType,
+		ContentType: contentType,
+		Size:        int64(len(data)),
+		CreatedAt:   common.GetTimestamp(),
+	}
+	err = backend.Put(storedFile.Path, data, contentType)
+	if err != nil {
+		return url, err
+	}
+	err = storedFile.Insert()
+	if err != nil {
+		_ = backend.Delete(storedFile.Path)
+		return url, err
+	}
+	return GetStorageUrl(storedFile.Path), nil
+}
+
+func storedFileExt(url string, contentType string) string {
+	ext := path.Ext(strings.SplitN(url, "?", 2)[0])
+	if ext != "" && len(ext) <= 6 {
+		return ext
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	exts, _ := mime.ExtensionsByType(mediaType)
+	if len(exts) > 0 {
+		return exts[0]
+	}
+	return ""
+}
+
+// StoreImageResponseUrls rewrites the urls of an openai image response, any
+// other field of the body is kept as is.
+func StoreImageResponseUrls(ctx context.Context, responseBody []byte) []byte {
+	if !StorageEnabled() {
+		return responseBody
+	}
+	for i, item := range gjson.GetBytes(responseBody, "data").Array() {
+		url := item.Get("url").String()
+		if url == "" {
+			continue
+		}
+		storageUrl, err := StoreRemoteFile(ctx, url, "images")
+		if err != nil {
+			common.LogError(ctx, "store image failed: "+err.Error())
+			continue
+		}
+		responseBody, _ = sjson.SetBytes(responseBody, fmt.Sprintf("data.%d.url", i), storageUrl)
+	}
+	return responseBody
+}
+
+// StoreJsonUrls rewrites every "*_url" string field of a task payload, e.g.
+// the audio_url and image_url of suno clips.
+func StoreJsonUrls(ctx context.Context, data json.RawMessage, prefix string) json.RawMessage {
+	if !StorageEnabled() || len(data) == 0 {
+		return data
+	}
+	var value any
+	if json.Unmarshal(data, &value) != nil {
+		return data
+	}
+	changed := storeJsonValueUrls(ctx, value, prefix)
+	if !changed {
+		return data
+	}
+	newData, err := json.Marshal(value)
+	if err != nil {
+		return data
+	}
+	return newData
+}
+
+func storeJsonValueUrls(ctx context.Context, value any, prefix string) bool {
+	changed := false
+	switch v := value.(type) {
+	case map[string]any:
+		for key, item := range v {
+			if url, ok := item.(string); ok && strings.HasSuffix(key, "_url") {
+				storageUrl, err := StoreRemoteFile(ctx, url, prefix)
+				if err != nil {
+					common.LogError(ctx, "store file failed: "+err.Error())
+					continue
+				}
+				if storageUrl != url {
+					v[key] = storageUrl
+					changed = true
+				}
+				continue
+			}
+			changed = storeJsonValueUrls(ctx, item, prefix) || changed
+		}
+	case []any:
+		for _, item := range v {
+			changed = storeJsonValueUrls(ctx, item, prefix) || changed
+		}
+	}
+	return changed
+}
+
+// ServeStoredFile streams a stored file from the backend it was written to.
+func ServeStoredFile(c *gin.Context, path string) {
+	storedFile, exist, err := model.GetStoredFile(path)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	if !exist {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "file_not_found",
+		})
+		return
+	}
+	backend := GetStorageBackend(storedFile.Backend)
+	if backend == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "storage_backend_not_found",
+		})
+		return
+	}
+	reader, err := backend.Get(storedFile.Path)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	defer reader.Close()
+	c.Writer.Header().Set("Content-Type", storedFile.ContentType)
+	c.Writer.Header().Set("Cache-Control", "public, max-age=86400")
+	c.Writer.WriteHeader(http.StatusOK)
+	_, err = io.Copy(c.Writer, reader)
+	if err != nil {
+		common.LogError(c, "copy stored file failed: "+err.Error())
+	}
+}
+
+// CleanStorage enforces the retention settings, files older than the
+// retention days go first, then the oldest files until the total size fits.
+func CleanStorage(frequency int) {
+	for {
+		time.Sleep(time.Duration(frequency) * time.Second)
+		if setting.StorageRetentionDays > 0 {
+			createdBefore := time.Now().AddDate(0, 0, -setting.StorageRetentionDays).Unix()
+			for {
+				storedFiles, err := model.GetOldestStoredFiles(createdBefore, 100)
+				if err != nil || len(storedFiles) == 0 || !deleteStoredFiles(storedFiles) {
+					break
+				}
+			}
+		}
+		if setting.StorageMaxSize > 0 {
+			maxSize := int64(setting.StorageMaxSize) << 20
+			for {
+				size, err := model.SumStoredFileSize()
+				if err != nil || size <= maxSize {
+					break
+				}
+				storedFiles, err := model.GetOldestStoredFiles(0, 100)
+				if err != nil || len(storedFiles) == 0 || !deleteStoredFiles(storedFiles) {
+					break
+				}
+			}
+		}
+	}
+}
+
+// deleteStoredFiles reports whether any file was deleted, so callers stop
+// looping when the backend keeps failing.
+func deleteStoredFiles(storedFiles []*model.StoredFile) bool {
+	deleted := false
+	for _, storedFile := range storedFiles {
+		backend := GetStorageBackend(storedFile.Backend)
+		if backend != nil {
+			err := backend.Delete(storedFile.Path)
+			if err != nil && !errors.Is(err, errStoredFileNotFound) {
+				common.SysError(fmt.Sprintf("failed to delete stored file %s: %s", storedFile.Path, err.Error()))
+				continue
+			}
+		}
+		err := storedFile.Delete()
+		if err != nil {
+			common.SysError(fmt.Sprintf("failed to delete stored file record %s: %s", storedFile.Path, err.Error()))
+			continue
+		}
+		deleted = true
+	}
+	return deleted
+}
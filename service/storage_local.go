@@ -0,0 +1,44 @@
+package service
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"one-api/setting"
+	"os"
+	"path/filepath"
+)
+
+var errStoredFileNotFound = errors.New("stored file not found")
+
+// LocalStorage keeps files under setting.StorageLocalPath.
+type LocalStorage struct{}
+
+func (s *LocalStorage) filePath(path string) string {
+	return filepath.Join(setting.StorageLocalPath, filepath.FromSlash(path))
+}
+
+func (s *LocalStorage) Put(path string, data []byte, contentType string) error {
+	filePath := s.filePath(path)
+	err := os.MkdirAll(filepath.Dir(filePath), 0755)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filePath, data, 0644)
+}
+
+func (s *LocalStorage) Get(path string) (io.ReadCloser, error) {
+	file, err := os.Open(s.filePath(path))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, errStoredFileNotFound
+	}
+	return file, err
+}
+
+func (s *LocalStorage) Delete(path string) error {
+	err := os.Remove(s.filePath(path))
+	if errors.Is(err, fs.ErrNotExist) {
+		return errStoredFileNotFound
+	}
+	return err
+}
@@ -0,0 +1,90 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"io"
+	"net/http"
+	"one-api/setting"
+	"strings"
+	"time"
+)
+
+// S3Storage talks to any S3 compatible server with path style urls, requests
+// are signed with SigV4 so no SDK client is needed.
+type S3Storage struct{}
+
+func (s *S3Storage) do(method string, path string, data []byte, contentType string) (*http.Response, error) {
+	if setting.StorageS3Endpoint == "" || setting.StorageS3Bucket == "" {
+		return nil, fmt.Errorf("s3 storage is not configured")
+	}
+	url := fmt.Sprintf("%s/%s/%s", strings.TrimSuffix(setting.StorageS3Endpoint, "/"), setting.StorageS3Bucket, path)
+	req, err := http.NewRequest(method, url, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	sum := sha256.Sum256(data)
+	payloadHash := hex.EncodeToString(sum[:])
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	credentials := aws.Credentials{
+		AccessKeyID:     setting.StorageS3AccessKey,
+		SecretAccessKey: setting.StorageS3SecretKey,
+	}
+	err = v4.NewSigner().SignHTTP(context.Background(), credentials, req, payloadHash, "s3", setting.StorageS3Region, time.Now(), func(options *v4.SignerOptions) {
+		options.DisableURIPathEscaping = true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return GetHttpClient().Do(req)
+}
+
+func s3ResponseError(resp *http.Response) error {
+	responseBody, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return errStoredFileNotFound
+	}
+	return fmt.Errorf("s3 request failed with status code %d: %s", resp.StatusCode, string(responseBody))
+}
+
+func (s *S3Storage) Put(path string, data []byte, contentType string) error {
+	resp, err := s.do(http.MethodPut, path, data, contentType)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return s3ResponseError(resp)
+	}
+	return resp.Body.Close()
+}
+
+func (s *S3Storage) Get(path string) (io.ReadCloser, error) {
+	resp, err := s.do(http.MethodGet, path, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, s3ResponseError(resp)
+	}
+	return resp.Body, nil
+}
+
+func (s *S3Storage) Delete(path string) error {
+	resp, err := s.do(http.MethodDelete, path, nil, "")
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return s3ResponseError(resp)
+	}
+	return resp.Body.Close()
+}
@@ -0,0 +1,185 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"one-api/dto"
+	"one-api/setting"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// stripeSignatureTolerance is how old a webhook timestamp may be, the same
+// default the official libraries use.
+const stripeSignatureTolerance = 5 * time.Minute
+
+// stripeZeroDecimalCurrencies are charged in whole units rather than cents.
+var stripeZeroDecimalCurrencies = map[string]bool{
+	"bif": true, "clp": true, "djf": true, "gnf": true, "jpy": true,
+	"kmf": true, "krw": true, "mga": true, "pyg": true, "rwf": true,
+	"ugx": true, "vnd": true, "vuv": true, "xaf": true, "xof": true,
+	"xpf": true,
+}
+
+// StripeAmount converts money to the smallest unit of currency.
+func StripeAmount(money float64, currency string) int64 {
+	if stripeZeroDecimalCurrencies[strings.ToLower(currency)] {
+		return int64(math.Round(money))
+	}
+	return int64(math.Round(money * 100))
+}
+
+// StripeMoney converts an amount in the smallest unit of currency back to money.
+func StripeMoney(amount int64, currency string) float64 {
+	if stripeZeroDecimalCurrencies[strings.ToLower(currency)] {
+		return float64(amount)
+	}
+	return float64(amount) / 100
+}
+
+func stripeRequest(method string, path string, form url.Values, idempotencyKey string, v any) error {
+	var body io.Reader
+	if method == http.MethodGet {
+		if len(form) > 0 {
+			path += "?" + form.Encode()
+		}
+	} else {
+		body = strings.NewReader(form.Encode())
+	}
+	req, err := http.NewRequest(method, setting.StripeApiAddress+path, body)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(setting.StripeApiSecret, "")
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if idempotencyKey != "" {
+		req.Header.Set("Idempotency-Key", idempotencyKey)
+	}
+	resp, err := GetImpatientHttpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var stripeErr dto.StripeError
+		if json.Unmarshal(respBody, &stripeErr) == nil && stripeErr.Error.Message != "" {
+			return fmt.Errorf("stripe error: %s", stripeErr.Error.Message)
+		}
+		return fmt.Errorf("stripe error: status code %d", resp.StatusCode)
+	}
+	return json.Unmarshal(respBody, v)
+}
+
+// StripeCreateCheckoutSession starts a one-off payment for tradeNo, the trade
+// number comes back in the webhook as client_reference_id.
+func StripeCreateCheckoutSession(tradeNo string, name string, money float64, successUrl string, cancelUrl string) (*dto.StripeCheckoutSession, error) {
+	currency := setting.StripeCurrency
+	form := url.Values{}
+	form.Set("mode", "payment")
+	form.Set("client_reference_id", tradeNo)
+	form.Set("success_url", successUrl)
+	form.Set("cancel_url", cancelUrl)
+	form.Set("metadata[trade_no]", tradeNo)
+	form.Set("payment_intent_data[metadata][trade_no]", tradeNo)
+	form.Set("line_items[0][quantity]", "1")
+	form.Set("line_items[0][price_data][currency]", currency)
+	form.Set("line_items[0][price_data][unit_amount]", strconv.FormatInt(StripeAmount(money, currency), 10))
+	form.Set("line_items[0][price_data][product_data][name]", name)
+	var session dto.StripeCheckoutSession
+	err := stripeRequest(http.MethodPost, "/v1/checkout/sessions", form, "checkout-"+tradeNo, &session)
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func StripeGetCheckoutSession(id string) (*dto.StripeCheckoutSession, error) {
+	var session dto.StripeCheckoutSession
+	err := stripeRequest(http.MethodGet, "/v1/checkout/sessions/"+url.PathEscape(id), nil, "", &session)
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// StripeGetPaymentIntent fetches the payment intent with its latest charge,
+// which carries the refunded amount.
+func StripeGetPaymentIntent(id string) (*dto.StripePaymentIntent, error) {
+	var paymentIntent dto.StripePaymentIntent
+	form := url.Values{"expand[]": {"latest_charge"}}
+	err := stripeRequest(http.MethodGet, "/v1/payment_intents/"+url.PathEscape(id), form, "", &paymentIntent)
+	if err != nil {
+		return nil, err
+	}
+	return &paymentIntent, nil
+}
+
+// StripeCreateRefund refunds money from paymentIntent, a zero money refunds
+// whatever is left.
+func StripeCreateRefund(paymentIntent string, money float64, currency string, idempotencyKey string) (*dto.StripeRefund, error) {
+	form := url.Values{}
+	form.Set("payment_intent", paymentIntent)
+	if money > 0 {
+		form.Set("amount", strconv.FormatInt(StripeAmount(money, currency), 10))
+	}
+	var refund dto.StripeRefund
+	err := stripeRequest(http.MethodPost, "/v1/refunds", form, idempotencyKey, &refund)
+	if err != nil {
+		return nil, err
+	}
+	return &refund, nil
+}
+
+// VerifyStripeSignature checks the Stripe-Signature header of a webhook
+// against the endpoint secret.
+func VerifyStripeSignature(payload []byte, header string, secret string) error {
+	var timestamp string
+	var signatures []string
+	for _, part := range strings.Split(header, ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "t":
+			timestamp = kv[1]
+		case "v1":
+			signatures = append(signatures, kv[1])
+		}
+	}
+	if timestamp == "" || len(signatures) == 0 {
+		return errors.New("invalid signature header")
+	}
+	t, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.New("invalid signature timestamp")
+	}
+	if time.Since(time.Unix(t, 0)).Abs() > stripeSignatureTolerance {
+		return errors.New("signature timestamp out of tolerance")
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	expected := mac.Sum(nil)
+	for _, signature := range signatures {
+		actual, err := hex.DecodeString(signature)
+		if err == nil && hmac.Equal(actual, expected) {
+			return nil
+		}
+	}
+	return errors.New("signature mismatch")
+}
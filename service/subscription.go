@@ -0,0 +1,61 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"one-api/common"
+	"one-api/model"
+	"time"
+)
+
+// StartPlanSubscription starts or renews the subscription and logs it for the
+// user, detail says how it was paid.
+func StartPlanSubscription(ctx context.Context, userId int, planId int, periods int, detail string) error {
+	subscription, err := model.StartSubscription(nil, userId, planId, periods)
+	if err != nil {
+		return err
+	}
+	recordPlanSubscription(ctx, subscription, periods, detail)
+	return nil
+}
+
+func recordPlanSubscription(ctx context.Context, subscription *model.Subscription, periods int, detail string) {
+	plan, err := model.GetPlanById(subscription.PlanId)
+	if err != nil {
+		common.LogError(ctx, fmt.Sprintf("failed to get plan %d: %s", subscription.PlanId, err.Error()))
+		return
+	}
+	common.LogInfo(ctx, fmt.Sprintf("user %d subscribed to plan %d for %d periods, expires at %d", subscription.UserId, plan.Id, periods, subscription.ExpireTime))
+	model.RecordLog(subscription.UserId, model.LogTypeTopup, fmt.Sprintf("订阅套餐 %s %d 个周期，到期时间 %s，%s", plan.Name, periods,
+		time.Unix(subscription.ExpireTime, 0).Format("2006-01-02 15:04:05"), detail))
+}
+
+// UpdateSubscriptions grants the plan quota of subscriptions entering a new
+// period and expires the ones that are no longer paid.
+func UpdateSubscriptions(frequency int) {
+	for {
+		time.Sleep(time.Duration(frequency) * time.Second)
+		now := common.GetTimestamp()
+		lastId := 0
+		for {
+			subscriptions, err := model.GetDueSubscriptions(now, lastId, 100)
+			if err != nil || len(subscriptions) == 0 {
+				break
+			}
+			lastId = subscriptions[len(subscriptions)-1].Id
+			for _, subscription := range subscriptions {
+				status, err := model.AdvanceSubscription(subscription, now)
+				if err != nil {
+					common.SysError(fmt.Sprintf("failed to update subscription %d: %s", subscription.Id, err.Error()))
+					continue
+				}
+				if status == model.SubscriptionStatusExpired {
+					model.RecordLog(subscription.UserId, model.LogTypeSystem, fmt.Sprintf("套餐订阅 %d 已到期", subscription.Id))
+				}
+			}
+			if len(subscriptions) < 100 {
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,5 @@
+package setting
+
+// BatchRatio is applied on top of the model and group ratios when a completed
+// batch is billed, openai prices batch requests at half the synchronous price.
+var BatchRatio = 0.5
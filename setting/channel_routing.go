@@ -0,0 +1,58 @@
+package setting
+
+import (
+	"encoding/json"
+	"errors"
+	"one-api/common"
+)
+
+const (
+	RoutingRandom           = "random" // priority then weighted random, the default
+	RoutingLeastLatency     = "least_latency"
+	RoutingLowestCost       = "lowest_cost"
+	RoutingLeastOutstanding = "least_outstanding"
+	RoutingStickyToken      = "sticky_token"
+	RoutingStickyUser       = "sticky_user"
+)
+
+// channelRoutingStrategy maps "group/model", "group" or "*" to a strategy,
+// the most specific entry wins.
+var channelRoutingStrategy = map[string]string{}
+
+func ChannelRoutingStrategy2JSONString() string {
+	jsonBytes, err := json.Marshal(channelRoutingStrategy)
+	if err != nil {
+		common.SysError("error marshalling channel routing strategy: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateChannelRoutingStrategyByJSONString(jsonStr string) error {
+	channelRoutingStrategy = make(map[string]string)
+	return json.Unmarshal([]byte(jsonStr), &channelRoutingStrategy)
+}
+
+func GetChannelRoutingStrategy(group string, model string) string {
+	for _, key := range []string{group + "/" + model, group, "*"} {
+		if strategy, ok := channelRoutingStrategy[key]; ok {
+			return strategy
+		}
+	}
+	return RoutingRandom
+}
+
+func CheckChannelRoutingStrategy(jsonStr string) error {
+	checkChannelRoutingStrategy := make(map[string]string)
+	err := json.Unmarshal([]byte(jsonStr), &checkChannelRoutingStrategy)
+	if err != nil {
+		return err
+	}
+	for key, strategy := range checkChannelRoutingStrategy {
+		switch strategy {
+		case RoutingRandom, RoutingLeastLatency, RoutingLowestCost, RoutingLeastOutstanding, RoutingStickyToken, RoutingStickyUser:
+		default:
+			return errors.New("unknown channel routing strategy for " + key + ": " + strategy)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,17 @@
+package setting
+
+// CircuitBreakerEnabled takes channels with a high error rate out of the
+// channel selection for a while, Channel.Status is never changed.
+var CircuitBreakerEnabled = false
+
+// CircuitBreakerErrorRate opens the breaker once this share of the recent
+// requests of a channel failed.
+var CircuitBreakerErrorRate = 0.5
+
+// CircuitBreakerMinRequests is how many recent requests are needed before the
+// error rate is trusted.
+var CircuitBreakerMinRequests = 10
+
+// CircuitBreakerOpenSeconds is how long an open breaker keeps the channel out
+// before a single probe request is let through.
+var CircuitBreakerOpenSeconds = 60
@@ -0,0 +1,52 @@
+package setting
+
+import (
+	"encoding/json"
+	"errors"
+	"one-api/common"
+	"time"
+)
+
+// hedgeRequestDelay maps "group/model", "group" or "*" to the delay in
+// milliseconds after which a chat or completions request that has not
+// produced its first token yet is also sent to a second channel. Groups
+// without an entry are not hedged.
+var hedgeRequestDelay = map[string]int{}
+
+func HedgeRequestDelay2JSONString() string {
+	jsonBytes, err := json.Marshal(hedgeRequestDelay)
+	if err != nil {
+		common.SysError("error marshalling hedge request delay: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateHedgeRequestDelayByJSONString(jsonStr string) error {
+	hedgeRequestDelay = make(map[string]int)
+	return json.Unmarshal([]byte(jsonStr), &hedgeRequestDelay)
+}
+
+// GetHedgeRequestDelay returns the hedge delay of the most specific entry,
+// false when requests of the group and model are not hedged.
+func GetHedgeRequestDelay(group string, model string) (time.Duration, bool) {
+	for _, key := range []string{group + "/" + model, group, "*"} {
+		if delay, ok := hedgeRequestDelay[key]; ok {
+			return time.Duration(delay) * time.Millisecond, delay > 0
+		}
+	}
+	return 0, false
+}
+
+func CheckHedgeRequestDelay(jsonStr string) error {
+	checkHedgeRequestDelay := make(map[string]int)
+	err := json.Unmarshal([]byte(jsonStr), &checkHedgeRequestDelay)
+	if err != nil {
+		return err
+	}
+	for key, delay := range checkHedgeRequestDelay {
+		if delay < 0 {
+			return errors.New("hedge request delay must not be negative for " + key)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package setting
+
+import (
+	"encoding/json"
+	"errors"
+	"one-api/common"
+)
+
+// error classes a fallback chain can be limited to
+const (
+	FallbackErrorRateLimit     = "rate_limit"
+	FallbackErrorServerError   = "server_error"
+	FallbackErrorTimeout       = "timeout"
+	FallbackErrorContentFilter = "content_filter"
+	FallbackErrorContextLength = "context_length"
+	FallbackErrorUnavailable   = "unavailable" // no channel left for the model
+)
+
+// ModelFallback is the chain tried, in order, when a model cannot be served.
+type ModelFallback struct {
+	Models []string `json:"models"`
+	// Errors are the classes that fall back right away, any other error only
+	// falls back once every channel of the model failed.
+	Errors []string `json:"errors"`
+}
+
+var modelFallback = map[string]ModelFallback{}
+
+// ModelFallbackHeaderEnabled sends the served model back in X-Served-Model
+// when a fallback model answered.
+var ModelFallbackHeaderEnabled = false
+
+func ModelFallback2JSONString() string {
+	jsonBytes, err := json.Marshal(modelFallback)
+	if err != nil {
+		common.SysError("error marshalling model fallback: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateModelFallbackByJSONString(jsonStr string) error {
+	modelFallback = make(map[string]ModelFallback)
+	return json.Unmarshal([]byte(jsonStr), &modelFallback)
+}
+
+func GetModelFallback(model string) (ModelFallback, bool) {
+	fallback, ok := modelFallback[model]
+	return fallback, ok && len(fallback.Models) > 0
+}
+
+func CheckModelFallback(jsonStr string) error {
+	checkModelFallback := make(map[string]ModelFallback)
+	err := json.Unmarshal([]byte(jsonStr), &checkModelFallback)
+	if err != nil {
+		return err
+	}
+	for model, fallback := range checkModelFallback {
+		for _, fallbackModel := range fallback.Models {
+			if fallbackModel == "" || fallbackModel == model {
+				return errors.New("invalid fallback model for " + model)
+			}
+		}
+		for _, errorClass := range fallback.Errors {
+			switch errorClass {
+			case FallbackErrorRateLimit, FallbackErrorServerError, FallbackErrorTimeout,
+				FallbackErrorContentFilter, FallbackErrorContextLength, FallbackErrorUnavailable:
+			default:
+				return errors.New("unknown fallback error class for " + model + ": " + errorClass)
+			}
+		}
+	}
+	return nil
+}
@@ -6,3 +6,21 @@ var EpayId = ""
 var EpayKey = ""
 var Price = 7.3
 var MinTopUp = 1
+
+// Stripe Checkout, StripePrice is what one unit of quota costs in
+// StripeCurrency, the same way Price is for Epay.
+var StripeApiSecret = ""
+var StripeWebhookSecret = ""
+var StripeCurrency = "usd"
+var StripePrice = 1.0
+
+// StripeApiAddress can point at a local stand-in such as stripe-mock.
+var StripeApiAddress = "https://api.stripe.com"
+
+func StripeEnabled() bool {
+	return StripeApiSecret != "" && StripeWebhookSecret != ""
+}
+
+// TopUpOrderExpireMinutes is how long an unpaid order stays pending before
+// the reconciliation job expires it.
+var TopUpOrderExpireMinutes = 120
@@ -0,0 +1,46 @@
+package setting
+
+import (
+	"encoding/json"
+	"errors"
+	"one-api/common"
+)
+
+// RateLimit is the per minute budget of a user group, 0 means unlimited.
+type RateLimit struct {
+	RPM int `json:"rpm"`
+	TPM int `json:"tpm"`
+}
+
+var groupRateLimit = map[string]RateLimit{}
+
+func GroupRateLimit2JSONString() string {
+	jsonBytes, err := json.Marshal(groupRateLimit)
+	if err != nil {
+		common.SysError("error marshalling group rate limit: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateGroupRateLimitByJSONString(jsonStr string) error {
+	groupRateLimit = make(map[string]RateLimit)
+	return json.Unmarshal([]byte(jsonStr), &groupRateLimit)
+}
+
+func GetGroupRateLimit(name string) RateLimit {
+	return groupRateLimit[name]
+}
+
+func CheckGroupRateLimit(jsonStr string) error {
+	checkGroupRateLimit := make(map[string]RateLimit)
+	err := json.Unmarshal([]byte(jsonStr), &checkGroupRateLimit)
+	if err != nil {
+		return err
+	}
+	for name, rateLimit := range checkGroupRateLimit {
+		if rateLimit.RPM < 0 || rateLimit.TPM < 0 {
+			return errors.New("group rate limit must be not less than 0: " + name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package setting
+
+// ResponseCacheTTL is the default lifetime of a cached response in seconds,
+// tokens may set their own.
+var ResponseCacheTTL = 3600
+
+// ResponseCacheRatio is applied to the normal charge of a cache hit, 0 makes
+// hits free.
+var ResponseCacheRatio = 0.0
+
+// ResponseCacheMaxSize caps a single cached response in KB, larger responses
+// are relayed but not cached.
+var ResponseCacheMaxSize = 1024
+
+const (
+	ResponseCacheScopeToken = "token"
+	ResponseCacheScopeUser  = "user"
+	ResponseCacheScopeGroup = "group"
+)
+
+// ResponseCacheScope decides who shares cached responses: only the token that
+// made the request (default), every token of the same user, or every token of
+// the same group. A shared scope lets a response made for one user be
+// replayed to another, only widen it when requests carry nothing private.
+var ResponseCacheScope = ResponseCacheScopeToken
+
+func IsValidResponseCacheScope(scope string) bool {
+	return scope == ResponseCacheScopeToken || scope == ResponseCacheScopeUser || scope == ResponseCacheScopeGroup
+}
@@ -0,0 +1,24 @@
+package setting
+
+const (
+	StorageTypeLocal = "local"
+	StorageTypeS3    = "s3"
+)
+
+// StorageType selects where generated media is kept, empty leaves upstream
+// urls untouched.
+var StorageType = ""
+var StorageLocalPath = "./storage"
+
+// S3 compatible backend, objects are addressed path style so MinIO and
+// similar servers work without extra DNS setup.
+var StorageS3Endpoint = ""
+var StorageS3Region = "us-east-1"
+var StorageS3Bucket = ""
+var StorageS3AccessKey = ""
+var StorageS3SecretKey = ""
+
+// StorageRetentionDays and StorageMaxSize (in MB) limit what is kept, 0 means
+// no limit.
+var StorageRetentionDays = 30
+var StorageMaxSize = 0